@@ -0,0 +1,245 @@
+// Package azure provisions the databases as Azure Database for PostgreSQL and MySQL flexible servers through the
+// Azure Resource Manager API
+package azure
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+
+	"github.com/pkg/errors"
+	"github.com/sorenmat/k8s-rds/crd"
+	"github.com/sorenmat/k8s-rds/kube"
+	"github.com/sorenmat/k8s-rds/provider"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+type Azure struct {
+	kc     kubernetes.Interface
+	env    *Environment
+	client *client
+	flavor flavor
+}
+
+// New returns the provider creating the servers in the subscription of the environment
+func New(db *crd.Database, kc kubernetes.Interface, env *Environment) (*Azure, error) {
+	f, err := flavorOf(db.Spec.Engine)
+	if err != nil {
+		return nil, err
+	}
+	return &Azure{kc: kc, env: env, client: &client{env: env}, flavor: f}, nil
+}
+
+// Ensure creates the flexible server with the database of the spec, or applies the changes of the spec when it exists,
+// and returns its status
+func (a *Azure) Ensure(ctx context.Context, db *crd.Database) (*provider.ProviderStatus, error) {
+	var current server
+	err := a.client.get(ctx, serverPath(db, a.env, a.flavor, ""), &current)
+	if isNotFound(err) {
+		err = a.create(ctx, db, &current)
+	} else if err == nil && ready(&current) {
+		err = a.update(ctx, db, &current)
+	}
+	if err != nil {
+		return nil, err
+	}
+	status := a.serverStatus(&current)
+	if !status.Ready {
+		// the database and the private endpoint are created once it's ready
+		return status, nil
+	}
+	if err := a.ensureDatabase(ctx, db); err != nil {
+		return nil, err
+	}
+	if usesPrivateEndpoint(db) {
+		ip, err := a.ensurePrivateEndpoint(ctx, db, current.ID)
+		if err != nil {
+			return nil, err
+		}
+		status.Hostname = ip
+		status.Ready = ip != ""
+	}
+	return status, nil
+}
+
+// create starts the creation of the server, current is set to the server being created
+func (a *Azure) create(ctx context.Context, db *crd.Database, current *server) error {
+	path := serverPath(db, a.env, a.flavor, "")
+	desired, err := desiredServer(db, a.env)
+	if err != nil {
+		return err
+	}
+	password, err := a.GetSecret(ctx, db.Namespace, db.Spec.Password.Name, db.Spec.Password.Key)
+	if err != nil {
+		return err
+	}
+	desired.Properties.AdministratorLoginPassword = password
+	log.Printf("creating flexible server %v\n", serverName(db))
+	if err := a.client.start(ctx, http.MethodPut, path, desired); err != nil {
+		return errors.Wrap(err, "unable to create the server")
+	}
+	err = a.client.get(ctx, path, current)
+	if isNotFound(err) {
+		// ARM only returns the server once the deployment has started
+		return nil
+	}
+	return err
+}
+
+// Describe returns the status of the server, its address is the IP of the private endpoint when it has one
+func (a *Azure) Describe(ctx context.Context, db *crd.Database) (*provider.ProviderStatus, error) {
+	var current server
+	err := a.client.get(ctx, serverPath(db, a.env, a.flavor, ""), &current)
+	if isNotFound(err) {
+		return &provider.ProviderStatus{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	status := a.serverStatus(&current)
+	if usesPrivateEndpoint(db) {
+		var pe privateEndpoint
+		err := a.client.get(ctx, privateEndpointPath(db, a.env), &pe)
+		if err != nil && !isNotFound(err) {
+			return nil, err
+		}
+		status.Hostname = endpointIP(&pe)
+		status.Ready = status.Ready && status.Hostname != ""
+	}
+	return status, nil
+}
+
+func ready(s *server) bool {
+	return s.Properties != nil && s.Properties.State == "Ready"
+}
+
+// serverStatus maps the server to the status of the provider, the service points at its DNS name
+func (a *Azure) serverStatus(s *server) *provider.ProviderStatus {
+	status := &provider.ProviderStatus{Exists: true, Ready: ready(s), State: "Provisioning", Port: a.flavor.port}
+	if s.Properties != nil && s.Properties.State != "" {
+		status.State = s.Properties.State
+		status.Hostname = s.Properties.FullyQualifiedDomainName
+		status.EngineVersion = s.Properties.Version
+	}
+	return status
+}
+
+func (a *Azure) ensureDatabase(ctx context.Context, db *crd.Database) error {
+	path := serverPath(db, a.env, a.flavor, "databases/"+db.Spec.DBName)
+	err := a.client.get(ctx, path, nil)
+	if !isNotFound(err) {
+		return err
+	}
+	log.Printf("creating database %v in %v\n", db.Spec.DBName, serverName(db))
+	var d database
+	d.Properties.Charset = a.flavor.charset
+	return errors.Wrap(a.client.call(ctx, http.MethodPut, path, d), "unable to create the database")
+}
+
+// ensurePrivateEndpoint creates the private endpoint of the server, and returns its IP, empty until it has one
+func (a *Azure) ensurePrivateEndpoint(ctx context.Context, db *crd.Database, serverID string) (string, error) {
+	path := privateEndpointPath(db, a.env)
+	var pe privateEndpoint
+	err := a.client.get(ctx, path, &pe)
+	if isNotFound(err) {
+		pe = privateEndpoint{Location: a.env.Location, Tags: tags(db)}
+		pe.Properties.Subnet.ID = db.Spec.Azure.PrivateEndpointSubnetID
+		connection := privateLinkServiceConnection{Name: serverName(db)}
+		connection.Properties.PrivateLinkServiceID = serverID
+		connection.Properties.GroupIDs = []string{a.flavor.groupID}
+		pe.Properties.PrivateLinkServiceConnections = []privateLinkServiceConnection{connection}
+		log.Printf("creating private endpoint %v\n", privateEndpointName(db))
+		if err := a.client.start(ctx, http.MethodPut, path, pe); err != nil {
+			return "", errors.Wrap(err, "unable to create the private endpoint")
+		}
+		// its IP is read again on the next resync
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	return endpointIP(&pe), nil
+}
+
+func endpointIP(pe *privateEndpoint) string {
+	for _, c := range pe.Properties.CustomDNSConfigs {
+		if len(c.IPAddresses) > 0 {
+			return c.IPAddresses[0]
+		}
+	}
+	return ""
+}
+
+// update applies the changes of the spec to the server
+func (a *Azure) update(ctx context.Context, db *crd.Database, current *server) error {
+	path := serverPath(db, a.env, a.flavor, "")
+	desired, err := desiredServer(db, a.env)
+	if err != nil {
+		return err
+	}
+	patch := serverChanges(desired, current)
+	if patch == nil {
+		return nil
+	}
+	log.Printf("updating flexible server %v\n", serverName(db))
+	err = a.client.start(ctx, http.MethodPatch, path, patch)
+	if isConflict(err) {
+		// a previous update is still running, the changes are compared again on the next resync
+		log.Printf("flexible server %v is busy with another operation\n", serverName(db))
+		return nil
+	}
+	return errors.Wrap(err, "unable to update the server")
+}
+
+// UpdatePassword sets the new password of the administrator
+func (a *Azure) UpdatePassword(ctx context.Context, db *crd.Database, password string) error {
+	log.Printf("changing the password of %v in %v\n", db.Spec.Username, serverName(db))
+	patch := &server{Properties: &serverProperties{AdministratorLoginPassword: password}}
+	err := a.client.call(ctx, http.MethodPatch, serverPath(db, a.env, a.flavor, ""), patch)
+	return errors.Wrap(err, "unable to change the password")
+}
+
+// Delete deletes the server and its private endpoint, unless it's deletion protected
+func (a *Azure) Delete(ctx context.Context, db *crd.Database) error {
+	if db.Spec.DeleteProtection {
+		log.Printf("Trying to delete a %v in %v which is a deleted protected database", db.Name, db.Namespace)
+		return nil
+	}
+	if usesPrivateEndpoint(db) {
+		log.Printf("deleting private endpoint %v\n", privateEndpointName(db))
+		err := a.client.call(ctx, http.MethodDelete, privateEndpointPath(db, a.env), nil)
+		if err != nil && !isNotFound(err) {
+			return errors.Wrap(err, "unable to delete the private endpoint")
+		}
+	}
+	log.Printf("deleting flexible server %v\n", serverName(db))
+	err := a.client.call(ctx, http.MethodDelete, serverPath(db, a.env, a.flavor, ""), nil)
+	if err != nil && !isNotFound(err) {
+		return errors.Wrap(err, "unable to delete the server")
+	}
+	return nil
+}
+
+// CreateService creates or updates a service pointing at the DNS name of the server, or the IP of its private endpoint
+func (a *Azure) CreateService(ctx context.Context, namespace string, hostname string, internalname string) error {
+	return kube.CreateAddressService(ctx, a.kc, namespace, hostname, internalname, a.flavor.port)
+}
+
+func (a *Azure) DeleteService(ctx context.Context, namespace string, dbname string) error {
+	err := kube.DeleteAddressService(ctx, a.kc, namespace, dbname)
+	if err != nil {
+		log.Println(err)
+		return errors.Wrap(err, fmt.Sprintf("delete of service %v failed in namespace %v", dbname, namespace))
+	}
+	return nil
+}
+
+func (a *Azure) GetSecret(ctx context.Context, namespace string, name string, key string) (string, error) {
+	secret, err := a.kc.CoreV1().Secrets(namespace).Get(ctx, name, metav1.GetOptions{})
+	if err != nil {
+		return "", errors.Wrap(err, fmt.Sprintf("unable to fetch secret %v", name))
+	}
+	return string(secret.Data[key]), nil
+}
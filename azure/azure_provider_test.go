@@ -0,0 +1,288 @@
+package azure
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/sorenmat/k8s-rds/crd"
+	"github.com/sorenmat/k8s-rds/provider"
+	"github.com/stretchr/testify/assert"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+// fakeARM is an in memory Azure Resource Manager, the long running operations finish the second time they're polled
+// and the servers and private endpoints are provisioned by finish
+type fakeARM struct {
+	lock      sync.Mutex
+	url       string
+	resources map[string]json.RawMessage // by path without the api version
+	polls     map[string]int
+	requests  []string
+	patches   []server
+}
+
+func newFakeARM() *fakeARM {
+	return &fakeARM{resources: map[string]json.RawMessage{}, polls: map[string]int{}}
+}
+
+func (f *fakeARM) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	if r.Header.Get("Authorization") != "Bearer token" {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	path := r.URL.Path
+	f.requests = append(f.requests, r.Method+" "+path)
+	if strings.HasPrefix(path, "/operations/") || strings.HasPrefix(path, "/locations/") {
+		f.polls[path]++
+		done := f.polls[path] > 1
+		if strings.HasPrefix(path, "/locations/") {
+			if !done {
+				w.WriteHeader(http.StatusAccepted)
+			}
+			return
+		}
+		status := "InProgress"
+		if done {
+			status = "Succeeded"
+		}
+		json.NewEncoder(w).Encode(map[string]string{"status": status})
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		resource, ok := f.resources[path]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			json.NewEncoder(w).Encode(map[string]apiError{"error": {Code: "ResourceNotFound", Message: "not found"}})
+			return
+		}
+		w.Write(resource)
+	case http.MethodPut:
+		var body map[string]interface{}
+		json.NewDecoder(r.Body).Decode(&body)
+		body["id"] = strings.TrimPrefix(path, "/subscriptions/sub")
+		if properties, ok := body["properties"].(map[string]interface{}); ok {
+			if strings.Contains(path, "/flexibleServers/") && !strings.Contains(path, "/databases/") {
+				properties["state"] = "Provisioning"
+				delete(properties, "administratorLoginPassword")
+			}
+		}
+		f.resources[path], _ = json.Marshal(body)
+		w.Header().Set("Azure-AsyncOperation", f.url+"/operations/"+path[strings.LastIndex(path, "/")+1:])
+		w.WriteHeader(http.StatusCreated)
+	case http.MethodPatch:
+		var patch server
+		json.NewDecoder(r.Body).Decode(&patch)
+		f.patches = append(f.patches, patch)
+		var current server
+		json.Unmarshal(f.resources[path], &current)
+		if patch.SKU != nil {
+			current.SKU = patch.SKU
+		}
+		f.resources[path], _ = json.Marshal(current)
+		w.Header().Set("Azure-AsyncOperation", f.url+"/operations/patch")
+		w.WriteHeader(http.StatusAccepted)
+	case http.MethodDelete:
+		if _, ok := f.resources[path]; !ok {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		// the sub resources are deleted with it
+		for p := range f.resources {
+			if p == path || strings.HasPrefix(p, path+"/") {
+				delete(f.resources, p)
+			}
+		}
+		w.Header().Set("Location", f.url+"/locations/"+path[strings.LastIndex(path, "/")+1:])
+		w.WriteHeader(http.StatusAccepted)
+	}
+}
+
+// finish provisions the server or the private endpoint at the path
+func (f *fakeARM) finish(path string) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	var resource map[string]interface{}
+	json.Unmarshal(f.resources[path], &resource)
+	properties := resource["properties"].(map[string]interface{})
+	if strings.Contains(path, "/privateEndpoints/") {
+		properties["customDnsConfigs"] = []map[string]interface{}{{"fqdn": "orders-shop.postgres.database.azure.com", "ipAddresses": []string{"10.2.0.5"}}}
+	} else {
+		properties["state"] = "Ready"
+		properties["fullyQualifiedDomainName"] = path[strings.LastIndex(path, "/")+1:] + ".postgres.database.azure.com"
+	}
+	f.resources[path], _ = json.Marshal(resource)
+}
+
+func testEnvironment(t *testing.T, f *fakeARM) *Environment {
+	pollInterval = time.Millisecond
+	server := httptest.NewServer(f)
+	t.Cleanup(server.Close)
+	f.url = server.URL
+	return &Environment{
+		SubscriptionID: "sub",
+		ResourceGroup:  "team-db",
+		Location:       "westeurope",
+		Endpoint:       server.URL,
+		HTTPClient:     server.Client(),
+		Token:          func(context.Context) (string, error) { return "token", nil },
+	}
+}
+
+func testDatabase() *crd.Database {
+	return &crd.Database{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "shop", Name: "orders"},
+		Spec: crd.DatabaseSpec{
+			Engine:                "postgres",
+			Version:               "14.4",
+			Class:                 "Standard_D2ds_v4",
+			Size:                  20,
+			BackupRetentionPeriod: 14,
+			MultiAZ:               true,
+			Username:              "app",
+			DBName:                "orders",
+			Password:              v1.SecretKeySelector{LocalObjectReference: v1.LocalObjectReference{Name: "orders"}, Key: "password"},
+			Azure: &crd.AzureSpec{
+				DelegatedSubnetID: "/subscriptions/sub/resourceGroups/network/providers/Microsoft.Network/virtualNetworks/aks/subnets/databases",
+				PrivateDNSZoneID:  "/subscriptions/sub/resourceGroups/network/providers/Microsoft.Network/privateDnsZones/shop.postgres.database.azure.com",
+			},
+		},
+	}
+}
+
+const serverURL = "/subscriptions/sub/resourceGroups/team-db/providers/Microsoft.DBforPostgreSQL/flexibleServers/orders-shop"
+
+func TestAzure(t *testing.T) {
+	ctx := context.Background()
+	f := newFakeARM()
+	env := testEnvironment(t, f)
+	kc := fake.NewSimpleClientset(&v1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "shop", Name: "orders"},
+		Data:       map[string][]byte{"password": []byte("secret")},
+	})
+	db := testDatabase()
+	a, err := New(db, kc, env)
+	assert.NoError(t, err)
+
+	// the creation isn't waited for
+	status, err := a.Ensure(ctx, db)
+	assert.NoError(t, err)
+	assert.Equal(t, &provider.ProviderStatus{Exists: true, State: "Provisioning", Port: 5432, EngineVersion: "14"}, status)
+	assert.NotContains(t, f.resources, serverURL+"/databases/orders", "the database is created once the server is ready")
+
+	f.finish(serverURL)
+	status, err = a.Ensure(ctx, db)
+	assert.NoError(t, err)
+	assert.Equal(t, &provider.ProviderStatus{Exists: true, Ready: true, State: "Ready", Hostname: "orders-shop.postgres.database.azure.com", Port: 5432, EngineVersion: "14"}, status)
+	hostname := status.Hostname
+	var s server
+	assert.NoError(t, json.Unmarshal(f.resources[serverURL], &s))
+	assert.Equal(t, &sku{Name: "Standard_D2ds_v4", Tier: "GeneralPurpose"}, s.SKU)
+	assert.Equal(t, "westeurope", s.Location)
+	assert.Equal(t, "14", s.Properties.Version)
+	assert.Equal(t, int64(32), s.Properties.Storage.StorageSizeGB)
+	assert.Equal(t, int64(14), s.Properties.Backup.BackupRetentionDays)
+	assert.Equal(t, "ZoneRedundant", s.Properties.HighAvailability.Mode)
+	assert.Equal(t, db.Spec.Azure.DelegatedSubnetID, s.Properties.Network.DelegatedSubnetResourceID)
+	assert.Contains(t, f.resources, serverURL+"/databases/orders")
+	assert.Contains(t, f.requests, "GET /operations/orders")
+	assert.NotContains(t, f.requests, "GET /operations/orders-shop")
+
+	_, err = a.Ensure(ctx, db)
+	assert.NoError(t, err)
+	assert.Empty(t, f.patches)
+	db.Spec.Class = "Standard_E4ds_v4"
+	_, err = a.Ensure(ctx, db)
+	assert.NoError(t, err)
+	assert.Len(t, f.patches, 1)
+	assert.Equal(t, &sku{Name: "Standard_E4ds_v4", Tier: "MemoryOptimized"}, f.patches[0].SKU)
+	assert.Nil(t, f.patches[0].Properties.Storage)
+
+	assert.NoError(t, a.UpdatePassword(ctx, db, "new-secret"))
+	assert.Equal(t, "new-secret", f.patches[1].Properties.AdministratorLoginPassword)
+
+	assert.NoError(t, a.CreateService(ctx, "shop", hostname, "orders"))
+	svc, err := kc.CoreV1().Services("shop").Get(ctx, "orders", metav1.GetOptions{})
+	assert.NoError(t, err)
+	assert.Equal(t, hostname, svc.Spec.ExternalName)
+
+	assert.NoError(t, a.Delete(ctx, db))
+	assert.NotContains(t, f.resources, serverURL)
+	assert.Contains(t, f.requests, "GET /locations/orders-shop")
+	status, err = a.Describe(ctx, db)
+	assert.NoError(t, err)
+	assert.False(t, status.Exists)
+	// it's gone already
+	assert.NoError(t, a.Delete(ctx, db))
+}
+
+func TestAzurePrivateEndpoint(t *testing.T) {
+	ctx := context.Background()
+	f := newFakeARM()
+	env := testEnvironment(t, f)
+	kc := fake.NewSimpleClientset(&v1.Secret{ObjectMeta: metav1.ObjectMeta{Namespace: "shop", Name: "orders"}})
+	db := testDatabase()
+	db.Spec.Azure = &crd.AzureSpec{
+		PrivateEndpointSubnetID: "/subscriptions/sub/resourceGroups/network/providers/Microsoft.Network/virtualNetworks/aks/subnets/endpoints",
+	}
+	a, err := New(db, kc, env)
+	assert.NoError(t, err)
+
+	_, err = a.Ensure(ctx, db)
+	assert.NoError(t, err)
+	f.finish(serverURL)
+	status, err := a.Ensure(ctx, db)
+	assert.NoError(t, err)
+	assert.False(t, status.Ready, "the private endpoint doesn't have an IP yet")
+	assert.Empty(t, status.Hostname)
+	f.finish("/subscriptions/sub/resourceGroups/team-db/providers/Microsoft.Network/privateEndpoints/orders-shop-pe")
+	status, err = a.Ensure(ctx, db)
+	assert.NoError(t, err)
+	assert.True(t, status.Ready)
+	assert.Equal(t, "10.2.0.5", status.Hostname)
+	hostname := status.Hostname
+	var s server
+	assert.NoError(t, json.Unmarshal(f.resources[serverURL], &s))
+	assert.Equal(t, "Disabled", s.Properties.Network.PublicNetworkAccess)
+	var pe privateEndpoint
+	assert.NoError(t, json.Unmarshal(f.resources["/subscriptions/sub/resourceGroups/team-db/providers/Microsoft.Network/privateEndpoints/orders-shop-pe"], &pe))
+	assert.Equal(t, []string{"postgresqlServer"}, pe.Properties.PrivateLinkServiceConnections[0].Properties.GroupIDs)
+	assert.Equal(t, "/resourceGroups/team-db/providers/Microsoft.DBforPostgreSQL/flexibleServers/orders-shop",
+		pe.Properties.PrivateLinkServiceConnections[0].Properties.PrivateLinkServiceID)
+
+	assert.NoError(t, a.CreateService(ctx, "shop", hostname, "orders"))
+	e, err := kc.CoreV1().Endpoints("shop").Get(ctx, "orders", metav1.GetOptions{})
+	assert.NoError(t, err)
+	assert.Equal(t, "10.2.0.5", e.Subsets[0].Addresses[0].IP)
+
+	status, err = a.Describe(ctx, db)
+	assert.NoError(t, err)
+	assert.True(t, status.Ready)
+	assert.Equal(t, "10.2.0.5", status.Hostname)
+
+	assert.NoError(t, a.Delete(ctx, db))
+	assert.Empty(t, f.resources)
+}
+
+func TestAzureErrors(t *testing.T) {
+	env := testEnvironment(t, newFakeARM())
+	env.Token = func(context.Context) (string, error) { return "expired", nil }
+	c := &client{env: env}
+	err := c.get(context.Background(), "resourceGroups/databases", nil)
+	assert.Error(t, err)
+	assert.False(t, isNotFound(err))
+
+	_, err = New(&crd.Database{Spec: crd.DatabaseSpec{Engine: "oracle-ee"}}, fake.NewSimpleClientset(), env)
+	assert.Error(t, err)
+}
@@ -0,0 +1,249 @@
+package azure
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// pollInterval is how often the long running operations are checked
+var pollInterval = 10 * time.Second
+
+// the resources of the flexible server API, only the fields the provider uses
+
+type server struct {
+	ID         string            `json:"id,omitempty"`
+	Location   string            `json:"location,omitempty"`
+	SKU        *sku              `json:"sku,omitempty"`
+	Tags       map[string]string `json:"tags,omitempty"`
+	Properties *serverProperties `json:"properties,omitempty"`
+}
+
+type sku struct {
+	Name string `json:"name"`
+	Tier string `json:"tier"` // Burstable, GeneralPurpose or MemoryOptimized
+}
+
+type serverProperties struct {
+	AdministratorLogin         string            `json:"administratorLogin,omitempty"`
+	AdministratorLoginPassword string            `json:"administratorLoginPassword,omitempty"`
+	Version                    string            `json:"version,omitempty"`
+	State                      string            `json:"state,omitempty"` // Ready when it's up
+	FullyQualifiedDomainName   string            `json:"fullyQualifiedDomainName,omitempty"`
+	Storage                    *storage          `json:"storage,omitempty"`
+	Backup                     *backup           `json:"backup,omitempty"`
+	HighAvailability           *highAvailability `json:"highAvailability,omitempty"`
+	Network                    *network          `json:"network,omitempty"`
+	CreateMode                 string            `json:"createMode,omitempty"`
+}
+
+type storage struct {
+	StorageSizeGB int64  `json:"storageSizeGB,omitempty"`
+	AutoGrow      string `json:"autoGrow,omitempty"` // Enabled or Disabled
+}
+
+type backup struct {
+	BackupRetentionDays int64 `json:"backupRetentionDays,omitempty"`
+}
+
+type highAvailability struct {
+	Mode string `json:"mode"` // ZoneRedundant or Disabled
+}
+
+type network struct {
+	DelegatedSubnetResourceID   string `json:"delegatedSubnetResourceId,omitempty"`
+	PrivateDNSZoneArmResourceID string `json:"privateDnsZoneArmResourceId,omitempty"`
+	PublicNetworkAccess         string `json:"publicNetworkAccess,omitempty"` // Enabled or Disabled
+}
+
+type database struct {
+	Properties struct {
+		Charset string `json:"charset,omitempty"`
+	} `json:"properties"`
+}
+
+type privateEndpoint struct {
+	Location   string                    `json:"location,omitempty"`
+	Tags       map[string]string         `json:"tags,omitempty"`
+	Properties privateEndpointProperties `json:"properties"`
+}
+
+type privateEndpointProperties struct {
+	Subnet struct {
+		ID string `json:"id"`
+	} `json:"subnet"`
+	PrivateLinkServiceConnections []privateLinkServiceConnection `json:"privateLinkServiceConnections,omitempty"`
+	CustomDNSConfigs              []struct {
+		FQDN        string   `json:"fqdn"`
+		IPAddresses []string `json:"ipAddresses"`
+	} `json:"customDnsConfigs,omitempty"`
+}
+
+type privateLinkServiceConnection struct {
+	Name       string `json:"name"`
+	Properties struct {
+		PrivateLinkServiceID string   `json:"privateLinkServiceId"`
+		GroupIDs             []string `json:"groupIds"`
+	} `json:"properties"`
+}
+
+// apiError is the error returned by ARM
+type apiError struct {
+	Status  int    `json:"-"`
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e *apiError) Error() string {
+	return fmt.Sprintf("azure: %v %v %v", e.Status, e.Code, e.Message)
+}
+
+func isNotFound(err error) bool {
+	var e *apiError
+	return errors.As(err, &e) && e.Status == http.StatusNotFound
+}
+
+// isConflict tells if the resource is busy with another operation
+func isConflict(err error) bool {
+	var e *apiError
+	return errors.As(err, &e) && e.Status == http.StatusConflict
+}
+
+// client calls ARM in the subscription
+type client struct {
+	env *Environment
+}
+
+// do sends the request, the path is relative to the subscription unless it's a URL
+func (c *client) do(ctx context.Context, method, path string, in, out interface{}) (*http.Response, error) {
+	var body bytes.Buffer
+	if in != nil {
+		if err := json.NewEncoder(&body).Encode(in); err != nil {
+			return nil, err
+		}
+	}
+	u := path
+	if !strings.HasPrefix(path, "http") {
+		u = fmt.Sprintf("%v/subscriptions/%v/%v", c.env.Endpoint, c.env.SubscriptionID, path)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, u, &body)
+	if err != nil {
+		return nil, err
+	}
+	token, err := c.env.accessToken(ctx)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := c.env.HTTPClient.Do(req)
+	if err != nil {
+		return nil, errors.Wrap(err, fmt.Sprintf("%v %v", method, path))
+	}
+	defer resp.Body.Close()
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode >= 300 {
+		var e struct {
+			Error apiError `json:"error"`
+		}
+		if err := json.Unmarshal(data, &e); err != nil || e.Error.Code == "" {
+			e.Error = apiError{Code: resp.Status, Message: string(data)}
+		}
+		e.Error.Status = resp.StatusCode
+		return nil, errors.Wrap(&e.Error, fmt.Sprintf("%v %v", method, path))
+	}
+	if out != nil && len(data) > 0 {
+		if err := json.Unmarshal(data, out); err != nil {
+			return nil, err
+		}
+	}
+	return resp, nil
+}
+
+// call sends a request and waits for the long running operation it started, if any
+func (c *client) call(ctx context.Context, method, path string, in interface{}) error {
+	resp, err := c.do(ctx, method, path, in, nil)
+	if err != nil {
+		return err
+	}
+	if op := resp.Header.Get("Azure-AsyncOperation"); op != "" {
+		return c.waitAsyncOperation(ctx, op)
+	}
+	if location := resp.Header.Get("Location"); resp.StatusCode == http.StatusAccepted && location != "" {
+		return c.waitLocation(ctx, location)
+	}
+	return nil
+}
+
+// start sends a request without waiting for the long running operation it started, the state of the resource
+// tells when it's done
+func (c *client) start(ctx context.Context, method, path string, in interface{}) error {
+	_, err := c.do(ctx, method, path, in, nil)
+	return err
+}
+
+// waitAsyncOperation polls the status of the operation until it's finished
+func (c *client) waitAsyncOperation(ctx context.Context, u string) error {
+	for {
+		var op struct {
+			Status string    `json:"status"` // InProgress, Succeeded, Failed or Canceled
+			Error  *apiError `json:"error,omitempty"`
+		}
+		if _, err := c.do(ctx, http.MethodGet, u, nil, &op); err != nil {
+			return err
+		}
+		switch op.Status {
+		case "Succeeded":
+			return nil
+		case "Failed", "Canceled":
+			if op.Error != nil {
+				return fmt.Errorf("operation %v: %v %v", op.Status, op.Error.Code, op.Error.Message)
+			}
+			return fmt.Errorf("operation %v", op.Status)
+		}
+		if err := sleep(ctx); err != nil {
+			return err
+		}
+	}
+}
+
+// waitLocation polls the location until it doesn't return 202 Accepted anymore
+func (c *client) waitLocation(ctx context.Context, u string) error {
+	for {
+		resp, err := c.do(ctx, http.MethodGet, u, nil, nil)
+		if err != nil {
+			return err
+		}
+		if resp.StatusCode != http.StatusAccepted {
+			return nil
+		}
+		if err := sleep(ctx); err != nil {
+			return err
+		}
+	}
+}
+
+func sleep(ctx context.Context) error {
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-time.After(pollInterval):
+		return nil
+	}
+}
+
+func (c *client) get(ctx context.Context, path string, out interface{}) error {
+	_, err := c.do(ctx, http.MethodGet, path, nil, out)
+	return err
+}
@@ -0,0 +1,149 @@
+package azure
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	// DefaultEndpoint is the Azure Resource Manager endpoint of the public cloud
+	DefaultEndpoint = "https://management.azure.com"
+	imdsURL         = "http://169.254.169.254/metadata/"
+)
+
+// Environment holds the subscription and location the servers are created in
+type Environment struct {
+	SubscriptionID string
+	ResourceGroup  string // default of the servers without spec.azure.resourceGroup
+	Location       string
+	Endpoint       string // ARM, tests point it at a fake server
+
+	// Token returns the access token of the ARM calls, it's taken from the workload identity or the managed identity
+	// of the node when nil
+	Token      func(ctx context.Context) (string, error)
+	HTTPClient *http.Client
+
+	lock    sync.Mutex
+	token   string
+	expires time.Time
+}
+
+// Discover fills in the subscription and location of the cluster from the instance metadata, unless they're given
+func Discover(ctx context.Context, subscriptionID, resourceGroup, location string) (*Environment, error) {
+	env := &Environment{SubscriptionID: subscriptionID, ResourceGroup: resourceGroup, Location: location,
+		Endpoint: DefaultEndpoint, HTTPClient: http.DefaultClient}
+	if env.SubscriptionID == "" || env.Location == "" {
+		var metadata struct {
+			Compute struct {
+				SubscriptionID string `json:"subscriptionId"`
+				Location       string `json:"location"`
+			} `json:"compute"`
+		}
+		if err := env.imds(ctx, "instance?api-version=2021-02-01", &metadata); err != nil {
+			return nil, errors.Wrap(err, "unable to discover the subscription and location, use --azure-subscription and --azure-location")
+		}
+		if env.SubscriptionID == "" {
+			env.SubscriptionID = metadata.Compute.SubscriptionID
+		}
+		if env.Location == "" {
+			env.Location = metadata.Compute.Location
+		}
+	}
+	return env, nil
+}
+
+func (e *Environment) imds(ctx context.Context, path string, out interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, imdsURL+path, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Metadata", "true")
+	return e.send(req, out)
+}
+
+func (e *Environment) send(req *http.Request, out interface{}) error {
+	resp, err := e.HTTPClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%v: %v %v", req.URL.Path, resp.Status, string(body))
+	}
+	return json.Unmarshal(body, out)
+}
+
+// tokenResponse is returned by both Azure AD and the IMDS, the IMDS has the expiry as a string
+type tokenResponse struct {
+	AccessToken string      `json:"access_token"`
+	ExpiresIn   json.Number `json:"expires_in"`
+}
+
+// accessToken returns the token of the ARM calls, it's cached until it expires. AKS workload identity is used when
+// its environment variables are set, the managed identity of the node otherwise.
+func (e *Environment) accessToken(ctx context.Context) (string, error) {
+	if e.Token != nil {
+		return e.Token(ctx)
+	}
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	if e.token != "" && time.Now().Before(e.expires) {
+		return e.token, nil
+	}
+
+	var t tokenResponse
+	if file := os.Getenv("AZURE_FEDERATED_TOKEN_FILE"); file != "" {
+		assertion, err := ioutil.ReadFile(file)
+		if err != nil {
+			return "", errors.Wrap(err, "unable to read the federated token")
+		}
+		authority := strings.TrimSuffix(os.Getenv("AZURE_AUTHORITY_HOST"), "/")
+		if authority == "" {
+			authority = "https://login.microsoftonline.com"
+		}
+		form := url.Values{
+			"grant_type":            {"client_credentials"},
+			"client_id":             {os.Getenv("AZURE_CLIENT_ID")},
+			"client_assertion_type": {"urn:ietf:params:oauth:client-assertion-type:jwt-bearer"},
+			"client_assertion":      {strings.TrimSpace(string(assertion))},
+			"scope":                 {DefaultEndpoint + "/.default"},
+		}
+		u := fmt.Sprintf("%v/%v/oauth2/v2.0/token", authority, os.Getenv("AZURE_TENANT_ID"))
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, u, strings.NewReader(form.Encode()))
+		if err != nil {
+			return "", err
+		}
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		if err := e.send(req, &t); err != nil {
+			return "", errors.Wrap(err, "unable to get an access token with the workload identity")
+		}
+	} else {
+		path := "identity/oauth2/token?api-version=2018-02-01&resource=" + url.QueryEscape(DefaultEndpoint+"/")
+		if id := os.Getenv("AZURE_CLIENT_ID"); id != "" {
+			path += "&client_id=" + url.QueryEscape(id)
+		}
+		if err := e.imds(ctx, path, &t); err != nil {
+			return "", errors.Wrap(err, "unable to get an access token with the managed identity")
+		}
+	}
+	expiresIn, _ := strconv.ParseInt(t.ExpiresIn.String(), 10, 64)
+	e.token = t.AccessToken
+	// renewed a minute before it expires
+	e.expires = time.Now().Add(time.Duration(expiresIn)*time.Second - time.Minute)
+	return e.token, nil
+}
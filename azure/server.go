@@ -0,0 +1,250 @@
+package azure
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+
+	"github.com/sorenmat/k8s-rds/crd"
+)
+
+// flavor holds what differs between the PostgreSQL and MySQL flexible servers
+type flavor struct {
+	namespace  string // resource provider
+	apiVersion string
+	groupID    string // of the private endpoints
+	charset    string
+	port       int32
+}
+
+var flavors = map[string]flavor{
+	"postgres": {namespace: "Microsoft.DBforPostgreSQL", apiVersion: "2024-08-01", groupID: "postgresqlServer", charset: "UTF8", port: 5432},
+	"mysql":    {namespace: "Microsoft.DBforMySQL", apiVersion: "2023-12-30", groupID: "mysqlServer", charset: "utf8mb4", port: 3306},
+}
+
+// skus are used for the smallest RDS classes, other sizes take an Azure SKU like Standard_D2ds_v4
+var skus = map[string]string{
+	"db.t2.micro":   "Standard_B1ms",
+	"db.t3.micro":   "Standard_B1ms",
+	"db.t4g.micro":  "Standard_B1ms",
+	"db.t2.small":   "Standard_B1ms",
+	"db.t3.small":   "Standard_B1ms",
+	"db.t4g.small":  "Standard_B1ms",
+	"db.t2.medium":  "Standard_B2s",
+	"db.t3.medium":  "Standard_B2s",
+	"db.t4g.medium": "Standard_B2s",
+}
+
+// postgresStorageSizes are the disk sizes PostgreSQL flexible servers support, in GiB
+var postgresStorageSizes = []int64{32, 64, 128, 256, 512, 1024, 2048, 4096, 8192, 16384, 32767}
+
+const (
+	minBackupRetentionDays = 7
+	maxBackupRetentionDays = 35
+)
+
+func flavorOf(engine string) (flavor, error) {
+	f, ok := flavors[engine]
+	if !ok {
+		return flavor{}, fmt.Errorf("the azure provider doesn't support the engine %v", engine)
+	}
+	return f, nil
+}
+
+// serverName returns the name of the server, it's part of its DNS name so lowercase letters, numbers and hyphens only
+func serverName(db *crd.Database) string {
+	name := strings.ToLower(strings.ReplaceAll(db.Name+"-"+db.Namespace, "_", "-"))
+	if len(name) > 63 {
+		name = name[:63]
+	}
+	return strings.TrimRight(name, "-")
+}
+
+func resourceGroup(db *crd.Database, env *Environment) string {
+	if db.Spec.Azure != nil && db.Spec.Azure.ResourceGroup != "" {
+		return db.Spec.Azure.ResourceGroup
+	}
+	return env.ResourceGroup
+}
+
+// serverPath returns the path of the server or of one of its sub resources, relative to the subscription
+func serverPath(db *crd.Database, env *Environment, f flavor, sub string) string {
+	path := fmt.Sprintf("resourceGroups/%v/providers/%v/flexibleServers/%v", resourceGroup(db, env), f.namespace, serverName(db))
+	if sub != "" {
+		path += "/" + sub
+	}
+	return path + "?api-version=" + f.apiVersion
+}
+
+// usesPrivateEndpoint tells if the server is reached through a private endpoint in a subnet of the cluster
+func usesPrivateEndpoint(db *crd.Database) bool {
+	return db.Spec.Azure != nil && db.Spec.Azure.PrivateEndpointSubnetID != ""
+}
+
+func privateEndpointName(db *crd.Database) string {
+	return serverName(db) + "-pe"
+}
+
+func privateEndpointPath(db *crd.Database, env *Environment) string {
+	return fmt.Sprintf("resourceGroups/%v/providers/Microsoft.Network/privateEndpoints/%v?api-version=2023-09-01",
+		resourceGroup(db, env), privateEndpointName(db))
+}
+
+// skuOf returns the SKU of the class, the tier is taken from the series of the SKU
+func skuOf(class string) (*sku, error) {
+	name := class
+	if s, ok := skus[class]; ok {
+		name = s
+	}
+	if !strings.HasPrefix(name, "Standard_") {
+		return nil, fmt.Errorf("unsupported class %v, use an Azure SKU like Standard_D2ds_v4", class)
+	}
+	switch name[len("Standard_")] {
+	case 'B':
+		return &sku{Name: name, Tier: "Burstable"}, nil
+	case 'D':
+		return &sku{Name: name, Tier: "GeneralPurpose"}, nil
+	case 'E':
+		return &sku{Name: name, Tier: "MemoryOptimized"}, nil
+	}
+	return nil, fmt.Errorf("unsupported SKU %v", name)
+}
+
+// version returns the version of the server, the major version for postgres and major.minor for mysql
+func version(engine, v string) string {
+	parts := strings.Split(v, ".")
+	if engine == "mysql" && len(parts) > 1 {
+		return parts[0] + "." + parts[1]
+	}
+	return parts[0]
+}
+
+// storageSize rounds the size up to one the server supports
+func storageSize(engine string, size int64) int64 {
+	if engine != "postgres" {
+		return size
+	}
+	for _, s := range postgresStorageSizes {
+		if s >= size {
+			return s
+		}
+	}
+	return postgresStorageSizes[len(postgresStorageSizes)-1]
+}
+
+// backupRetentionDays clamps the retention to what Azure supports, backups can't be disabled
+func backupRetentionDays(days int64) int64 {
+	if days < minBackupRetentionDays {
+		return minBackupRetentionDays
+	}
+	if days > maxBackupRetentionDays {
+		return maxBackupRetentionDays
+	}
+	return days
+}
+
+func tags(db *crd.Database) map[string]string {
+	result := map[string]string{}
+	for k, v := range db.Spec.Tags {
+		result[k] = v
+	}
+	result["managed-by"] = "k8s-rds"
+	result["k8s-rds-namespace"] = db.Namespace
+	result["k8s-rds-database"] = db.Name
+	return result
+}
+
+// desiredServer maps the spec to a server, without the password
+func desiredServer(db *crd.Database, env *Environment) (*server, error) {
+	if db.Spec.Version == "" {
+		return nil, fmt.Errorf("the azure provider needs the version of the engine")
+	}
+	if resourceGroup(db, env) == "" {
+		return nil, fmt.Errorf("the azure provider needs a resource group, use --azure-resource-group or spec.azure.resourceGroup")
+	}
+	s, err := skuOf(db.Spec.Class)
+	if err != nil {
+		return nil, err
+	}
+	ha := "Disabled"
+	if db.Spec.MultiAZ {
+		if s.Tier == "Burstable" {
+			return nil, fmt.Errorf("zone redundant high availability isn't available with the burstable SKU %v", s.Name)
+		}
+		ha = "ZoneRedundant"
+	}
+
+	result := &server{
+		Location: env.Location,
+		SKU:      s,
+		Tags:     tags(db),
+		Properties: &serverProperties{
+			AdministratorLogin: db.Spec.Username,
+			Version:            version(db.Spec.Engine, db.Spec.Version),
+			Storage:            &storage{StorageSizeGB: storageSize(db.Spec.Engine, db.Spec.Size), AutoGrow: "Disabled"},
+			Backup:             &backup{BackupRetentionDays: backupRetentionDays(db.Spec.BackupRetentionPeriod)},
+			HighAvailability:   &highAvailability{Mode: ha},
+			Network:            &network{PublicNetworkAccess: "Disabled"},
+			CreateMode:         "Default",
+		},
+	}
+	if db.Spec.MaxAllocatedSize > db.Spec.Size {
+		result.Properties.Storage.AutoGrow = "Enabled"
+	}
+
+	spec := db.Spec.Azure
+	if spec == nil {
+		spec = &crd.AzureSpec{}
+	}
+	switch {
+	case spec.DelegatedSubnetID != "":
+		if spec.PrivateEndpointSubnetID != "" {
+			return nil, fmt.Errorf("a server in a delegated subnet can't have a private endpoint")
+		}
+		result.Properties.Network.DelegatedSubnetResourceID = spec.DelegatedSubnetID
+		result.Properties.Network.PrivateDNSZoneArmResourceID = spec.PrivateDNSZoneID
+		// it's set by Azure for servers in a delegated subnet
+		result.Properties.Network.PublicNetworkAccess = ""
+	case spec.PrivateEndpointSubnetID != "":
+	case db.Spec.PubliclyAccessible:
+		result.Properties.Network.PublicNetworkAccess = "Enabled"
+	default:
+		return nil, fmt.Errorf("the server needs spec.azure.delegatedSubnetID, spec.azure.privateEndpointSubnetID or publicaccess")
+	}
+	return result, nil
+}
+
+// serverChanges returns a patch of the server settings that differ from the desired ones, or nil when there's
+// nothing to change. The storage can only grow, and the version and network can't be changed.
+func serverChanges(desired, current *server) *server {
+	patch := &server{Properties: &serverProperties{}}
+	changed := false
+	if current.SKU == nil || *desired.SKU != *current.SKU {
+		patch.SKU = desired.SKU
+		changed = true
+	}
+	if !reflect.DeepEqual(desired.Tags, current.Tags) {
+		patch.Tags = desired.Tags
+		changed = true
+	}
+	d, c := desired.Properties, current.Properties
+	if c.Storage == nil || d.Storage.StorageSizeGB > c.Storage.StorageSizeGB || d.Storage.AutoGrow != c.Storage.AutoGrow {
+		patch.Properties.Storage = &storage{AutoGrow: d.Storage.AutoGrow}
+		if c.Storage == nil || d.Storage.StorageSizeGB > c.Storage.StorageSizeGB {
+			patch.Properties.Storage.StorageSizeGB = d.Storage.StorageSizeGB
+		}
+		changed = true
+	}
+	if c.Backup == nil || *d.Backup != *c.Backup {
+		patch.Properties.Backup = d.Backup
+		changed = true
+	}
+	if c.HighAvailability == nil || d.HighAvailability.Mode != c.HighAvailability.Mode {
+		patch.Properties.HighAvailability = d.HighAvailability
+		changed = true
+	}
+	if !changed {
+		return nil
+	}
+	return patch
+}
@@ -0,0 +1,97 @@
+package azure
+
+import (
+	"testing"
+
+	"github.com/sorenmat/k8s-rds/crd"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSKU(t *testing.T) {
+	s, err := skuOf("db.t3.micro")
+	assert.NoError(t, err)
+	assert.Equal(t, &sku{Name: "Standard_B1ms", Tier: "Burstable"}, s)
+	s, err = skuOf("Standard_D4ds_v5")
+	assert.NoError(t, err)
+	assert.Equal(t, "GeneralPurpose", s.Tier)
+	_, err = skuOf("db.m5.large")
+	assert.Error(t, err)
+	_, err = skuOf("Standard_L8s_v3")
+	assert.Error(t, err)
+}
+
+func TestVersion(t *testing.T) {
+	assert.Equal(t, "14", version("postgres", "14.4"))
+	assert.Equal(t, "16", version("postgres", "16"))
+	assert.Equal(t, "8.0", version("mysql", "8.0.31"))
+	assert.Equal(t, "5.7", version("mysql", "5.7"))
+}
+
+func TestStorageSize(t *testing.T) {
+	assert.Equal(t, int64(32), storageSize("postgres", 20))
+	assert.Equal(t, int64(128), storageSize("postgres", 100))
+	assert.Equal(t, int64(32767), storageSize("postgres", 64000))
+	assert.Equal(t, int64(100), storageSize("mysql", 100))
+}
+
+func TestBackupRetentionDays(t *testing.T) {
+	assert.Equal(t, int64(7), backupRetentionDays(0))
+	assert.Equal(t, int64(14), backupRetentionDays(14))
+	assert.Equal(t, int64(35), backupRetentionDays(40))
+}
+
+func TestDesiredServer(t *testing.T) {
+	env := &Environment{ResourceGroup: "team-db", Location: "westeurope"}
+	db := testDatabase()
+	db.Spec.Tags = crd.Tags{"team": "checkout"}
+	db.Spec.MaxAllocatedSize = 100
+	s, err := desiredServer(db, env)
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{"team": "checkout", "managed-by": "k8s-rds", "k8s-rds-namespace": "shop", "k8s-rds-database": "orders"}, s.Tags)
+	assert.Equal(t, "Enabled", s.Properties.Storage.AutoGrow)
+	assert.Equal(t, "", s.Properties.Network.PublicNetworkAccess)
+
+	db.Spec.Azure = nil
+	_, err = desiredServer(db, env)
+	assert.Error(t, err, "a network is needed")
+	db.Spec.PubliclyAccessible = true
+	s, err = desiredServer(db, env)
+	assert.NoError(t, err)
+	assert.Equal(t, "Enabled", s.Properties.Network.PublicNetworkAccess)
+
+	db.Spec.Class = "db.t3.micro"
+	_, err = desiredServer(db, env)
+	assert.Error(t, err, "burstable servers can't be zone redundant")
+
+	db.Spec.Class = "Standard_D2ds_v4"
+	_, err = desiredServer(db, &Environment{})
+	assert.Error(t, err, "a resource group is needed")
+	db.Spec.Azure = &crd.AzureSpec{ResourceGroup: "team-a"}
+	assert.Equal(t, "team-a", resourceGroup(db, env))
+}
+
+func TestServerChanges(t *testing.T) {
+	env := &Environment{ResourceGroup: "team-db", Location: "westeurope"}
+	db := testDatabase()
+	desired, err := desiredServer(db, env)
+	assert.NoError(t, err)
+	current := *desired
+	properties := *desired.Properties
+	properties.Storage = &storage{StorageSizeGB: 64, AutoGrow: "Disabled"}
+	current.Properties = &properties
+	assert.Nil(t, serverChanges(desired, &current), "the storage doesn't shrink")
+
+	db.Spec.Size = 100
+	db.Spec.MultiAZ = false
+	db.Spec.BackupRetentionPeriod = 30
+	desired, err = desiredServer(db, env)
+	assert.NoError(t, err)
+	patch := serverChanges(desired, &current)
+	assert.Nil(t, patch.SKU)
+	assert.Nil(t, patch.Tags)
+	assert.Equal(t, &serverProperties{
+		Storage:          &storage{StorageSizeGB: 128, AutoGrow: "Disabled"},
+		Backup:           &backup{BackupRetentionDays: 30},
+		HighAvailability: &highAvailability{Mode: "Disabled"},
+	}, patch.Properties)
+}
@@ -2,6 +2,9 @@ package crd
 
 import (
 	"context"
+	"encoding/json"
+	"strings"
+
 	v1 "k8s.io/api/core/v1"
 	apiextv1beta1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1beta1"
 	apiextcs "k8s.io/apiextensions-apiserver/pkg/client/clientset/clientset"
@@ -14,13 +17,42 @@ import (
 )
 
 const (
-	CRDPlural          string = "databases"
-	CRDGroup           string = "k8s.io"
-	CRDVersion         string = "v1"
-	FullCRDName        string = "databases." + CRDGroup
-	StorageTypePattern string = `gp2|io1`
-	DBNamePattern      string = "^[A-Za-z]\\w+$"
-	DBUsernamePattern  string = "^[A-Za-z]\\w+$"
+	CRDPlural              string = "databases"
+	CRDGroup               string = "k8s.io"
+	CRDVersion             string = "v1"
+	FullCRDName            string = "databases." + CRDGroup
+	StorageTypePattern     string = `^(standard|gp2|gp3|io1|io2)$`
+	DBNamePattern          string = "^[A-Za-z]\\w+$"
+	DBUsernamePattern      string = "^[A-Za-z]\\w+$"
+	SubnetIDPattern        string = "^subnet-[0-9a-f]+$"
+	SecurityGroupIDPattern string = "^sg-[0-9a-f]+$"
+	RoleARNPattern         string = "^arn:aws[a-z-]*:iam::[0-9]{12}:role/.+$"
+	KMSKeyIDPattern        string = "^(arn:aws[a-z-]*:kms:[a-z0-9-]+:[0-9]{12}:(key|alias)/.+|alias/.+|[0-9a-f-]{36}|mrk-[0-9a-f]{32})$"
+	InstanceIDPattern      string = "^[A-Za-z][A-Za-z0-9-]{0,62}$"
+	AzureSubnetIDPattern   string = "^/subscriptions/[^/]+/resourceGroups/[^/]+/providers/Microsoft.Network/virtualNetworks/[^/]+/subnets/[^/]+$"
+
+	BackupWindowPattern      string = "^([01][0-9]|2[0-3]):[0-5][0-9]-([01][0-9]|2[0-3]):[0-5][0-9]$"
+	MaintenanceWindowPattern string = "^(mon|tue|wed|thu|fri|sat|sun):([01][0-9]|2[0-3]):[0-5][0-9]-(mon|tue|wed|thu|fri|sat|sun):([01][0-9]|2[0-3]):[0-5][0-9]$"
+)
+
+// drift policies, Report only sets the Drifted condition and Revert also modifies the instance back to the spec
+const (
+	DriftPolicyReport string = "Report"
+	DriftPolicyRevert string = "Revert"
+
+	// ConditionDrifted is true when the instance differs from the spec
+	ConditionDrifted string = "Drifted"
+	// ConditionReady is true when the database accepts connections, according to its provider
+	ConditionReady string = "Ready"
+	// ConditionKMSKeyMismatch is true when the storage is encrypted with another key than the one in the spec
+	ConditionKMSKeyMismatch string = "KMSKeyMismatch"
+)
+
+// pool modes of the connection pooler
+const (
+	PoolModeSession     string = "session"
+	PoolModeTransaction string = "transaction"
+	PoolModeStatement   string = "statement"
 )
 
 func intptr(x int64) *int64 {
@@ -101,7 +133,7 @@ func NewDatabaseCRD() *apiextv1beta1.CustomResourceDefinition {
 								},
 								"storagetype": {
 									Type:        "string",
-									Description: "gp2 (General Purpose SSD) or io1 (Provisioned IOPS SSD)",
+									Description: "standard (Magnetic), gp2 or gp3 (General Purpose SSD), io1 or io2 (Provisioned IOPS SSD)",
 									Pattern:     StorageTypePattern,
 								},
 								"iops": {
@@ -110,6 +142,12 @@ func NewDatabaseCRD() *apiextv1beta1.CustomResourceDefinition {
 									Minimum:     floatptr(1000),
 									Maximum:     floatptr(80000),
 								},
+								"storagethroughput": {
+									Type:        "integer",
+									Description: "Storage throughput in MiB/s, only for gp3",
+									Minimum:     floatptr(125),
+									Maximum:     floatptr(4000),
+								},
 								"backupretentionperiod": {
 									Type:        "integer",
 									Description: "Retention period in days. 0 means disabled, 7 is the default and 35 is the maximum",
@@ -121,8 +159,273 @@ func NewDatabaseCRD() *apiextv1beta1.CustomResourceDefinition {
 									Description: "Enable or disable deletion protection",
 								},
 								"tags": {
+									Description: "Tags of the database instance, either as a map or in the format key=value,key1=value1",
+									AnyOf: []apiextv1beta1.JSONSchemaProps{
+										{Type: "string"},
+										{
+											Type: "object",
+											AdditionalProperties: &apiextv1beta1.JSONSchemaPropsOrBool{
+												Allows: true,
+												Schema: &apiextv1beta1.JSONSchemaProps{Type: "string"},
+											},
+										},
+									},
+								},
+								"maintenancewindow": {
+									Type:        "string",
+									Description: "Weekly window for system maintenance in UTC, ex: sun:03:00-sun:04:00",
+									Pattern:     MaintenanceWindowPattern,
+								},
+								"schedule": {
+									Type:        "object",
+									Description: "Hours the database runs, it's stopped the rest of the time",
+									Required:    []string{"start", "stop"},
+									Properties: map[string]apiextv1beta1.JSONSchemaProps{
+										"start": {
+											Type:        "string",
+											Description: "Cron expression of when the database starts, ex: 0 8 * * 1-5",
+											MinLength:   intptr(9),
+										},
+										"stop": {
+											Type:        "string",
+											Description: "Cron expression of when the database stops, ex: 0 20 * * 1-5",
+											MinLength:   intptr(9),
+										},
+										"timezone": {
+											Type:        "string",
+											Description: "Time zone of the cron expressions, ex: Europe/Copenhagen. Default is UTC",
+										},
+									},
+								},
+								"pooler": {
+									Type:        "object",
+									Description: "PgBouncer connection pooler deployed in front of a postgres database",
+									Properties: map[string]apiextv1beta1.JSONSchemaProps{
+										"mode": {
+											Type:        "string",
+											Description: "When a server connection is released to the pool. Default is transaction",
+											Enum:        []apiextv1beta1.JSON{{Raw: []byte(`"session"`)}, {Raw: []byte(`"transaction"`)}, {Raw: []byte(`"statement"`)}},
+										},
+										"poolSize": {
+											Type:        "integer",
+											Description: "Server connections per user and database. Default is 20",
+											Minimum:     floatptr(1),
+										},
+										"maxClients": {
+											Type:        "integer",
+											Description: "Maximum number of client connections. Default is 100",
+											Minimum:     floatptr(1),
+										},
+									},
+								},
+								"backupwindow": {
 									Type:        "string",
-									Description: "Tags to create on the database instance format key=value,key1=value1",
+									Description: "Daily window for the automated backups in UTC, ex: 01:00-01:30. Must not overlap the maintenance window",
+									Pattern:     BackupWindowPattern,
+								},
+								"autominorversionupgrade": {
+									Type:        "boolean",
+									Description: "Apply minor engine upgrades automatically in the maintenance window, enabled when not set",
+								},
+								"copytagstosnapshot": {
+									Type:        "boolean",
+									Description: "Copy the tags of the database to its snapshots",
+								},
+								"azure": {
+									Type:        "object",
+									Description: "Settings only used by the azure provider",
+									Properties: map[string]apiextv1beta1.JSONSchemaProps{
+										"resourceGroup": {
+											Type:        "string",
+											Description: "Resource group of the server, default is the one of --azure-resource-group",
+											MinLength:   intptr(1),
+											MaxLength:   intptr(90),
+										},
+										"delegatedSubnetID": {
+											Type:        "string",
+											Description: "Subnet delegated to the flexible servers, the server gets a private IP in it",
+											Pattern:     AzureSubnetIDPattern,
+										},
+										"privateDNSZoneID": {
+											Type:        "string",
+											Description: "Private DNS zone resolving the name of a server in a delegated subnet",
+										},
+										"privateEndpointSubnetID": {
+											Type:        "string",
+											Description: "Subnet of a private endpoint to the server, public access is disabled",
+											Pattern:     AzureSubnetIDPattern,
+										},
+									},
+								},
+								"aws": {
+									Type:        "object",
+									Description: "Settings only used by the aws provider",
+									Properties: map[string]apiextv1beta1.JSONSchemaProps{
+										"instanceIdentifier": {
+											Type:        "string",
+											Description: "Identifier of the RDS instance, default is <name>-<namespace>",
+											Pattern:     InstanceIDPattern,
+										},
+										"adopt": {
+											Type:        "boolean",
+											Description: "Import an existing instance instead of creating it, it's only modified once the k8s-rds.io/adopt annotation is set to confirmed",
+										},
+										"subnetIDs": {
+											Type:        "array",
+											Description: "Subnets to place the database in, overrides the subnets discovered from the nodes",
+											Items:       &apiextv1beta1.JSONSchemaPropsOrArray{Schema: &apiextv1beta1.JSONSchemaProps{Type: "string", Pattern: SubnetIDPattern}},
+										},
+										"subnetSelector": {
+											Type:        "object",
+											Description: "Tags used to select the subnets for the database, ex: tier: database",
+											AdditionalProperties: &apiextv1beta1.JSONSchemaPropsOrBool{
+												Allows: true,
+												Schema: &apiextv1beta1.JSONSchemaProps{Type: "string"},
+											},
+										},
+										"securityGroupIDs": {
+											Type:        "array",
+											Description: "Security groups to attach to the database, overrides the security groups of the nodes",
+											Items:       &apiextv1beta1.JSONSchemaPropsOrArray{Schema: &apiextv1beta1.JSONSchemaProps{Type: "string", Pattern: SecurityGroupIDPattern}},
+										},
+										"createSecurityGroup": {
+											Type:        "boolean",
+											Description: "Create a security group that only allows the nodes to connect on the engine port",
+										},
+										"roleARN": {
+											Type:        "string",
+											Description: "IAM role to assume for creating the database, it must be allowed by the k8s-rds.io/aws-allowed-role-arns annotation of the namespace",
+											Pattern:     RoleARNPattern,
+										},
+										"externalID": {
+											Type:        "string",
+											Description: "External ID to use when assuming the role",
+										},
+										"managedPassword": {
+											Type:        "boolean",
+											Description: "Let RDS generate and rotate the master password in Secrets Manager, it's copied into the password secret",
+										},
+										"passwordRotationDays": {
+											Type:        "integer",
+											Description: "Number of days between the rotations of the managed password",
+											Minimum:     floatptr(1),
+											Maximum:     floatptr(1000),
+										},
+										"driftPolicy": {
+											Type:        "string",
+											Description: "What to do when the instance differs from the spec, Report (default) or Revert",
+											Enum:        []apiextv1beta1.JSON{{Raw: []byte(`"Report"`)}, {Raw: []byte(`"Revert"`)}},
+										},
+										"kmsKeyID": {
+											Type:        "string",
+											Description: "KMS key to encrypt the storage with, as key ID, key ARN, alias name or alias ARN. Turns on encryption",
+											Pattern:     KMSKeyIDPattern,
+										},
+										"proxy": {
+											Type:        "object",
+											Description: "RDS Proxy pooling the connections to the database",
+											Properties: map[string]apiextv1beta1.JSONSchemaProps{
+												"roleARN": {
+													Type:        "string",
+													Description: "Role the proxy reads the password secret with, created by the operator when empty",
+													Pattern:     RoleARNPattern,
+												},
+												"requireTLS": {
+													Type:        "boolean",
+													Description: "Only allow TLS connections to the proxy",
+												},
+												"idleClientTimeout": {
+													Type:        "integer",
+													Description: "Seconds before an idle client connection is closed, default is 1800",
+													Minimum:     floatptr(1),
+													Maximum:     floatptr(28800),
+												},
+												"maxConnectionsPercent": {
+													Type:        "integer",
+													Description: "Share of max_connections the proxy can use, default is 100",
+													Minimum:     floatptr(1),
+													Maximum:     floatptr(100),
+												},
+												"replaceService": {
+													Type:        "boolean",
+													Description: "Point the service of the database at the proxy instead of creating a <name>-proxy service",
+												},
+											},
+										},
+										"monitoring": {
+											Type:        "object",
+											Description: "Performance Insights, enhanced monitoring and CloudWatch log exports",
+											Properties: map[string]apiextv1beta1.JSONSchemaProps{
+												"performanceInsights": {
+													Type:        "boolean",
+													Description: "Enable Performance Insights",
+												},
+												"performanceInsightsRetentionDays": {
+													Type:        "integer",
+													Description: "Days to keep the Performance Insights data: 7, 731 or a multiple of 31",
+													Minimum:     floatptr(7),
+													Maximum:     floatptr(731),
+												},
+												"performanceInsightsKMSKeyID": {
+													Type:        "string",
+													Description: "KMS key to encrypt the Performance Insights data with, it can't be changed once enabled",
+													Pattern:     KMSKeyIDPattern,
+												},
+												"interval": {
+													Type:        "integer",
+													Description: "Seconds between the enhanced monitoring metrics, 0 disables enhanced monitoring",
+													Enum:        []apiextv1beta1.JSON{{Raw: []byte("0")}, {Raw: []byte("1")}, {Raw: []byte("5")}, {Raw: []byte("10")}, {Raw: []byte("15")}, {Raw: []byte("30")}, {Raw: []byte("60")}},
+												},
+												"roleARN": {
+													Type:        "string",
+													Description: "Role RDS uses to send the enhanced monitoring metrics, a role managed by the operator when not set",
+													Pattern:     RoleARNPattern,
+												},
+												"logExports": {
+													Type:        "array",
+													Description: "Logs to export to CloudWatch Logs, ex: postgresql, upgrade, error, slowquery",
+													Items: &apiextv1beta1.JSONSchemaPropsOrArray{Schema: &apiextv1beta1.JSONSchemaProps{
+														Type: "string",
+														Enum: []apiextv1beta1.JSON{{Raw: []byte(`"postgresql"`)}, {Raw: []byte(`"upgrade"`)}, {Raw: []byte(`"error"`)}, {Raw: []byte(`"slowquery"`)}, {Raw: []byte(`"general"`)}, {Raw: []byte(`"audit"`)}},
+													}},
+												},
+											},
+										},
+										"iamAuthentication": {
+											Type:        "boolean",
+											Description: "Allow connecting to the database with IAM authentication tokens",
+										},
+										"iamUsers": {
+											Type:        "array",
+											Description: "Database users the IAM roles are allowed to connect as with an authentication token",
+											Items: &apiextv1beta1.JSONSchemaPropsOrArray{Schema: &apiextv1beta1.JSONSchemaProps{
+												Type:     "object",
+												Required: []string{"user", "roleARNs"},
+												Properties: map[string]apiextv1beta1.JSONSchemaProps{
+													"user": {
+														Type:        "string",
+														Description: "Database user to connect as",
+														MinLength:   intptr(1),
+														Pattern:     DBUsernamePattern,
+													},
+													"roleARNs": {
+														Type:        "array",
+														Description: "IAM roles of the service accounts, ex: the IRSA role of the application",
+														MinItems:    intptr(1),
+														Items:       &apiextv1beta1.JSONSchemaPropsOrArray{Schema: &apiextv1beta1.JSONSchemaProps{Type: "string", Pattern: RoleARNPattern}},
+													},
+												},
+											}},
+										},
+									},
+								},
+								"parameters": {
+									Type:        "object",
+									Description: "Engine parameters to set on the database, ex: max_connections: \"200\"",
+									AdditionalProperties: &apiextv1beta1.JSONSchemaPropsOrBool{
+										Allows: true,
+										Schema: &apiextv1beta1.JSONSchemaProps{Type: "string"},
+									},
 								},
 							},
 						},
@@ -167,16 +470,179 @@ type DatabaseSpec struct {
 	StorageEncrypted      bool                 `json:"encrypted,omitempty"`
 	StorageType           string               `json:"storagetype,omitempty"`
 	Iops                  int64                `json:"iops,omitempty"`
+	StorageThroughput     int64                `json:"storagethroughput,omitempty"`     // MiB/s, gp3 only
 	BackupRetentionPeriod int64                `json:"backupretentionperiod,omitempty"` // between 0 and 35, zero means disable
 	DeleteProtection      bool                 `json:"deleteprotection,omitempty"`
-	Tags                  string               `json:"tags,omitempty"`     // key=value,key1=value1
-	Provider              string               `json:"provider,omitempty"` // local or aws
+	Tags                  Tags                 `json:"tags,omitempty"`       // map or key=value,key1=value1
+	Provider              string               `json:"provider,omitempty"`   // local or aws
+	Parameters            map[string]string    `json:"parameters,omitempty"` // engine parameters like max_connections
+	AWS                   *AWSSpec             `json:"aws,omitempty"`
+	Azure                 *AzureSpec           `json:"azure,omitempty"`
+
+	MaintenanceWindow       string `json:"maintenancewindow,omitempty"`       // ddd:hh24:mi-ddd:hh24:mi in UTC
+	BackupWindow            string `json:"backupwindow,omitempty"`            // hh24:mi-hh24:mi in UTC
+	AutoMinorVersionUpgrade *bool  `json:"autominorversionupgrade,omitempty"` // nil keeps the default of the provider
+	CopyTagsToSnapshot      bool   `json:"copytagstosnapshot,omitempty"`
+
+	Schedule *ScheduleSpec `json:"schedule,omitempty"` // runs the database on a schedule, it's stopped the rest of the time
+
+	Pooler *PoolerSpec `json:"pooler,omitempty"` // PgBouncer in front of the database
+}
+
+// PoolerSpec holds the settings of the PgBouncer connection pooler
+type PoolerSpec struct {
+	Mode       string `json:"mode,omitempty"`       // session, transaction or statement
+	PoolSize   int64  `json:"poolSize,omitempty"`   // server connections per user and database
+	MaxClients int64  `json:"maxClients,omitempty"` // client connections
+}
+
+// ScheduleSpec holds the hours the database runs, as cron expressions of when it starts and stops
+type ScheduleSpec struct {
+	Start    string `json:"start"`              // ex: "0 8 * * 1-5"
+	Stop     string `json:"stop"`               // ex: "0 20 * * 1-5"
+	Timezone string `json:"timezone,omitempty"` // IANA time zone, default is UTC
+}
 
+// Tags are the tags of the database, they can be written as a map or as a string in the format key=value,key1=value1
+type Tags map[string]string
+
+// UnmarshalJSON accepts both formats of the tags
+func (t *Tags) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err == nil {
+		*t = ParseTags(s)
+		return nil
+	}
+	var m map[string]string
+	if err := json.Unmarshal(b, &m); err != nil {
+		return err
+	}
+	*t = m
+	return nil
+}
+
+// ParseTags parses tags in the format key=value,key1=value1, entries without a value are skipped
+func ParseTags(s string) Tags {
+	tags := Tags{}
+	for _, v := range strings.Split(s, ",") {
+		kv := strings.SplitN(v, "=", 2)
+		if len(kv) != 2 || strings.TrimSpace(kv[0]) == "" {
+			continue
+		}
+		tags[strings.TrimSpace(kv[0])] = strings.TrimSpace(kv[1])
+	}
+	return tags
+}
+
+// AWSSpec holds the settings that are only used by the aws provider
+type AWSSpec struct {
+	InstanceIdentifier   string            `json:"instanceIdentifier,omitempty"` // defaults to <name>-<namespace>
+	Adopt                bool              `json:"adopt,omitempty"`              // import an existing instance
+	SubnetIDs            []string          `json:"subnetIDs,omitempty"`
+	SubnetSelector       map[string]string `json:"subnetSelector,omitempty"` // tags the subnets must have
+	SecurityGroupIDs     []string          `json:"securityGroupIDs,omitempty"`
+	CreateSecurityGroup  bool              `json:"createSecurityGroup,omitempty"` // only allow the nodes on the engine port
+	RoleARN              string            `json:"roleARN,omitempty"`             // role to assume, must be allowed by the namespace
+	ExternalID           string            `json:"externalID,omitempty"`
+	ManagedPassword      bool              `json:"managedPassword,omitempty"` // password is generated and rotated by RDS
+	PasswordRotationDays int64             `json:"passwordRotationDays,omitempty"`
+	KMSKeyID             string            `json:"kmsKeyID,omitempty"` // customer managed key for the storage encryption
+	Monitoring           *MonitoringSpec   `json:"monitoring,omitempty"`
+	IAMAuthentication    bool              `json:"iamAuthentication,omitempty"`
+	IAMUsers             []IAMUser         `json:"iamUsers,omitempty"` // roles allowed to connect with rds-db:connect
+	DriftPolicy          string            `json:"driftPolicy,omitempty"`
+	Proxy                *ProxySpec        `json:"proxy,omitempty"`
+}
+
+// AzureSpec holds the settings that are only used by the azure provider
+type AzureSpec struct {
+	ResourceGroup           string `json:"resourceGroup,omitempty"`
+	DelegatedSubnetID       string `json:"delegatedSubnetID,omitempty"` // VNet integration
+	PrivateDNSZoneID        string `json:"privateDNSZoneID,omitempty"`
+	PrivateEndpointSubnetID string `json:"privateEndpointSubnetID,omitempty"`
+}
+
+// ProxySpec holds the settings of the RDS Proxy in front of the instance
+type ProxySpec struct {
+	RoleARN               string `json:"roleARN,omitempty"` // role reading the password secret, created by the operator when empty
+	RequireTLS            bool   `json:"requireTLS,omitempty"`
+	IdleClientTimeout     int64  `json:"idleClientTimeout,omitempty"`     // seconds
+	MaxConnectionsPercent int64  `json:"maxConnectionsPercent,omitempty"` // of max_connections
+	ReplaceService        bool   `json:"replaceService,omitempty"`        // the service of the database points at the proxy
+}
+
+// MonitoringSpec holds the observability settings of an RDS instance
+type MonitoringSpec struct {
+	PerformanceInsights              bool     `json:"performanceInsights,omitempty"`
+	PerformanceInsightsRetentionDays int64    `json:"performanceInsightsRetentionDays,omitempty"` // 7 days when not set
+	PerformanceInsightsKMSKeyID      string   `json:"performanceInsightsKMSKeyID,omitempty"`
+	Interval                         int64    `json:"interval,omitempty"` // enhanced monitoring interval in seconds
+	RoleARN                          string   `json:"roleARN,omitempty"`  // monitoring role, managed by the operator when empty
+	LogExports                       []string `json:"logExports,omitempty"`
+}
+
+// IAMUser grants IAM roles access to a database user with IAM authentication tokens
+type IAMUser struct {
+	User     string   `json:"user"`
+	RoleARNs []string `json:"roleARNs"`
 }
 
 type DatabaseStatus struct {
-	State   string `json:"state,omitempty" description:"State of the deploy"`
-	Message string `json:"message,omitempty" description:"Detailed message around the state"`
+	State          string `json:"state,omitempty" description:"State of the deploy"`
+	Message        string `json:"message,omitempty" description:"Detailed message around the state"`
+	ParameterGroup string `json:"parametergroup,omitempty" description:"Name of the parameter group managed for the database"`
+	PendingReboot  bool   `json:"pendingreboot,omitempty" description:"Parameter changes are waiting for a reboot of the database"`
+
+	PasswordSecretARN    string        `json:"passwordsecretarn,omitempty" description:"Secrets Manager secret holding the managed master password"`
+	PasswordSecretStatus string        `json:"passwordsecretstatus,omitempty" description:"Status of the managed master password secret"`
+	PasswordLastRotated  *meta_v1.Time `json:"passwordlastrotated,omitempty" description:"Last time the master password was rotated"`
+	PasswordHash         string        `json:"passwordhash,omitempty" description:"Keyed hash of the password last applied to the database"`
+
+	ResourceID string   `json:"resourceid,omitempty" description:"Resource ID of the database, used in the ARN of IAM authentication tokens"`
+	IAMRoles   []string `json:"iamroles,omitempty" description:"IAM roles the connect policy is attached to"`
+	KMSKeyID   string   `json:"kmskeyid,omitempty" description:"ARN of the KMS key the storage is encrypted with"`
+
+	EngineVersion   string `json:"engineversion,omitempty" description:"Engine version the database is running"`
+	UpgradeState    string `json:"upgradestate,omitempty" description:"Progress of the engine version upgrade: Snapshotting, Upgrading, Upgraded or Failed"`
+	UpgradeTarget   string `json:"upgradetarget,omitempty" description:"Version requested by the last upgrade"`
+	UpgradeSnapshot string `json:"upgradesnapshot,omitempty" description:"Snapshot taken before the major version upgrade"`
+	UpgradeMessage  string `json:"upgrademessage,omitempty" description:"Detailed message around the upgrade state"`
+
+	StorageModified         *meta_v1.Time `json:"storagemodified,omitempty" description:"Last time the storage was modified"`
+	NextStorageModification *meta_v1.Time `json:"nextstoragemodification,omitempty" description:"Storage changes are postponed until this time"`
+	StorageMessage          string        `json:"storagemessage,omitempty" description:"Detailed message around the storage changes"`
+
+	AppliedTags []string `json:"appliedtags,omitempty" description:"Keys of the tags the operator applied to the instance"`
+
+	Adoption string           `json:"adoption,omitempty" description:"Pending until the takeover of an adopted instance is confirmed, then Confirmed"`
+	Drift    []DriftedField   `json:"drift,omitempty" description:"Settings of the instance that differ from the spec"`
+	Applied  *AppliedSettings `json:"applied,omitempty" description:"Settings of the spec last applied to the instance"`
+
+	Conditions []meta_v1.Condition `json:"conditions,omitempty" description:"Latest observations of the database"`
+
+	Stopped         bool          `json:"stopped,omitempty" description:"The database is stopped"`
+	StoppedSince    *meta_v1.Time `json:"stoppedsince,omitempty" description:"When the database was stopped by the schedule"`
+	ScheduleMessage string        `json:"schedulemessage,omitempty" description:"Last action taken by the schedule"`
+
+	ProxyStatus   string `json:"proxystatus,omitempty" description:"Status of the RDS Proxy"`
+	ProxyEndpoint string `json:"proxyendpoint,omitempty" description:"Endpoint of the RDS Proxy"`
+}
+
+// AppliedSettings are the settings of the spec last applied to the instance. A spec that differs from them was
+// edited and is applied, an instance that differs from them was changed outside of the operator.
+type AppliedSettings struct {
+	Class                 string `json:"class,omitempty"`
+	MultiAZ               bool   `json:"multiaz,omitempty"`
+	PubliclyAccessible    bool   `json:"publicaccess,omitempty"`
+	BackupRetentionPeriod int64  `json:"backupretentionperiod,omitempty"`
+	DeleteProtection      bool   `json:"deleteprotection,omitempty"`
+}
+
+// DriftedField is a setting of the instance that differs from the spec
+type DriftedField struct {
+	Field   string `json:"field"`
+	Desired string `json:"desired"`
+	Actual  string `json:"actual"`
 }
 
 type DatabaseList struct {
@@ -1,6 +1,7 @@
 package crd
 
 import (
+	"encoding/json"
 	"fmt"
 	"io/ioutil"
 	"testing"
@@ -273,7 +274,7 @@ func TestInvalidStorageType(t *testing.T) {
 			Size:                  65,
 			MaxAllocatedSize:      65,
 			StorageEncrypted:      true,
-			StorageType:           "io2",
+			StorageType:           "gp1",
 			Username:              "dbuser",
 		},
 	}
@@ -343,3 +344,409 @@ func TestIopsTooBig(t *testing.T) {
 	assert.NoError(t, err)
 	assert.False(t, result.Valid(), result.Errors())
 }
+
+func TestParametersAreValid(t *testing.T) {
+	d := Database{
+		ObjectMeta: meta_v1.ObjectMeta{Name: "my_db", Namespace: "default"},
+		TypeMeta:   meta_v1.TypeMeta{Kind: "Database", APIVersion: "k8s.io/v1"},
+		Spec: DatabaseSpec{
+			Class:            "db.t2.micro",
+			DBName:           "database_name",
+			Engine:           "postgres",
+			Password:         v1.SecretKeySelector{Key: "key", LocalObjectReference: v1.LocalObjectReference{Name: "DB-Secret"}},
+			Size:             65,
+			MaxAllocatedSize: 65,
+			Username:         "dbuser",
+			Parameters: map[string]string{
+				"max_connections":          "200",
+				"shared_preload_libraries": "pg_stat_statements",
+			},
+		},
+	}
+
+	loader := gojsonschema.NewGoLoader(NewDatabaseCRD().Spec.Validation.OpenAPIV3Schema)
+	documentLoader := gojsonschema.NewGoLoader(d)
+
+	result, err := gojsonschema.Validate(loader, documentLoader)
+	assert.NoError(t, err)
+	assert.True(t, result.Valid(), result.Errors())
+}
+
+func TestAWSNetworkSettings(t *testing.T) {
+	d := Database{
+		ObjectMeta: meta_v1.ObjectMeta{Name: "my_db", Namespace: "default"},
+		TypeMeta:   meta_v1.TypeMeta{Kind: "Database", APIVersion: "k8s.io/v1"},
+		Spec: DatabaseSpec{
+			Class:            "db.t2.micro",
+			DBName:           "database_name",
+			Engine:           "postgres",
+			Password:         v1.SecretKeySelector{Key: "key", LocalObjectReference: v1.LocalObjectReference{Name: "DB-Secret"}},
+			Size:             65,
+			MaxAllocatedSize: 65,
+			Username:         "dbuser",
+			AWS: &AWSSpec{
+				SubnetIDs:           []string{"subnet-0a1b2c3d", "subnet-4e5f6a7b"},
+				SecurityGroupIDs:    []string{"sg-0123abcd"},
+				CreateSecurityGroup: true,
+			},
+		},
+	}
+
+	loader := gojsonschema.NewGoLoader(NewDatabaseCRD().Spec.Validation.OpenAPIV3Schema)
+	result, err := gojsonschema.Validate(loader, gojsonschema.NewGoLoader(d))
+	assert.NoError(t, err)
+	assert.True(t, result.Valid(), result.Errors())
+
+	d.Spec.AWS.SecurityGroupIDs = []string{"my-group"}
+	result, err = gojsonschema.Validate(loader, gojsonschema.NewGoLoader(d))
+	assert.NoError(t, err)
+	assert.False(t, result.Valid(), result.Errors())
+}
+
+func TestIAMUsers(t *testing.T) {
+	d := Database{
+		ObjectMeta: meta_v1.ObjectMeta{Name: "my_db", Namespace: "default"},
+		TypeMeta:   meta_v1.TypeMeta{Kind: "Database", APIVersion: "k8s.io/v1"},
+		Spec: DatabaseSpec{
+			Class:            "db.t2.micro",
+			DBName:           "database_name",
+			Engine:           "postgres",
+			Password:         v1.SecretKeySelector{Key: "key", LocalObjectReference: v1.LocalObjectReference{Name: "DB-Secret"}},
+			Size:             65,
+			MaxAllocatedSize: 65,
+			Username:         "dbuser",
+			AWS: &AWSSpec{
+				IAMAuthentication: true,
+				IAMUsers:          []IAMUser{{User: "app", RoleARNs: []string{"arn:aws:iam::123456789012:role/app"}}},
+			},
+		},
+	}
+
+	loader := gojsonschema.NewGoLoader(NewDatabaseCRD().Spec.Validation.OpenAPIV3Schema)
+	result, err := gojsonschema.Validate(loader, gojsonschema.NewGoLoader(d))
+	assert.NoError(t, err)
+	assert.True(t, result.Valid(), result.Errors())
+
+	d.Spec.AWS.IAMUsers[0].RoleARNs = []string{"app"}
+	result, err = gojsonschema.Validate(loader, gojsonschema.NewGoLoader(d))
+	assert.NoError(t, err)
+	assert.False(t, result.Valid(), result.Errors())
+}
+
+func TestKMSKeyID(t *testing.T) {
+	d := Database{
+		ObjectMeta: meta_v1.ObjectMeta{Name: "my_db", Namespace: "default"},
+		TypeMeta:   meta_v1.TypeMeta{Kind: "Database", APIVersion: "k8s.io/v1"},
+		Spec: DatabaseSpec{
+			Class:            "db.t2.micro",
+			DBName:           "database_name",
+			Engine:           "postgres",
+			Password:         v1.SecretKeySelector{Key: "key", LocalObjectReference: v1.LocalObjectReference{Name: "DB-Secret"}},
+			Size:             65,
+			MaxAllocatedSize: 65,
+			Username:         "dbuser",
+			AWS:              &AWSSpec{},
+		},
+	}
+
+	loader := gojsonschema.NewGoLoader(NewDatabaseCRD().Spec.Validation.OpenAPIV3Schema)
+	for _, key := range []string{
+		"1234abcd-12ab-34cd-56ef-1234567890ab",
+		"arn:aws:kms:eu-west-1:123456789012:key/1234abcd-12ab-34cd-56ef-1234567890ab",
+		"alias/tenant-a",
+		"arn:aws:kms:eu-west-1:123456789012:alias/tenant-a",
+		"mrk-1234abcd12ab34cd56ef1234567890ab",
+	} {
+		d.Spec.AWS.KMSKeyID = key
+		result, err := gojsonschema.Validate(loader, gojsonschema.NewGoLoader(d))
+		assert.NoError(t, err)
+		assert.True(t, result.Valid(), key, result.Errors())
+	}
+
+	d.Spec.AWS.KMSKeyID = "tenant-a"
+	result, err := gojsonschema.Validate(loader, gojsonschema.NewGoLoader(d))
+	assert.NoError(t, err)
+	assert.False(t, result.Valid(), result.Errors())
+}
+
+func TestWindows(t *testing.T) {
+	d := Database{
+		ObjectMeta: meta_v1.ObjectMeta{Name: "my_db", Namespace: "default"},
+		TypeMeta:   meta_v1.TypeMeta{Kind: "Database", APIVersion: "k8s.io/v1"},
+		Spec: DatabaseSpec{
+			Class:             "db.t2.micro",
+			DBName:            "database_name",
+			Engine:            "postgres",
+			Password:          v1.SecretKeySelector{Key: "key", LocalObjectReference: v1.LocalObjectReference{Name: "DB-Secret"}},
+			Size:              65,
+			MaxAllocatedSize:  65,
+			Username:          "dbuser",
+			MaintenanceWindow: "sun:03:00-sun:04:00",
+			BackupWindow:      "01:00-01:30",
+		},
+	}
+
+	loader := gojsonschema.NewGoLoader(NewDatabaseCRD().Spec.Validation.OpenAPIV3Schema)
+	result, err := gojsonschema.Validate(loader, gojsonschema.NewGoLoader(d))
+	assert.NoError(t, err)
+	assert.True(t, result.Valid(), result.Errors())
+
+	d.Spec.MaintenanceWindow = "sunday 03:00"
+	result, err = gojsonschema.Validate(loader, gojsonschema.NewGoLoader(d))
+	assert.NoError(t, err)
+	assert.False(t, result.Valid(), result.Errors())
+
+	d.Spec.MaintenanceWindow = ""
+	d.Spec.BackupWindow = "1:00-1:30"
+	result, err = gojsonschema.Validate(loader, gojsonschema.NewGoLoader(d))
+	assert.NoError(t, err)
+	assert.False(t, result.Valid(), result.Errors())
+}
+
+func TestMonitoring(t *testing.T) {
+	d := Database{
+		ObjectMeta: meta_v1.ObjectMeta{Name: "my_db", Namespace: "default"},
+		TypeMeta:   meta_v1.TypeMeta{Kind: "Database", APIVersion: "k8s.io/v1"},
+		Spec: DatabaseSpec{
+			Class:            "db.t2.micro",
+			DBName:           "database_name",
+			Engine:           "postgres",
+			Password:         v1.SecretKeySelector{Key: "key", LocalObjectReference: v1.LocalObjectReference{Name: "DB-Secret"}},
+			Size:             65,
+			MaxAllocatedSize: 65,
+			Username:         "dbuser",
+			AWS: &AWSSpec{Monitoring: &MonitoringSpec{
+				PerformanceInsights:              true,
+				PerformanceInsightsRetentionDays: 7,
+				Interval:                         60,
+				LogExports:                       []string{"postgresql", "upgrade"},
+			}},
+		},
+	}
+
+	loader := gojsonschema.NewGoLoader(NewDatabaseCRD().Spec.Validation.OpenAPIV3Schema)
+	result, err := gojsonschema.Validate(loader, gojsonschema.NewGoLoader(d))
+	assert.NoError(t, err)
+	assert.True(t, result.Valid(), result.Errors())
+
+	d.Spec.AWS.Monitoring.Interval = 20
+	result, err = gojsonschema.Validate(loader, gojsonschema.NewGoLoader(d))
+	assert.NoError(t, err)
+	assert.False(t, result.Valid(), result.Errors())
+
+	d.Spec.AWS.Monitoring.Interval = 60
+	d.Spec.AWS.Monitoring.LogExports = []string{"everything"}
+	result, err = gojsonschema.Validate(loader, gojsonschema.NewGoLoader(d))
+	assert.NoError(t, err)
+	assert.False(t, result.Valid(), result.Errors())
+}
+
+func TestStorageTypes(t *testing.T) {
+	d := Database{
+		ObjectMeta: meta_v1.ObjectMeta{Name: "my_db", Namespace: "default"},
+		TypeMeta:   meta_v1.TypeMeta{Kind: "Database", APIVersion: "k8s.io/v1"},
+		Spec: DatabaseSpec{
+			Class:             "db.t2.micro",
+			DBName:            "database_name",
+			Engine:            "postgres",
+			Password:          v1.SecretKeySelector{Key: "key", LocalObjectReference: v1.LocalObjectReference{Name: "DB-Secret"}},
+			Size:              400,
+			MaxAllocatedSize:  1000,
+			Username:          "dbuser",
+			StorageThroughput: 500,
+		},
+	}
+
+	loader := gojsonschema.NewGoLoader(NewDatabaseCRD().Spec.Validation.OpenAPIV3Schema)
+	for _, storageType := range []string{"standard", "gp2", "gp3", "io1", "io2"} {
+		d.Spec.StorageType = storageType
+		result, err := gojsonschema.Validate(loader, gojsonschema.NewGoLoader(d))
+		assert.NoError(t, err)
+		assert.True(t, result.Valid(), storageType, result.Errors())
+	}
+
+	d.Spec.StorageType = "gp3"
+	d.Spec.StorageThroughput = 100
+	result, err := gojsonschema.Validate(loader, gojsonschema.NewGoLoader(d))
+	assert.NoError(t, err)
+	assert.False(t, result.Valid(), result.Errors())
+}
+
+func TestTagsFormats(t *testing.T) {
+	var spec DatabaseSpec
+	assert.NoError(t, json.Unmarshal([]byte(`{"tags": "key=value, key1 = value1,broken,url=a=b"}`), &spec))
+	assert.Equal(t, Tags{"key": "value", "key1": "value1", "url": "a=b"}, spec.Tags)
+
+	assert.NoError(t, json.Unmarshal([]byte(`{"tags": {"key": "value"}}`), &spec))
+	assert.Equal(t, Tags{"key": "value"}, spec.Tags)
+
+	assert.Error(t, json.Unmarshal([]byte(`{"tags": 42}`), &spec))
+
+	loader := gojsonschema.NewGoLoader(NewDatabaseCRD().Spec.Validation.OpenAPIV3Schema)
+	for _, doc := range []string{
+		`{"spec": {"tags": "key=value,key1=value1"}}`,
+		`{"spec": {"tags": {"key": "value", "key1": "value1"}}}`,
+	} {
+		result, err := gojsonschema.Validate(loader, gojsonschema.NewStringLoader(doc))
+		assert.NoError(t, err)
+		assert.True(t, result.Valid(), doc, result.Errors())
+	}
+	result, err := gojsonschema.Validate(loader, gojsonschema.NewStringLoader(`{"spec": {"tags": ["key"]}}`))
+	assert.NoError(t, err)
+	assert.False(t, result.Valid(), result.Errors())
+}
+
+func TestInstanceIdentifier(t *testing.T) {
+	d := Database{
+		ObjectMeta: meta_v1.ObjectMeta{Name: "my_db", Namespace: "default"},
+		TypeMeta:   meta_v1.TypeMeta{Kind: "Database", APIVersion: "k8s.io/v1"},
+		Spec: DatabaseSpec{
+			Class:            "db.t2.micro",
+			DBName:           "database_name",
+			Engine:           "postgres",
+			Password:         v1.SecretKeySelector{Key: "key", LocalObjectReference: v1.LocalObjectReference{Name: "DB-Secret"}},
+			Size:             65,
+			MaxAllocatedSize: 65,
+			Username:         "dbuser",
+			AWS:              &AWSSpec{InstanceIdentifier: "legacy-orders-db", Adopt: true},
+		},
+	}
+
+	loader := gojsonschema.NewGoLoader(NewDatabaseCRD().Spec.Validation.OpenAPIV3Schema)
+	result, err := gojsonschema.Validate(loader, gojsonschema.NewGoLoader(d))
+	assert.NoError(t, err)
+	assert.True(t, result.Valid(), result.Errors())
+
+	d.Spec.AWS.InstanceIdentifier = "1-orders"
+	result, err = gojsonschema.Validate(loader, gojsonschema.NewGoLoader(d))
+	assert.NoError(t, err)
+	assert.False(t, result.Valid(), result.Errors())
+}
+
+func TestDriftPolicy(t *testing.T) {
+	d := Database{
+		ObjectMeta: meta_v1.ObjectMeta{Name: "my_db", Namespace: "default"},
+		TypeMeta:   meta_v1.TypeMeta{Kind: "Database", APIVersion: "k8s.io/v1"},
+		Spec: DatabaseSpec{
+			Class:            "db.t2.micro",
+			DBName:           "database_name",
+			Engine:           "postgres",
+			Password:         v1.SecretKeySelector{Key: "key", LocalObjectReference: v1.LocalObjectReference{Name: "DB-Secret"}},
+			Size:             65,
+			MaxAllocatedSize: 65,
+			Username:         "dbuser",
+			AWS:              &AWSSpec{},
+		},
+	}
+
+	loader := gojsonschema.NewGoLoader(NewDatabaseCRD().Spec.Validation.OpenAPIV3Schema)
+	for _, policy := range []string{DriftPolicyReport, DriftPolicyRevert} {
+		d.Spec.AWS.DriftPolicy = policy
+		result, err := gojsonschema.Validate(loader, gojsonschema.NewGoLoader(d))
+		assert.NoError(t, err)
+		assert.True(t, result.Valid(), policy, result.Errors())
+	}
+
+	d.Spec.AWS.DriftPolicy = "Ignore"
+	result, err := gojsonschema.Validate(loader, gojsonschema.NewGoLoader(d))
+	assert.NoError(t, err)
+	assert.False(t, result.Valid(), result.Errors())
+}
+
+func TestProxy(t *testing.T) {
+	d := Database{
+		ObjectMeta: meta_v1.ObjectMeta{Name: "my_db", Namespace: "default"},
+		TypeMeta:   meta_v1.TypeMeta{Kind: "Database", APIVersion: "k8s.io/v1"},
+		Spec: DatabaseSpec{
+			Class:            "db.t2.micro",
+			DBName:           "database_name",
+			Engine:           "postgres",
+			Password:         v1.SecretKeySelector{Key: "key", LocalObjectReference: v1.LocalObjectReference{Name: "DB-Secret"}},
+			Size:             65,
+			MaxAllocatedSize: 65,
+			Username:         "dbuser",
+			AWS: &AWSSpec{Proxy: &ProxySpec{
+				RoleARN:               "arn:aws:iam::123456789012:role/proxy",
+				RequireTLS:            true,
+				IdleClientTimeout:     600,
+				MaxConnectionsPercent: 50,
+				ReplaceService:        true,
+			}},
+		},
+	}
+
+	loader := gojsonschema.NewGoLoader(NewDatabaseCRD().Spec.Validation.OpenAPIV3Schema)
+	result, err := gojsonschema.Validate(loader, gojsonschema.NewGoLoader(d))
+	assert.NoError(t, err)
+	assert.True(t, result.Valid(), result.Errors())
+
+	d.Spec.AWS.Proxy.MaxConnectionsPercent = 150
+	result, err = gojsonschema.Validate(loader, gojsonschema.NewGoLoader(d))
+	assert.NoError(t, err)
+	assert.False(t, result.Valid(), result.Errors())
+}
+
+func TestPooler(t *testing.T) {
+	d := Database{
+		ObjectMeta: meta_v1.ObjectMeta{Name: "my_db", Namespace: "default"},
+		TypeMeta:   meta_v1.TypeMeta{Kind: "Database", APIVersion: "k8s.io/v1"},
+		Spec: DatabaseSpec{
+			Class:            "db.t2.micro",
+			DBName:           "database_name",
+			Engine:           "postgres",
+			Password:         v1.SecretKeySelector{Key: "key", LocalObjectReference: v1.LocalObjectReference{Name: "DB-Secret"}},
+			Size:             65,
+			MaxAllocatedSize: 65,
+			Username:         "dbuser",
+			Pooler:           &PoolerSpec{PoolSize: 10, MaxClients: 200},
+		},
+	}
+
+	loader := gojsonschema.NewGoLoader(NewDatabaseCRD().Spec.Validation.OpenAPIV3Schema)
+	for _, mode := range []string{"", PoolModeSession, PoolModeTransaction, PoolModeStatement} {
+		d.Spec.Pooler.Mode = mode
+		result, err := gojsonschema.Validate(loader, gojsonschema.NewGoLoader(d))
+		assert.NoError(t, err)
+		assert.True(t, result.Valid(), mode, result.Errors())
+	}
+
+	d.Spec.Pooler.Mode = "query"
+	result, err := gojsonschema.Validate(loader, gojsonschema.NewGoLoader(d))
+	assert.NoError(t, err)
+	assert.False(t, result.Valid(), result.Errors())
+
+	d.Spec.Pooler = &PoolerSpec{PoolSize: -1}
+	result, err = gojsonschema.Validate(loader, gojsonschema.NewGoLoader(d))
+	assert.NoError(t, err)
+	assert.False(t, result.Valid(), result.Errors())
+}
+
+func TestAzureSpec(t *testing.T) {
+	d := Database{
+		ObjectMeta: meta_v1.ObjectMeta{Name: "my_db", Namespace: "default"},
+		TypeMeta:   meta_v1.TypeMeta{Kind: "Database", APIVersion: "k8s.io/v1"},
+		Spec: DatabaseSpec{
+			Class:            "Standard_D2ds_v4",
+			DBName:           "database_name",
+			Engine:           "postgres",
+			Password:         v1.SecretKeySelector{Key: "key", LocalObjectReference: v1.LocalObjectReference{Name: "DB-Secret"}},
+			Size:             65,
+			MaxAllocatedSize: 65,
+			Username:         "dbuser",
+			Azure: &AzureSpec{
+				ResourceGroup:     "databases",
+				DelegatedSubnetID: "/subscriptions/0000/resourceGroups/network/providers/Microsoft.Network/virtualNetworks/aks/subnets/databases",
+			},
+		},
+	}
+
+	loader := gojsonschema.NewGoLoader(NewDatabaseCRD().Spec.Validation.OpenAPIV3Schema)
+	result, err := gojsonschema.Validate(loader, gojsonschema.NewGoLoader(d))
+	assert.NoError(t, err)
+	assert.True(t, result.Valid(), result.Errors())
+
+	d.Spec.Azure.PrivateEndpointSubnetID = "subnet-0a1b2c3d"
+	result, err = gojsonschema.Validate(loader, gojsonschema.NewGoLoader(d))
+	assert.NoError(t, err)
+	assert.False(t, result.Valid(), result.Errors())
+}
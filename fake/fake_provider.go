@@ -0,0 +1,213 @@
+// Package fake simulates a database provider in memory, so the operator and the applications using its databases can
+// be tested without a cloud account or a database pod
+package fake
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/sorenmat/k8s-rds/crd"
+	"github.com/sorenmat/k8s-rds/kube"
+	"github.com/sorenmat/k8s-rds/provider"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+const (
+	// DelayAnnotation on a database overrides how long its operations take, ex: 30s
+	DelayAnnotation = "k8s-rds.io/fake-delay"
+	// FailAnnotation on a database lists the operations that fail, ex: create,delete
+	FailAnnotation = "k8s-rds.io/fake-fail"
+)
+
+// Operations that can be made to fail
+const (
+	OperationCreate   = "create"
+	OperationUpdate   = "update"
+	OperationPassword = "password"
+	OperationDelete   = "delete"
+)
+
+// Options are the defaults of the databases without annotations
+type Options struct {
+	Delay time.Duration // how long the instances stay creating, modifying or deleting
+	Fail  []string      // operations that fail
+}
+
+type Fake struct {
+	kc    kubernetes.Interface
+	store *Store
+	delay time.Duration
+	fail  []string
+	port  int32
+}
+
+// New returns the provider keeping the instances in the store
+func New(db *crd.Database, kc kubernetes.Interface, store *Store, options Options) (*Fake, error) {
+	f := &Fake{kc: kc, store: store, delay: options.Delay, fail: options.Fail, port: 5432}
+	if v, ok := db.Annotations[DelayAnnotation]; ok {
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			return nil, errors.Wrap(err, fmt.Sprintf("invalid %v annotation", DelayAnnotation))
+		}
+		f.delay = d
+	}
+	if v, ok := db.Annotations[FailAnnotation]; ok {
+		f.fail = strings.Split(v, ",")
+	}
+	if strings.HasPrefix(db.Spec.Engine, "mysql") || strings.HasPrefix(db.Spec.Engine, "mariadb") {
+		f.port = 3306
+	}
+	return f, nil
+}
+
+func hostname(db *crd.Database) string {
+	return fmt.Sprintf("%v.%v.fake.k8s-rds.local", db.Name, db.Namespace)
+}
+
+// fails tells if the operation is set to fail
+func (f *Fake) fails(operation string) bool {
+	for _, o := range f.fail {
+		if strings.TrimSpace(o) == operation {
+			return true
+		}
+	}
+	return false
+}
+
+// copySpec returns a copy of the spec that doesn't share its maps and slices with the database
+func copySpec(spec crd.DatabaseSpec) crd.DatabaseSpec {
+	var result crd.DatabaseSpec
+	b, _ := json.Marshal(spec)
+	_ = json.Unmarshal(b, &result)
+	return result
+}
+
+// Ensure starts the creation of the instance, or the update when the spec changed, and returns its status without
+// waiting for the operation. The failure of an operation is returned by the first call that sees it, the next one
+// creates a failed instance again.
+func (f *Fake) Ensure(ctx context.Context, db *crd.Database) (*provider.ProviderStatus, error) {
+	i, ok := f.store.Get(db.Namespace, db.Name)
+	switch {
+	case !ok || (i.State == StateFailed && i.Error == ""):
+		if err := f.create(ctx, db); err != nil {
+			return nil, err
+		}
+	case i.State == StateAvailable && i.Error == "":
+		f.update(db, i)
+	}
+	if i, ok := f.store.Get(db.Namespace, db.Name); ok && i.Error != "" {
+		f.store.update(db.Namespace, db.Name, func(i *Instance) { i.Error = "" })
+		return nil, errors.New(i.Error)
+	}
+	return f.Describe(ctx, db)
+}
+
+func (f *Fake) create(ctx context.Context, db *crd.Database) error {
+	password, err := f.GetSecret(ctx, db.Namespace, db.Spec.Password.Name, db.Spec.Password.Key)
+	if err != nil {
+		return err
+	}
+	log.Printf("creating fake database %v in %v\n", db.Name, db.Namespace)
+	f.store.update(db.Namespace, db.Name, func(i *Instance) {
+		i.State = StateCreating
+		i.Hostname = hostname(db)
+		i.Password = password
+		i.Created = time.Now()
+		i.Until = i.Created.Add(f.delay)
+		i.Pending = copySpec(db.Spec)
+		i.Fails = f.fails(OperationCreate)
+	})
+	return nil
+}
+
+func (f *Fake) update(db *crd.Database, i Instance) {
+	spec := copySpec(db.Spec)
+	if reflect.DeepEqual(i.Spec, spec) {
+		return
+	}
+	log.Printf("updating fake database %v in %v\n", db.Name, db.Namespace)
+	f.store.update(db.Namespace, db.Name, func(i *Instance) {
+		i.State = StateModifying
+		i.Until = time.Now().Add(f.delay)
+		i.Pending = spec
+		i.Fails = f.fails(OperationUpdate)
+	})
+}
+
+// Describe returns the status of the instance
+func (f *Fake) Describe(ctx context.Context, db *crd.Database) (*provider.ProviderStatus, error) {
+	i, ok := f.store.Get(db.Namespace, db.Name)
+	if !ok {
+		return &provider.ProviderStatus{}, nil
+	}
+	return &provider.ProviderStatus{
+		Exists:        true,
+		Ready:         i.State == StateAvailable || i.State == StateModifying,
+		State:         i.State,
+		Hostname:      i.Hostname,
+		Port:          f.port,
+		EngineVersion: i.Spec.Version,
+	}, nil
+}
+
+// UpdatePassword sets the new password of the instance, it's done right away
+func (f *Fake) UpdatePassword(ctx context.Context, db *crd.Database, password string) error {
+	if _, ok := f.store.Get(db.Namespace, db.Name); !ok {
+		return fmt.Errorf("fake database %v doesn't exist", db.Name)
+	}
+	if f.fails(OperationPassword) {
+		return fmt.Errorf("fake %v of %v failed", OperationPassword, db.Name)
+	}
+	f.store.update(db.Namespace, db.Name, func(i *Instance) { i.Password = password })
+	return nil
+}
+
+// Delete starts the deletion of the instance, unless it's deletion protected. The instance is gone once the delay
+// is over.
+func (f *Fake) Delete(ctx context.Context, db *crd.Database) error {
+	if db.Spec.DeleteProtection {
+		log.Printf("Trying to delete a %v in %v which is a deleted protected database", db.Name, db.Namespace)
+		return nil
+	}
+	if _, ok := f.store.Get(db.Namespace, db.Name); !ok {
+		return nil
+	}
+	if f.fails(OperationDelete) {
+		return fmt.Errorf("fake %v of %v failed", OperationDelete, db.Name)
+	}
+	log.Printf("deleting fake database %v in %v\n", db.Name, db.Namespace)
+	f.store.update(db.Namespace, db.Name, func(i *Instance) {
+		i.State = StateDeleting
+		i.Until = time.Now().Add(f.delay)
+	})
+	return nil
+}
+
+// CreateService creates or updates a service pointing at the hostname of the instance
+func (f *Fake) CreateService(ctx context.Context, namespace string, hostname string, internalname string) error {
+	return kube.CreateAddressService(ctx, f.kc, namespace, hostname, internalname, f.port)
+}
+
+func (f *Fake) DeleteService(ctx context.Context, namespace string, dbname string) error {
+	err := kube.DeleteAddressService(ctx, f.kc, namespace, dbname)
+	if err != nil {
+		log.Println(err)
+		return errors.Wrap(err, fmt.Sprintf("delete of service %v failed in namespace %v", dbname, namespace))
+	}
+	return nil
+}
+
+func (f *Fake) GetSecret(ctx context.Context, namespace string, name string, key string) (string, error) {
+	secret, err := f.kc.CoreV1().Secrets(namespace).Get(ctx, name, metav1.GetOptions{})
+	if err != nil {
+		return "", errors.Wrap(err, fmt.Sprintf("unable to fetch secret %v", name))
+	}
+	return string(secret.Data[key]), nil
+}
@@ -0,0 +1,166 @@
+package fake
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/sorenmat/k8s-rds/crd"
+	"github.com/sorenmat/k8s-rds/provider"
+	"github.com/stretchr/testify/assert"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	kubefake "k8s.io/client-go/kubernetes/fake"
+)
+
+func testDatabase() *crd.Database {
+	return &crd.Database{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "shop", Name: "orders"},
+		Spec: crd.DatabaseSpec{
+			Engine:   "postgres",
+			Version:  "14.4",
+			Class:    "db.t3.micro",
+			Size:     20,
+			Username: "app",
+			DBName:   "orders",
+			Password: v1.SecretKeySelector{LocalObjectReference: v1.LocalObjectReference{Name: "orders"}, Key: "password"},
+			Tags:     crd.Tags{"team": "checkout"},
+		},
+	}
+}
+
+func testClient() *kubefake.Clientset {
+	return kubefake.NewSimpleClientset(&v1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "shop", Name: "orders"},
+		Data:       map[string][]byte{"password": []byte("secret")},
+	})
+}
+
+func TestFake(t *testing.T) {
+	ctx := context.Background()
+	kc := testClient()
+	store := NewStore()
+	db := testDatabase()
+	f, err := New(db, kc, store, Options{Delay: 50 * time.Millisecond})
+	assert.NoError(t, err)
+
+	// the creation isn't waited for
+	status, err := f.Ensure(ctx, db)
+	assert.NoError(t, err)
+	assert.Equal(t, &provider.ProviderStatus{Exists: true, State: StateCreating, Hostname: "orders.shop.fake.k8s-rds.local", Port: 5432}, status)
+	assert.Eventually(t, func() bool {
+		status, err = f.Ensure(ctx, db)
+		return err == nil && status.State == StateAvailable
+	}, time.Second, 5*time.Millisecond)
+	assert.Equal(t, &provider.ProviderStatus{Exists: true, Ready: true, State: StateAvailable, Hostname: "orders.shop.fake.k8s-rds.local", Port: 5432, EngineVersion: "14.4"}, status)
+	hostname := status.Hostname
+	i, _ := store.Get("shop", "orders")
+	assert.Equal(t, "secret", i.Password)
+	assert.Equal(t, "db.t3.micro", i.Spec.Class)
+
+	// the stored spec doesn't change with the database
+	db.Spec.Tags["team"] = "payments"
+	i, _ = store.Get("shop", "orders")
+	assert.Equal(t, "checkout", i.Spec.Tags["team"])
+	db.Spec.Tags["team"] = "checkout"
+
+	db.Spec.Class = "db.m5.large"
+	db.Spec.Version = "14.7"
+	status, err = f.Ensure(ctx, db)
+	assert.NoError(t, err)
+	assert.Equal(t, StateModifying, status.State)
+	assert.True(t, status.Ready)
+	assert.Equal(t, "14.4", status.EngineVersion)
+	assert.Eventually(t, func() bool {
+		status, err = f.Ensure(ctx, db)
+		return err == nil && status.State == StateAvailable
+	}, time.Second, 5*time.Millisecond)
+	i, _ = store.Get("shop", "orders")
+	assert.Equal(t, "db.m5.large", i.Spec.Class)
+	assert.Equal(t, "14.7", status.EngineVersion)
+	assert.Equal(t, "orders.shop.fake.k8s-rds.local", status.Hostname)
+	assert.Len(t, store.List(), 1)
+
+	assert.NoError(t, f.UpdatePassword(ctx, db, "new-secret"))
+	i, _ = store.Get("shop", "orders")
+	assert.Equal(t, "new-secret", i.Password)
+
+	assert.NoError(t, f.CreateService(ctx, "shop", hostname, "orders"))
+	svc, err := kc.CoreV1().Services("shop").Get(ctx, "orders", metav1.GetOptions{})
+	assert.NoError(t, err)
+	assert.Equal(t, hostname, svc.Spec.ExternalName)
+	assert.Equal(t, int32(5432), svc.Spec.Ports[0].Port)
+
+	db.Spec.DeleteProtection = true
+	assert.NoError(t, f.Delete(ctx, db))
+	assert.Len(t, store.List(), 1)
+	db.Spec.DeleteProtection = false
+	assert.NoError(t, f.Delete(ctx, db))
+	status, err = f.Describe(ctx, db)
+	assert.NoError(t, err)
+	assert.Equal(t, StateDeleting, status.State)
+	assert.Eventually(t, func() bool { return len(store.List()) == 0 }, time.Second, 5*time.Millisecond)
+	status, err = f.Describe(ctx, db)
+	assert.NoError(t, err)
+	assert.False(t, status.Exists)
+	// it's gone already
+	assert.NoError(t, f.Delete(ctx, db))
+}
+
+func TestFakeFailures(t *testing.T) {
+	ctx := context.Background()
+	store := NewStore()
+	db := testDatabase()
+	db.Annotations = map[string]string{FailAnnotation: "create, delete"}
+	f, err := New(db, testClient(), store, Options{Delay: time.Hour})
+	assert.NoError(t, err)
+	assert.Equal(t, time.Hour, f.delay)
+
+	db.Annotations[DelayAnnotation] = "0s"
+	f, err = New(db, testClient(), store, Options{Delay: time.Hour})
+	assert.NoError(t, err)
+	_, err = f.Ensure(ctx, db)
+	assert.Error(t, err)
+	i, _ := store.Get("shop", "orders")
+	assert.Equal(t, StateFailed, i.State)
+
+	// the failed instance is created again
+	db.Annotations[FailAnnotation] = "delete,update"
+	f, err = New(db, testClient(), store, Options{})
+	assert.NoError(t, err)
+	status, err := f.Ensure(ctx, db)
+	assert.NoError(t, err)
+	assert.Equal(t, StateAvailable, status.State)
+	assert.Error(t, f.Delete(ctx, db))
+	i, _ = store.Get("shop", "orders")
+	assert.Equal(t, StateAvailable, i.State)
+
+	// a failed update keeps the previous spec, the failure is reported once
+	db.Spec.Class = "db.m5.large"
+	_, err = f.Ensure(ctx, db)
+	assert.Error(t, err)
+	i, _ = store.Get("shop", "orders")
+	assert.Equal(t, "db.t3.micro", i.Spec.Class)
+	assert.Equal(t, StateAvailable, i.State)
+
+	// a failure after the delay is reported by the first call that sees it
+	db = testDatabase()
+	db.Annotations = map[string]string{FailAnnotation: "create"}
+	store = NewStore()
+	f, err = New(db, testClient(), store, Options{Delay: 20 * time.Millisecond})
+	assert.NoError(t, err)
+	status, err = f.Ensure(ctx, db)
+	assert.NoError(t, err)
+	assert.Equal(t, StateCreating, status.State)
+	assert.Eventually(t, func() bool {
+		_, err = f.Ensure(ctx, db)
+		return err != nil
+	}, time.Second, 5*time.Millisecond)
+	status, err = f.Ensure(ctx, db)
+	assert.NoError(t, err)
+	assert.Equal(t, StateCreating, status.State, "the next call creates it again")
+
+	db.Annotations[DelayAnnotation] = "soon"
+	_, err = New(db, testClient(), store, Options{})
+	assert.Error(t, err)
+}
@@ -0,0 +1,124 @@
+package fake
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/sorenmat/k8s-rds/crd"
+)
+
+// States of the instances
+const (
+	StateCreating  = "creating"
+	StateAvailable = "available"
+	StateModifying = "modifying"
+	StateDeleting  = "deleting"
+	StateFailed    = "failed"
+)
+
+// Instance is a database of the fake provider
+type Instance struct {
+	Namespace string
+	Name      string
+	State     string
+	Hostname  string
+	Spec      crd.DatabaseSpec
+	Password  string
+	Created   time.Time
+
+	// the operation in progress (creating, modifying or deleting) is done at Until, and applies Pending unless it fails
+	Until   time.Time
+	Pending crd.DatabaseSpec
+	Fails   bool
+	// Error is the failure of the last operation, until it's reported
+	Error string
+}
+
+// Store keeps the instances in memory, it's shared by the providers of the operator so the instances outlive the
+// events they were created for
+type Store struct {
+	lock      sync.Mutex
+	instances map[string]*Instance
+}
+
+func NewStore() *Store {
+	return &Store{instances: map[string]*Instance{}}
+}
+
+func key(namespace, name string) string {
+	return namespace + "/" + name
+}
+
+// finish completes the operation in progress of the instance once its time is up, it must be called under the lock
+func (s *Store) finish(i *Instance, now time.Time) {
+	if now.Before(i.Until) {
+		return
+	}
+	switch i.State {
+	case StateCreating:
+		i.State = StateAvailable
+		i.Spec = i.Pending
+		if i.Fails {
+			i.State = StateFailed
+			i.Error = fmt.Sprintf("fake %v of %v failed", OperationCreate, i.Name)
+		}
+	case StateModifying:
+		// a failed update keeps the previous spec
+		i.State = StateAvailable
+		if i.Fails {
+			i.Error = fmt.Sprintf("fake %v of %v failed", OperationUpdate, i.Name)
+		} else {
+			i.Spec = i.Pending
+		}
+	case StateDeleting:
+		delete(s.instances, key(i.Namespace, i.Name))
+	}
+}
+
+// Get returns a copy of the instance
+func (s *Store) Get(namespace, name string) (Instance, bool) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	i, ok := s.instances[key(namespace, name)]
+	if !ok {
+		return Instance{}, false
+	}
+	s.finish(i, time.Now())
+	if _, ok := s.instances[key(namespace, name)]; !ok {
+		return Instance{}, false
+	}
+	return *i, true
+}
+
+// List returns copies of the instances, sorted by namespace and name
+func (s *Store) List() []Instance {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	for _, i := range s.instances {
+		s.finish(i, time.Now())
+	}
+	result := make([]Instance, 0, len(s.instances))
+	for _, i := range s.instances {
+		result = append(result, *i)
+	}
+	sort.Slice(result, func(a, b int) bool {
+		return key(result[a].Namespace, result[a].Name) < key(result[b].Namespace, result[b].Name)
+	})
+	return result
+}
+
+// update changes the instance under the lock, it's created when it doesn't exist. An operation started without a
+// delay is done right away.
+func (s *Store) update(namespace, name string, f func(*Instance)) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	i, ok := s.instances[key(namespace, name)]
+	if !ok {
+		i = &Instance{Namespace: namespace, Name: name}
+		s.instances[key(namespace, name)] = i
+	}
+	f(i)
+	s.finish(i, time.Now())
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"text/tabwriter"
+
+	"github.com/sorenmat/k8s-rds/client"
+	"github.com/sorenmat/k8s-rds/crd"
+	"github.com/sorenmat/k8s-rds/kube"
+	"github.com/sorenmat/k8s-rds/provider"
+	"github.com/sorenmat/k8s-rds/rds"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// keepFunc returns a function telling if the resources of the Database with the namespace and name must be kept,
+// the resources in namespaces the operator doesn't watch are always kept
+func keepFunc(databases []crd.Database, excludeNamespaces, includeNamespaces []string) func(namespace, name string) bool {
+	existing := map[string]bool{}
+	for _, db := range databases {
+		existing[db.Namespace+"/"+db.Name] = true
+	}
+	return func(namespace, name string) bool {
+		if len(excludeNamespaces) > 0 && stringInSlice(namespace, excludeNamespaces) {
+			return true
+		}
+		if len(includeNamespaces) > 0 && !stringInSlice(namespace, includeNamespaces) {
+			return true
+		}
+		return existing[namespace+"/"+name]
+	}
+}
+
+// gc lists the resources left behind by deleted databases, and deletes them unless it's a dry run
+func gc(dbprovider string, excludeNamespaces, includeNamespaces []string, dryRun bool) error {
+	ctx := context.Background()
+	config, err := getClientConfig(kube.Config())
+	if err != nil {
+		return err
+	}
+	crdcs, scheme, err := crd.NewClient(config)
+	if err != nil {
+		return err
+	}
+	databases, err := client.CrdClient(crdcs, scheme, "").List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return fmt.Errorf("unable to list databases: %v", err)
+	}
+	keep := keepFunc(databases.Items, excludeNamespaces, includeNamespaces)
+
+	kubectl, err := getKubectl()
+	if err != nil {
+		return err
+	}
+	orphans, err := kube.Orphans(ctx, kubectl, keep)
+	if err != nil {
+		return err
+	}
+	if dbprovider == "aws" {
+		env, err := getAWSEnvironment(ctx, kubectl)
+		if err != nil {
+			return err
+		}
+		awsOrphans, err := rds.Orphans(ctx, env, keep)
+		if err != nil {
+			return err
+		}
+		orphans = append(orphans, awsOrphans...)
+	}
+
+	printOrphans(orphans)
+	if dryRun {
+		if len(orphans) > 0 {
+			fmt.Println("\nThis was a dry run, use --dry-run=false to delete them")
+		}
+		return nil
+	}
+	failed := 0
+	for _, o := range orphans {
+		if err := o.Delete(ctx); err != nil {
+			log.Printf("unable to delete %v %v: %v\n", o.Kind, o.Name, err)
+			failed++
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("%v of %v orphans couldn't be deleted", failed, len(orphans))
+	}
+	return nil
+}
+
+func printOrphans(orphans []provider.Orphan) {
+	if len(orphans) == 0 {
+		fmt.Println("No orphans found")
+		return
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "KIND\tNAMESPACE\tNAME")
+	for _, o := range orphans {
+		fmt.Fprintf(w, "%v\t%v\t%v\n", o.Kind, o.Namespace, o.Name)
+	}
+	w.Flush()
+}
@@ -0,0 +1,251 @@
+package gcp
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// pollInterval is how often the operations are checked
+var pollInterval = 10 * time.Second
+
+// the resources of the Cloud SQL Admin API v1, only the fields the provider uses
+
+type instance struct {
+	Name            string      `json:"name,omitempty"`
+	DatabaseVersion string      `json:"databaseVersion,omitempty"`
+	Region          string      `json:"region,omitempty"`
+	State           string      `json:"state,omitempty"` // RUNNABLE when it's up
+	IPAddresses     []ipMapping `json:"ipAddresses,omitempty"`
+	Settings        *settings   `json:"settings,omitempty"`
+}
+
+type ipMapping struct {
+	Type      string `json:"type"` // PRIMARY is the public IP, PRIVATE the one in the VPC
+	IPAddress string `json:"ipAddress"`
+}
+
+type settings struct {
+	Tier                      string               `json:"tier,omitempty"`
+	DataDiskSizeGb            int64                `json:"dataDiskSizeGb,omitempty,string"`
+	DataDiskType              string               `json:"dataDiskType,omitempty"`
+	StorageAutoResize         *bool                `json:"storageAutoResize,omitempty"` // enabled when not set
+	StorageAutoResizeLimit    int64                `json:"storageAutoResizeLimit,omitempty,string"`
+	AvailabilityType          string               `json:"availabilityType,omitempty"`
+	BackupConfiguration       *backupConfiguration `json:"backupConfiguration,omitempty"`
+	IPConfiguration           *ipConfiguration     `json:"ipConfiguration,omitempty"`
+	MaintenanceWindow         *maintenanceWindow   `json:"maintenanceWindow,omitempty"`
+	DatabaseFlags             []databaseFlag       `json:"databaseFlags,omitempty"`
+	UserLabels                map[string]string    `json:"userLabels,omitempty"`
+	DeletionProtectionEnabled bool                 `json:"deletionProtectionEnabled,omitempty"`
+}
+
+type backupConfiguration struct {
+	Enabled                 bool                     `json:"enabled"`
+	StartTime               string                   `json:"startTime,omitempty"` // HH:MM in UTC
+	BackupRetentionSettings *backupRetentionSettings `json:"backupRetentionSettings,omitempty"`
+}
+
+type backupRetentionSettings struct {
+	RetentionUnit   string `json:"retentionUnit"`
+	RetainedBackups int64  `json:"retainedBackups"`
+}
+
+type ipConfiguration struct {
+	IPv4Enabled    *bool  `json:"ipv4Enabled,omitempty"` // enabled when not set
+	PrivateNetwork string `json:"privateNetwork,omitempty"`
+}
+
+type maintenanceWindow struct {
+	Day  int64 `json:"day"` // 1 is monday
+	Hour int64 `json:"hour"`
+}
+
+type databaseFlag struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+type sqlDatabase struct {
+	Name string `json:"name"`
+}
+
+type user struct {
+	Name     string `json:"name"`
+	Password string `json:"password,omitempty"`
+}
+
+type operation struct {
+	Name   string `json:"name"`
+	Status string `json:"status"` // PENDING, RUNNING or DONE
+	Error  *struct {
+		Errors []struct {
+			Code    string `json:"code"`
+			Message string `json:"message"`
+		} `json:"errors"`
+	} `json:"error,omitempty"`
+}
+
+// apiError is the error returned by the API
+type apiError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e *apiError) Error() string {
+	return fmt.Sprintf("cloud sql: %v %v", e.Code, e.Message)
+}
+
+func isNotFound(err error) bool {
+	var e *apiError
+	return errors.As(err, &e) && e.Code == http.StatusNotFound
+}
+
+// isConflict tells if the instance is busy with another operation
+func isConflict(err error) bool {
+	var e *apiError
+	return errors.As(err, &e) && e.Code == http.StatusConflict
+}
+
+// client calls the Cloud SQL Admin API of the project
+type client struct {
+	env *Environment
+}
+
+func (c *client) do(ctx context.Context, method, path string, in, out interface{}) error {
+	var body bytes.Buffer
+	if in != nil {
+		if err := json.NewEncoder(&body).Encode(in); err != nil {
+			return err
+		}
+	}
+	u := fmt.Sprintf("%v/v1/projects/%v/%v", c.env.Endpoint, url.PathEscape(c.env.Project), path)
+	req, err := http.NewRequestWithContext(ctx, method, u, &body)
+	if err != nil {
+		return err
+	}
+	token, err := c.env.accessToken(ctx)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := c.env.HTTPClient.Do(req)
+	if err != nil {
+		return errors.Wrap(err, fmt.Sprintf("%v %v", method, path))
+	}
+	defer resp.Body.Close()
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode >= 300 {
+		var e struct {
+			Error apiError `json:"error"`
+		}
+		if err := json.Unmarshal(data, &e); err != nil || e.Error.Code == 0 {
+			e.Error = apiError{Code: resp.StatusCode, Message: string(data)}
+		}
+		return errors.Wrap(&e.Error, fmt.Sprintf("%v %v", method, path))
+	}
+	if out == nil {
+		return nil
+	}
+	return json.Unmarshal(data, out)
+}
+
+// wait polls the operation until it's done
+func (c *client) wait(ctx context.Context, op *operation) error {
+	for op.Status != "DONE" {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(pollInterval):
+		}
+		if err := c.do(ctx, http.MethodGet, "operations/"+op.Name, nil, op); err != nil {
+			return err
+		}
+	}
+	if op.Error != nil && len(op.Error.Errors) > 0 {
+		return fmt.Errorf("operation %v failed: %v %v", op.Name, op.Error.Errors[0].Code, op.Error.Errors[0].Message)
+	}
+	return nil
+}
+
+// call runs a request returning an operation and waits for it
+func (c *client) call(ctx context.Context, method, path string, in interface{}) error {
+	var op operation
+	if err := c.do(ctx, method, path, in, &op); err != nil {
+		return err
+	}
+	return c.wait(ctx, &op)
+}
+
+// start runs a request returning an operation without waiting for it, the state of the instance tells when
+// it's done
+func (c *client) start(ctx context.Context, method, path string, in interface{}) error {
+	var op operation
+	if err := c.do(ctx, method, path, in, &op); err != nil {
+		return err
+	}
+	if op.Error != nil && len(op.Error.Errors) > 0 {
+		return fmt.Errorf("operation %v failed: %v %v", op.Name, op.Error.Errors[0].Code, op.Error.Errors[0].Message)
+	}
+	return nil
+}
+
+func (c *client) getInstance(ctx context.Context, name string) (*instance, error) {
+	var i instance
+	if err := c.do(ctx, http.MethodGet, "instances/"+name, nil, &i); err != nil {
+		return nil, err
+	}
+	return &i, nil
+}
+
+func (c *client) insertInstance(ctx context.Context, i *instance) error {
+	return c.start(ctx, http.MethodPost, "instances", i)
+}
+
+func (c *client) patchInstance(ctx context.Context, name string, patch map[string]interface{}) error {
+	return c.start(ctx, http.MethodPatch, "instances/"+name, patch)
+}
+
+func (c *client) deleteInstance(ctx context.Context, name string) error {
+	return c.start(ctx, http.MethodDelete, "instances/"+name, nil)
+}
+
+func (c *client) listDatabases(ctx context.Context, instanceName string) ([]sqlDatabase, error) {
+	var list struct {
+		Items []sqlDatabase `json:"items"`
+	}
+	err := c.do(ctx, http.MethodGet, "instances/"+instanceName+"/databases", nil, &list)
+	return list.Items, err
+}
+
+func (c *client) insertDatabase(ctx context.Context, instanceName, name string) error {
+	return c.call(ctx, http.MethodPost, "instances/"+instanceName+"/databases", sqlDatabase{Name: name})
+}
+
+func (c *client) listUsers(ctx context.Context, instanceName string) ([]user, error) {
+	var list struct {
+		Items []user `json:"items"`
+	}
+	err := c.do(ctx, http.MethodGet, "instances/"+instanceName+"/users", nil, &list)
+	return list.Items, err
+}
+
+func (c *client) insertUser(ctx context.Context, instanceName string, u user) error {
+	return c.call(ctx, http.MethodPost, "instances/"+instanceName+"/users", u)
+}
+
+func (c *client) updateUser(ctx context.Context, instanceName string, u user) error {
+	return c.call(ctx, http.MethodPut, "instances/"+instanceName+"/users?name="+url.QueryEscape(u.Name), u)
+}
@@ -0,0 +1,109 @@
+package gcp
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	// DefaultEndpoint is the Cloud SQL Admin API
+	DefaultEndpoint = "https://sqladmin.googleapis.com"
+	metadataURL     = "http://metadata.google.internal/computeMetadata/v1/"
+)
+
+// Environment holds the project and network the Cloud SQL instances are created in
+type Environment struct {
+	Project  string
+	Region   string
+	Network  string // VPC of the private IPs, ex: projects/my-project/global/networks/default
+	Endpoint string // Cloud SQL Admin API, tests point it at a fake server
+
+	// Token returns the OAuth access token of the API calls, it's taken from the metadata server when nil
+	Token      func(ctx context.Context) (string, error)
+	HTTPClient *http.Client
+
+	lock    sync.Mutex
+	token   string
+	expires time.Time
+}
+
+// Discover fills in the project and region of the cluster from the GKE metadata server, unless they're given
+func Discover(ctx context.Context, project, region, network string) (*Environment, error) {
+	env := &Environment{Project: project, Region: region, Network: network, Endpoint: DefaultEndpoint, HTTPClient: http.DefaultClient}
+	if env.Project == "" {
+		p, err := env.metadata(ctx, "project/project-id")
+		if err != nil {
+			return nil, errors.Wrap(err, "unable to discover the project, use --gcp-project")
+		}
+		env.Project = p
+	}
+	if env.Region == "" {
+		// projects/123456789/zones/europe-west1-b
+		zone, err := env.metadata(ctx, "instance/zone")
+		if err != nil {
+			return nil, errors.Wrap(err, "unable to discover the region, use --gcp-region")
+		}
+		zone = zone[strings.LastIndex(zone, "/")+1:]
+		env.Region = zone[:strings.LastIndex(zone, "-")]
+	}
+	if env.Network != "" && !strings.Contains(env.Network, "/") {
+		env.Network = fmt.Sprintf("projects/%v/global/networks/%v", env.Project, env.Network)
+	}
+	return env, nil
+}
+
+func (e *Environment) metadata(ctx context.Context, path string) (string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, metadataURL+path, nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Metadata-Flavor", "Google")
+	resp, err := e.HTTPClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return "", err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("metadata %v: %v", path, resp.Status)
+	}
+	return strings.TrimSpace(string(body)), nil
+}
+
+// accessToken returns the token of the API calls, the one of the metadata server is cached until it expires
+func (e *Environment) accessToken(ctx context.Context) (string, error) {
+	if e.Token != nil {
+		return e.Token(ctx)
+	}
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	if e.token != "" && time.Now().Before(e.expires) {
+		return e.token, nil
+	}
+	body, err := e.metadata(ctx, "instance/service-accounts/default/token")
+	if err != nil {
+		return "", errors.Wrap(err, "unable to get an access token")
+	}
+	var t struct {
+		AccessToken string `json:"access_token"`
+		ExpiresIn   int64  `json:"expires_in"`
+	}
+	if err := json.Unmarshal([]byte(body), &t); err != nil {
+		return "", errors.Wrap(err, "unable to read the access token")
+	}
+	e.token = t.AccessToken
+	// renewed a minute before it expires
+	e.expires = time.Now().Add(time.Duration(t.ExpiresIn)*time.Second - time.Minute)
+	return e.token, nil
+}
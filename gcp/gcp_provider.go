@@ -0,0 +1,177 @@
+// Package gcp provisions the databases as Google Cloud SQL instances through the Cloud SQL Admin API
+package gcp
+
+import (
+	"context"
+	"log"
+
+	"github.com/pkg/errors"
+	"github.com/sorenmat/k8s-rds/crd"
+	"github.com/sorenmat/k8s-rds/provider"
+	"k8s.io/client-go/kubernetes"
+)
+
+type GCP struct {
+	kc     kubernetes.Interface
+	env    *Environment
+	client *client
+	port   int32 // of the engine, the service forwards it to the instance
+}
+
+// New returns the provider creating the instances in the project of the environment
+func New(db *crd.Database, kc kubernetes.Interface, env *Environment) (*GCP, error) {
+	port := int32(5432)
+	if db.Spec.Engine == "mysql" {
+		port = 3306
+	}
+	return &GCP{kc: kc, env: env, client: &client{env: env}, port: port}, nil
+}
+
+// Ensure creates the Cloud SQL instance with the database and the user of the spec, or applies the changes of the
+// spec to its settings when it exists, and returns its status
+func (g *GCP) Ensure(ctx context.Context, db *crd.Database) (*provider.ProviderStatus, error) {
+	name := instanceName(db)
+	i, err := g.client.getInstance(ctx, name)
+	if isNotFound(err) {
+		i, err = g.create(ctx, db)
+	} else if err == nil && i.State == "RUNNABLE" {
+		err = g.update(ctx, db, i)
+	}
+	if err != nil {
+		return nil, err
+	}
+	status := g.instanceStatus(i)
+	if !status.Ready {
+		// the database and the user are created once it runs
+		return status, nil
+	}
+	password, err := g.GetSecret(ctx, db.Namespace, db.Spec.Password.Name, db.Spec.Password.Key)
+	if err != nil {
+		return nil, err
+	}
+	if err := g.ensureDatabase(ctx, name, db.Spec.DBName); err != nil {
+		return nil, err
+	}
+	if err := g.ensureUser(ctx, name, user{Name: db.Spec.Username, Password: password}); err != nil {
+		return nil, err
+	}
+	return status, nil
+}
+
+// create starts the creation of the instance, it's reported as PENDING_CREATE until it runs
+func (g *GCP) create(ctx context.Context, db *crd.Database) (*instance, error) {
+	name := instanceName(db)
+	version, err := databaseVersion(db.Spec.Engine, db.Spec.Version)
+	if err != nil {
+		return nil, err
+	}
+	s, err := desiredSettings(db, g.env)
+	if err != nil {
+		return nil, err
+	}
+	log.Printf("creating Cloud SQL instance %v\n", name)
+	err = g.client.insertInstance(ctx, &instance{Name: name, DatabaseVersion: version, Region: g.env.Region, Settings: s})
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to create the instance")
+	}
+	return g.client.getInstance(ctx, name)
+}
+
+// Describe returns the status of the instance
+func (g *GCP) Describe(ctx context.Context, db *crd.Database) (*provider.ProviderStatus, error) {
+	i, err := g.client.getInstance(ctx, instanceName(db))
+	if isNotFound(err) {
+		return &provider.ProviderStatus{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return g.instanceStatus(i), nil
+}
+
+// instanceStatus maps the instance to the status of the provider, the service points at its IP
+func (g *GCP) instanceStatus(i *instance) *provider.ProviderStatus {
+	return &provider.ProviderStatus{
+		Exists:        true,
+		Ready:         i.State == "RUNNABLE",
+		State:         i.State,
+		Hostname:      host(i),
+		Port:          g.port,
+		EngineVersion: i.DatabaseVersion,
+	}
+}
+
+func (g *GCP) ensureDatabase(ctx context.Context, instanceName, dbname string) error {
+	databases, err := g.client.listDatabases(ctx, instanceName)
+	if err != nil {
+		return err
+	}
+	for _, d := range databases {
+		if d.Name == dbname {
+			return nil
+		}
+	}
+	log.Printf("creating database %v in %v\n", dbname, instanceName)
+	return errors.Wrap(g.client.insertDatabase(ctx, instanceName, dbname), "unable to create the database")
+}
+
+// ensureUser creates the user, the password of an existing one is changed by UpdatePassword
+func (g *GCP) ensureUser(ctx context.Context, instanceName string, u user) error {
+	users, err := g.client.listUsers(ctx, instanceName)
+	if err != nil {
+		return err
+	}
+	for _, existing := range users {
+		if existing.Name == u.Name {
+			return nil
+		}
+	}
+	log.Printf("creating user %v in %v\n", u.Name, instanceName)
+	return errors.Wrap(g.client.insertUser(ctx, instanceName, u), "unable to create the user")
+}
+
+// update applies the changes of the spec to the settings of the instance
+func (g *GCP) update(ctx context.Context, db *crd.Database, i *instance) error {
+	name := instanceName(db)
+	if i.Settings == nil {
+		return nil
+	}
+	desired, err := desiredSettings(db, g.env)
+	if err != nil {
+		return err
+	}
+	changes := settingsChanges(desired, i.Settings)
+	if changes == nil {
+		return nil
+	}
+	log.Printf("updating Cloud SQL instance %v: %v\n", name, changes)
+	err = g.client.patchInstance(ctx, name, map[string]interface{}{"settings": changes})
+	if isConflict(err) {
+		// a previous update is still running, the changes are compared again on the next resync
+		log.Printf("Cloud SQL instance %v is busy with another operation\n", name)
+		return nil
+	}
+	return errors.Wrap(err, "unable to update the instance")
+}
+
+// UpdatePassword sets the new password on the user of the spec
+func (g *GCP) UpdatePassword(ctx context.Context, db *crd.Database, password string) error {
+	log.Printf("changing the password of %v in %v\n", db.Spec.Username, instanceName(db))
+	err := g.client.updateUser(ctx, instanceName(db), user{Name: db.Spec.Username, Password: password})
+	return errors.Wrap(err, "unable to change the password")
+}
+
+// Delete deletes the instance, unless it's deletion protected
+func (g *GCP) Delete(ctx context.Context, db *crd.Database) error {
+	name := instanceName(db)
+	if db.Spec.DeleteProtection {
+		log.Printf("Trying to delete a %v in %v which is a deleted protected database", db.Name, db.Namespace)
+		return nil
+	}
+	log.Printf("deleting Cloud SQL instance %v\n", name)
+	err := g.client.deleteInstance(ctx, name)
+	if isNotFound(err) {
+		return nil
+	}
+	return errors.Wrap(err, "unable to delete the instance")
+}
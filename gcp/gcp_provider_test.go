@@ -0,0 +1,221 @@
+package gcp
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/sorenmat/k8s-rds/crd"
+	"github.com/sorenmat/k8s-rds/provider"
+	"github.com/stretchr/testify/assert"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+// fakeCloudSQL is an in memory Cloud SQL Admin API, the operations are done the second time they're read and
+// the instances are created by run
+type fakeCloudSQL struct {
+	lock      sync.Mutex
+	instances map[string]*instance
+	databases map[string][]sqlDatabase
+	users     map[string][]user
+	ops       map[string]int
+	patches   []map[string]interface{}
+	busy      bool // the patches are refused as another operation is running
+}
+
+func newFakeCloudSQL() *fakeCloudSQL {
+	return &fakeCloudSQL{instances: map[string]*instance{}, databases: map[string][]sqlDatabase{}, users: map[string][]user{}, ops: map[string]int{}}
+}
+
+func (f *fakeCloudSQL) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	if r.Header.Get("Authorization") != "Bearer token" {
+		writeError(w, http.StatusUnauthorized)
+		return
+	}
+	// /v1/projects/my-project/<resource>
+	parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/v1/projects/my-project/"), "/")
+	operation := func(name string) {
+		f.ops[name] = 0
+		json.NewEncoder(w).Encode(map[string]string{"name": name, "status": "PENDING"})
+	}
+	switch {
+	case parts[0] == "operations" && r.Method == http.MethodGet:
+		f.ops[parts[1]]++
+		status := "RUNNING"
+		if f.ops[parts[1]] > 1 {
+			status = "DONE"
+		}
+		json.NewEncoder(w).Encode(map[string]string{"name": parts[1], "status": status})
+	case len(parts) == 1 && r.Method == http.MethodPost:
+		var i instance
+		json.NewDecoder(r.Body).Decode(&i)
+		i.State = "PENDING_CREATE"
+		f.instances[i.Name] = &i
+		f.users[i.Name] = []user{{Name: "postgres"}}
+		operation("create-" + i.Name)
+	case len(parts) == 2 && f.instances[parts[1]] == nil:
+		writeError(w, http.StatusNotFound)
+	case len(parts) == 2 && r.Method == http.MethodGet:
+		json.NewEncoder(w).Encode(f.instances[parts[1]])
+	case len(parts) == 2 && r.Method == http.MethodPatch && f.busy:
+		writeError(w, http.StatusConflict)
+	case len(parts) == 2 && r.Method == http.MethodPatch:
+		var patch map[string]map[string]interface{}
+		json.NewDecoder(r.Body).Decode(&patch)
+		f.patches = append(f.patches, patch["settings"])
+		if tier, ok := patch["settings"]["tier"]; ok {
+			f.instances[parts[1]].Settings.Tier = tier.(string)
+		}
+		operation("patch-" + parts[1])
+	case len(parts) == 2 && r.Method == http.MethodDelete:
+		delete(f.instances, parts[1])
+		operation("delete-" + parts[1])
+	case len(parts) == 3 && parts[2] == "databases" && r.Method == http.MethodGet:
+		json.NewEncoder(w).Encode(map[string][]sqlDatabase{"items": f.databases[parts[1]]})
+	case len(parts) == 3 && parts[2] == "databases" && r.Method == http.MethodPost:
+		var d sqlDatabase
+		json.NewDecoder(r.Body).Decode(&d)
+		f.databases[parts[1]] = append(f.databases[parts[1]], d)
+		operation("database-" + d.Name)
+	case len(parts) == 3 && parts[2] == "users" && r.Method == http.MethodGet:
+		json.NewEncoder(w).Encode(map[string][]user{"items": f.users[parts[1]]})
+	case len(parts) == 3 && parts[2] == "users" && r.Method == http.MethodPost:
+		var u user
+		json.NewDecoder(r.Body).Decode(&u)
+		f.users[parts[1]] = append(f.users[parts[1]], u)
+		operation("user-" + u.Name)
+	case len(parts) == 3 && parts[2] == "users" && r.Method == http.MethodPut:
+		var u user
+		json.NewDecoder(r.Body).Decode(&u)
+		for n, existing := range f.users[parts[1]] {
+			if existing.Name == r.URL.Query().Get("name") {
+				f.users[parts[1]][n] = u
+			}
+		}
+		operation("password-" + u.Name)
+	default:
+		writeError(w, http.StatusBadRequest)
+	}
+}
+
+// run finishes the creation of the instance
+func (f *fakeCloudSQL) run(name string) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	f.instances[name].State = "RUNNABLE"
+	f.instances[name].IPAddresses = []ipMapping{{Type: "PRIVATE", IPAddress: "10.1.2.3"}}
+}
+
+func writeError(w http.ResponseWriter, code int) {
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(map[string]apiError{"error": {Code: code, Message: http.StatusText(code)}})
+}
+
+func TestGCP(t *testing.T) {
+	pollInterval = time.Millisecond
+	ctx := context.Background()
+	f := newFakeCloudSQL()
+	server := httptest.NewServer(f)
+	defer server.Close()
+	env := &Environment{
+		Project:    "my-project",
+		Region:     "europe-west1",
+		Network:    "projects/my-project/global/networks/default",
+		Endpoint:   server.URL,
+		HTTPClient: server.Client(),
+		Token:      func(context.Context) (string, error) { return "token", nil },
+	}
+	kc := fake.NewSimpleClientset(&v1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "shop", Name: "orders"},
+		Data:       map[string][]byte{"password": []byte("secret")},
+	})
+	db := &crd.Database{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "shop", Name: "orders"},
+		Spec: crd.DatabaseSpec{
+			Engine:   "postgres",
+			Version:  "14.4",
+			Class:    "db.t3.micro",
+			Size:     20,
+			Username: "app",
+			DBName:   "orders",
+			Password: v1.SecretKeySelector{LocalObjectReference: v1.LocalObjectReference{Name: "orders"}, Key: "password"},
+		},
+	}
+	g, err := New(db, kc, env)
+	assert.NoError(t, err)
+
+	// the creation isn't waited for
+	status, err := g.Ensure(ctx, db)
+	assert.NoError(t, err)
+	assert.Equal(t, &provider.ProviderStatus{Exists: true, State: "PENDING_CREATE", Port: 5432, EngineVersion: "POSTGRES_14"}, status)
+	assert.Empty(t, f.databases["orders-shop"], "the database is created once the instance runs")
+
+	f.run("orders-shop")
+	status, err = g.Ensure(ctx, db)
+	assert.NoError(t, err)
+	assert.Equal(t, &provider.ProviderStatus{Exists: true, Ready: true, State: "RUNNABLE", Hostname: "10.1.2.3", Port: 5432, EngineVersion: "POSTGRES_14"}, status)
+	hostname := status.Hostname
+	i := f.instances["orders-shop"]
+	assert.Equal(t, "POSTGRES_14", i.DatabaseVersion)
+	assert.Equal(t, "europe-west1", i.Region)
+	assert.Equal(t, "db-f1-micro", i.Settings.Tier)
+	assert.Equal(t, []sqlDatabase{{Name: "orders"}}, f.databases["orders-shop"])
+	assert.Equal(t, []user{{Name: "postgres"}, {Name: "app", Password: "secret"}}, f.users["orders-shop"])
+
+	// ensuring it again doesn't change anything
+	_, err = g.Ensure(ctx, db)
+	assert.NoError(t, err)
+	assert.Len(t, f.databases["orders-shop"], 1)
+	assert.Len(t, f.users["orders-shop"], 2)
+	assert.Empty(t, f.patches)
+
+	db.Spec.Class = "db-custom-2-7680"
+	f.busy = true
+	_, err = g.Ensure(ctx, db)
+	assert.NoError(t, err, "the update is tried again once the running operation is done")
+	assert.Empty(t, f.patches)
+	f.busy = false
+	_, err = g.Ensure(ctx, db)
+	assert.NoError(t, err)
+	assert.Equal(t, []map[string]interface{}{{"tier": "db-custom-2-7680"}}, f.patches)
+
+	assert.NoError(t, g.UpdatePassword(ctx, db, "new-secret"))
+	assert.Equal(t, user{Name: "app", Password: "new-secret"}, f.users["orders-shop"][1])
+
+	assert.NoError(t, g.CreateService(ctx, "shop", hostname, "orders"))
+	e, err := kc.CoreV1().Endpoints("shop").Get(ctx, "orders", metav1.GetOptions{})
+	assert.NoError(t, err)
+	assert.Equal(t, "10.1.2.3", e.Subsets[0].Addresses[0].IP)
+	assert.Equal(t, int32(5432), e.Subsets[0].Ports[0].Port)
+
+	assert.NoError(t, g.Delete(ctx, db))
+	assert.Empty(t, f.instances)
+	status, err = g.Describe(ctx, db)
+	assert.NoError(t, err)
+	assert.False(t, status.Exists)
+	// it's gone already
+	assert.NoError(t, g.Delete(ctx, db))
+}
+
+func TestGCPErrors(t *testing.T) {
+	pollInterval = time.Millisecond
+	server := httptest.NewServer(newFakeCloudSQL())
+	defer server.Close()
+	env := &Environment{Project: "my-project", Endpoint: server.URL, HTTPClient: server.Client(),
+		Token: func(context.Context) (string, error) { return "expired", nil }}
+	c := &client{env: env}
+
+	_, err := c.getInstance(context.Background(), "orders-shop")
+	assert.Error(t, err)
+	assert.False(t, isNotFound(err))
+	assert.Contains(t, err.Error(), "401 Unauthorized")
+}
@@ -0,0 +1,230 @@
+package gcp
+
+import (
+	"fmt"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/sorenmat/k8s-rds/crd"
+)
+
+// tiers are the shared core tiers used for the smallest RDS classes, other sizes take a Cloud SQL tier like db-custom-2-7680
+var tiers = map[string]string{
+	"db.t2.micro":  "db-f1-micro",
+	"db.t3.micro":  "db-f1-micro",
+	"db.t4g.micro": "db-f1-micro",
+	"db.t2.small":  "db-g1-small",
+	"db.t3.small":  "db-g1-small",
+	"db.t4g.small": "db-g1-small",
+}
+
+var days = map[string]int64{"mon": 1, "tue": 2, "wed": 3, "thu": 4, "fri": 5, "sat": 6, "sun": 7}
+
+var invalidLabel = regexp.MustCompile(`[^a-z0-9_-]`)
+
+// instanceName returns the name of the Cloud SQL instance, lowercase letters, numbers and hyphens only
+func instanceName(db *crd.Database) string {
+	name := strings.ToLower(strings.ReplaceAll(db.Name+"-"+db.Namespace, "_", "-"))
+	if len(name) > 98 {
+		name = name[:98]
+	}
+	return strings.TrimRight(name, "-")
+}
+
+func tier(class string) (string, error) {
+	if strings.HasPrefix(class, "db-") {
+		return class, nil
+	}
+	if t, ok := tiers[class]; ok {
+		return t, nil
+	}
+	return "", fmt.Errorf("unsupported class %v, use a Cloud SQL tier like db-custom-2-7680", class)
+}
+
+// databaseVersion returns the Cloud SQL version of the engine, ex: POSTGRES_14 or MYSQL_8_0
+func databaseVersion(engine, version string) (string, error) {
+	if version == "" {
+		return "", fmt.Errorf("the gcp provider needs the version of the engine")
+	}
+	parts := strings.Split(version, ".")
+	major := parts[0]
+	n, err := strconv.Atoi(major)
+	if err != nil {
+		return "", fmt.Errorf("invalid version %v", version)
+	}
+	if len(parts) > 1 && (engine != "postgres" || n < 10) {
+		major += "_" + parts[1]
+	}
+	switch engine {
+	case "postgres":
+		return "POSTGRES_" + major, nil
+	case "mysql":
+		return "MYSQL_" + major, nil
+	}
+	return "", fmt.Errorf("the gcp provider doesn't support the engine %v", engine)
+}
+
+// labelValue makes a tag usable as a label, they only allow lowercase letters, numbers, underscores and hyphens
+func labelValue(s string) string {
+	s = invalidLabel.ReplaceAllString(strings.ToLower(s), "_")
+	if len(s) > 63 {
+		s = s[:63]
+	}
+	return s
+}
+
+func labels(db *crd.Database) map[string]string {
+	result := map[string]string{}
+	for k, v := range db.Spec.Tags {
+		if key := labelValue(k); key != "" {
+			result[key] = labelValue(v)
+		}
+	}
+	result["managed-by"] = "k8s-rds"
+	result["k8s-rds-namespace"] = labelValue(db.Namespace)
+	result["k8s-rds-database"] = labelValue(db.Name)
+	return result
+}
+
+func flags(db *crd.Database) []databaseFlag {
+	result := []databaseFlag{}
+	for k, v := range db.Spec.Parameters {
+		result = append(result, databaseFlag{Name: k, Value: v})
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Name < result[j].Name })
+	return result
+}
+
+// desiredSettings maps the spec to the settings of the instance
+func desiredSettings(db *crd.Database, env *Environment) (*settings, error) {
+	t, err := tier(db.Spec.Class)
+	if err != nil {
+		return nil, err
+	}
+	s := &settings{
+		Tier:                      t,
+		DataDiskSizeGb:            db.Spec.Size,
+		DataDiskType:              "PD_SSD",
+		AvailabilityType:          "ZONAL",
+		UserLabels:                labels(db),
+		DatabaseFlags:             flags(db),
+		DeletionProtectionEnabled: db.Spec.DeleteProtection,
+	}
+	if db.Spec.StorageType == "standard" {
+		s.DataDiskType = "PD_HDD"
+	}
+	if db.Spec.MultiAZ {
+		s.AvailabilityType = "REGIONAL"
+	}
+	autoResize := db.Spec.MaxAllocatedSize > db.Spec.Size
+	s.StorageAutoResize = &autoResize
+	if autoResize {
+		s.StorageAutoResizeLimit = db.Spec.MaxAllocatedSize
+	}
+
+	s.BackupConfiguration = &backupConfiguration{Enabled: db.Spec.BackupRetentionPeriod > 0}
+	if s.BackupConfiguration.Enabled {
+		// backups are daily, so the number of days is the number of backups
+		s.BackupConfiguration.BackupRetentionSettings = &backupRetentionSettings{RetentionUnit: "COUNT", RetainedBackups: db.Spec.BackupRetentionPeriod}
+		if db.Spec.BackupWindow != "" {
+			s.BackupConfiguration.StartTime = db.Spec.BackupWindow[:5]
+		}
+	}
+
+	public := db.Spec.PubliclyAccessible
+	s.IPConfiguration = &ipConfiguration{IPv4Enabled: &public, PrivateNetwork: env.Network}
+	if !public && env.Network == "" {
+		return nil, fmt.Errorf("an instance without a public IP needs a network, use --gcp-network")
+	}
+
+	if db.Spec.MaintenanceWindow != "" {
+		// ddd:hh24:mi-ddd:hh24:mi, Cloud SQL only takes the day and the hour of the start
+		hour, _ := strconv.ParseInt(db.Spec.MaintenanceWindow[4:6], 10, 64)
+		s.MaintenanceWindow = &maintenanceWindow{Day: days[db.Spec.MaintenanceWindow[:3]], Hour: hour}
+	}
+	return s, nil
+}
+
+// settingsChanges returns the settings of the instance that differ from the desired ones, or nil when there's nothing
+// to change. The disk can only grow and the private network can't be changed.
+func settingsChanges(desired, current *settings) map[string]interface{} {
+	changes := map[string]interface{}{}
+	if desired.Tier != current.Tier {
+		changes["tier"] = desired.Tier
+	}
+	if desired.DataDiskSizeGb > current.DataDiskSizeGb {
+		changes["dataDiskSizeGb"] = strconv.FormatInt(desired.DataDiskSizeGb, 10)
+	}
+	if desired.AvailabilityType != current.AvailabilityType {
+		changes["availabilityType"] = desired.AvailabilityType
+	}
+	if current.StorageAutoResize == nil || *desired.StorageAutoResize != *current.StorageAutoResize ||
+		desired.StorageAutoResizeLimit != current.StorageAutoResizeLimit {
+		changes["storageAutoResize"] = *desired.StorageAutoResize
+		changes["storageAutoResizeLimit"] = strconv.FormatInt(desired.StorageAutoResizeLimit, 10)
+	}
+	if !backupsEqual(desired.BackupConfiguration, current.BackupConfiguration) {
+		changes["backupConfiguration"] = desired.BackupConfiguration
+	}
+	if current.IPConfiguration == nil || current.IPConfiguration.IPv4Enabled == nil ||
+		*desired.IPConfiguration.IPv4Enabled != *current.IPConfiguration.IPv4Enabled {
+		changes["ipConfiguration"] = map[string]interface{}{"ipv4Enabled": *desired.IPConfiguration.IPv4Enabled}
+	}
+	if desired.MaintenanceWindow != nil && !reflect.DeepEqual(desired.MaintenanceWindow, current.MaintenanceWindow) {
+		changes["maintenanceWindow"] = desired.MaintenanceWindow
+	}
+	if len(desired.DatabaseFlags) != len(current.DatabaseFlags) ||
+		(len(desired.DatabaseFlags) > 0 && !reflect.DeepEqual(desired.DatabaseFlags, sortedFlags(current.DatabaseFlags))) {
+		changes["databaseFlags"] = desired.DatabaseFlags
+	}
+	if !reflect.DeepEqual(desired.UserLabels, current.UserLabels) {
+		changes["userLabels"] = desired.UserLabels
+	}
+	if desired.DeletionProtectionEnabled != current.DeletionProtectionEnabled {
+		changes["deletionProtectionEnabled"] = desired.DeletionProtectionEnabled
+	}
+	if len(changes) == 0 {
+		return nil
+	}
+	return changes
+}
+
+func sortedFlags(f []databaseFlag) []databaseFlag {
+	result := append([]databaseFlag{}, f...)
+	sort.Slice(result, func(i, j int) bool { return result[i].Name < result[j].Name })
+	return result
+}
+
+func backupsEqual(desired, current *backupConfiguration) bool {
+	if current == nil {
+		return !desired.Enabled
+	}
+	if desired.Enabled != current.Enabled {
+		return false
+	}
+	if !desired.Enabled {
+		return true
+	}
+	if desired.StartTime != "" && desired.StartTime != current.StartTime {
+		return false
+	}
+	return current.BackupRetentionSettings != nil &&
+		desired.BackupRetentionSettings.RetainedBackups == current.BackupRetentionSettings.RetainedBackups
+}
+
+// host returns the address of the instance, the private IP is preferred
+func host(i *instance) string {
+	result := ""
+	for _, ip := range i.IPAddresses {
+		switch ip.Type {
+		case "PRIVATE":
+			return ip.IPAddress
+		case "PRIMARY":
+			result = ip.IPAddress
+		}
+	}
+	return result
+}
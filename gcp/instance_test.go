@@ -0,0 +1,119 @@
+package gcp
+
+import (
+	"testing"
+
+	"github.com/sorenmat/k8s-rds/crd"
+	"github.com/stretchr/testify/assert"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestInstanceName(t *testing.T) {
+	db := &crd.Database{ObjectMeta: metav1.ObjectMeta{Name: "My_DB", Namespace: "shop"}}
+	assert.Equal(t, "my-db-shop", instanceName(db))
+}
+
+func TestDatabaseVersion(t *testing.T) {
+	for _, c := range []struct{ engine, version, expected string }{
+		{"postgres", "14.4", "POSTGRES_14"},
+		{"postgres", "15", "POSTGRES_15"},
+		{"postgres", "9.6.20", "POSTGRES_9_6"},
+		{"mysql", "8.0.31", "MYSQL_8_0"},
+		{"mysql", "5.7", "MYSQL_5_7"},
+	} {
+		v, err := databaseVersion(c.engine, c.version)
+		assert.NoError(t, err)
+		assert.Equal(t, c.expected, v)
+	}
+	_, err := databaseVersion("postgres", "")
+	assert.Error(t, err)
+	_, err = databaseVersion("oracle-ee", "19")
+	assert.Error(t, err)
+}
+
+func TestTier(t *testing.T) {
+	tier, err := tier("db.t3.small")
+	assert.NoError(t, err)
+	assert.Equal(t, "db-g1-small", tier)
+	_, err = desiredSettings(&crd.Database{Spec: crd.DatabaseSpec{Class: "db.m5.large"}}, &Environment{})
+	assert.Error(t, err)
+}
+
+func TestDesiredSettings(t *testing.T) {
+	db := &crd.Database{
+		ObjectMeta: metav1.ObjectMeta{Name: "orders", Namespace: "shop"},
+		Spec: crd.DatabaseSpec{
+			Class:                 "db-custom-2-7680",
+			Size:                  20,
+			MaxAllocatedSize:      100,
+			MultiAZ:               true,
+			StorageType:           "standard",
+			BackupRetentionPeriod: 7,
+			BackupWindow:          "01:00-01:30",
+			MaintenanceWindow:     "sun:03:00-sun:04:00",
+			Parameters:            map[string]string{"max_connections": "200", "log_min_duration_statement": "500"},
+			Tags:                  crd.Tags{"Team": "Check Out"},
+		},
+	}
+	env := &Environment{Network: "projects/p/global/networks/default"}
+	s, err := desiredSettings(db, env)
+	assert.NoError(t, err)
+	assert.Equal(t, "PD_HDD", s.DataDiskType)
+	assert.Equal(t, "REGIONAL", s.AvailabilityType)
+	assert.True(t, *s.StorageAutoResize)
+	assert.Equal(t, int64(100), s.StorageAutoResizeLimit)
+	assert.Equal(t, &backupConfiguration{Enabled: true, StartTime: "01:00",
+		BackupRetentionSettings: &backupRetentionSettings{RetentionUnit: "COUNT", RetainedBackups: 7}}, s.BackupConfiguration)
+	assert.False(t, *s.IPConfiguration.IPv4Enabled)
+	assert.Equal(t, &maintenanceWindow{Day: 7, Hour: 3}, s.MaintenanceWindow)
+	assert.Equal(t, []databaseFlag{{"log_min_duration_statement", "500"}, {"max_connections", "200"}}, s.DatabaseFlags)
+	assert.Equal(t, map[string]string{"team": "check_out", "managed-by": "k8s-rds", "k8s-rds-namespace": "shop", "k8s-rds-database": "orders"}, s.UserLabels)
+
+	// a private instance needs a network
+	_, err = desiredSettings(db, &Environment{})
+	assert.Error(t, err)
+	db.Spec.PubliclyAccessible = true
+	_, err = desiredSettings(db, &Environment{})
+	assert.NoError(t, err)
+}
+
+func TestSettingsChanges(t *testing.T) {
+	db := &crd.Database{
+		ObjectMeta: metav1.ObjectMeta{Name: "orders", Namespace: "shop"},
+		Spec:       crd.DatabaseSpec{Class: "db-custom-2-7680", Size: 50, PubliclyAccessible: true},
+	}
+	desired, err := desiredSettings(db, &Environment{})
+	assert.NoError(t, err)
+	current := *desired
+	assert.Nil(t, settingsChanges(desired, &current))
+
+	// the disk grew with the auto resize
+	current.DataDiskSizeGb = 80
+	assert.Nil(t, settingsChanges(desired, &current))
+
+	current.Tier = "db-custom-1-3840"
+	current.AvailabilityType = "REGIONAL"
+	current.DatabaseFlags = []databaseFlag{{"max_connections", "100"}}
+	assert.Equal(t, map[string]interface{}{
+		"tier":             "db-custom-2-7680",
+		"availabilityType": "ZONAL",
+		"databaseFlags":    []databaseFlag{},
+	}, settingsChanges(desired, &current))
+
+	db.Spec.Size = 100
+	db.Spec.BackupRetentionPeriod = 3
+	desired, err = desiredSettings(db, &Environment{})
+	assert.NoError(t, err)
+	current = *desired
+	current.DataDiskSizeGb = 80
+	current.BackupConfiguration = &backupConfiguration{Enabled: false}
+	assert.Equal(t, map[string]interface{}{
+		"dataDiskSizeGb":      "100",
+		"backupConfiguration": desired.BackupConfiguration,
+	}, settingsChanges(desired, &current))
+}
+
+func TestHost(t *testing.T) {
+	assert.Equal(t, "35.1.2.3", host(&instance{IPAddresses: []ipMapping{{Type: "PRIMARY", IPAddress: "35.1.2.3"}}}))
+	assert.Equal(t, "10.1.2.3", host(&instance{IPAddresses: []ipMapping{{Type: "PRIMARY", IPAddress: "35.1.2.3"}, {Type: "PRIVATE", IPAddress: "10.1.2.3"}}}))
+}
@@ -0,0 +1,33 @@
+package gcp
+
+import (
+	"context"
+	"fmt"
+	"log"
+
+	"github.com/pkg/errors"
+	"github.com/sorenmat/k8s-rds/kube"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// CreateService creates or updates a service pointing at the IP of the instance
+func (g *GCP) CreateService(ctx context.Context, namespace string, hostname string, internalname string) error {
+	return kube.CreateAddressService(ctx, g.kc, namespace, hostname, internalname, g.port)
+}
+
+func (g *GCP) DeleteService(ctx context.Context, namespace string, dbname string) error {
+	err := kube.DeleteAddressService(ctx, g.kc, namespace, dbname)
+	if err != nil {
+		log.Println(err)
+		return errors.Wrap(err, fmt.Sprintf("delete of service %v failed in namespace %v", dbname, namespace))
+	}
+	return nil
+}
+
+func (g *GCP) GetSecret(ctx context.Context, namespace string, name string, key string) (string, error) {
+	secret, err := g.kc.CoreV1().Secrets(namespace).Get(ctx, name, metav1.GetOptions{})
+	if err != nil {
+		return "", errors.Wrap(err, fmt.Sprintf("unable to fetch secret %v", name))
+	}
+	return string(secret.Data[key]), nil
+}
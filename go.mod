@@ -3,17 +3,26 @@ module github.com/sorenmat/k8s-rds
 go 1.16
 
 require (
-	github.com/aws/aws-sdk-go-v2 v1.3.0
-	github.com/aws/aws-sdk-go-v2/config v1.1.3
-	github.com/aws/aws-sdk-go-v2/service/ec2 v1.2.0
-	github.com/aws/aws-sdk-go-v2/service/rds v1.2.0
+	github.com/aws/aws-sdk-go-v2 v1.17.3
+	github.com/aws/aws-sdk-go-v2/config v1.18.3
+	github.com/aws/aws-sdk-go-v2/credentials v1.13.3
+	github.com/aws/aws-sdk-go-v2/feature/ec2/imds v1.12.19
+	github.com/aws/aws-sdk-go-v2/service/ec2 v1.72.0
+	github.com/aws/aws-sdk-go-v2/service/iam v1.19.0
+	github.com/aws/aws-sdk-go-v2/service/kms v1.20.0
+	github.com/aws/aws-sdk-go-v2/service/rds v1.40.0
+	github.com/aws/aws-sdk-go-v2/service/secretsmanager v1.16.7
+	github.com/aws/aws-sdk-go-v2/service/sts v1.17.5
+	github.com/aws/smithy-go v1.13.5
 	github.com/ghodss/yaml v1.0.0
 	github.com/golangci/golangci-lint v1.39.0
 	github.com/mitchellh/go-homedir v1.1.0
 	github.com/pkg/errors v0.9.1
+	github.com/robfig/cron/v3 v3.0.1
 	github.com/spf13/cobra v1.1.3
 	github.com/stretchr/testify v1.7.0
 	github.com/xeipuuv/gojsonschema v1.2.0
+	google.golang.org/grpc v1.43.0
 	k8s.io/api v0.21.0
 	k8s.io/apiextensions-apiserver v0.21.0
 	k8s.io/apimachinery v0.21.0
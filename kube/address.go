@@ -0,0 +1,76 @@
+package kube
+
+import (
+	"context"
+	"net"
+
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/client-go/kubernetes"
+)
+
+// CreateAddressService creates or updates a service named name pointing at the address of a database. A DNS name
+// gets an ExternalName service. An ExternalName service doesn't take an IP, so an IP gets a service without a
+// selector and endpoints holding the IP.
+func CreateAddressService(ctx context.Context, kc kubernetes.Interface, namespace, address, name string, port int32) error {
+	services := kc.CoreV1().Services(namespace)
+	s, err := services.Get(ctx, name, metav1.GetOptions{})
+	create := apierrors.IsNotFound(err)
+	if create {
+		s = &v1.Service{}
+	} else if err != nil {
+		return err
+	}
+	ip := net.ParseIP(address) != nil
+	s.Name = name
+	s.Namespace = namespace
+	s.Annotations = map[string]string{OriginAnnotation: "k8s-rds"}
+	s.Spec.Ports = []v1.ServicePort{{Name: "pgsql", Port: port, TargetPort: intstr.FromInt(int(port))}}
+	if ip {
+		s.Spec.Type = v1.ServiceTypeClusterIP
+		s.Spec.ExternalName = ""
+	} else {
+		s.Spec.Type = v1.ServiceTypeExternalName
+		s.Spec.ExternalName = address
+		s.Spec.ClusterIP = ""
+		s.Spec.ClusterIPs = nil
+	}
+	if create {
+		s, err = services.Create(ctx, s, metav1.CreateOptions{})
+	} else {
+		s, err = services.Update(ctx, s, metav1.UpdateOptions{})
+	}
+	if err != nil || !ip {
+		return err
+	}
+
+	endpoints := kc.CoreV1().Endpoints(namespace)
+	e, err := endpoints.Get(ctx, name, metav1.GetOptions{})
+	create = apierrors.IsNotFound(err)
+	if create {
+		e = &v1.Endpoints{}
+	} else if err != nil {
+		return err
+	}
+	e.Name = name
+	e.Namespace = namespace
+	// deleted with the service
+	e.OwnerReferences = []metav1.OwnerReference{{APIVersion: "v1", Kind: "Service", Name: s.Name, UID: s.UID}}
+	e.Subsets = []v1.EndpointSubset{{
+		Addresses: []v1.EndpointAddress{{IP: address}},
+		Ports:     []v1.EndpointPort{{Name: "pgsql", Port: port}},
+	}}
+	if create {
+		_, err = endpoints.Create(ctx, e, metav1.CreateOptions{})
+	} else {
+		_, err = endpoints.Update(ctx, e, metav1.UpdateOptions{})
+	}
+	return err
+}
+
+// DeleteAddressService deletes the service of a database, its endpoints are deleted with it
+func DeleteAddressService(ctx context.Context, kc kubernetes.Interface, namespace, name string) error {
+	return kc.CoreV1().Services(namespace).Delete(ctx, name, metav1.DeleteOptions{})
+}
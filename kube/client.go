@@ -10,6 +10,20 @@ import (
 )
 
 func Client() (*kubernetes.Clientset, error) {
+	config, err := RestConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	kubectl, err := kubernetes.NewForConfig(config)
+	if err != nil {
+		return nil, err
+	}
+	return kubectl, nil
+}
+
+// RestConfig returns the in cluster config, or the config from ~/.kube/config when running outside of a cluster
+func RestConfig() (*rest.Config, error) {
 	config, err := rest.InClusterConfig()
 	if err != nil {
 		log.Println("Appears we are not running in a cluster")
@@ -20,12 +34,7 @@ func Client() (*kubernetes.Clientset, error) {
 	} else {
 		log.Println("Seems like we are running in a Kubernetes cluster!!")
 	}
-
-	kubectl, err := kubernetes.NewForConfig(config)
-	if err != nil {
-		return nil, err
-	}
-	return kubectl, nil
+	return config, nil
 }
 
 func home() string {
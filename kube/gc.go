@@ -0,0 +1,78 @@
+package kube
+
+import (
+	"context"
+
+	"github.com/pkg/errors"
+	"github.com/sorenmat/k8s-rds/provider"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+const (
+	// OriginAnnotation is set on the Kubernetes resources created for a database, they're named after the Database
+	OriginAnnotation = "origin"
+	// DatabaseAnnotation names the Database of the resources that aren't named after it
+	DatabaseAnnotation = "k8s-rds.io/database"
+
+	// repositoryAnnotation is the only mark on the volumes created by older versions
+	repositoryAnnotation = "repository"
+	repositoryURL        = "https://github.com/sorenmat/k8s-rds"
+)
+
+// origins are the values of the origin annotation set by the providers
+var origins = map[string]bool{"rds": true, "k8s-rds": true}
+
+func created(annotations map[string]string) bool {
+	return origins[annotations[OriginAnnotation]] || annotations[repositoryAnnotation] == repositoryURL
+}
+
+// Orphans lists the Deployments, PersistentVolumeClaims and Services created for a Database that doesn't exist
+// anymore. keep tells if the resource with the namespace and name must be kept.
+func Orphans(ctx context.Context, kc kubernetes.Interface, keep func(namespace, name string) bool) ([]provider.Orphan, error) {
+	var result []provider.Orphan
+	add := func(kind string, meta metav1.ObjectMeta, del func(context.Context, string, metav1.DeleteOptions) error) {
+		namespace, name := meta.Namespace, meta.Name
+		owner := name
+		if db, ok := meta.Annotations[DatabaseAnnotation]; ok {
+			owner = db
+		}
+		if keep(namespace, owner) {
+			return
+		}
+		result = append(result, provider.Orphan{Kind: kind, Name: name, Namespace: namespace, Delete: func(ctx context.Context) error {
+			return del(ctx, name, metav1.DeleteOptions{})
+		}})
+	}
+
+	deployments, err := kc.AppsV1().Deployments(metav1.NamespaceAll).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to list deployments")
+	}
+	for _, d := range deployments.Items {
+		if created(d.Annotations) {
+			add("Deployment", d.ObjectMeta, kc.AppsV1().Deployments(d.Namespace).Delete)
+		}
+	}
+
+	pvcs, err := kc.CoreV1().PersistentVolumeClaims(metav1.NamespaceAll).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to list persistent volume claims")
+	}
+	for _, pvc := range pvcs.Items {
+		if created(pvc.Annotations) {
+			add("PersistentVolumeClaim", pvc.ObjectMeta, kc.CoreV1().PersistentVolumeClaims(pvc.Namespace).Delete)
+		}
+	}
+
+	services, err := kc.CoreV1().Services(metav1.NamespaceAll).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to list services")
+	}
+	for _, s := range services.Items {
+		if created(s.Annotations) {
+			add("Service", s.ObjectMeta, kc.CoreV1().Services(s.Namespace).Delete)
+		}
+	}
+	return result, nil
+}
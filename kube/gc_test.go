@@ -0,0 +1,45 @@
+package kube
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	appsv1 "k8s.io/api/apps/v1"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestOrphans(t *testing.T) {
+	meta := func(namespace, name string, annotations map[string]string) metav1.ObjectMeta {
+		return metav1.ObjectMeta{Namespace: namespace, Name: name, Annotations: annotations}
+	}
+	kc := fake.NewSimpleClientset(
+		&appsv1.Deployment{ObjectMeta: meta("shop", "orders", map[string]string{"origin": "k8s-rds"})},
+		&appsv1.Deployment{ObjectMeta: meta("shop", "carts", map[string]string{"origin": "k8s-rds"})},
+		&appsv1.Deployment{ObjectMeta: meta("shop", "web", nil)},
+		&v1.PersistentVolumeClaim{ObjectMeta: meta("shop", "carts", map[string]string{"repository": "https://github.com/sorenmat/k8s-rds"})},
+		&v1.Service{ObjectMeta: meta("shop", "carts", map[string]string{"origin": "rds"})},
+		&v1.Service{ObjectMeta: meta("shop", "cdn", map[string]string{"origin": "cloudfront"})},
+		&v1.Service{ObjectMeta: meta("shop", "orders-proxy", map[string]string{"origin": "rds", "k8s-rds.io/database": "orders"})},
+		&v1.Service{ObjectMeta: meta("shop", "carts-proxy", map[string]string{"origin": "rds", "k8s-rds.io/database": "carts"})},
+	)
+	keep := func(namespace, name string) bool { return namespace == "shop" && name == "orders" }
+
+	orphans, err := Orphans(context.Background(), kc, keep)
+	assert.NoError(t, err)
+	var found []string
+	for _, o := range orphans {
+		found = append(found, o.Kind+" "+o.Namespace+"/"+o.Name)
+	}
+	assert.Equal(t, []string{"Deployment shop/carts", "PersistentVolumeClaim shop/carts", "Service shop/carts", "Service shop/carts-proxy"}, found)
+
+	for _, o := range orphans {
+		assert.NoError(t, o.Delete(context.Background()))
+	}
+	_, err = kc.AppsV1().Deployments("shop").Get(context.Background(), "carts", metav1.GetOptions{})
+	assert.Error(t, err)
+	_, err = kc.AppsV1().Deployments("shop").Get(context.Background(), "orders", metav1.GetOptions{})
+	assert.NoError(t, err)
+}
@@ -0,0 +1,82 @@
+package local
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log"
+	"strings"
+
+	e "github.com/pkg/errors"
+	"github.com/sorenmat/k8s-rds/crd"
+	"github.com/sorenmat/k8s-rds/kube"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/tools/remotecommand"
+)
+
+// UpdatePassword changes the password of the database user by running ALTER USER in the database pod.
+// The postgres image only reads POSTGRES_PASSWORD when the data directory is initialized.
+func (l *Local) UpdatePassword(ctx context.Context, db *crd.Database, password string) error {
+	if db.Spec.Engine != "" && db.Spec.Engine != "postgres" {
+		return fmt.Errorf("changing the password isn't supported for engine %v", db.Spec.Engine)
+	}
+	pod, err := l.runningPod(ctx, db)
+	if err != nil {
+		return err
+	}
+
+	config, err := kube.RestConfig()
+	if err != nil {
+		return err
+	}
+	// the statement is sent on stdin, so the password doesn't show up in the process list of the pod
+	req := l.kc.CoreV1().RESTClient().Post().
+		Resource("pods").Namespace(db.Namespace).Name(pod).SubResource("exec").
+		VersionedParams(&corev1.PodExecOptions{
+			Container: db.Name,
+			Command:   []string{"psql", "-v", "ON_ERROR_STOP=1", "-U", db.Spec.Username, "-d", db.Spec.DBName},
+			Stdin:     true,
+			Stdout:    true,
+			Stderr:    true,
+		}, scheme.ParameterCodec)
+	exec, err := remotecommand.NewSPDYExecutor(config, "POST", req.URL())
+	if err != nil {
+		return e.Wrap(err, "unable to exec in the database pod")
+	}
+	var stdout, stderr bytes.Buffer
+	err = exec.Stream(remotecommand.StreamOptions{
+		Stdin:  strings.NewReader(alterUserSQL(db.Spec.Username, password)),
+		Stdout: &stdout,
+		Stderr: &stderr,
+	})
+	if err != nil {
+		return e.Wrap(err, fmt.Sprintf("unable to change the password in pod %v: %v", pod, stderr.String()))
+	}
+
+	log.Printf("Changed the password of %v in pod %v\n", db.Spec.Username, pod)
+	now := metav1.Now()
+	db.Status.PasswordLastRotated = &now
+	return nil
+}
+
+// runningPod returns the name of a running pod of the database deployment
+func (l *Local) runningPod(ctx context.Context, db *crd.Database) (string, error) {
+	pods, err := l.kc.CoreV1().Pods(db.Namespace).List(ctx, metav1.ListOptions{LabelSelector: "db=" + db.Name})
+	if err != nil {
+		return "", e.Wrap(err, "unable to list the database pods")
+	}
+	for _, p := range pods.Items {
+		if p.Status.Phase == corev1.PodRunning {
+			return p.Name, nil
+		}
+	}
+	return "", fmt.Errorf("no running pod found for database %v", db.Name)
+}
+
+// alterUserSQL returns the statement changing the password, with the user and password quoted
+func alterUserSQL(user, password string) string {
+	return fmt.Sprintf("ALTER USER \"%s\" WITH PASSWORD '%s';\n",
+		strings.ReplaceAll(user, `"`, `""`), strings.ReplaceAll(password, `'`, `''`))
+}
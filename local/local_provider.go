@@ -4,11 +4,15 @@ import (
 	"context"
 	"fmt"
 	"log"
+	"sort"
+	"strconv"
+	"strings"
 	"time"
 
 	e "github.com/pkg/errors"
 	"github.com/sorenmat/k8s-rds/crd"
 	"github.com/sorenmat/k8s-rds/provider"
+	"github.com/sorenmat/k8s-rds/schedule"
 	v1 "k8s.io/api/apps/v1"
 	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/errors"
@@ -29,19 +33,18 @@ func New(db *crd.Database, kc kubernetes.Interface, repository string) (*Local,
 	return &r, nil
 }
 
-// CreateDatabase creates a database from the CRD database object, is also ensures that the correct
-// subnets are created for the database so we can access it
-func (l *Local) CreateDatabase(ctx context.Context, db *crd.Database) (string, error) {
+// Ensure creates or updates the deployment and the volume of the database, and returns its status
+func (l *Local) Ensure(ctx context.Context, db *crd.Database) (*provider.ProviderStatus, error) {
 
 	if err := l.createPVC(ctx, db.Name, db.Namespace, db.Spec.Size); err != nil {
-		return "", err
+		return nil, err
 	}
 
 	_new := false
 	d, err := l.kc.AppsV1().Deployments(db.Namespace).Get(ctx, db.Name, metav1.GetOptions{})
 	if err != nil && !errors.IsNotFound(err) {
 		// we got an error and it's not the NotFound, let's crash
-		return "", err
+		return nil, err
 	}
 	if errors.IsNotFound(err) {
 		// Deployment seems to be empty, let's assume it means we need to create it
@@ -49,29 +52,78 @@ func (l *Local) CreateDatabase(ctx context.Context, db *crd.Database) (string, e
 		_new = true
 	}
 
+	if !_new {
+		if err := checkVersionChange(d, db); err != nil {
+			return nil, err
+		}
+	}
+
 	d.Name = db.Name
 	d.Labels = map[string]string{"db": "true"}
 
 	d.ObjectMeta = metav1.ObjectMeta{
-		Name: db.Name,
+		Name:        db.Name,
+		Annotations: map[string]string{"origin": "k8s-rds"},
 	}
 	d.Spec = toSpec(db, l.repository)
+	active, err := schedule.Active(db.Spec.Schedule, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	if !active {
+		// the service and the volume are kept, only the database is scaled down
+		d.Spec.Replicas = int32Ptr(0)
+	}
+	db.Status.Stopped = !active
 
 	if _new {
 		log.Printf("creating database %v", db.Name)
-		_, err = l.kc.AppsV1().Deployments(db.Namespace).Create(ctx, d, metav1.CreateOptions{})
+		d, err = l.kc.AppsV1().Deployments(db.Namespace).Create(ctx, d, metav1.CreateOptions{})
 		if err != nil {
-			return "", err
+			return nil, err
 		}
 	} else {
 		log.Printf("updating database %v", db.Name)
-		_, err = l.kc.AppsV1().Deployments(db.Namespace).Update(ctx, d, metav1.UpdateOptions{})
+		d, err = l.kc.AppsV1().Deployments(db.Namespace).Update(ctx, d, metav1.UpdateOptions{})
 		if err != nil {
-			return "", err
+			return nil, err
 		}
 	}
 
-	return db.Name, nil
+	return deploymentStatus(db, d), nil
+}
+
+// Describe returns the status of the deployment of the database
+func (l *Local) Describe(ctx context.Context, db *crd.Database) (*provider.ProviderStatus, error) {
+	d, err := l.kc.AppsV1().Deployments(db.Namespace).Get(ctx, db.Name, metav1.GetOptions{})
+	if errors.IsNotFound(err) {
+		return &provider.ProviderStatus{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return deploymentStatus(db, d), nil
+}
+
+// deploymentStatus maps the deployment to the status of the provider, the service of the database selects its pods
+func deploymentStatus(db *crd.Database, d *v1.Deployment) *provider.ProviderStatus {
+	status := &provider.ProviderStatus{
+		Exists:   true,
+		Ready:    d.Status.ReadyReplicas > 0,
+		State:    "starting",
+		Hostname: db.Name,
+		Port:     5432,
+	}
+	if containers := d.Spec.Template.Spec.Containers; len(containers) > 0 {
+		status.EngineVersion = containers[0].Image[strings.LastIndex(containers[0].Image, ":")+1:]
+	}
+	switch {
+	case d.Spec.Replicas != nil && *d.Spec.Replicas == 0:
+		status.State = "stopped"
+	case status.Ready:
+		status.State = "available"
+	}
+	return status
 }
 
 const (
@@ -104,6 +156,7 @@ func (l *Local) createPVC(ctx context.Context, name, namespace string, size int6
 
 	pvc.Annotations = map[string]string{
 		"repository": "https://github.com/sorenmat/k8s-rds",
+		"origin":     "k8s-rds",
 	}
 
 	storageClass := "default"
@@ -187,8 +240,8 @@ const (
 	nDeleteAttempts = 20
 )
 
-// DeleteDatabase deletes the db pod and pvc
-func (l *Local) DeleteDatabase(ctx context.Context, db *crd.Database) error {
+// Delete deletes the db pod and pvc
+func (l *Local) Delete(ctx context.Context, db *crd.Database) error {
 	// delete the database instance
 
 	for i := 0; i < nDeleteAttempts; i++ {
@@ -214,6 +267,63 @@ func (l *Local) DeleteDatabase(ctx context.Context, db *crd.Database) error {
 
 func int32Ptr(i int32) *int32 { return &i }
 
+// majorVersion returns the major part of a version, ex: 9.6 for postgres 9.6.20 and 13 for postgres 13.4
+func majorVersion(engine, version string) string {
+	parts := strings.Split(version, ".")
+	if engine == "postgres" {
+		if n, err := strconv.Atoi(parts[0]); err == nil && n >= 10 {
+			return parts[0]
+		}
+	}
+	if len(parts) > 1 {
+		return parts[0] + "." + parts[1]
+	}
+	return parts[0]
+}
+
+// checkVersionChange refuses to change the major version of a running database, the new server can't
+// read the data directory of the old one. The data has to be moved with a dump and restore instead.
+func checkVersionChange(d *v1.Deployment, db *crd.Database) error {
+	for _, c := range d.Spec.Template.Spec.Containers {
+		current := c.Image[strings.LastIndex(c.Image, ":")+1:]
+		wanted := db.Spec.Version
+		if current == "latest" || wanted == "" || current == c.Image {
+			continue
+		}
+		if majorVersion(db.Spec.Engine, current) != majorVersion(db.Spec.Engine, wanted) {
+			return fmt.Errorf("the local provider doesn't support major version upgrades from %v to %v, "+
+				"dump the database and restore it into a new one", current, wanted)
+		}
+	}
+	return nil
+}
+
+// serverArgs renders the parameters from the spec as command line flags for the database server
+func serverArgs(db *crd.Database) []string {
+	if len(db.Spec.Parameters) == 0 {
+		return nil
+	}
+	names := make([]string, 0, len(db.Spec.Parameters))
+	for k := range db.Spec.Parameters {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+
+	var args []string
+	switch db.Spec.Engine {
+	case "mysql", "mariadb":
+		for _, k := range names {
+			args = append(args, fmt.Sprintf("--%v=%v", k, db.Spec.Parameters[k]))
+		}
+	default:
+		args = append(args, "postgres")
+		for _, k := range names {
+			args = append(args, "-c", fmt.Sprintf("%v=%v", k, db.Spec.Parameters[k]))
+		}
+	}
+	return args
+}
+
 func toSpec(db *crd.Database, repository string) v1.DeploymentSpec {
 	version := db.Spec.Version
 	if version == "" {
@@ -242,6 +352,7 @@ func toSpec(db *crd.Database, repository string) v1.DeploymentSpec {
 					{
 						Name:  db.Name,
 						Image: image, // TODO is this correct
+						Args:  serverArgs(db),
 						Env: []corev1.EnvVar{corev1.EnvVar{
 							Name: "POSTGRES_PASSWORD",
 							ValueFrom: &corev1.EnvVarSource{
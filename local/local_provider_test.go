@@ -58,9 +58,9 @@ func TestCreateDatabase(t *testing.T) {
 	assert.NoError(t, err)
 	// we need it to not wait for status
 	l.SkipWaiting = true
-	host, err := l.CreateDatabase(context.Background(), db)
+	status, err := l.Ensure(context.Background(), db)
 	assert.NoError(t, err)
-	assert.NotEmpty(t, host)
+	assert.Equal(t, "mydb", status.Hostname)
 
 	sequence := []struct {
 		Action   string
@@ -120,11 +120,11 @@ func TestUpdateDatabase(t *testing.T) {
 	assert.NoError(t, err)
 	// we need it to not wait for status
 	l.SkipWaiting = true
-	host, err := l.CreateDatabase(context.Background(), db)
+	status, err := l.Ensure(context.Background(), db)
 	assert.NoError(t, err)
-	assert.NotEmpty(t, host)
+	assert.Equal(t, "mydb", status.Hostname)
 	assert.Equal(t, 4, len(kc.Fake.Actions()))
-	_, err = l.CreateDatabase(context.Background(), db)
+	_, err = l.Ensure(context.Background(), db)
 	assert.NoError(t, err)
 	assert.Equal(t, 8, len(kc.Fake.Actions()))
 
@@ -182,3 +182,121 @@ func TestUpdateDatabase(t *testing.T) {
 		assert.Equal(t, sequence[i].Resource, action.GetResource().GroupResource().Resource)
 	}
 }
+
+func TestServerArgs(t *testing.T) {
+	db := &crd.Database{
+		ObjectMeta: meta_v1.ObjectMeta{Name: "mydb"},
+		Spec: crd.DatabaseSpec{
+			Engine: "postgres",
+			Parameters: map[string]string{
+				"max_connections":          "200",
+				"shared_preload_libraries": "pg_stat_statements",
+			},
+		},
+	}
+	assert.Equal(t, []string{"postgres", "-c", "max_connections=200", "-c", "shared_preload_libraries=pg_stat_statements"}, serverArgs(db))
+
+	db.Spec.Engine = "mysql"
+	assert.Equal(t, []string{"--max_connections=200", "--shared_preload_libraries=pg_stat_statements"}, serverArgs(db))
+
+	db.Spec.Parameters = nil
+	assert.Nil(t, serverArgs(db))
+
+	spec := toSpec(db, "")
+	assert.Nil(t, spec.Template.Spec.Containers[0].Args)
+}
+
+func TestAlterUserSQL(t *testing.T) {
+	assert.Equal(t, "ALTER USER \"myuser\" WITH PASSWORD 'secret';\n", alterUserSQL("myuser", "secret"))
+	assert.Equal(t, "ALTER USER \"my\"\"user\" WITH PASSWORD 'it''s';\n", alterUserSQL("my\"user", "it's"))
+}
+
+func TestUpdatePasswordUnsupportedEngine(t *testing.T) {
+	db := &crd.Database{
+		ObjectMeta: meta_v1.ObjectMeta{Name: "mydb"},
+		Spec:       crd.DatabaseSpec{Engine: "mysql"},
+	}
+	l, err := New(db, testclient.NewSimpleClientset(), "")
+	assert.NoError(t, err)
+	assert.Error(t, l.UpdatePassword(context.Background(), db, "secret"))
+}
+
+func TestMajorVersion(t *testing.T) {
+	assert.Equal(t, "9.6", majorVersion("postgres", "9.6.20"))
+	assert.Equal(t, "9.6", majorVersion("postgres", "9.6"))
+	assert.Equal(t, "13", majorVersion("postgres", "13.4"))
+	assert.Equal(t, "13", majorVersion("postgres", "13"))
+	assert.Equal(t, "5.7", majorVersion("mysql", "5.7.40"))
+	assert.Equal(t, "8.0", majorVersion("mysql", "8.0"))
+}
+
+func TestUpdateDatabaseRefusesMajorUpgrade(t *testing.T) {
+	db := &crd.Database{
+		ObjectMeta: meta_v1.ObjectMeta{Name: "mydb"},
+		Spec: crd.DatabaseSpec{
+			DBName:   "mydb",
+			Engine:   "postgres",
+			Version:  "9.6",
+			Username: "myuser",
+			Size:     100,
+			Password: v1.SecretKeySelector{LocalObjectReference: v1.LocalObjectReference{Name: "password"}, Key: "mypassword"},
+		},
+	}
+	l, err := New(db, testclient.NewSimpleClientset(), "")
+	assert.NoError(t, err)
+	l.SkipWaiting = true
+	_, err = l.Ensure(context.Background(), db)
+	assert.NoError(t, err)
+
+	db.Spec.Version = "9.6.24"
+	status, err := l.Ensure(context.Background(), db)
+	assert.NoError(t, err, "minor upgrades are allowed")
+	assert.Equal(t, "9.6.24", status.EngineVersion)
+
+	db.Spec.Version = "13"
+	_, err = l.Ensure(context.Background(), db)
+	assert.Error(t, err)
+}
+
+func TestScheduledDatabaseIsScaledDown(t *testing.T) {
+	db := &crd.Database{
+		ObjectMeta: meta_v1.ObjectMeta{Name: "mydb"},
+		Spec: crd.DatabaseSpec{
+			DBName:   "mydb",
+			Engine:   "postgres",
+			Username: "myuser",
+			Size:     10,
+			Password: v1.SecretKeySelector{LocalObjectReference: v1.LocalObjectReference{Name: "password"}, Key: "mypassword"},
+			// stopped every minute and never started
+			Schedule: &crd.ScheduleSpec{Start: "0 0 31 2 *", Stop: "* * * * *"},
+		},
+	}
+	kc := testclient.NewSimpleClientset()
+	l, err := New(db, kc, "")
+	assert.NoError(t, err)
+	l.SkipWaiting = true
+	_, err = l.Ensure(context.Background(), db)
+	assert.NoError(t, err)
+	assert.True(t, db.Status.Stopped)
+
+	d, err := kc.AppsV1().Deployments("").Get(context.Background(), "mydb", meta_v1.GetOptions{})
+	assert.NoError(t, err)
+	assert.Equal(t, int32(0), *d.Spec.Replicas)
+	status, err := l.Describe(context.Background(), db)
+	assert.NoError(t, err)
+	assert.Equal(t, "stopped", status.State)
+	assert.False(t, status.Ready)
+
+	db.Spec.Schedule = nil
+	_, err = l.Ensure(context.Background(), db)
+	assert.NoError(t, err)
+	assert.False(t, db.Status.Stopped)
+	d, err = kc.AppsV1().Deployments("").Get(context.Background(), "mydb", meta_v1.GetOptions{})
+	assert.NoError(t, err)
+	assert.Equal(t, int32(1), *d.Spec.Replicas)
+
+	assert.NoError(t, l.Delete(context.Background(), db))
+	status, err = l.Describe(context.Background(), db)
+	assert.NoError(t, err)
+	assert.False(t, status.Exists)
+}
@@ -2,18 +2,35 @@ package main
 
 import (
 	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
 	"fmt"
 	"log"
+	"reflect"
+	"strings"
+	"sync"
 	"time"
 
+	"github.com/sorenmat/k8s-rds/azure"
 	"github.com/sorenmat/k8s-rds/client"
 	"github.com/sorenmat/k8s-rds/crd"
+	"github.com/sorenmat/k8s-rds/fake"
+	"github.com/sorenmat/k8s-rds/gcp"
 	"github.com/sorenmat/k8s-rds/kube"
 	"github.com/sorenmat/k8s-rds/local"
+	"github.com/sorenmat/k8s-rds/plugin"
+	"github.com/sorenmat/k8s-rds/pooler"
 	"github.com/sorenmat/k8s-rds/provider"
 	"github.com/sorenmat/k8s-rds/rds"
 	"github.com/spf13/cobra"
+	"google.golang.org/grpc"
+	v1 "k8s.io/api/core/v1"
 	apiextcs "k8s.io/apiextensions-apiserver/pkg/client/clientset/clientset"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/rest"
 	"k8s.io/client-go/tools/cache"
@@ -23,6 +40,87 @@ import (
 
 const Failed = "Failed"
 
+var (
+	// awsRegion is the region set with --aws-region, it takes precedence over the discovered region
+	awsRegion string
+	// clusterName is set with --cluster-name and tagged on the RDS instances
+	clusterName string
+
+	// the AWS environment is discovered once and shared by all databases using the aws provider
+	awsEnvLock sync.Mutex
+	awsEnv     *rds.Environment
+
+	// gcpProject, gcpRegion and gcpNetwork are set with the --gcp flags, the project and region are discovered when empty
+	gcpProject string
+	gcpRegion  string
+	gcpNetwork string
+
+	gcpEnvLock sync.Mutex
+	gcpEnv     *gcp.Environment
+
+	// azureSubscription, azureResourceGroup and azureLocation are set with the --azure flags, the subscription and
+	// location are discovered when empty
+	azureSubscription  string
+	azureResourceGroup string
+	azureLocation      string
+
+	azureEnvLock sync.Mutex
+	azureEnv     *azure.Environment
+
+	// fakeStore holds the databases of the fake provider for the life of the operator, fakeOptions are set with the
+	// --fake flags
+	fakeStore   = fake.NewStore()
+	fakeOptions fake.Options
+
+	// plugins are the providers registered with --provider-plugin, by name. They're connected to the first time
+	// they're used.
+	plugins     map[string]string
+	pluginTLS   plugin.TLSOptions
+	pluginLock  sync.Mutex
+	pluginConns = map[string]*grpc.ClientConn{}
+	// pluginTimeout is the time the plugins have to answer a call
+	pluginTimeout time.Duration
+
+	// newKubectl returns the client the providers and the pooler use, the tests replace it with a fake one
+	newKubectl = func() (kubernetes.Interface, error) { return getKubectl() }
+)
+
+// builtinProviders can't be replaced by plugins
+var builtinProviders = []string{"aws", "azure", "gcp", "local", "fake"}
+
+// parsePlugins reads the name=address values of --provider-plugin
+func parsePlugins(values []string) (map[string]string, error) {
+	result := map[string]string{}
+	for _, v := range values {
+		kv := strings.SplitN(v, "=", 2)
+		if len(kv) != 2 || kv[0] == "" || kv[1] == "" {
+			return nil, fmt.Errorf("invalid provider plugin %v, the format is name=address", v)
+		}
+		if stringInSlice(kv[0], builtinProviders) {
+			return nil, fmt.Errorf("the plugin %v has the name of a built-in provider", kv[0])
+		}
+		result[kv[0]] = kv[1]
+	}
+	return result, nil
+}
+
+// getPluginConn returns the connection to the plugin, it's dialed (and started) the first time. The connection
+// reconnects by itself when the plugin is restarted.
+func getPluginConn(name, address string) (*grpc.ClientConn, error) {
+	pluginLock.Lock()
+	defer pluginLock.Unlock()
+	if conn, ok := pluginConns[name]; ok {
+		return conn, nil
+	}
+	conn, err := plugin.Dial(name, address, pluginTLS)
+	if err != nil {
+		return nil, err
+	}
+	log.Printf("Using provider plugin %v at %v\n", name, address)
+	pluginConns[name] = conn
+	return conn, nil
+}
+
 // return rest config, if path not specified assume in cluster config
 func getClientConfig(kubeconfig string) (*rest.Config, error) {
 	cfg, err := rest.InClusterConfig()
@@ -59,19 +157,53 @@ func main() {
 		excludeNamespaces []string
 		includeNamespaces []string
 		repository        string
+		pluginValues      []string
 	)
 	var rootCmd = &cobra.Command{
 		Use:   "k8s-rds",
 		Short: "Kubernetes database provisioner",
 		Long:  `Kubernetes database provisioner`,
+		PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+			var err error
+			plugins, err = parsePlugins(pluginValues)
+			return err
+		},
 		Run: func(cmd *cobra.Command, args []string) {
 			execute(_provider, excludeNamespaces, includeNamespaces, repository)
 		},
 	}
-	rootCmd.PersistentFlags().StringVar(&_provider, "provider", "aws", "Type of provider (aws, local)")
+	rootCmd.PersistentFlags().StringVar(&_provider, "provider", "aws", "Type of provider (aws, azure, gcp, local, fake, or the name of a plugin)")
 	rootCmd.PersistentFlags().StringSliceVar(&excludeNamespaces, "exclude-namespaces", nil, "list of namespaces to exclude. Mutually exclusive with --include-namespaces.")
 	rootCmd.PersistentFlags().StringSliceVar(&includeNamespaces, "include-namespaces", nil, "list of namespaces to include. Mutually exclusive with --exclude-namespaces.")
 	rootCmd.PersistentFlags().StringVar(&repository, "repository", "", "Docker image repository, default is hub.docker.com)")
+	rootCmd.PersistentFlags().StringArrayVar(&pluginValues, "provider-plugin", nil, "Provider served by a gRPC plugin as name=address, the address is host:port, unix:///path or exec:/path/to/command. Can be repeated")
+	rootCmd.PersistentFlags().DurationVar(&pluginTimeout, "provider-plugin-timeout", 5*time.Minute, "Time the plugins have to answer a call, 0 waits forever")
+	rootCmd.PersistentFlags().StringVar(&pluginTLS.CAFile, "plugin-tls-ca", "", "CA certificate of the plugins that aren't on a unix socket or a loopback address, default is the roots of the system")
+	rootCmd.PersistentFlags().StringVar(&pluginTLS.CertFile, "plugin-tls-cert", "", "Client certificate for the plugins requiring mutual TLS")
+	rootCmd.PersistentFlags().StringVar(&pluginTLS.KeyFile, "plugin-tls-key", "", "Key of the client certificate for the plugins")
+	rootCmd.PersistentFlags().StringVar(&awsRegion, "aws-region", "", "AWS region, default is discovered from the nodes, the instance metadata or the SDK configuration")
+	rootCmd.PersistentFlags().StringVar(&clusterName, "cluster-name", "", "Name of the cluster tagged on the RDS instances, default is taken from the tags of the nodes")
+	rootCmd.PersistentFlags().StringVar(&gcpProject, "gcp-project", "", "GCP project of the Cloud SQL instances, default is the project of the cluster")
+	rootCmd.PersistentFlags().StringVar(&gcpRegion, "gcp-region", "", "GCP region of the Cloud SQL instances, default is the region of the cluster")
+	rootCmd.PersistentFlags().StringVar(&azureSubscription, "azure-subscription", "", "Azure subscription of the flexible servers, default is the subscription of the cluster")
+	rootCmd.PersistentFlags().StringVar(&azureResourceGroup, "azure-resource-group", "", "Azure resource group of the flexible servers, can be set per database with spec.azure.resourceGroup")
+	rootCmd.PersistentFlags().StringVar(&azureLocation, "azure-location", "", "Azure location of the flexible servers, default is the location of the cluster")
+	rootCmd.PersistentFlags().DurationVar(&fakeOptions.Delay, "fake-delay", 0, "How long the operations of the fake provider take, can be set per database with the k8s-rds.io/fake-delay annotation")
+	rootCmd.PersistentFlags().StringSliceVar(&fakeOptions.Fail, "fake-fail", nil, "Operations of the fake provider that fail (create, update, password, delete), can be set per database with the k8s-rds.io/fake-fail annotation")
+	rootCmd.PersistentFlags().StringVar(&gcpNetwork, "gcp-network", "", "VPC network of the private IPs of the Cloud SQL instances, ex: default")
+
+	dryRun := true
+	var gcCmd = &cobra.Command{
+		Use:   "gc",
+		Short: "Find the resources left behind by deleted databases",
+		Long:  `Lists the RDS instances, subnet, parameter and security groups, deployments, volumes and services created for databases that don't exist anymore, and deletes them with --dry-run=false`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return gc(_provider, excludeNamespaces, includeNamespaces, dryRun)
+		},
+	}
+	gcCmd.Flags().BoolVar(&dryRun, "dry-run", true, "only list the orphans")
+	rootCmd.AddCommand(gcCmd)
+
 	if len(excludeNamespaces) > 0 && len(includeNamespaces) > 0 {
 		panic("--include-namespaces and --exclude-namespaces are mutually exclusive")
 	}
@@ -107,10 +239,20 @@ func execute(dbprovider string, excludeNamespaces, includeNamespaces []string, r
 		panic(err)
 	}
 
+	if dbprovider == "aws" {
+		kubectl, err := getKubectl()
+		if err != nil {
+			panic(err)
+		}
+		if _, err := getAWSEnvironment(context.Background(), kubectl); err != nil {
+			panic(err)
+		}
+	}
+
 	// Create a CRD client interface
 	crdclient := client.CrdClient(crdcs, scheme, "")
 	log.Println("Watching for database changes...")
-	_, controller := cache.NewInformer(
+	store, controller := cache.NewInformer(
 		crdclient.NewListWatch(),
 		&crd.Database{},
 		time.Minute*2,
@@ -121,14 +263,7 @@ func execute(dbprovider string, excludeNamespaces, includeNamespaces []string, r
 					return
 				}
 				_client := client.CrdClient(crdcs, scheme, db.Namespace) // add the database namespace to the client
-				err = handleCreateDatabase(context.Background(), db, _client, dbprovider, repository)
-				if err != nil {
-					log.Printf("database creation failed: %v", err)
-					err := updateStatus(context.Background(), db, crd.DatabaseStatus{Message: fmt.Sprintf("%v", err), State: Failed}, _client)
-					if err != nil {
-						log.Printf("database CRD status update failed: %v", err)
-					}
-				}
+				handleDatabase(context.Background(), db, _client, dbprovider, repository)
 			},
 			DeleteFunc: func(obj interface{}) {
 				ctx := context.Background()
@@ -145,7 +280,7 @@ func execute(dbprovider string, excludeNamespaces, includeNamespaces []string, r
 					return
 				}
 
-				err = r.DeleteDatabase(ctx, db)
+				err = r.Delete(ctx, db)
 				if err != nil {
 					log.Println(err)
 				}
@@ -154,22 +289,85 @@ func execute(dbprovider string, excludeNamespaces, includeNamespaces []string, r
 				if err != nil {
 					log.Println(err)
 				}
+
+				kubectl, err := getKubectl()
+				if err == nil {
+					err = pooler.Delete(ctx, kubectl, db)
+				}
+				if err != nil {
+					log.Println(err)
+				}
 				log.Printf("Deletion of database %v done\n", db.Name)
 			},
 			UpdateFunc: func(oldObj, newObj interface{}) {
+				db := newObj.(*crd.Database)
+				if excluded(db, excludeNamespaces, includeNamespaces) {
+					return
+				}
+				if (db.Status.State == "" || db.Status.State == "Creating") && !resync(oldObj.(*crd.Database), db) {
+					// the creation is in progress, it's checked again on the next resync
+					return
+				}
+				if db.Status.State != "Created" && !retryCreation(oldObj.(*crd.Database), db) {
+					return
+				}
+				_client := client.CrdClient(crdcs, scheme, db.Namespace) // add the database namespace to the client
+				handleDatabase(context.Background(), db, _client, dbprovider, repository)
 			},
 		},
 	)
 
+	kubectl, err := getKubectl()
+	if err != nil {
+		panic(err)
+	}
+	log.Println("Watching for password secret changes...")
 	stop := make(chan struct{})
 	go controller.Run(stop)
 
+	// the Database resync compares the passwords as well, the watch makes the changes apply right away
+	namespaces := includeNamespaces
+	if len(namespaces) == 0 {
+		namespaces = []string{metav1.NamespaceAll}
+	}
+	secretChanged := func(obj interface{}) {
+		secret := obj.(*v1.Secret)
+		for _, db := range databasesUsingSecret(store, secret) {
+			if excluded(db, excludeNamespaces, includeNamespaces) {
+				continue
+			}
+			password := string(secret.Data[db.Spec.Password.Key])
+			if db.Status.PasswordHash == passwordHash(db, password) {
+				continue
+			}
+			_client := client.CrdClient(crdcs, scheme, db.Namespace) // add the database namespace to the client
+			err := handlePasswordChange(context.Background(), db, _client, dbprovider, repository, password)
+			if err != nil {
+				log.Printf("password change of database %v failed: %v", db.Name, err)
+			}
+		}
+	}
+	for _, namespace := range namespaces {
+		_, secretController := cache.NewInformer(
+			cache.NewListWatchFromClient(kubectl.CoreV1().RESTClient(), "secrets", namespace, secretSelector(excludeNamespaces)),
+			&v1.Secret{},
+			0,
+			cache.ResourceEventHandlerFuncs{
+				AddFunc: secretChanged,
+				UpdateFunc: func(oldObj, newObj interface{}) {
+					secretChanged(newObj)
+				},
+			},
+		)
+		go secretController.Run(stop)
+	}
+
 	// Wait forever
 	select {}
 }
 
 func getProvider(db *crd.Database, dbprovider, repository string) (provider.DatabaseProvider, error) {
-	kubectl, err := getKubectl()
+	kubectl, err := newKubectl()
 	if err != nil {
 		log.Println(err)
 		return nil, err
@@ -178,9 +376,42 @@ func getProvider(db *crd.Database, dbprovider, repository string) (provider.Data
 	if db.Spec.Provider != "" {
 		_provider = db.Spec.Provider
 	}
+	if address, ok := plugins[_provider]; ok {
+		conn, err := getPluginConn(_provider, address)
+		if err != nil {
+			return nil, err
+		}
+		return plugin.New(_provider, conn, kubectl, pluginTimeout), nil
+	}
 	switch _provider {
 	case "aws":
-		r, err := rds.New(context.Background(), db, kubectl)
+		env, err := getAWSEnvironment(context.Background(), kubectl)
+		if err != nil {
+			return nil, err
+		}
+		r, err := rds.New(context.Background(), db, kubectl, env)
+		if err != nil {
+			return nil, err
+		}
+		return r, nil
+
+	case "azure":
+		env, err := getAzureEnvironment(context.Background())
+		if err != nil {
+			return nil, err
+		}
+		r, err := azure.New(db, kubectl, env)
+		if err != nil {
+			return nil, err
+		}
+		return r, nil
+
+	case "gcp":
+		env, err := getGCPEnvironment(context.Background())
+		if err != nil {
+			return nil, err
+		}
+		r, err := gcp.New(db, kubectl, env)
 		if err != nil {
 			return nil, err
 		}
@@ -192,51 +423,301 @@ func getProvider(db *crd.Database, dbprovider, repository string) (provider.Data
 			return nil, err
 		}
 		return r, nil
+
+	case "fake":
+		r, err := fake.New(db, kubectl, fakeStore, fakeOptions)
+		if err != nil {
+			return nil, err
+		}
+		return r, nil
 	}
 	return nil, fmt.Errorf("unable to find provider for %v", dbprovider)
 }
 
-func handleCreateDatabase(ctx context.Context, db *crd.Database, crdclient *client.Crdclient, dbprovider, repository string) error {
-	// we don't need to skip when it is a local provider without running pod
-	if db.Status.State == "Created" && dbprovider == "aws" {
-		log.Printf("database %v already created, skipping\n", db.Name)
-		return nil
+// getAzureEnvironment discovers the Azure subscription and location the first time it's called
+func getAzureEnvironment(ctx context.Context) (*azure.Environment, error) {
+	azureEnvLock.Lock()
+	defer azureEnvLock.Unlock()
+	if azureEnv != nil {
+		return azureEnv, nil
 	}
-	// validate dbname is only alpha numeric
-	// This check is needed in case local provider with already created db
-	if db.Status.State != "Created" {
-		err := updateStatus(context.Background(), db, crd.DatabaseStatus{Message: "Creating", State: "Creating"}, crdclient)
-		if err != nil {
+	env, err := azure.Discover(ctx, azureSubscription, azureResourceGroup, azureLocation)
+	if err != nil {
+		return nil, err
+	}
+	log.Printf("Using Azure subscription %v in %v\n", env.SubscriptionID, env.Location)
+	azureEnv = env
+	return azureEnv, nil
+}
+
+// getGCPEnvironment discovers the GCP project and region the first time it's called
+func getGCPEnvironment(ctx context.Context) (*gcp.Environment, error) {
+	gcpEnvLock.Lock()
+	defer gcpEnvLock.Unlock()
+	if gcpEnv != nil {
+		return gcpEnv, nil
+	}
+	env, err := gcp.Discover(ctx, gcpProject, gcpRegion, gcpNetwork)
+	if err != nil {
+		return nil, err
+	}
+	log.Printf("Using GCP project %v in %v\n", env.Project, env.Region)
+	gcpEnv = env
+	return gcpEnv, nil
+}
+
+// getAWSEnvironment discovers the AWS region and instance the first time it's called
+func getAWSEnvironment(ctx context.Context, kubectl kubernetes.Interface) (*rds.Environment, error) {
+	awsEnvLock.Lock()
+	defer awsEnvLock.Unlock()
+	if awsEnv != nil {
+		return awsEnv, nil
+	}
+	env, err := rds.Discover(ctx, kubectl, awsRegion)
+	if err != nil {
+		return nil, err
+	}
+	env.ClusterName = clusterName
+	awsEnv = env
+	return awsEnv, nil
+}
+
+// reconcile brings the database in line with its spec: the provider ensures the database, the service points at
+// the address it reports and the pooler follows
+func reconcile(ctx context.Context, db *crd.Database, crdclient *client.Crdclient, dbprovider, repository string) error {
+	// the object is shared with the informer cache, so the provider gets a copy to update the status on
+	current := *db
+	db = &current
+	status := db.Status
+	if db.Status.State == "" {
+		db.Status.State = "Creating"
+		db.Status.Message = "Creating"
+		if err := updateStatus(ctx, db, db.Status, crdclient); err != nil {
 			return fmt.Errorf("database CRD status update failed: %v", err)
 		}
+		status = db.Status
 	}
 
-	log.Println("trying to get kubectl")
-
 	r, err := getProvider(db, dbprovider, repository)
 	if err != nil {
 		return err
 	}
-
-	hostname, err := r.CreateDatabase(ctx, db)
+	ps, err := r.Ensure(ctx, db)
 	if err != nil {
 		return err
 	}
+	if !ps.Exists {
+		return fmt.Errorf("database %v doesn't exist after it was ensured", db.Name)
+	}
+	if stillCreating(db, ps) {
+		// the creation is still in progress, the database is reconciled again on the next resync
+		log.Printf("database %v isn't ready yet, it's %v\n", db.Name, ps.State)
+		if ps.Hostname != "" {
+			if err := r.CreateService(ctx, db.Namespace, ps.Hostname, db.Name); err != nil {
+				return err
+			}
+		}
+		db.Status.State = "Creating"
+		db.Status.Message = fmt.Sprintf("The database is %v", ps.State)
+		providerStatus(db, ps)
+		if !statusChanged(status, db.Status) {
+			return nil
+		}
+		return updateStatus(ctx, db, db.Status, crdclient)
+	}
+	if ps.Hostname == "" {
+		return fmt.Errorf("database %v doesn't have an address, it's %v", db.Name, ps.State)
+	}
 
-	log.Printf("Creating service '%v' for %v\n", db.Name, hostname)
-	err = r.CreateService(ctx, db.Namespace, hostname, db.Name)
+	// a password changed while the operator wasn't watching is applied on the resync
+	password, err := r.GetSecret(ctx, db.Namespace, db.Spec.Password.Name, db.Spec.Password.Key)
 	if err != nil {
+		log.Printf("unable to compare the password of database %v: %v", db.Name, err)
+	} else if err := syncPassword(ctx, db, r, password); err != nil {
+		return err
+	}
+
+	if db.Status.State != "Created" {
+		log.Printf("Creating service '%v' for %v\n", db.Name, ps.Hostname)
+	}
+	if err := r.CreateService(ctx, db.Namespace, ps.Hostname, db.Name); err != nil {
+		return err
+	}
+	if err := ensurePooler(ctx, db, repository); err != nil {
 		return err
 	}
 
-	err = updateStatus(context.Background(), db, crd.DatabaseStatus{Message: "Created", State: "Created"}, crdclient)
+	if db.Status.State != "Created" {
+		log.Printf("Creation of database %v done\n", db.Name)
+	}
+	db.Status.State = "Created"
+	db.Status.Message = "Created"
+	providerStatus(db, ps)
+	if !statusChanged(status, db.Status) {
+		return nil
+	}
+	return updateStatus(ctx, db, db.Status, crdclient)
+}
+
+// providerStatus reports the status of the provider on the database, with the Ready condition
+func providerStatus(db *crd.Database, ps *provider.ProviderStatus) {
+	if ps.EngineVersion != "" {
+		db.Status.EngineVersion = ps.EngineVersion
+	}
+	condition := metav1.Condition{
+		Type:               crd.ConditionReady,
+		Status:             metav1.ConditionTrue,
+		Reason:             "Available",
+		Message:            fmt.Sprintf("The database is %v", ps.State),
+		ObservedGeneration: db.Generation,
+	}
+	if !ps.Ready {
+		condition.Status = metav1.ConditionFalse
+		condition.Reason = "NotAvailable"
+	}
+	// the conditions are shared with the object in the informer cache, they're updated on a copy
+	db.Status.Conditions = append([]metav1.Condition(nil), db.Status.Conditions...)
+	meta.SetStatusCondition(&db.Status.Conditions, condition)
+}
+
+// stillCreating tells if the database isn't created yet and the provider doesn't report it ready, it stays Creating
+func stillCreating(db *crd.Database, ps *provider.ProviderStatus) bool {
+	return db.Status.State != "Created" && (!ps.Ready || ps.Hostname == "")
+}
+
+// failedStatus returns the status of a database that failed to be created. The rest of the status is kept, it
+// describes what was created so far.
+func failedStatus(status crd.DatabaseStatus, err error) crd.DatabaseStatus {
+	status.State = Failed
+	status.Message = fmt.Sprintf("%v", err)
+	return status
+}
+
+// resync tells if the update is a resync of the informer, the database didn't change
+func resync(old, db *crd.Database) bool {
+	return old.ResourceVersion == db.ResourceVersion
+}
+
+// retryCreation tells if a failed database is created again: on a resync, or when it's changed. Writing the error on
+// the status doesn't retry it, or it would never stop failing.
+func retryCreation(old, db *crd.Database) bool {
+	if resync(old, db) {
+		return true
+	}
+	return !reflect.DeepEqual(old.Spec, db.Spec) || !reflect.DeepEqual(old.Annotations, db.Annotations) ||
+		!reflect.DeepEqual(old.Labels, db.Labels)
+}
+
+// handleDatabase reconciles the database, a database that isn't created yet is failed when it doesn't work out
+func handleDatabase(ctx context.Context, db *crd.Database, crdclient *client.Crdclient, dbprovider, repository string) {
+	err := reconcile(ctx, db, crdclient, dbprovider, repository)
+	if err == nil {
+		return
+	}
+	if db.Status.State == "Created" {
+		// it keeps running as it is, the change is retried on the next update
+		log.Printf("database update failed: %v", err)
+		return
+	}
+	log.Printf("database creation failed: %v", err)
+	status := failedStatus(db.Status, err)
+	if !statusChanged(db.Status, status) {
+		return
+	}
+	if err := updateStatus(ctx, db, status, crdclient); err != nil {
+		log.Printf("database CRD status update failed: %v", err)
+	}
+}
+
+// ensurePooler creates, updates or deletes the connection pooler of the database
+func ensurePooler(ctx context.Context, db *crd.Database, repository string) error {
+	kubectl, err := newKubectl()
 	if err != nil {
 		return err
 	}
-	log.Printf("Creation of database %v done\n", db.Name)
+	return pooler.Ensure(ctx, kubectl, db, repository)
+}
+
+// statusChanged compares the statuses the way they are stored, so timestamps read back from the
+// API server are equal to the ones the provider reported
+func statusChanged(a, b crd.DatabaseStatus) bool {
+	x, errA := json.Marshal(a)
+	y, errB := json.Marshal(b)
+	return errA != nil || errB != nil || string(x) != string(y)
+}
+
+// databasesUsingSecret returns the created databases that take their password from the secret
+func databasesUsingSecret(store cache.Store, secret *v1.Secret) []*crd.Database {
+	var result []*crd.Database
+	for _, obj := range store.List() {
+		db := obj.(*crd.Database)
+		if db.Namespace == secret.Namespace && db.Spec.Password.Name == secret.Name && db.Status.State == "Created" {
+			result = append(result, db)
+		}
+	}
+	return result
+}
+
+// secretSelector leaves the excluded namespaces and the service account tokens out of the watch of the secrets
+func secretSelector(excludeNamespaces []string) fields.Selector {
+	selectors := []fields.Selector{fields.OneTermNotEqualSelector("type", string(v1.SecretTypeServiceAccountToken))}
+	for _, namespace := range excludeNamespaces {
+		selectors = append(selectors, fields.OneTermNotEqualSelector("metadata.namespace", namespace))
+	}
+	return fields.AndSelectors(selectors...)
+}
+
+// passwordHash is the hash of the password recorded in the status. It's keyed with the UID of the database, so
+// databases with the same password don't have the same hash.
+func passwordHash(db *crd.Database, password string) string {
+	mac := hmac.New(sha256.New, []byte(db.UID))
+	mac.Write([]byte(password))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// syncPassword applies the password to the database unless it's the one applied last. The first password recorded is
+// the one the database was created with, so it isn't applied again.
+func syncPassword(ctx context.Context, db *crd.Database, r provider.DatabaseProvider, password string) error {
+	hash := passwordHash(db, password)
+	if db.Status.PasswordHash == hash {
+		return nil
+	}
+	if db.Status.PasswordHash != "" {
+		updater, ok := r.(provider.PasswordUpdater)
+		if !ok {
+			log.Printf("the provider of database %v doesn't support changing the password", db.Name)
+		} else if err := updater.UpdatePassword(ctx, db, password); err != nil {
+			return err
+		}
+	}
+	db.Status.PasswordHash = hash
 	return nil
 }
 
+// handlePasswordChange sets the new password on the database, if the provider supports it
+func handlePasswordChange(ctx context.Context, db *crd.Database, crdclient *client.Crdclient, dbprovider, repository, password string) error {
+	r, err := getProvider(db, dbprovider, repository)
+	if err != nil {
+		return err
+	}
+
+	// the object is shared with the informer cache, so the provider gets a copy to update the status on
+	current := *db
+	db = &current
+	status := db.Status
+	if err := syncPassword(ctx, db, r, password); err != nil {
+		return err
+	}
+	if !statusChanged(status, db.Status) {
+		return nil
+	}
+	// the pooler reads the password when it starts, its pods are rolled
+	if err := ensurePooler(ctx, db, repository); err != nil {
+		return err
+	}
+	return updateStatus(ctx, db, db.Status, crdclient)
+}
+
 func updateStatus(ctx context.Context, db *crd.Database, status crd.DatabaseStatus, crdclient *client.Crdclient) error {
 	db, err := crdclient.Get(ctx, db.Name)
 	if err != nil {
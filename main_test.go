@@ -1,11 +1,29 @@
 package main
 
 import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path"
 	"strconv"
+	"sync"
 	"testing"
+	"time"
 
+	"github.com/sorenmat/k8s-rds/client"
 	"github.com/sorenmat/k8s-rds/crd"
+	"github.com/sorenmat/k8s-rds/fake"
+	"github.com/sorenmat/k8s-rds/provider"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/client-go/kubernetes"
+	kubefake "k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/cache"
 )
 
 func TestExcluded(t *testing.T) {
@@ -86,3 +104,342 @@ func TestStringInSlice(t *testing.T) {
 		})
 	}
 }
+
+func TestStatusChanged(t *testing.T) {
+	rotated := time.Date(2021, 4, 1, 10, 30, 0, 0, time.UTC)
+	a := crd.DatabaseStatus{State: "Created", PasswordLastRotated: &metav1.Time{Time: rotated}}
+	b := crd.DatabaseStatus{State: "Created", PasswordLastRotated: &metav1.Time{Time: rotated.Local()}}
+	if statusChanged(a, b) {
+		t.Errorf("expected the same time in another location to be unchanged")
+	}
+	b.PendingReboot = true
+	if !statusChanged(a, b) {
+		t.Errorf("expected a changed status")
+	}
+}
+
+func TestDatabasesUsingSecret(t *testing.T) {
+	store := cache.NewStore(cache.MetaNamespaceKeyFunc)
+	databases := []*crd.Database{
+		{ObjectMeta: metav1.ObjectMeta{Name: "db1", Namespace: "default"}, Status: crd.DatabaseStatus{State: "Created"}},
+		{ObjectMeta: metav1.ObjectMeta{Name: "db2", Namespace: "default"}, Status: crd.DatabaseStatus{State: "Creating"}},
+		{ObjectMeta: metav1.ObjectMeta{Name: "db3", Namespace: "other"}, Status: crd.DatabaseStatus{State: "Created"}},
+		{ObjectMeta: metav1.ObjectMeta{Name: "db4", Namespace: "default"}, Status: crd.DatabaseStatus{State: "Created"}},
+	}
+	databases[0].Spec.Password.Name = "mysecret"
+	databases[1].Spec.Password.Name = "mysecret"
+	databases[2].Spec.Password.Name = "mysecret"
+	databases[3].Spec.Password.Name = "othersecret"
+	for _, db := range databases {
+		if err := store.Add(db); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	secret := &v1.Secret{ObjectMeta: metav1.ObjectMeta{Name: "mysecret", Namespace: "default"}}
+	result := databasesUsingSecret(store, secret)
+	if len(result) != 1 || result[0].Name != "db1" {
+		t.Errorf("expected only db1, actual %v", result)
+	}
+}
+
+func TestSecretSelector(t *testing.T) {
+	selector := secretSelector([]string{"kube-system"})
+	if !selector.Matches(fields.Set{"type": "Opaque", "metadata.namespace": "default"}) {
+		t.Errorf("expected secrets in default to be watched")
+	}
+	if selector.Matches(fields.Set{"type": "Opaque", "metadata.namespace": "kube-system"}) {
+		t.Errorf("expected secrets in the excluded namespace to be left out")
+	}
+	if selector.Matches(fields.Set{"type": "kubernetes.io/service-account-token", "metadata.namespace": "default"}) {
+		t.Errorf("expected service account tokens to be left out")
+	}
+}
+
+func TestSyncPassword(t *testing.T) {
+	ctx := context.Background()
+	db := &crd.Database{ObjectMeta: metav1.ObjectMeta{Name: "orders", Namespace: "shop", UID: "1234"}}
+	db.Spec.Password = v1.SecretKeySelector{LocalObjectReference: v1.LocalObjectReference{Name: "orders"}, Key: "password"}
+	kc := kubefake.NewSimpleClientset(&v1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "orders", Namespace: "shop"},
+		Data:       map[string][]byte{"password": []byte("secret")},
+	})
+	store := fake.NewStore()
+	r, err := fake.New(db, kc, store, fake.Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.Ensure(ctx, db); err != nil {
+		t.Fatal(err)
+	}
+
+	// the password the database was created with is only recorded
+	if err := syncPassword(ctx, db, r, "secret"); err != nil {
+		t.Fatal(err)
+	}
+	if db.Status.PasswordHash != passwordHash(db, "secret") || db.Status.PasswordHash == passwordHash(&crd.Database{}, "secret") {
+		t.Errorf("expected the hash of the password keyed with the UID, actual %v", db.Status.PasswordHash)
+	}
+
+	if err := syncPassword(ctx, db, r, "new-secret"); err != nil {
+		t.Fatal(err)
+	}
+	if i, _ := store.Get("shop", "orders"); i.Password != "new-secret" {
+		t.Errorf("expected the new password to be applied, actual %v", i.Password)
+	}
+	if db.Status.PasswordHash != passwordHash(db, "new-secret") {
+		t.Errorf("expected the hash of the new password")
+	}
+
+	// a failed change is tried again
+	r, _ = fake.New(&crd.Database{ObjectMeta: metav1.ObjectMeta{Annotations: map[string]string{fake.FailAnnotation: "password"}}}, kc, store, fake.Options{})
+	if err := syncPassword(ctx, db, r, "newer-secret"); err == nil {
+		t.Errorf("expected the failure of the provider")
+	}
+	if db.Status.PasswordHash != passwordHash(db, "new-secret") {
+		t.Errorf("expected the hash of the applied password to be kept")
+	}
+}
+
+func TestKeepFunc(t *testing.T) {
+	databases := []crd.Database{
+		{ObjectMeta: metav1.ObjectMeta{Name: "orders", Namespace: "shop"}},
+		{ObjectMeta: metav1.ObjectMeta{Name: "users", Namespace: "auth"}},
+	}
+
+	keep := keepFunc(databases, nil, nil)
+	if !keep("shop", "orders") || !keep("auth", "users") {
+		t.Error("existing databases must be kept")
+	}
+	if keep("shop", "users") || keep("billing", "orders") {
+		t.Error("missing databases must not be kept")
+	}
+
+	keep = keepFunc(databases, []string{"billing"}, nil)
+	if !keep("billing", "invoices") {
+		t.Error("databases in excluded namespaces must be kept")
+	}
+
+	keep = keepFunc(databases, nil, []string{"shop"})
+	if !keep("billing", "invoices") {
+		t.Error("databases outside the included namespaces must be kept")
+	}
+	if keep("shop", "carts") {
+		t.Error("missing databases in included namespaces must not be kept")
+	}
+}
+
+func TestParsePlugins(t *testing.T) {
+	plugins, err := parsePlugins([]string{"crunchy=localhost:9000", "sqlite=exec:/plugins/sqlite --dir=/data"})
+	if err != nil {
+		t.Error(err)
+	}
+	if plugins["crunchy"] != "localhost:9000" || plugins["sqlite"] != "exec:/plugins/sqlite --dir=/data" {
+		t.Errorf("unexpected plugins %v", plugins)
+	}
+	for _, v := range []string{"crunchy", "=localhost:9000", "crunchy=", "aws=localhost:9000"} {
+		if _, err := parsePlugins([]string{v}); err == nil {
+			t.Errorf("%v should be invalid", v)
+		}
+	}
+}
+
+func TestProviderStatus(t *testing.T) {
+	db := &crd.Database{ObjectMeta: metav1.ObjectMeta{Generation: 3}, Status: crd.DatabaseStatus{EngineVersion: "14.4"}}
+	providerStatus(db, &provider.ProviderStatus{Exists: true, State: "modifying", Hostname: "orders"})
+	if db.Status.EngineVersion != "14.4" {
+		t.Errorf("the engine version must be kept when the provider doesn't report it, got %v", db.Status.EngineVersion)
+	}
+	c := meta.FindStatusCondition(db.Status.Conditions, crd.ConditionReady)
+	if c == nil || c.Status != metav1.ConditionFalse || c.Message != "The database is modifying" || c.ObservedGeneration != 3 {
+		t.Errorf("unexpected condition %v", c)
+	}
+
+	providerStatus(db, &provider.ProviderStatus{Exists: true, Ready: true, State: "available", Hostname: "orders", EngineVersion: "14.7"})
+	if db.Status.EngineVersion != "14.7" {
+		t.Errorf("expected the engine version of the provider, got %v", db.Status.EngineVersion)
+	}
+	if !meta.IsStatusConditionTrue(db.Status.Conditions, crd.ConditionReady) || len(db.Status.Conditions) != 1 {
+		t.Errorf("unexpected conditions %v", db.Status.Conditions)
+	}
+}
+
+func TestRetryCreation(t *testing.T) {
+	old := &crd.Database{
+		ObjectMeta: metav1.ObjectMeta{ResourceVersion: "1"},
+		Spec:       crd.DatabaseSpec{Class: "db.t3.micro"},
+		Status:     crd.DatabaseStatus{State: "Failed", Message: "RequestID: 1"},
+	}
+	if !retryCreation(old, old) {
+		t.Error("a resync must retry")
+	}
+	db := *old
+	db.ResourceVersion = "2"
+	db.Status.Message = "RequestID: 2"
+	if retryCreation(old, &db) {
+		t.Error("a status update must not retry")
+	}
+	db.Spec.Class = "db.t3.small"
+	if !retryCreation(old, &db) {
+		t.Error("a spec change must retry")
+	}
+	db.Spec = old.Spec
+	db.Annotations = map[string]string{"k8s-rds.io/fake-fail": "update"}
+	if !retryCreation(old, &db) {
+		t.Error("an annotation change must retry")
+	}
+}
+
+func TestStillCreating(t *testing.T) {
+	db := &crd.Database{Status: crd.DatabaseStatus{State: "Creating"}}
+	if !stillCreating(db, &provider.ProviderStatus{Exists: true, State: "creating"}) {
+		t.Error("a database without an address must stay Creating")
+	}
+	if !stillCreating(db, &provider.ProviderStatus{Exists: true, State: "backing-up", Hostname: "orders.rds.amazonaws.com"}) {
+		t.Error("a database that isn't ready must stay Creating")
+	}
+	if stillCreating(db, &provider.ProviderStatus{Exists: true, Ready: true, State: "available", Hostname: "orders.rds.amazonaws.com"}) {
+		t.Error("a ready database is created")
+	}
+	db.Status.State = "Created"
+	if stillCreating(db, &provider.ProviderStatus{Exists: true, State: "modifying", Hostname: "orders.rds.amazonaws.com"}) {
+		t.Error("a created database isn't created again")
+	}
+}
+
+func TestFailedStatus(t *testing.T) {
+	status := crd.DatabaseStatus{State: "Creating", KMSKeyID: "arn:aws:kms:eu-west-1:123456789012:key/abc", ParameterGroup: "orders-shop"}
+	meta.SetStatusCondition(&status.Conditions, metav1.Condition{Type: crd.ConditionReady, Status: metav1.ConditionFalse, Reason: "NotAvailable"})
+	failed := failedStatus(status, fmt.Errorf("CreateDBInstance: quota exceeded"))
+	if failed.State != Failed || failed.Message != "CreateDBInstance: quota exceeded" {
+		t.Errorf("expected the failure in the status, actual %v: %v", failed.State, failed.Message)
+	}
+	if failed.KMSKeyID != status.KMSKeyID || failed.ParameterGroup != status.ParameterGroup || len(failed.Conditions) != 1 {
+		t.Errorf("expected the rest of the status to be kept, actual %v", failed)
+	}
+}
+
+// fakeDatabaseAPI serves the databases of a namespace to the crd client, the way the API server does
+type fakeDatabaseAPI struct {
+	lock      sync.Mutex
+	databases map[string]*crd.Database
+	version   int
+}
+
+func (f *fakeDatabaseAPI) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	name := path.Base(r.URL.Path)
+	db, ok := f.databases[name]
+	if !ok {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	if r.Method == http.MethodPut {
+		db = &crd.Database{}
+		if err := json.NewDecoder(r.Body).Decode(db); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		f.version++
+		db.ResourceVersion = strconv.Itoa(f.version)
+		f.databases[name] = db
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(db)
+}
+
+// get returns a copy of the database, like the informer does
+func (f *fakeDatabaseAPI) get(name string) *crd.Database {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	b, _ := json.Marshal(f.databases[name])
+	var db crd.Database
+	_ = json.Unmarshal(b, &db)
+	return &db
+}
+
+func TestHandleDatabase(t *testing.T) {
+	ctx := context.Background()
+	database := func(name string, annotations map[string]string) *crd.Database {
+		return &crd.Database{
+			TypeMeta:   metav1.TypeMeta{APIVersion: crd.SchemeGroupVersion.String(), Kind: "Database"},
+			ObjectMeta: metav1.ObjectMeta{Namespace: "shop", Name: name, Annotations: annotations},
+			Spec: crd.DatabaseSpec{
+				Engine:   "postgres",
+				Class:    "db.t3.micro",
+				Username: "app",
+				DBName:   name,
+				Password: v1.SecretKeySelector{LocalObjectReference: v1.LocalObjectReference{Name: "shop"}, Key: "password"},
+			},
+		}
+	}
+	api := &fakeDatabaseAPI{databases: map[string]*crd.Database{
+		"orders": database("orders", map[string]string{fake.DelayAnnotation: "50ms", fake.FailAnnotation: fake.OperationUpdate}),
+		"carts":  database("carts", map[string]string{fake.FailAnnotation: fake.OperationCreate}),
+	}}
+	server := httptest.NewServer(api)
+	defer server.Close()
+	crdcs, scheme, err := crd.NewClient(&rest.Config{Host: server.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+	crdclient := client.CrdClient(crdcs, scheme, "shop")
+
+	kc := kubefake.NewSimpleClientset(&v1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "shop", Name: "shop"},
+		Data:       map[string][]byte{"password": []byte("secret")},
+	})
+	defer func(f func() (kubernetes.Interface, error)) { newKubectl = f }(newKubectl)
+	newKubectl = func() (kubernetes.Interface, error) { return kc, nil }
+	service := func(name string) string {
+		s, err := kc.CoreV1().Services("shop").Get(ctx, name, metav1.GetOptions{})
+		if err != nil {
+			return ""
+		}
+		return s.Spec.ExternalName
+	}
+
+	// the instance is creating, the service already points at it
+	handleDatabase(ctx, api.get("orders"), crdclient, "fake", "")
+	db := api.get("orders")
+	if db.Status.State != "Creating" || db.Status.Message != "The database is creating" {
+		t.Errorf("expected the database to be creating, actual %v: %v", db.Status.State, db.Status.Message)
+	}
+	if service("orders") != "orders.shop.fake.k8s-rds.local" {
+		t.Errorf("expected the service to point at the instance, actual %q", service("orders"))
+	}
+
+	time.Sleep(100 * time.Millisecond)
+	handleDatabase(ctx, db, crdclient, "fake", "")
+	db = api.get("orders")
+	if db.Status.State != "Created" || !meta.IsStatusConditionTrue(db.Status.Conditions, crd.ConditionReady) {
+		t.Errorf("expected the database to be created and ready, actual %v: %v", db.Status.State, db.Status.Conditions)
+	}
+
+	// a created database isn't failed by an update that doesn't work out, it keeps running with its service
+	db.Spec.Class = "db.t3.small"
+	if db, err = crdclient.Update(ctx, db); err != nil {
+		t.Fatal(err)
+	}
+	handleDatabase(ctx, db, crdclient, "fake", "")
+	time.Sleep(100 * time.Millisecond)
+	handleDatabase(ctx, api.get("orders"), crdclient, "fake", "")
+	db = api.get("orders")
+	if db.Status.State != "Created" {
+		t.Errorf("expected the database to stay created, actual %v: %v", db.Status.State, db.Status.Message)
+	}
+	if service("orders") != "orders.shop.fake.k8s-rds.local" {
+		t.Errorf("expected the service to be kept, actual %q", service("orders"))
+	}
+
+	// a database that can't be created is failed, without a service
+	handleDatabase(ctx, api.get("carts"), crdclient, "fake", "")
+	db = api.get("carts")
+	if db.Status.State != Failed || db.Status.Message != "fake create of carts failed" {
+		t.Errorf("expected the creation to fail, actual %v: %v", db.Status.State, db.Status.Message)
+	}
+	if service("carts") != "" {
+		t.Errorf("expected no service for a failed database, actual %q", service("carts"))
+	}
+}
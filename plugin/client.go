@@ -0,0 +1,257 @@
+package plugin
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/sorenmat/k8s-rds/crd"
+	"github.com/sorenmat/k8s-rds/kube"
+	"github.com/sorenmat/k8s-rds/provider"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// defaultPort is used when the plugin doesn't return the port of the database
+const defaultPort = 5432
+
+// TLSOptions are the files used to connect to the plugins over TLS, the CA defaults to the roots of the system
+type TLSOptions struct {
+	CAFile   string
+	CertFile string // client certificate, for plugins requiring mutual TLS
+	KeyFile  string
+}
+
+// restartDelay is how long the operator waits before starting a plugin that exited again, it doubles up to
+// maxRestartDelay while the plugin keeps exiting
+var (
+	restartDelay    = time.Second
+	maxRestartDelay = time.Minute
+)
+
+// Dial connects to a plugin. An address starting with exec: is a command started as a subprocess, it listens on
+// the unix socket passed in K8S_RDS_PLUGIN_ADDRESS. The requests carry the passwords of the databases, so the
+// connection is only unencrypted to unix sockets and loopback addresses, the other plugins are connected to with TLS.
+func Dial(name, address string, options TLSOptions) (*grpc.ClientConn, error) {
+	if strings.HasPrefix(address, "exec:") {
+		socket, err := start(name, strings.Fields(strings.TrimPrefix(address, "exec:")))
+		if err != nil {
+			return nil, err
+		}
+		address = socket
+	}
+	creds := insecure.NewCredentials()
+	if !local(address) {
+		config, err := tlsConfig(options)
+		if err != nil {
+			return nil, errors.Wrap(err, fmt.Sprintf("unable to connect to plugin %v", name))
+		}
+		creds = credentials.NewTLS(config)
+	}
+	conn, err := grpc.Dial(address,
+		grpc.WithTransportCredentials(creds),
+		grpc.WithDefaultCallOptions(grpc.CallContentSubtype(codec{}.Name())))
+	return conn, errors.Wrap(err, fmt.Sprintf("unable to connect to plugin %v", name))
+}
+
+// local tells if the address is a unix socket or a loopback address, that can be connected to without TLS
+func local(address string) bool {
+	if strings.HasPrefix(address, "unix:") {
+		return true
+	}
+	if i := strings.LastIndex(address, "/"); i >= 0 {
+		// a target with a scheme, ex: dns:///plugin:9000
+		address = address[i+1:]
+	}
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return false
+	}
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+func tlsConfig(options TLSOptions) (*tls.Config, error) {
+	config := &tls.Config{MinVersion: tls.VersionTLS12}
+	if options.CAFile != "" {
+		ca, err := ioutil.ReadFile(options.CAFile)
+		if err != nil {
+			return nil, err
+		}
+		config.RootCAs = x509.NewCertPool()
+		if !config.RootCAs.AppendCertsFromPEM(ca) {
+			return nil, fmt.Errorf("no certificates found in %v", options.CAFile)
+		}
+	}
+	if options.CertFile != "" || options.KeyFile != "" {
+		cert, err := tls.LoadX509KeyPair(options.CertFile, options.KeyFile)
+		if err != nil {
+			return nil, err
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+	return config, nil
+}
+
+// start runs the plugin command, and returns the address it listens on. The plugin is started again when it exits,
+// the connection reconnects to it by itself. On Linux it gets a SIGTERM when the operator exits.
+func start(name string, command []string) (string, error) {
+	if len(command) == 0 {
+		return "", fmt.Errorf("plugin %v doesn't have a command", name)
+	}
+	address := "unix://" + filepath.Join(os.TempDir(), fmt.Sprintf("k8s-rds-plugin-%v.sock", name))
+	run := func() (*exec.Cmd, error) {
+		cmd := exec.Command(command[0], command[1:]...)
+		cmd.Env = append(os.Environ(), AddressEnv+"="+address)
+		cmd.Stdout = os.Stdout
+		cmd.Stderr = os.Stderr
+		stopWithParent(cmd)
+		return cmd, cmd.Start()
+	}
+	cmd, err := run()
+	if err != nil {
+		return "", errors.Wrap(err, fmt.Sprintf("unable to start plugin %v", name))
+	}
+	go supervise(name, cmd, run, restartDelay)
+	return address, nil
+}
+
+// supervise starts the plugin again every time it exits. The delay is reset once it ran for a while.
+func supervise(name string, cmd *exec.Cmd, run func() (*exec.Cmd, error), initialDelay time.Duration) {
+	delay := initialDelay
+	for {
+		started := time.Now()
+		log.Printf("plugin %v exited: %v\n", name, cmd.Wait())
+		if time.Since(started) > maxRestartDelay {
+			delay = initialDelay
+		}
+		for {
+			time.Sleep(delay)
+			if delay < maxRestartDelay {
+				delay *= 2
+			}
+			log.Printf("Restarting plugin %v\n", name)
+			var err error
+			if cmd, err = run(); err == nil {
+				break
+			}
+			log.Printf("unable to start plugin %v: %v\n", name, err)
+		}
+	}
+}
+
+// Client is a provider calling a plugin, the services and secrets are handled by the operator
+type Client struct {
+	name    string
+	conn    *grpc.ClientConn
+	kc      kubernetes.Interface
+	port    int32
+	timeout time.Duration // of each call, so a plugin that hangs doesn't block the operator
+}
+
+// New returns the provider of the plugin, the calls fail after the timeout unless it's 0
+func New(name string, conn *grpc.ClientConn, kc kubernetes.Interface, timeout time.Duration) *Client {
+	return &Client{name: name, conn: conn, kc: kc, port: defaultPort, timeout: timeout}
+}
+
+func (c *Client) invoke(ctx context.Context, method string, in, out interface{}) error {
+	if c.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.timeout)
+		defer cancel()
+	}
+	err := c.conn.Invoke(ctx, "/"+ServiceName+"/"+method, in, out)
+	return errors.Wrap(err, fmt.Sprintf("plugin %v: %v", c.name, method))
+}
+
+// setStatus takes the status reported by the plugin, the state and message are the operator's
+func setStatus(db *crd.Database, status crd.DatabaseStatus) {
+	status.State = db.Status.State
+	status.Message = db.Status.Message
+	db.Status = status
+}
+
+// Ensure creates the database with the plugin, or applies the changes of the spec, and returns its status
+func (c *Client) Ensure(ctx context.Context, db *crd.Database) (*provider.ProviderStatus, error) {
+	password, err := c.GetSecret(ctx, db.Namespace, db.Spec.Password.Name, db.Spec.Password.Key)
+	if err != nil {
+		return nil, err
+	}
+	var resp EnsureResponse
+	if err := c.invoke(ctx, "Ensure", &EnsureRequest{Database: db, Password: password}, &resp); err != nil {
+		return nil, err
+	}
+	setStatus(db, resp.DatabaseStatus)
+	return c.status(&resp.Status), nil
+}
+
+// Describe returns the status of the database reported by the plugin
+func (c *Client) Describe(ctx context.Context, db *crd.Database) (*provider.ProviderStatus, error) {
+	var resp DescribeResponse
+	if err := c.invoke(ctx, "Describe", &DescribeRequest{Database: db}, &resp); err != nil {
+		return nil, err
+	}
+	return c.status(&resp.Status), nil
+}
+
+// status keeps the port of the database for its service
+func (c *Client) status(s *provider.ProviderStatus) *provider.ProviderStatus {
+	if s.Port == 0 {
+		s.Port = defaultPort
+	}
+	c.port = s.Port
+	return s
+}
+
+// UpdatePassword sets the new password with the plugin
+func (c *Client) UpdatePassword(ctx context.Context, db *crd.Database, password string) error {
+	var resp UpdatePasswordResponse
+	if err := c.invoke(ctx, "UpdatePassword", &UpdatePasswordRequest{Database: db, Password: password}, &resp); err != nil {
+		return err
+	}
+	setStatus(db, resp.DatabaseStatus)
+	return nil
+}
+
+// Delete deletes the database with the plugin
+func (c *Client) Delete(ctx context.Context, db *crd.Database) error {
+	return c.invoke(ctx, "Delete", &DeleteRequest{Database: db}, &DeleteResponse{})
+}
+
+// CreateService creates or updates a service pointing at the address returned by the plugin
+func (c *Client) CreateService(ctx context.Context, namespace string, hostname string, internalname string) error {
+	return kube.CreateAddressService(ctx, c.kc, namespace, hostname, internalname, c.port)
+}
+
+func (c *Client) DeleteService(ctx context.Context, namespace string, dbname string) error {
+	err := kube.DeleteAddressService(ctx, c.kc, namespace, dbname)
+	if err != nil {
+		log.Println(err)
+		return errors.Wrap(err, fmt.Sprintf("delete of service %v failed in namespace %v", dbname, namespace))
+	}
+	return nil
+}
+
+func (c *Client) GetSecret(ctx context.Context, namespace string, name string, key string) (string, error) {
+	secret, err := c.kc.CoreV1().Secrets(namespace).Get(ctx, name, metav1.GetOptions{})
+	if err != nil {
+		return "", errors.Wrap(err, fmt.Sprintf("unable to fetch secret %v", name))
+	}
+	return string(secret.Data[key]), nil
+}
@@ -0,0 +1,150 @@
+// Package plugin runs database providers outside the operator, as gRPC services. The messages are JSON encoded so the
+// plugins don't need generated code, a plugin implements ProviderServer and calls ListenAndServe.
+package plugin
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"os"
+	"strings"
+
+	"github.com/sorenmat/k8s-rds/crd"
+	"github.com/sorenmat/k8s-rds/provider"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/encoding"
+)
+
+const (
+	// ServiceName is the gRPC service of the plugins
+	ServiceName = "k8srds.plugin.v1.Provider"
+	// AddressEnv holds the address the plugin listens on when the operator starts it as a subprocess
+	AddressEnv = "K8S_RDS_PLUGIN_ADDRESS"
+)
+
+// EnsureRequest asks for the database to be created, or brought in line with the spec when it exists
+type EnsureRequest struct {
+	Database *crd.Database `json:"database"`
+	Password string        `json:"password"`
+}
+
+// EnsureResponse returns the status of the database, the service of the database points at its hostname
+type EnsureResponse struct {
+	Status         provider.ProviderStatus `json:"status"`
+	DatabaseStatus crd.DatabaseStatus      `json:"databaseStatus"` // fields set on the status of the Database
+}
+
+type DescribeRequest struct {
+	Database *crd.Database `json:"database"`
+}
+
+type DescribeResponse struct {
+	Status provider.ProviderStatus `json:"status"`
+}
+
+type DeleteRequest struct {
+	Database *crd.Database `json:"database"`
+}
+
+type DeleteResponse struct{}
+
+// UpdatePasswordRequest asks for the password of the user of the spec to be changed
+type UpdatePasswordRequest struct {
+	Database *crd.Database `json:"database"`
+	Password string        `json:"password"`
+}
+
+type UpdatePasswordResponse struct {
+	DatabaseStatus crd.DatabaseStatus `json:"databaseStatus"`
+}
+
+// ProviderServer is implemented by the plugins, it mirrors provider.DatabaseProvider and provider.PasswordUpdater
+type ProviderServer interface {
+	Ensure(context.Context, *EnsureRequest) (*EnsureResponse, error)
+	Describe(context.Context, *DescribeRequest) (*DescribeResponse, error)
+	Delete(context.Context, *DeleteRequest) (*DeleteResponse, error)
+	UpdatePassword(context.Context, *UpdatePasswordRequest) (*UpdatePasswordResponse, error)
+}
+
+// codec encodes the messages as JSON, it's selected with the json content subtype
+type codec struct{}
+
+func (codec) Marshal(v interface{}) ([]byte, error)      { return json.Marshal(v) }
+func (codec) Unmarshal(data []byte, v interface{}) error { return json.Unmarshal(data, v) }
+func (codec) Name() string                               { return "json" }
+
+func init() {
+	encoding.RegisterCodec(codec{})
+}
+
+// unary returns the description of a method, call invokes it on the server with the decoded request
+func unary(name string, request func() interface{}, call func(ProviderServer, context.Context, interface{}) (interface{}, error)) grpc.MethodDesc {
+	return grpc.MethodDesc{
+		MethodName: name,
+		Handler: func(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+			in := request()
+			if err := dec(in); err != nil {
+				return nil, err
+			}
+			handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+				return call(srv.(ProviderServer), ctx, req)
+			}
+			if interceptor == nil {
+				return handler(ctx, in)
+			}
+			return interceptor(ctx, in, &grpc.UnaryServerInfo{Server: srv, FullMethod: "/" + ServiceName + "/" + name}, handler)
+		},
+	}
+}
+
+// ServiceDesc describes the service for grpc.Server.RegisterService
+var ServiceDesc = grpc.ServiceDesc{
+	ServiceName: ServiceName,
+	HandlerType: (*ProviderServer)(nil),
+	Methods: []grpc.MethodDesc{
+		unary("Ensure", func() interface{} { return &EnsureRequest{} }, func(s ProviderServer, ctx context.Context, in interface{}) (interface{}, error) {
+			return s.Ensure(ctx, in.(*EnsureRequest))
+		}),
+		unary("Describe", func() interface{} { return &DescribeRequest{} }, func(s ProviderServer, ctx context.Context, in interface{}) (interface{}, error) {
+			return s.Describe(ctx, in.(*DescribeRequest))
+		}),
+		unary("Delete", func() interface{} { return &DeleteRequest{} }, func(s ProviderServer, ctx context.Context, in interface{}) (interface{}, error) {
+			return s.Delete(ctx, in.(*DeleteRequest))
+		}),
+		unary("UpdatePassword", func() interface{} { return &UpdatePasswordRequest{} }, func(s ProviderServer, ctx context.Context, in interface{}) (interface{}, error) {
+			return s.UpdatePassword(ctx, in.(*UpdatePasswordRequest))
+		}),
+	},
+	Metadata: "plugin.go",
+}
+
+// Register adds the provider to the server
+func Register(s *grpc.Server, p ProviderServer) {
+	s.RegisterService(&ServiceDesc, p)
+}
+
+// listen opens the address, unix:///path/to.sock for a unix socket, or host:port
+func listen(address string) (net.Listener, error) {
+	if strings.HasPrefix(address, "unix://") {
+		path := strings.TrimPrefix(address, "unix://")
+		// the socket of a previous run
+		_ = os.Remove(path)
+		return net.Listen("unix", path)
+	}
+	return net.Listen("tcp", address)
+}
+
+// ListenAndServe serves the provider on the address, or on the one the operator set when it started the plugin.
+// Plugins listening on other hosts than the operator must serve TLS, ex: with grpc.Creds(credentials.NewServerTLSFromFile(...)).
+func ListenAndServe(address string, p ProviderServer, options ...grpc.ServerOption) error {
+	if a := os.Getenv(AddressEnv); a != "" {
+		address = a
+	}
+	lis, err := listen(address)
+	if err != nil {
+		return err
+	}
+	s := grpc.NewServer(options...)
+	Register(s, p)
+	return s.Serve(lis)
+}
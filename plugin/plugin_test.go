@@ -0,0 +1,193 @@
+package plugin
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/sorenmat/k8s-rds/crd"
+	"github.com/sorenmat/k8s-rds/provider"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+// fakeProvider keeps the databases in memory, like an in-house backend would run them
+type fakeProvider struct {
+	passwords map[string]string
+	hang      bool // Describe doesn't answer
+}
+
+func (f *fakeProvider) Ensure(ctx context.Context, in *EnsureRequest) (*EnsureResponse, error) {
+	if _, ok := f.passwords[in.Database.Name]; !ok {
+		f.passwords[in.Database.Name] = in.Password
+	}
+	return &EnsureResponse{
+		Status:         f.status(in.Database),
+		DatabaseStatus: crd.DatabaseStatus{EngineVersion: in.Database.Spec.Version},
+	}, nil
+}
+
+func (f *fakeProvider) Describe(ctx context.Context, in *DescribeRequest) (*DescribeResponse, error) {
+	if f.hang {
+		<-ctx.Done()
+		return nil, ctx.Err()
+	}
+	return &DescribeResponse{Status: f.status(in.Database)}, nil
+}
+
+func (f *fakeProvider) status(db *crd.Database) provider.ProviderStatus {
+	if _, ok := f.passwords[db.Name]; !ok {
+		return provider.ProviderStatus{}
+	}
+	return provider.ProviderStatus{Exists: true, Ready: true, State: "serving", Hostname: db.Name + ".vitess.internal", Port: 3306, EngineVersion: db.Spec.Version}
+}
+
+func (f *fakeProvider) Delete(ctx context.Context, in *DeleteRequest) (*DeleteResponse, error) {
+	if _, ok := f.passwords[in.Database.Name]; !ok {
+		return nil, status.Error(codes.NotFound, "no such database")
+	}
+	delete(f.passwords, in.Database.Name)
+	return &DeleteResponse{}, nil
+}
+
+func (f *fakeProvider) UpdatePassword(ctx context.Context, in *UpdatePasswordRequest) (*UpdatePasswordResponse, error) {
+	f.passwords[in.Database.Name] = in.Password
+	return &UpdatePasswordResponse{}, nil
+}
+
+func TestPlugin(t *testing.T) {
+	ctx := context.Background()
+	f := &fakeProvider{passwords: map[string]string{}}
+	address := "unix://" + filepath.Join(t.TempDir(), "vitess.sock")
+	lis, err := listen(address)
+	assert.NoError(t, err)
+	server := grpc.NewServer()
+	Register(server, f)
+	go server.Serve(lis)
+	defer server.Stop()
+
+	conn, err := Dial("vitess", address, TLSOptions{})
+	assert.NoError(t, err)
+	defer conn.Close()
+	kc := fake.NewSimpleClientset(&v1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "shop", Name: "orders"},
+		Data:       map[string][]byte{"password": []byte("secret")},
+	})
+	c := New("vitess", conn, kc, time.Minute)
+	db := &crd.Database{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "shop", Name: "orders"},
+		Spec: crd.DatabaseSpec{
+			Version:  "16.0",
+			Password: v1.SecretKeySelector{LocalObjectReference: v1.LocalObjectReference{Name: "orders"}, Key: "password"},
+		},
+		Status: crd.DatabaseStatus{State: "Creating", Message: "Creating"},
+	}
+
+	ps, err := c.Ensure(ctx, db)
+	assert.NoError(t, err)
+	assert.Equal(t, &provider.ProviderStatus{Exists: true, Ready: true, State: "serving", Hostname: "orders.vitess.internal", Port: 3306, EngineVersion: "16.0"}, ps)
+	assert.Equal(t, "secret", f.passwords["orders"])
+	assert.Equal(t, crd.DatabaseStatus{State: "Creating", Message: "Creating", EngineVersion: "16.0"}, db.Status)
+
+	assert.NoError(t, c.CreateService(ctx, "shop", ps.Hostname, "orders"))
+	s, err := kc.CoreV1().Services("shop").Get(ctx, "orders", metav1.GetOptions{})
+	assert.NoError(t, err)
+	assert.Equal(t, "orders.vitess.internal", s.Spec.ExternalName)
+	assert.Equal(t, int32(3306), s.Spec.Ports[0].Port)
+
+	db.Spec.Version = "16.1"
+	_, err = c.Ensure(ctx, db)
+	assert.NoError(t, err)
+	assert.Equal(t, "16.1", db.Status.EngineVersion)
+	assert.NoError(t, c.UpdatePassword(ctx, db, "new-secret"))
+	assert.Equal(t, "new-secret", f.passwords["orders"])
+
+	assert.NoError(t, c.Delete(ctx, db))
+	ps, err = c.Describe(ctx, db)
+	assert.NoError(t, err)
+	assert.False(t, ps.Exists)
+	err = c.Delete(ctx, db)
+	assert.Error(t, err)
+	assert.Equal(t, codes.NotFound, status.Code(errors.Cause(err)))
+}
+
+func TestTimeout(t *testing.T) {
+	address := "unix://" + filepath.Join(t.TempDir(), "vitess.sock")
+	lis, err := listen(address)
+	assert.NoError(t, err)
+	server := grpc.NewServer()
+	Register(server, &fakeProvider{passwords: map[string]string{}, hang: true})
+	go server.Serve(lis)
+	defer server.Stop()
+
+	conn, err := Dial("vitess", address, TLSOptions{})
+	assert.NoError(t, err)
+	defer conn.Close()
+	c := New("vitess", conn, fake.NewSimpleClientset(), 50*time.Millisecond)
+	_, err = c.Describe(context.Background(), &crd.Database{ObjectMeta: metav1.ObjectMeta{Namespace: "shop", Name: "orders"}})
+	assert.Equal(t, codes.DeadlineExceeded, status.Code(errors.Cause(err)))
+}
+
+func TestLocal(t *testing.T) {
+	assert.True(t, local("unix:///plugins/vault.sock"))
+	assert.True(t, local("localhost:9000"))
+	assert.True(t, local("127.0.0.1:9000"))
+	assert.True(t, local("[::1]:9000"))
+	assert.True(t, local("dns:///localhost:9000"))
+	assert.False(t, local("crunchy.databases.svc:9000"))
+	assert.False(t, local("10.0.0.12:9000"))
+	assert.False(t, local("dns:///crunchy:9000"))
+}
+
+func TestTLSConfig(t *testing.T) {
+	config, err := tlsConfig(TLSOptions{})
+	assert.NoError(t, err)
+	assert.Nil(t, config.RootCAs, "the roots of the system are used")
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(t, err)
+	template := &x509.Certificate{SerialNumber: big.NewInt(1), Subject: pkix.Name{CommonName: "plugins"}, NotAfter: time.Now().Add(time.Hour), IsCA: true}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	assert.NoError(t, err)
+	ca := filepath.Join(t.TempDir(), "ca.pem")
+	assert.NoError(t, ioutil.WriteFile(ca, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600))
+
+	config, err = tlsConfig(TLSOptions{CAFile: ca})
+	assert.NoError(t, err)
+	assert.NotNil(t, config.RootCAs)
+
+	_, err = tlsConfig(TLSOptions{CAFile: filepath.Join(t.TempDir(), "missing.pem")})
+	assert.Error(t, err)
+	_, err = tlsConfig(TLSOptions{CertFile: ca})
+	assert.Error(t, err)
+}
+
+func TestSupervise(t *testing.T) {
+	restartDelay = time.Millisecond
+	defer func() { restartDelay = time.Second }()
+	starts := filepath.Join(t.TempDir(), "starts")
+
+	// the plugin exits right away, it's started again every time
+	_, err := start("flaky", []string{"sh", "-c", "echo started >> " + starts})
+	assert.NoError(t, err)
+	assert.Eventually(t, func() bool {
+		b, _ := ioutil.ReadFile(starts)
+		return strings.Count(string(b), "started") >= 3
+	}, 5*time.Second, 10*time.Millisecond)
+}
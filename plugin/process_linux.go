@@ -0,0 +1,14 @@
+//go:build linux
+// +build linux
+
+package plugin
+
+import (
+	"os/exec"
+	"syscall"
+)
+
+// stopWithParent makes the kernel terminate the plugin when the operator exits, even when it's killed
+func stopWithParent(cmd *exec.Cmd) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{Pdeathsig: syscall.SIGTERM}
+}
@@ -0,0 +1,16 @@
+package plugin
+
+import (
+	"os/exec"
+	"syscall"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStopWithParent(t *testing.T) {
+	cmd := exec.Command("true")
+	stopWithParent(cmd)
+	assert.Equal(t, syscall.SIGTERM, cmd.SysProcAttr.Pdeathsig)
+	assert.NoError(t, cmd.Run())
+}
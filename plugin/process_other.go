@@ -0,0 +1,10 @@
+//go:build !linux
+// +build !linux
+
+package plugin
+
+import "os/exec"
+
+// stopWithParent does nothing, only Linux can terminate a subprocess when its parent exits. The operator runs on
+// Linux, elsewhere the plugin keeps running after the operator exits.
+func stopWithParent(cmd *exec.Cmd) {}
@@ -0,0 +1,204 @@
+// Package pooler deploys PgBouncer in front of a postgres database, whatever provider runs the database
+package pooler
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/sorenmat/k8s-rds/crd"
+	"github.com/sorenmat/k8s-rds/kube"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/client-go/kubernetes"
+)
+
+const (
+	image             = "edoburu/pgbouncer"
+	port              = 5432
+	defaultPoolSize   = 20
+	defaultMaxClients = 100
+
+	// passwordVersionAnnotation holds the resource version of the password secret, the pods are rolled when it changes
+	passwordVersionAnnotation = "k8s-rds.io/password-version"
+)
+
+// Name returns the name of the Deployment and Service of the pooler
+func Name(db *crd.Database) string {
+	return db.Name + "-pooler"
+}
+
+// Host returns the address of the database the pooler connects to, the Service created for it by the provider
+func Host(db *crd.Database) string {
+	return fmt.Sprintf("%v.%v.svc", db.Name, db.Namespace)
+}
+
+func labels(db *crd.Database) map[string]string {
+	return map[string]string{"pooler": db.Name}
+}
+
+func annotations(db *crd.Database) map[string]string {
+	return map[string]string{kube.OriginAnnotation: "k8s-rds", kube.DatabaseAnnotation: db.Name}
+}
+
+// settings returns the spec with the defaults filled in
+func settings(spec *crd.PoolerSpec) crd.PoolerSpec {
+	s := *spec
+	if s.Mode == "" {
+		s.Mode = crd.PoolModeTransaction
+	}
+	if s.PoolSize == 0 {
+		s.PoolSize = defaultPoolSize
+	}
+	if s.MaxClients == 0 {
+		s.MaxClients = defaultMaxClients
+	}
+	return s
+}
+
+// deployment returns the PgBouncer deployment of the database, passwordVersion is the resource version of the secret
+func deployment(db *crd.Database, repository, passwordVersion string) *appsv1.Deployment {
+	s := settings(db.Spec.Pooler)
+	img := image
+	if repository != "" {
+		img = fmt.Sprintf("%v/%v", repository, image)
+	}
+	replicas := int32(1)
+	return &appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        Name(db),
+			Namespace:   db.Namespace,
+			Labels:      labels(db),
+			Annotations: annotations(db),
+		},
+		Spec: appsv1.DeploymentSpec{
+			Replicas: &replicas,
+			Selector: &metav1.LabelSelector{MatchLabels: labels(db)},
+			Template: corev1.PodTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{
+					Labels:      labels(db),
+					Annotations: map[string]string{passwordVersionAnnotation: passwordVersion},
+				},
+				Spec: corev1.PodSpec{
+					Containers: []corev1.Container{
+						{
+							Name:  "pgbouncer",
+							Image: img,
+							Env: []corev1.EnvVar{
+								{Name: "DB_HOST", Value: Host(db)},
+								{Name: "DB_PORT", Value: strconv.Itoa(port)},
+								{Name: "DB_USER", Value: db.Spec.Username},
+								{Name: "DB_NAME", Value: db.Spec.DBName},
+								{Name: "DB_PASSWORD", ValueFrom: &corev1.EnvVarSource{
+									SecretKeyRef: &corev1.SecretKeySelector{
+										LocalObjectReference: corev1.LocalObjectReference{Name: db.Spec.Password.Name},
+										Key:                  db.Spec.Password.Key,
+									},
+								}},
+								{Name: "AUTH_TYPE", Value: "scram-sha-256"},
+								{Name: "POOL_MODE", Value: s.Mode},
+								{Name: "DEFAULT_POOL_SIZE", Value: strconv.FormatInt(s.PoolSize, 10)},
+								{Name: "MAX_CLIENT_CONN", Value: strconv.FormatInt(s.MaxClients, 10)},
+							},
+							Ports: []corev1.ContainerPort{
+								{Name: "pgbouncer", Protocol: corev1.ProtocolTCP, ContainerPort: port},
+							},
+						},
+					},
+				},
+			},
+		},
+	}
+}
+
+func service(db *crd.Database) *corev1.Service {
+	return &corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        Name(db),
+			Namespace:   db.Namespace,
+			Labels:      labels(db),
+			Annotations: annotations(db),
+		},
+		Spec: corev1.ServiceSpec{
+			Type:     corev1.ServiceTypeClusterIP,
+			Selector: labels(db),
+			Ports: []corev1.ServicePort{
+				{Name: "pgbouncer", Protocol: corev1.ProtocolTCP, Port: port, TargetPort: intstr.FromInt(port)},
+			},
+		},
+	}
+}
+
+// Ensure creates or updates the pooler of the database, and deletes it when the spec doesn't have one
+func Ensure(ctx context.Context, kc kubernetes.Interface, db *crd.Database, repository string) error {
+	if db.Spec.Pooler == nil {
+		return Delete(ctx, kc, db)
+	}
+	if !strings.Contains(db.Spec.Engine, "postgres") {
+		return fmt.Errorf("the pooler only supports postgres, not %v", db.Spec.Engine)
+	}
+
+	secret, err := kc.CoreV1().Secrets(db.Namespace).Get(ctx, db.Spec.Password.Name, metav1.GetOptions{})
+	if err != nil {
+		return errors.Wrap(err, "unable to get the password secret of the pooler")
+	}
+	d := deployment(db, repository, secret.ResourceVersion)
+	deployments := kc.AppsV1().Deployments(db.Namespace)
+	existing, err := deployments.Get(ctx, d.Name, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		log.Printf("creating pooler %v in %v\n", d.Name, db.Namespace)
+		if _, err := deployments.Create(ctx, d, metav1.CreateOptions{}); err != nil {
+			return errors.Wrap(err, "unable to create the pooler deployment")
+		}
+	} else if err != nil {
+		return errors.Wrap(err, "unable to get the pooler deployment")
+	} else {
+		existing.Labels = d.Labels
+		existing.Annotations = d.Annotations
+		existing.Spec = d.Spec
+		if _, err := deployments.Update(ctx, existing, metav1.UpdateOptions{}); err != nil {
+			return errors.Wrap(err, "unable to update the pooler deployment")
+		}
+	}
+
+	s := service(db)
+	services := kc.CoreV1().Services(db.Namespace)
+	existingService, err := services.Get(ctx, s.Name, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		if _, err := services.Create(ctx, s, metav1.CreateOptions{}); err != nil {
+			return errors.Wrap(err, "unable to create the pooler service")
+		}
+		return nil
+	} else if err != nil {
+		return errors.Wrap(err, "unable to get the pooler service")
+	}
+	// the cluster IP is allocated by kubernetes and kept
+	s.Spec.ClusterIP = existingService.Spec.ClusterIP
+	s.Spec.ClusterIPs = existingService.Spec.ClusterIPs
+	existingService.Labels = s.Labels
+	existingService.Annotations = s.Annotations
+	existingService.Spec = s.Spec
+	if _, err := services.Update(ctx, existingService, metav1.UpdateOptions{}); err != nil {
+		return errors.Wrap(err, "unable to update the pooler service")
+	}
+	return nil
+}
+
+// Delete removes the pooler of the database, if there's one
+func Delete(ctx context.Context, kc kubernetes.Interface, db *crd.Database) error {
+	err := kc.AppsV1().Deployments(db.Namespace).Delete(ctx, Name(db), metav1.DeleteOptions{})
+	if err != nil && !apierrors.IsNotFound(err) {
+		return errors.Wrap(err, "unable to delete the pooler deployment")
+	}
+	err = kc.CoreV1().Services(db.Namespace).Delete(ctx, Name(db), metav1.DeleteOptions{})
+	if err != nil && !apierrors.IsNotFound(err) {
+		return errors.Wrap(err, "unable to delete the pooler service")
+	}
+	return nil
+}
@@ -0,0 +1,93 @@
+package pooler
+
+import (
+	"context"
+	"testing"
+
+	"github.com/sorenmat/k8s-rds/crd"
+	"github.com/stretchr/testify/assert"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func env(c v1.Container) map[string]string {
+	result := map[string]string{}
+	for _, e := range c.Env {
+		result[e.Name] = e.Value
+	}
+	return result
+}
+
+func TestEnsure(t *testing.T) {
+	ctx := context.Background()
+	secret := &v1.Secret{ObjectMeta: metav1.ObjectMeta{Namespace: "shop", Name: "orders-password", ResourceVersion: "1"}}
+	kc := fake.NewSimpleClientset(secret)
+	db := &crd.Database{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "shop", Name: "orders"},
+		Spec: crd.DatabaseSpec{
+			Engine:   "postgres",
+			Username: "app",
+			DBName:   "orders",
+			Password: v1.SecretKeySelector{LocalObjectReference: v1.LocalObjectReference{Name: "orders-password"}, Key: "password"},
+			Pooler:   &crd.PoolerSpec{PoolSize: 5},
+		},
+	}
+
+	assert.NoError(t, Ensure(ctx, kc, db, "registry.example.com"))
+	d, err := kc.AppsV1().Deployments("shop").Get(ctx, "orders-pooler", metav1.GetOptions{})
+	assert.NoError(t, err)
+	c := d.Spec.Template.Spec.Containers[0]
+	assert.Equal(t, "registry.example.com/edoburu/pgbouncer", c.Image)
+	assert.Equal(t, map[string]string{
+		"DB_HOST":           "orders.shop.svc",
+		"DB_PORT":           "5432",
+		"DB_USER":           "app",
+		"DB_NAME":           "orders",
+		"DB_PASSWORD":       "",
+		"AUTH_TYPE":         "scram-sha-256",
+		"POOL_MODE":         "transaction",
+		"DEFAULT_POOL_SIZE": "5",
+		"MAX_CLIENT_CONN":   "100",
+	}, env(c))
+	assert.Equal(t, "orders-password", c.Env[4].ValueFrom.SecretKeyRef.Name)
+	assert.Equal(t, "1", d.Spec.Template.Annotations[passwordVersionAnnotation])
+	assert.Equal(t, "orders", d.Annotations["k8s-rds.io/database"])
+	s, err := kc.CoreV1().Services("shop").Get(ctx, "orders-pooler", metav1.GetOptions{})
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{"pooler": "orders"}, s.Spec.Selector)
+
+	// a new password and new settings roll the pods
+	secret.ResourceVersion = "2"
+	_, err = kc.CoreV1().Secrets("shop").Update(ctx, secret, metav1.UpdateOptions{})
+	assert.NoError(t, err)
+	db.Spec.Pooler.Mode = crd.PoolModeSession
+	assert.NoError(t, Ensure(ctx, kc, db, ""))
+	d, err = kc.AppsV1().Deployments("shop").Get(ctx, "orders-pooler", metav1.GetOptions{})
+	assert.NoError(t, err)
+	assert.Equal(t, "2", d.Spec.Template.Annotations[passwordVersionAnnotation])
+	assert.Equal(t, "edoburu/pgbouncer", d.Spec.Template.Spec.Containers[0].Image)
+	assert.Equal(t, "session", env(d.Spec.Template.Spec.Containers[0])["POOL_MODE"])
+
+	// removing the pooler from the spec deletes it
+	db.Spec.Pooler = nil
+	assert.NoError(t, Ensure(ctx, kc, db, ""))
+	_, err = kc.AppsV1().Deployments("shop").Get(ctx, "orders-pooler", metav1.GetOptions{})
+	assert.Error(t, err)
+	_, err = kc.CoreV1().Services("shop").Get(ctx, "orders-pooler", metav1.GetOptions{})
+	assert.Error(t, err)
+	assert.NoError(t, Delete(ctx, kc, db))
+}
+
+func TestEnsureNotPostgres(t *testing.T) {
+	kc := fake.NewSimpleClientset()
+	db := &crd.Database{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "shop", Name: "carts"},
+		Spec:       crd.DatabaseSpec{Engine: "mysql", Pooler: &crd.PoolerSpec{}},
+	}
+	assert.Error(t, Ensure(context.Background(), kc, db, ""))
+
+	db.Spec.Engine = "aurora-postgresql"
+	// the password secret is missing
+	assert.Error(t, Ensure(context.Background(), kc, db, ""))
+}
@@ -5,16 +5,42 @@ import (
 	"github.com/sorenmat/k8s-rds/crd"
 )
 
-// DatabaseProvider is the interface for creating and deleting databases
+// DatabaseProvider is the interface for creating, updating and deleting databases
 // this is the main interface that should be implemented if a new provider is created
 type DatabaseProvider interface {
-	CreateDatabase(context.Context, *crd.Database) (string, error)
-	DeleteDatabase(context.Context, *crd.Database) error
+	// Ensure creates the database, or applies the changes of the spec when it exists, and returns its status
+	Ensure(context.Context, *crd.Database) (*ProviderStatus, error)
+	// Describe returns the status of the database without changing it
+	Describe(context.Context, *crd.Database) (*ProviderStatus, error)
+	Delete(context.Context, *crd.Database) error
 	ServiceProvider
 }
 
+// ProviderStatus is what the backend of a provider runs for a database
+type ProviderStatus struct {
+	Exists        bool   `json:"exists"`
+	Ready         bool   `json:"ready"`              // the database accepts connections
+	State         string `json:"state,omitempty"`    // as reported by the backend, ex: available
+	Hostname      string `json:"hostname,omitempty"` // DNS name or IP the service of the database points at
+	Port          int32  `json:"port,omitempty"`
+	EngineVersion string `json:"engineVersion,omitempty"`
+}
+
+// PasswordUpdater is implemented by providers that can change the password of a running database
+type PasswordUpdater interface {
+	UpdatePassword(ctx context.Context, db *crd.Database, password string) error
+}
+
 type ServiceProvider interface {
 	CreateService(ctx context.Context, namespace string, hostname string, internalname string) error
 	DeleteService(ctx context.Context, namespace string, dbname string) error
 	GetSecret(ctx context.Context, namepspace string, pwname string, pwkey string) (string, error)
 }
+
+// Orphan is a resource created for a Database that doesn't exist anymore
+type Orphan struct {
+	Kind      string // ex: Service, RDS instance
+	Name      string
+	Namespace string // namespace of the resource or of the missing Database
+	Delete    func(context.Context) error
+}
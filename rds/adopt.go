@@ -0,0 +1,43 @@
+package rds
+
+import (
+	"github.com/aws/aws-sdk-go-v2/aws"
+	rdstypes "github.com/aws/aws-sdk-go-v2/service/rds/types"
+	"github.com/sorenmat/k8s-rds/crd"
+)
+
+const (
+	// AdoptAnnotation confirms the takeover of an adopted instance when it's set to adoptConfirmed
+	AdoptAnnotation = "k8s-rds.io/adopt"
+	adoptConfirmed  = "confirmed"
+
+	// states of an adoption reported in status.adoption
+	adoptionPendingState   = "Pending"
+	adoptionConfirmedState = "Confirmed"
+)
+
+// instanceIdentifier returns the identifier of the RDS instance, the one from the spec or <name>-<namespace>
+func instanceIdentifier(db *crd.Database) string {
+	if db.Spec.AWS != nil && db.Spec.AWS.InstanceIdentifier != "" {
+		return db.Spec.AWS.InstanceIdentifier
+	}
+	return dbidentifier(db)
+}
+
+// adopted tells if the database manages an instance that was created outside of the operator
+func adopted(db *crd.Database) bool {
+	return db.Spec.AWS != nil && db.Spec.AWS.Adopt
+}
+
+// adoptionPending tells if the instance is adopted but the takeover isn't confirmed yet, it must not be modified
+func adoptionPending(db *crd.Database) bool {
+	return adopted(db) && db.Annotations[AdoptAnnotation] != adoptConfirmed
+}
+
+// importStatus copies the state of the instance to the status
+func importStatus(db *crd.Database, instance *rdstypes.DBInstance) {
+	parameterStatus(db, instance)
+	db.Status.KMSKeyID = aws.ToString(instance.KmsKeyId)
+	db.Status.EngineVersion = aws.ToString(instance.EngineVersion)
+	reportDrift(db, detectDrift(db, instance), false)
+}
@@ -0,0 +1,86 @@
+package rds
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/credentials/stscreds"
+	"github.com/aws/aws-sdk-go-v2/service/sts"
+	"github.com/pkg/errors"
+	"github.com/sorenmat/k8s-rds/crd"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+const (
+	// RoleAnnotation on a namespace sets the role to assume for the databases in it
+	RoleAnnotation = "k8s-rds.io/aws-role-arn"
+	// ExternalIDAnnotation on a namespace sets the external ID used when assuming the role
+	ExternalIDAnnotation = "k8s-rds.io/aws-external-id"
+	// AllowedRolesAnnotation on a namespace lists the roles the databases in it may set in their spec, comma separated
+	AllowedRolesAnnotation = "k8s-rds.io/aws-allowed-role-arns"
+	roleSessionName        = "k8s-rds"
+)
+
+// assumedRole is the role the provider should assume for a database
+type assumedRole struct {
+	ARN        string
+	ExternalID string
+}
+
+// roleFor returns the role to assume for the database. The namespace is controlled by the cluster admins, so the
+// role in the spec is only accepted when the namespace allows it, and otherwise the role of the namespace is used.
+// An empty role means the operator's own credentials are used.
+func roleFor(ctx context.Context, kc kubernetes.Interface, db *crd.Database) (assumedRole, error) {
+	ns, err := kc.CoreV1().Namespaces().Get(ctx, db.Namespace, metav1.GetOptions{})
+	if err != nil {
+		return assumedRole{}, errors.Wrap(err, fmt.Sprintf("unable to get namespace %v", db.Namespace))
+	}
+	if db.Spec.AWS == nil || db.Spec.AWS.RoleARN == "" {
+		return assumedRole{ARN: ns.Annotations[RoleAnnotation], ExternalID: ns.Annotations[ExternalIDAnnotation]}, nil
+	}
+	arn := db.Spec.AWS.RoleARN
+	if arn == ns.Annotations[RoleAnnotation] {
+		externalID := db.Spec.AWS.ExternalID
+		if externalID == "" {
+			externalID = ns.Annotations[ExternalIDAnnotation]
+		}
+		return assumedRole{ARN: arn, ExternalID: externalID}, nil
+	}
+	for _, allowed := range strings.Split(ns.Annotations[AllowedRolesAnnotation], ",") {
+		if strings.TrimSpace(allowed) == arn {
+			return assumedRole{ARN: arn, ExternalID: db.Spec.AWS.ExternalID}, nil
+		}
+	}
+	return assumedRole{}, fmt.Errorf("role %v isn't allowed in namespace %v, it must be listed in the %v annotation", arn, db.Namespace, AllowedRolesAnnotation)
+}
+
+// ConfigFor returns the AWS config with credentials for the role. The credentials are cached per role,
+// so STS is only called when they expire.
+func (e *Environment) ConfigFor(role assumedRole) aws.Config {
+	if role.ARN == "" {
+		return e.Config
+	}
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	if e.roles == nil {
+		e.roles = map[assumedRole]aws.CredentialsProvider{}
+	}
+	creds, ok := e.roles[role]
+	if !ok {
+		log.Printf("Assuming role %v\n", role.ARN)
+		creds = aws.NewCredentialsCache(stscreds.NewAssumeRoleProvider(sts.NewFromConfig(e.Config), role.ARN, func(o *stscreds.AssumeRoleOptions) {
+			o.RoleSessionName = roleSessionName
+			if role.ExternalID != "" {
+				o.ExternalID = aws.String(role.ExternalID)
+			}
+		}))
+		e.roles[role] = creds
+	}
+	cfg := e.Config.Copy()
+	cfg.Credentials = creds
+	return cfg
+}
@@ -0,0 +1,268 @@
+package rds
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/rds"
+	rdstypes "github.com/aws/aws-sdk-go-v2/service/rds/types"
+	"github.com/pkg/errors"
+	"github.com/sorenmat/k8s-rds/crd"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// reasons of the Drifted condition
+const (
+	driftReasonInSync    = "InSync"
+	driftReasonDrifted   = "SpecDrift"
+	driftReasonReverting = "Reverting"
+)
+
+func driftPolicy(db *crd.Database) string {
+	if db.Spec.AWS == nil || db.Spec.AWS.DriftPolicy == "" {
+		return crd.DriftPolicyReport
+	}
+	return db.Spec.AWS.DriftPolicy
+}
+
+// detectDrift compares the instance with the state it would be created in from the spec. Modifications that are
+// pending count as applied, so a change that is on its way isn't reported. Settings that are not in the spec are skipped.
+func detectDrift(db *crd.Database, instance *rdstypes.DBInstance) []crd.DriftedField {
+	desired := convertSpecToInput(db, "", nil, "")
+	pending := instance.PendingModifiedValues
+	if pending == nil {
+		pending = &rdstypes.PendingModifiedValues{}
+	}
+	var drift []crd.DriftedField
+	add := func(field string, desired, actual interface{}) {
+		drift = append(drift, crd.DriftedField{Field: field, Desired: fmt.Sprint(desired), Actual: fmt.Sprint(actual)})
+	}
+	orPending := func(actual, pending *string) string {
+		if pending != nil {
+			return *pending
+		}
+		return aws.ToString(actual)
+	}
+
+	if class := orPending(instance.DBInstanceClass, pending.DBInstanceClass); db.Spec.Class != "" && db.Spec.Class != class {
+		add("class", db.Spec.Class, class)
+	}
+	if db.Spec.Engine != "" && db.Spec.Engine != aws.ToString(instance.Engine) {
+		add("engine", db.Spec.Engine, aws.ToString(instance.Engine))
+	}
+	if version := orPending(instance.EngineVersion, pending.EngineVersion); desired.EngineVersion != nil && !versionMatches(*desired.EngineVersion, version) {
+		add("version", *desired.EngineVersion, version)
+	}
+
+	size := instance.AllocatedStorage
+	if pending.AllocatedStorage != nil {
+		size = *pending.AllocatedStorage
+	}
+	// the storage can only grow, and autoscaling makes it larger than the spec
+	if want := aws.ToInt32(desired.AllocatedStorage); size < want {
+		add("size", want, size)
+	}
+	if want := aws.ToInt32(desired.MaxAllocatedStorage); want > 0 && want != aws.ToInt32(instance.MaxAllocatedStorage) {
+		add("MaxAllocatedSize", want, aws.ToInt32(instance.MaxAllocatedStorage))
+	}
+	if storageType := orPending(instance.StorageType, pending.StorageType); desired.StorageType != nil && *desired.StorageType != storageType {
+		add("storagetype", *desired.StorageType, storageType)
+	}
+	iops := aws.ToInt32(instance.Iops)
+	if pending.Iops != nil {
+		iops = *pending.Iops
+	}
+	if desired.Iops != nil && *desired.Iops != iops {
+		add("iops", *desired.Iops, iops)
+	}
+	throughput := aws.ToInt32(instance.StorageThroughput)
+	if pending.StorageThroughput != nil {
+		throughput = *pending.StorageThroughput
+	}
+	if desired.StorageThroughput != nil && *desired.StorageThroughput != throughput {
+		add("storagethroughput", *desired.StorageThroughput, throughput)
+	}
+
+	multiAZ := instance.MultiAZ
+	if pending.MultiAZ != nil {
+		multiAZ = *pending.MultiAZ
+	}
+	if aws.ToBool(desired.MultiAZ) != multiAZ {
+		add("multiaz", aws.ToBool(desired.MultiAZ), multiAZ)
+	}
+	if aws.ToBool(desired.PubliclyAccessible) != instance.PubliclyAccessible {
+		add("publicaccess", aws.ToBool(desired.PubliclyAccessible), instance.PubliclyAccessible)
+	}
+	if aws.ToBool(desired.StorageEncrypted) && !instance.StorageEncrypted {
+		add("encrypted", true, false)
+	}
+	retention := instance.BackupRetentionPeriod
+	if pending.BackupRetentionPeriod != nil {
+		retention = *pending.BackupRetentionPeriod
+	}
+	if want := aws.ToInt32(desired.BackupRetentionPeriod); want != retention {
+		add("backupretentionperiod", want, retention)
+	}
+	if aws.ToBool(desired.DeletionProtection) != instance.DeletionProtection {
+		add("deleteprotection", aws.ToBool(desired.DeletionProtection), instance.DeletionProtection)
+	}
+
+	if db.Spec.Username != "" && db.Spec.Username != aws.ToString(instance.MasterUsername) {
+		add("username", db.Spec.Username, aws.ToString(instance.MasterUsername))
+	}
+	if db.Spec.DBName != "" && db.Spec.DBName != aws.ToString(instance.DBName) {
+		add("dbname", db.Spec.DBName, aws.ToString(instance.DBName))
+	}
+	if w := db.Spec.MaintenanceWindow; w != "" && !strings.EqualFold(w, aws.ToString(instance.PreferredMaintenanceWindow)) {
+		add("maintenancewindow", w, aws.ToString(instance.PreferredMaintenanceWindow))
+	}
+	if w := db.Spec.BackupWindow; w != "" && w != aws.ToString(instance.PreferredBackupWindow) {
+		add("backupwindow", w, aws.ToString(instance.PreferredBackupWindow))
+	}
+	return drift
+}
+
+// revertChanges returns the modification that puts the drifted settings back to the spec, or nil. Only the settings
+// that are set at creation are covered, the storage, version and windows are applied on every update anyway, and
+// the engine, user, database name and encryption can't be changed.
+func revertChanges(db *crd.Database, instance *rdstypes.DBInstance, drift []crd.DriftedField) *rds.ModifyDBInstanceInput {
+	desired := convertSpecToInput(db, "", nil, "")
+	input := &rds.ModifyDBInstanceInput{DBInstanceIdentifier: instance.DBInstanceIdentifier, ApplyImmediately: true}
+	changed := false
+	for _, d := range drift {
+		switch d.Field {
+		case "class":
+			input.DBInstanceClass = desired.DBInstanceClass
+		case "multiaz":
+			input.MultiAZ = desired.MultiAZ
+		case "publicaccess":
+			input.PubliclyAccessible = desired.PubliclyAccessible
+		case "backupretentionperiod":
+			input.BackupRetentionPeriod = desired.BackupRetentionPeriod
+		case "deleteprotection":
+			input.DeletionProtection = desired.DeletionProtection
+		default:
+			continue
+		}
+		changed = true
+	}
+	if !changed {
+		return nil
+	}
+	return input
+}
+
+// appliedSettings returns the settings of the spec that specChanges applies
+func appliedSettings(db *crd.Database) *crd.AppliedSettings {
+	return &crd.AppliedSettings{
+		Class:                 db.Spec.Class,
+		MultiAZ:               db.Spec.MultiAZ,
+		PubliclyAccessible:    db.Spec.PubliclyAccessible,
+		BackupRetentionPeriod: db.Spec.BackupRetentionPeriod,
+		DeleteProtection:      db.Spec.DeleteProtection,
+	}
+}
+
+// specChanges returns the modification applying the settings edited in the spec since they were last applied, or
+// nil. A setting that wasn't edited is left alone even when the instance differs, that's drift and follows the
+// drift policy. Without applied settings, as for instances created before they were recorded, nothing is modified.
+func specChanges(db *crd.Database, instance *rdstypes.DBInstance) *rds.ModifyDBInstanceInput {
+	previous := db.Status.Applied
+	if previous == nil {
+		return nil
+	}
+	desired := appliedSettings(db)
+	pending := instance.PendingModifiedValues
+	if pending == nil {
+		pending = &rdstypes.PendingModifiedValues{}
+	}
+	input := &rds.ModifyDBInstanceInput{DBInstanceIdentifier: instance.DBInstanceIdentifier, ApplyImmediately: true}
+	changed := false
+
+	class := aws.ToString(instance.DBInstanceClass)
+	if pending.DBInstanceClass != nil {
+		class = *pending.DBInstanceClass
+	}
+	if desired.Class != "" && desired.Class != previous.Class && desired.Class != class {
+		input.DBInstanceClass = aws.String(desired.Class)
+		changed = true
+	}
+	multiAZ := instance.MultiAZ
+	if pending.MultiAZ != nil {
+		multiAZ = *pending.MultiAZ
+	}
+	if desired.MultiAZ != previous.MultiAZ && desired.MultiAZ != multiAZ {
+		input.MultiAZ = aws.Bool(desired.MultiAZ)
+		changed = true
+	}
+	if desired.PubliclyAccessible != previous.PubliclyAccessible && desired.PubliclyAccessible != instance.PubliclyAccessible {
+		input.PubliclyAccessible = aws.Bool(desired.PubliclyAccessible)
+		changed = true
+	}
+	retention := instance.BackupRetentionPeriod
+	if pending.BackupRetentionPeriod != nil {
+		retention = *pending.BackupRetentionPeriod
+	}
+	if desired.BackupRetentionPeriod != previous.BackupRetentionPeriod && int32(desired.BackupRetentionPeriod) != retention {
+		input.BackupRetentionPeriod = aws.Int32(int32(desired.BackupRetentionPeriod))
+		changed = true
+	}
+	if desired.DeleteProtection != previous.DeleteProtection && desired.DeleteProtection != instance.DeletionProtection {
+		input.DeletionProtection = aws.Bool(desired.DeleteProtection)
+		changed = true
+	}
+	if !changed {
+		return nil
+	}
+	return input
+}
+
+// reportDrift sets the field list and the Drifted condition
+func reportDrift(db *crd.Database, drift []crd.DriftedField, reverting bool) {
+	db.Status.Drift = drift
+	condition := metav1.Condition{
+		Type:               crd.ConditionDrifted,
+		Status:             metav1.ConditionFalse,
+		Reason:             driftReasonInSync,
+		Message:            "The instance matches the spec",
+		ObservedGeneration: db.Generation,
+	}
+	if len(drift) > 0 {
+		var fields []string
+		for _, d := range drift {
+			fields = append(fields, d.Field)
+		}
+		condition.Status = metav1.ConditionTrue
+		condition.Reason = driftReasonDrifted
+		condition.Message = fmt.Sprintf("The instance differs from the spec: %v", strings.Join(fields, ", "))
+		if reverting {
+			condition.Reason = driftReasonReverting
+		}
+	}
+	// the conditions are shared with the object in the informer cache, they're updated on a copy
+	db.Status.Conditions = append([]metav1.Condition(nil), db.Status.Conditions...)
+	meta.SetStatusCondition(&db.Status.Conditions, condition)
+}
+
+// ensureDrift reports the differences between the instance and the spec, and reverts them when the drift policy says so
+func (r *RDS) ensureDrift(ctx context.Context, db *crd.Database, instance *rdstypes.DBInstance) error {
+	drift := detectDrift(db, instance)
+	reverting := false
+	if driftPolicy(db) == crd.DriftPolicyRevert {
+		if input := revertChanges(db, instance, drift); input != nil {
+			log.Printf("Reverting the drift of %v\n", *instance.DBInstanceIdentifier)
+			if _, err := r.rdsclient().ModifyDBInstance(ctx, input); err != nil {
+				return errors.Wrap(err, "ModifyDBInstance")
+			}
+			reverting = true
+		}
+	} else if len(drift) > 0 {
+		log.Printf("db instance %v differs from the spec of %v in %v\n", *instance.DBInstanceIdentifier, db.Name, db.Namespace)
+	}
+	reportDrift(db, drift, reverting)
+	return nil
+}
@@ -0,0 +1,115 @@
+package rds
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/feature/ec2/imds"
+	"github.com/pkg/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+const (
+	regionLabel       = "topology.kubernetes.io/region"
+	legacyRegionLabel = "failure-domain.beta.kubernetes.io/region"
+	imdsTimeout       = 5 * time.Second
+)
+
+// Environment is what the operator knows about the AWS account it runs in. It's discovered once at
+// startup and shared by all the databases.
+type Environment struct {
+	Config      aws.Config
+	Region      string
+	InstanceID  string // EC2 instance the VPC, subnets and security groups are taken from
+	ClusterName string // tagged on the instances, taken from the tags of the node when empty
+
+	lock  sync.Mutex
+	roles map[assumedRole]aws.CredentialsProvider
+}
+
+// Discover resolves the region and the EC2 instance of the cluster. The region is taken from, in order,
+// the region argument, the topology label of the nodes, the EC2 instance metadata and the SDK default chain.
+func Discover(ctx context.Context, kc kubernetes.Interface, region string) (*Environment, error) {
+	cfg, err := config.LoadDefaultConfig(ctx)
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to load SDK config")
+	}
+
+	var labels map[string]string
+	instanceID := ""
+	nodes, err := kc.CoreV1().Nodes().List(ctx, metav1.ListOptions{})
+	if err != nil {
+		log.Println(errors.Wrap(err, "unable to get nodes"))
+	} else if len(nodes.Items) > 0 {
+		// take the first one, we assume that all nodes are created in the same VPC
+		labels = nodes.Items[0].Labels
+		instanceID = getIDFromProvider(nodes.Items[0].Spec.ProviderID)
+	}
+
+	var document *imds.InstanceIdentityDocument
+	identity := func() (*imds.InstanceIdentityDocument, error) {
+		if document != nil {
+			return document, nil
+		}
+		ctx, cancel := context.WithTimeout(ctx, imdsTimeout)
+		defer cancel()
+		res, err := imds.NewFromConfig(cfg).GetInstanceIdentityDocument(ctx, &imds.GetInstanceIdentityDocumentInput{})
+		if err != nil {
+			return nil, err
+		}
+		document = &res.InstanceIdentityDocument
+		return document, nil
+	}
+
+	region, source := resolveRegion(region, labels, func() (string, error) {
+		doc, err := identity()
+		if err != nil {
+			return "", err
+		}
+		return doc.Region, nil
+	}, cfg.Region)
+	if region == "" {
+		return nil, errors.New("unable to resolve the AWS region, use --aws-region to set it")
+	}
+	log.Printf("Using AWS region %v from %v\n", region, source)
+	cfg.Region = region
+
+	if instanceID == "" {
+		doc, err := identity()
+		if err != nil {
+			return nil, errors.Wrap(err, "unable to find the EC2 instance from the nodes or the instance metadata")
+		}
+		instanceID = doc.InstanceID
+		log.Printf("Using EC2 instance %v from the instance metadata\n", instanceID)
+	} else {
+		log.Printf("Using EC2 instance %v from the node provider ID\n", instanceID)
+	}
+
+	return &Environment{Config: cfg, Region: region, InstanceID: instanceID}, nil
+}
+
+// resolveRegion returns the first region found and where it was found
+func resolveRegion(flag string, labels map[string]string, metadata func() (string, error), sdkDefault string) (string, string) {
+	if flag != "" {
+		return flag, "the --aws-region flag"
+	}
+	if r := labels[regionLabel]; r != "" {
+		return r, "the " + regionLabel + " node label"
+	}
+	if r := labels[legacyRegionLabel]; r != "" {
+		return r, "the " + legacyRegionLabel + " node label"
+	}
+	r, err := metadata()
+	if err == nil && r != "" {
+		return r, "the EC2 instance metadata"
+	}
+	if err != nil {
+		log.Printf("unable to get the region from the EC2 instance metadata: %v\n", err)
+	}
+	return sdkDefault, "the SDK default configuration"
+}
@@ -0,0 +1,247 @@
+package rds
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"regexp"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	ec2types "github.com/aws/aws-sdk-go-v2/service/ec2/types"
+	"github.com/aws/aws-sdk-go-v2/service/rds"
+	rdstypes "github.com/aws/aws-sdk-go-v2/service/rds/types"
+	"github.com/pkg/errors"
+	"github.com/sorenmat/k8s-rds/provider"
+)
+
+// subnetGroupOwner matches the description of the subnet groups created per database
+var subnetGroupOwner = regexp.MustCompile(`^RDS Subnet Group for database (\S+) in namespace (\S+)$`)
+
+// parameterGroupOwner matches the description of the parameter groups created per database
+var parameterGroupOwner = regexp.MustCompile(`^Parameters for database (\S+) in namespace (\S+)$`)
+
+// securityGroupOwner matches the description of the security groups created per database
+var securityGroupOwner = regexp.MustCompile(`^Access to database (\S+) in namespace (\S+)$`)
+
+// ownerNamespace returns the namespace of the Database found in the description, or an empty string
+func ownerNamespace(owner *regexp.Regexp, description string) string {
+	if m := owner.FindStringSubmatch(description); m != nil {
+		return m[2]
+	}
+	return ""
+}
+
+func tagMap(tags []rdstypes.Tag) map[string]string {
+	result := map[string]string{}
+	for _, t := range tags {
+		result[aws.ToString(t.Key)] = aws.ToString(t.Value)
+	}
+	return result
+}
+
+// orphanInstance tells if the instance was created by the operator of the cluster for a Database that must not be kept.
+// Instances without the owner tags, like the ones created before they existed or adopted ones, are never orphans.
+func orphanInstance(instance rdstypes.DBInstance, clusterName string, keep func(namespace, name string) bool) bool {
+	tags := tagMap(instance.TagList)
+	namespace, okNamespace := tags[namespaceTag]
+	name, okName := tags[databaseTag]
+	if !okNamespace || !okName || tags[clusterTag] != clusterName {
+		return false
+	}
+	return !keep(namespace, name)
+}
+
+// orphanSubnetGroup tells if the subnet group was created by the operator and isn't needed anymore. The per database
+// groups are found from their description, the shared groups are orphans once no instances are using them.
+func orphanSubnetGroup(group rdstypes.DBSubnetGroup, tags []rdstypes.Tag, used map[string]bool, vpcID string, keep func(namespace, name string) bool) bool {
+	name := aws.ToString(group.DBSubnetGroupName)
+	if used[name] || aws.ToString(group.VpcId) != vpcID || tagMap(tags)["Warning"] != "Managed by k8s-rds." {
+		return false
+	}
+	if isSharedSubnetGroup(name) {
+		return true
+	}
+	owner := subnetGroupOwner.FindStringSubmatch(aws.ToString(group.DBSubnetGroupDescription))
+	return owner != nil && !keep(owner[2], owner[1])
+}
+
+// orphanParameterGroup tells if the parameter group was created by the operator for a Database that must not be kept,
+// and no instances are using it
+func orphanParameterGroup(group rdstypes.DBParameterGroup, tags []rdstypes.Tag, used map[string]bool, keep func(namespace, name string) bool) bool {
+	if used[aws.ToString(group.DBParameterGroupName)] || tagMap(tags)["Warning"] != "Managed by k8s-rds." {
+		return false
+	}
+	owner := parameterGroupOwner.FindStringSubmatch(aws.ToString(group.Description))
+	return owner != nil && !keep(owner[2], owner[1])
+}
+
+// orphanSecurityGroup tells if the security group was created by the operator for a Database that must not be kept,
+// and no instances are using it
+func orphanSecurityGroup(group ec2types.SecurityGroup, used map[string]bool, vpcID string, keep func(namespace, name string) bool) bool {
+	if used[aws.ToString(group.GroupId)] || aws.ToString(group.VpcId) != vpcID {
+		return false
+	}
+	managed := false
+	for _, t := range group.Tags {
+		if aws.ToString(t.Key) == "Warning" && aws.ToString(t.Value) == "Managed by k8s-rds." {
+			managed = true
+		}
+	}
+	owner := securityGroupOwner.FindStringSubmatch(aws.ToString(group.Description))
+	return managed && owner != nil && !keep(owner[2], owner[1])
+}
+
+// Orphans lists the instances, subnet groups, parameter groups and security groups created by the operator in the account of the environment for a
+// Database that doesn't exist anymore. keep tells if the Database with the namespace and name must be kept.
+func Orphans(ctx context.Context, env *Environment, keep func(namespace, name string) bool) ([]provider.Orphan, error) {
+	nodeInfo, err := describeNodeEC2Instance(ctx, env.InstanceID, ec2.NewFromConfig(env.Config))
+	if err != nil {
+		return nil, err
+	}
+	node := nodeInfo.Reservations[0].Instances[0]
+	clusterName := env.ClusterName
+	if clusterName == "" {
+		clusterName = clusterNameFromTags(node.Tags)
+	}
+	r := &RDS{Config: env.Config}
+	svc := r.rdsclient()
+
+	var result []provider.Orphan
+	used := map[string]bool{}
+	usedParameterGroups := map[string]bool{}
+	usedSecurityGroups := map[string]bool{}
+	input := &rds.DescribeDBInstancesInput{}
+	for {
+		res, err := svc.DescribeDBInstances(ctx, input)
+		if err != nil {
+			return nil, errors.Wrap(err, "DescribeDBInstances")
+		}
+		for _, instance := range res.DBInstances {
+			if instance.DBSubnetGroup != nil {
+				used[aws.ToString(instance.DBSubnetGroup.DBSubnetGroupName)] = true
+			}
+			for _, g := range instance.DBParameterGroups {
+				usedParameterGroups[aws.ToString(g.DBParameterGroupName)] = true
+			}
+			for _, g := range instance.VpcSecurityGroups {
+				usedSecurityGroups[aws.ToString(g.VpcSecurityGroupId)] = true
+			}
+			if orphanInstance(instance, clusterName, keep) {
+				id := aws.ToString(instance.DBInstanceIdentifier)
+				tags := tagMap(instance.TagList)
+				result = append(result, provider.Orphan{Kind: "RDS instance", Name: id, Namespace: tags[namespaceTag], Delete: func(ctx context.Context) error {
+					return r.deleteOrphanInstance(ctx, id)
+				}})
+			}
+		}
+		if res.Marker == nil || *res.Marker == "" {
+			break
+		}
+		input.Marker = res.Marker
+	}
+
+	groups := &rds.DescribeDBSubnetGroupsInput{}
+	for {
+		res, err := svc.DescribeDBSubnetGroups(ctx, groups)
+		if err != nil {
+			return nil, errors.Wrap(err, "DescribeDBSubnetGroups")
+		}
+		for _, group := range res.DBSubnetGroups {
+			if used[aws.ToString(group.DBSubnetGroupName)] || aws.ToString(group.VpcId) != aws.ToString(node.VpcId) {
+				continue
+			}
+			tags, err := svc.ListTagsForResource(ctx, &rds.ListTagsForResourceInput{ResourceName: group.DBSubnetGroupArn})
+			if err != nil {
+				return nil, errors.Wrap(err, "ListTagsForResource")
+			}
+			if orphanSubnetGroup(group, tags.TagList, used, aws.ToString(node.VpcId), keep) {
+				name := aws.ToString(group.DBSubnetGroupName)
+				namespace := ownerNamespace(subnetGroupOwner, aws.ToString(group.DBSubnetGroupDescription))
+				result = append(result, provider.Orphan{Kind: "DB subnet group", Name: name, Namespace: namespace, Delete: func(ctx context.Context) error {
+					return r.deleteSubnetGroup(ctx, name)
+				}})
+			}
+		}
+		if res.Marker == nil || *res.Marker == "" {
+			break
+		}
+		groups.Marker = res.Marker
+	}
+
+	parameterGroups := &rds.DescribeDBParameterGroupsInput{}
+	for {
+		res, err := svc.DescribeDBParameterGroups(ctx, parameterGroups)
+		if err != nil {
+			return nil, errors.Wrap(err, "DescribeDBParameterGroups")
+		}
+		for _, group := range res.DBParameterGroups {
+			name := aws.ToString(group.DBParameterGroupName)
+			if usedParameterGroups[name] || !parameterGroupOwner.MatchString(aws.ToString(group.Description)) {
+				continue
+			}
+			tags, err := svc.ListTagsForResource(ctx, &rds.ListTagsForResourceInput{ResourceName: group.DBParameterGroupArn})
+			if err != nil {
+				return nil, errors.Wrap(err, "ListTagsForResource")
+			}
+			if orphanParameterGroup(group, tags.TagList, usedParameterGroups, keep) {
+				namespace := ownerNamespace(parameterGroupOwner, aws.ToString(group.Description))
+				result = append(result, provider.Orphan{Kind: "DB parameter group", Name: name, Namespace: namespace, Delete: func(ctx context.Context) error {
+					_, err := svc.DeleteDBParameterGroup(ctx, &rds.DeleteDBParameterGroupInput{DBParameterGroupName: aws.String(name)})
+					return errors.Wrap(err, fmt.Sprintf("unable to delete parameter group %v", name))
+				}})
+			}
+		}
+		if res.Marker == nil || *res.Marker == "" {
+			break
+		}
+		parameterGroups.Marker = res.Marker
+	}
+
+	ec2svc := ec2.NewFromConfig(env.Config)
+	securityGroups := &ec2.DescribeSecurityGroupsInput{Filters: []ec2types.Filter{
+		{Name: aws.String("vpc-id"), Values: []string{aws.ToString(node.VpcId)}},
+		{Name: aws.String("tag:Warning"), Values: []string{"Managed by k8s-rds."}},
+	}}
+	for {
+		res, err := ec2svc.DescribeSecurityGroups(ctx, securityGroups)
+		if err != nil {
+			return nil, errors.Wrap(err, "DescribeSecurityGroups")
+		}
+		for _, group := range res.SecurityGroups {
+			if orphanSecurityGroup(group, usedSecurityGroups, aws.ToString(node.VpcId), keep) {
+				id, name := group.GroupId, aws.ToString(group.GroupName)
+				namespace := ownerNamespace(securityGroupOwner, aws.ToString(group.Description))
+				result = append(result, provider.Orphan{Kind: "Security group", Name: name, Namespace: namespace, Delete: func(ctx context.Context) error {
+					_, err := ec2svc.DeleteSecurityGroup(ctx, &ec2.DeleteSecurityGroupInput{GroupId: id})
+					return errors.Wrap(err, fmt.Sprintf("unable to delete security group %v", name))
+				}})
+			}
+		}
+		if res.NextToken == nil || *res.NextToken == "" {
+			break
+		}
+		securityGroups.NextToken = res.NextToken
+	}
+	return result, nil
+}
+
+// finalSnapshotName returns the name of the snapshot taken when the instance is deleted, ex: orders-shop-gc-20210607090000
+func finalSnapshotName(id, reason string, now time.Time) string {
+	return fmt.Sprintf("%v-%v-%v", id, reason, now.UTC().Format("20060102150405"))
+}
+
+// deleteOrphanInstance deletes the instance with a final snapshot, the data might still be wanted
+func (r *RDS) deleteOrphanInstance(ctx context.Context, id string) error {
+	snapshot := finalSnapshotName(id, "gc", time.Now())
+	log.Printf("Deleting db instance %v with final snapshot %v\n", id, snapshot)
+	_, err := r.rdsclient().DeleteDBInstance(ctx, &rds.DeleteDBInstanceInput{
+		DBInstanceIdentifier:      aws.String(id),
+		FinalDBSnapshotIdentifier: aws.String(snapshot),
+	})
+	if err != nil {
+		return errors.Wrap(err, fmt.Sprintf("unable to delete db instance %v", id))
+	}
+	return nil
+}
@@ -0,0 +1,180 @@
+package rds
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/iam"
+	iamtypes "github.com/aws/aws-sdk-go-v2/service/iam/types"
+	"github.com/aws/aws-sdk-go-v2/service/rds"
+	rdstypes "github.com/aws/aws-sdk-go-v2/service/rds/types"
+	"github.com/pkg/errors"
+	"github.com/sorenmat/k8s-rds/crd"
+)
+
+// policyDocument is an IAM policy allowing to connect as database users
+type policyDocument struct {
+	Version   string
+	Statement []policyStatement
+}
+
+type policyStatement struct {
+	Effect   string
+	Action   string
+	Resource []string
+}
+
+func iamAuthentication(db *crd.Database) bool {
+	return db.Spec.AWS != nil && db.Spec.AWS.IAMAuthentication
+}
+
+// connectPolicyName is the name of the inline policy put on the roles of the applications
+func connectPolicyName(db *crd.Database) string {
+	return "k8s-rds-" + dbidentifier(db)
+}
+
+// roleName returns the name of the role from its ARN, the path is not part of the name
+func roleName(arn string) string {
+	return arn[strings.LastIndex(arn, "/")+1:]
+}
+
+// arnAccount returns the account of the ARN, or an empty string when it can't be parsed
+func arnAccount(arn string) string {
+	parts := strings.Split(arn, ":")
+	if len(parts) < 6 {
+		return ""
+	}
+	return parts[4]
+}
+
+// dbUserARN returns the resource used in rds-db:connect policies for the user, built from the ARN
+// of the instance, ex: arn:aws:rds-db:eu-west-1:123456789012:dbuser:db-ABCDEFGHIJKL/app
+func dbUserARN(instanceARN, resourceID, user string) (string, error) {
+	parts := strings.Split(instanceARN, ":")
+	if len(parts) < 5 {
+		return "", fmt.Errorf("unable to parse the ARN of the db instance %v", instanceARN)
+	}
+	return fmt.Sprintf("arn:%v:rds-db:%v:%v:dbuser:%v/%v", parts[1], parts[3], parts[4], resourceID, user), nil
+}
+
+// connectPolicies returns the policy document for each role that is allowed to connect to the database
+func connectPolicies(db *crd.Database, instanceARN, resourceID string) (map[string]string, error) {
+	result := map[string]string{}
+	if !iamAuthentication(db) {
+		return result, nil
+	}
+	users := map[string][]string{}
+	account := arnAccount(instanceARN)
+	for _, u := range db.Spec.AWS.IAMUsers {
+		arn, err := dbUserARN(instanceARN, resourceID, u.User)
+		if err != nil {
+			return nil, err
+		}
+		for _, role := range u.RoleARNs {
+			// the policy is put on the role by name, so it must be in the account of the instance
+			if arnAccount(role) != account {
+				return nil, fmt.Errorf("role %v of user %v isn't in account %v of the db instance", role, u.User, account)
+			}
+			users[role] = append(users[role], arn)
+		}
+	}
+	for role, resources := range users {
+		sort.Strings(resources)
+		doc, err := json.Marshal(policyDocument{
+			Version:   "2012-10-17",
+			Statement: []policyStatement{{Effect: "Allow", Action: "rds-db:connect", Resource: resources}},
+		})
+		if err != nil {
+			return nil, err
+		}
+		result[role] = string(doc)
+	}
+	return result, nil
+}
+
+// ensureIAMAuthentication turns IAM authentication on or off for an existing instance
+func (r *RDS) ensureIAMAuthentication(ctx context.Context, db *crd.Database, instance *rdstypes.DBInstance) error {
+	if instance.IAMDatabaseAuthenticationEnabled == iamAuthentication(db) {
+		return nil
+	}
+	log.Printf("Setting IAM authentication of %v to %v\n", *instance.DBInstanceIdentifier, iamAuthentication(db))
+	_, err := r.rdsclient().ModifyDBInstance(ctx, &rds.ModifyDBInstanceInput{
+		DBInstanceIdentifier:            instance.DBInstanceIdentifier,
+		EnableIAMDatabaseAuthentication: aws.Bool(iamAuthentication(db)),
+		ApplyImmediately:                true,
+	})
+	if err != nil {
+		return errors.Wrap(err, "ModifyDBInstance")
+	}
+	return nil
+}
+
+// ensureConnectPolicies puts the rds-db:connect policy on the roles listed in the spec, and removes it from
+// the roles that are no longer listed. The resource ID and the roles are reported in the status.
+func (r *RDS) ensureConnectPolicies(ctx context.Context, db *crd.Database, instance *rdstypes.DBInstance) error {
+	resourceID := aws.ToString(instance.DbiResourceId)
+	db.Status.ResourceID = resourceID
+	policies, err := connectPolicies(db, aws.ToString(instance.DBInstanceArn), resourceID)
+	if err != nil {
+		return err
+	}
+
+	svc := iam.NewFromConfig(r.Config)
+	name := aws.String(connectPolicyName(db))
+	var roles []string
+	for role, doc := range policies {
+		_, err := svc.PutRolePolicy(ctx, &iam.PutRolePolicyInput{
+			RoleName:       aws.String(roleName(role)),
+			PolicyName:     name,
+			PolicyDocument: aws.String(doc),
+		})
+		if err != nil {
+			return errors.Wrap(err, fmt.Sprintf("unable to put policy %v on role %v", *name, role))
+		}
+		roles = append(roles, role)
+	}
+	sort.Strings(roles)
+
+	for _, role := range db.Status.IAMRoles {
+		if _, ok := policies[role]; ok {
+			continue
+		}
+		if err := deleteConnectPolicy(ctx, svc, role, *name); err != nil {
+			return err
+		}
+	}
+	db.Status.IAMRoles = roles
+	return nil
+}
+
+// deleteConnectPolicies removes the rds-db:connect policy from all the roles it was put on
+func (r *RDS) deleteConnectPolicies(ctx context.Context, db *crd.Database) error {
+	svc := iam.NewFromConfig(r.Config)
+	for _, role := range db.Status.IAMRoles {
+		if err := deleteConnectPolicy(ctx, svc, role, connectPolicyName(db)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func deleteConnectPolicy(ctx context.Context, svc *iam.Client, role, name string) error {
+	_, err := svc.DeleteRolePolicy(ctx, &iam.DeleteRolePolicyInput{
+		RoleName:   aws.String(roleName(role)),
+		PolicyName: aws.String(name),
+	})
+	if err != nil {
+		var notFound *iamtypes.NoSuchEntityException
+		if errors.As(err, &notFound) {
+			return nil
+		}
+		return errors.Wrap(err, fmt.Sprintf("unable to delete policy %v from role %v", name, role))
+	}
+	log.Printf("Deleted policy %v from role %v\n", name, role)
+	return nil
+}
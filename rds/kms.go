@@ -0,0 +1,93 @@
+package rds
+
+import (
+	"context"
+	"fmt"
+	"log"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/kms"
+	kmstypes "github.com/aws/aws-sdk-go-v2/service/kms/types"
+	rdstypes "github.com/aws/aws-sdk-go-v2/service/rds/types"
+	"github.com/pkg/errors"
+	"github.com/sorenmat/k8s-rds/crd"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// kmsKeyID returns the customer managed key set in the spec, empty means the default aws/rds key
+func kmsKeyID(db *crd.Database) string {
+	if db.Spec.AWS == nil {
+		return ""
+	}
+	return db.Spec.AWS.KMSKeyID
+}
+
+// validateKMSKey checks that the key in the spec can be used for the storage encryption, and returns its ARN
+func (r *RDS) validateKMSKey(ctx context.Context, db *crd.Database) (string, error) {
+	id := kmsKeyID(db)
+	if id == "" {
+		return "", nil
+	}
+	res, err := kms.NewFromConfig(r.Config).DescribeKey(ctx, &kms.DescribeKeyInput{KeyId: aws.String(id)})
+	if err != nil {
+		return "", errors.Wrap(err, fmt.Sprintf("unable to describe KMS key %v", id))
+	}
+	if err := checkKey(id, res.KeyMetadata); err != nil {
+		return "", err
+	}
+	return aws.ToString(res.KeyMetadata.Arn), nil
+}
+
+// checkKey returns an error if the key can't encrypt the storage of a database
+func checkKey(id string, key *kmstypes.KeyMetadata) error {
+	if key == nil {
+		return fmt.Errorf("KMS key %v not found", id)
+	}
+	if key.KeyState != kmstypes.KeyStateEnabled {
+		return fmt.Errorf("KMS key %v is %v, it must be enabled", id, key.KeyState)
+	}
+	if key.KeyUsage != kmstypes.KeyUsageTypeEncryptDecrypt {
+		return fmt.Errorf("KMS key %v is used for %v, it must be used for %v", id, key.KeyUsage, kmstypes.KeyUsageTypeEncryptDecrypt)
+	}
+	if key.KeySpec != "" && key.KeySpec != kmstypes.KeySpecSymmetricDefault {
+		return fmt.Errorf("KMS key %v is a %v key, only symmetric keys can encrypt a database", id, key.KeySpec)
+	}
+	return nil
+}
+
+// ensureKMSKey checks that the instance is encrypted with the key from the spec. The key of an existing instance
+// can't be changed, a different key is reported with the KMSKeyMismatch condition and the rest of the spec is
+// still applied.
+func (r *RDS) ensureKMSKey(ctx context.Context, db *crd.Database, instance *rdstypes.DBInstance) error {
+	arn, err := r.validateKMSKey(ctx, db)
+	if err != nil {
+		return err
+	}
+	kmsKeyCondition(db, aws.ToString(instance.KmsKeyId), arn)
+	return nil
+}
+
+// kmsKeyCondition sets the KMSKeyMismatch condition, it's removed when the spec has no key
+func kmsKeyCondition(db *crd.Database, current, arn string) {
+	// the conditions are shared with the object in the informer cache, they're updated on a copy
+	db.Status.Conditions = append([]metav1.Condition(nil), db.Status.Conditions...)
+	if arn == "" {
+		meta.RemoveStatusCondition(&db.Status.Conditions, crd.ConditionKMSKeyMismatch)
+		return
+	}
+	condition := metav1.Condition{
+		Type:               crd.ConditionKMSKeyMismatch,
+		Status:             metav1.ConditionFalse,
+		Reason:             "KeyMatches",
+		Message:            "The storage is encrypted with the key from the spec",
+		ObservedGeneration: db.Generation,
+	}
+	if current != arn {
+		log.Printf("Database %v is encrypted with %v, the key can't be changed to %v\n", db.Name, current, arn)
+		condition.Status = metav1.ConditionTrue
+		condition.Reason = "KeyCantChange"
+		condition.Message = fmt.Sprintf("The storage is encrypted with %v, the key of an existing database can't be changed to %v", current, arn)
+	}
+	meta.SetStatusCondition(&db.Status.Conditions, condition)
+}
@@ -0,0 +1,190 @@
+package rds
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/iam"
+	iamtypes "github.com/aws/aws-sdk-go-v2/service/iam/types"
+	"github.com/aws/aws-sdk-go-v2/service/rds"
+	rdstypes "github.com/aws/aws-sdk-go-v2/service/rds/types"
+	"github.com/pkg/errors"
+	"github.com/sorenmat/k8s-rds/crd"
+)
+
+const (
+	// monitoringRoleName is the role created by the operator for enhanced monitoring, it's shared by all databases
+	monitoringRoleName   = "k8s-rds-monitoring"
+	monitoringPolicyPath = "policy/service-role/AmazonRDSEnhancedMonitoringRole"
+	monitoringTrust      = `{"Version":"2012-10-17","Statement":[{"Effect":"Allow","Principal":{"Service":"monitoring.rds.amazonaws.com"},"Action":"sts:AssumeRole"}]}`
+)
+
+func monitoring(db *crd.Database) *crd.MonitoringSpec {
+	if db.Spec.AWS == nil {
+		return nil
+	}
+	return db.Spec.AWS.Monitoring
+}
+
+// validateMonitoring checks the settings the schema can't express
+func validateMonitoring(db *crd.Database) error {
+	m := monitoring(db)
+	if m == nil {
+		return nil
+	}
+	if days := m.PerformanceInsightsRetentionDays; days != 0 && days != 7 && days != 731 && days%31 != 0 {
+		return fmt.Errorf("performance insights retention must be 7, 731 or a multiple of 31 days, not %v", days)
+	}
+	return nil
+}
+
+// ensureMonitoringRole returns the role RDS sends the enhanced monitoring metrics with, the role managed by
+// the operator is created the first time it's needed. An empty role is returned if enhanced monitoring is off.
+func (r *RDS) ensureMonitoringRole(ctx context.Context, db *crd.Database) (string, error) {
+	m := monitoring(db)
+	if m == nil || m.Interval == 0 {
+		return "", nil
+	}
+	if m.RoleARN != "" {
+		return m.RoleARN, nil
+	}
+
+	svc := iam.NewFromConfig(r.Config)
+	var arn string
+	res, err := svc.GetRole(ctx, &iam.GetRoleInput{RoleName: aws.String(monitoringRoleName)})
+	var notFound *iamtypes.NoSuchEntityException
+	switch {
+	case err == nil:
+		arn = aws.ToString(res.Role.Arn)
+	case errors.As(err, &notFound):
+		log.Printf("Creating enhanced monitoring role %v\n", monitoringRoleName)
+		created, err := svc.CreateRole(ctx, &iam.CreateRoleInput{
+			RoleName:                 aws.String(monitoringRoleName),
+			AssumeRolePolicyDocument: aws.String(monitoringTrust),
+			Description:              aws.String("Enhanced monitoring of the databases created by k8s-rds"),
+			Tags:                     []iamtypes.Tag{{Key: aws.String("Warning"), Value: aws.String("Managed by k8s-rds.")}},
+		})
+		if err != nil {
+			return "", errors.Wrap(err, "CreateRole")
+		}
+		arn = aws.ToString(created.Role.Arn)
+	default:
+		return "", errors.Wrap(err, "GetRole")
+	}
+
+	// attaching is repeated every time, the role may have been created by a run that failed to attach the policy
+	_, err = svc.AttachRolePolicy(ctx, &iam.AttachRolePolicyInput{
+		RoleName:  aws.String(monitoringRoleName),
+		PolicyArn: aws.String(monitoringPolicyARN(arn)),
+	})
+	if err != nil {
+		return "", errors.Wrap(err, "AttachRolePolicy")
+	}
+	return arn, nil
+}
+
+// monitoringPolicyARN returns the ARN of the AWS managed enhanced monitoring policy in the partition of the role
+func monitoringPolicyARN(roleARN string) string {
+	partition := "aws"
+	if parts := strings.Split(roleARN, ":"); len(parts) > 1 {
+		partition = parts[1]
+	}
+	return fmt.Sprintf("arn:%v:iam::aws:%v", partition, monitoringPolicyPath)
+}
+
+// applyMonitoring sets the monitoring settings of the spec on the create request
+func applyMonitoring(input *rds.CreateDBInstanceInput, db *crd.Database, roleARN string) {
+	m := monitoring(db)
+	if m == nil {
+		return
+	}
+	if m.PerformanceInsights {
+		input.EnablePerformanceInsights = aws.Bool(true)
+		if m.PerformanceInsightsRetentionDays > 0 {
+			input.PerformanceInsightsRetentionPeriod = aws.Int32(int32(m.PerformanceInsightsRetentionDays))
+		}
+		if m.PerformanceInsightsKMSKeyID != "" {
+			input.PerformanceInsightsKMSKeyId = aws.String(m.PerformanceInsightsKMSKeyID)
+		}
+	}
+	if m.Interval > 0 {
+		input.MonitoringInterval = aws.Int32(int32(m.Interval))
+		input.MonitoringRoleArn = aws.String(roleARN)
+	}
+	if len(m.LogExports) > 0 {
+		input.EnableCloudwatchLogsExports = m.LogExports
+	}
+}
+
+// logExportChanges returns the log types to enable and to disable
+func logExportChanges(wanted, current []string) ([]string, []string) {
+	enabled := map[string]bool{}
+	for _, l := range current {
+		enabled[l] = true
+	}
+	var enable, disable []string
+	for _, l := range wanted {
+		if !enabled[l] {
+			enable = append(enable, l)
+		}
+		delete(enabled, l)
+	}
+	for l := range enabled {
+		disable = append(disable, l)
+	}
+	sort.Strings(enable)
+	sort.Strings(disable)
+	return enable, disable
+}
+
+// monitoringChanges returns the modification needed to apply the monitoring settings of the spec, or nil
+// when the instance already has them. The instance is left as it is when the spec has no monitoring settings.
+func monitoringChanges(db *crd.Database, instance *rdstypes.DBInstance, roleARN string) *rds.ModifyDBInstanceInput {
+	m := monitoring(db)
+	if m == nil {
+		return nil
+	}
+	input := &rds.ModifyDBInstanceInput{DBInstanceIdentifier: instance.DBInstanceIdentifier, ApplyImmediately: true}
+	changed := false
+
+	enabled := aws.ToBool(instance.PerformanceInsightsEnabled)
+	if m.PerformanceInsights != enabled {
+		input.EnablePerformanceInsights = aws.Bool(m.PerformanceInsights)
+		if m.PerformanceInsights && m.PerformanceInsightsKMSKeyID != "" {
+			input.PerformanceInsightsKMSKeyId = aws.String(m.PerformanceInsightsKMSKeyID)
+		}
+		changed = true
+	}
+	if days := int32(m.PerformanceInsightsRetentionDays); m.PerformanceInsights && days > 0 && days != aws.ToInt32(instance.PerformanceInsightsRetentionPeriod) {
+		input.EnablePerformanceInsights = aws.Bool(true)
+		input.PerformanceInsightsRetentionPeriod = aws.Int32(days)
+		changed = true
+	}
+
+	if interval := int32(m.Interval); interval != aws.ToInt32(instance.MonitoringInterval) ||
+		(interval > 0 && roleARN != aws.ToString(instance.MonitoringRoleArn)) {
+		input.MonitoringInterval = aws.Int32(interval)
+		if interval > 0 {
+			input.MonitoringRoleArn = aws.String(roleARN)
+		}
+		changed = true
+	}
+
+	enable, disable := logExportChanges(m.LogExports, instance.EnabledCloudwatchLogsExports)
+	if len(enable) > 0 || len(disable) > 0 {
+		input.CloudwatchLogsExportConfiguration = &rdstypes.CloudwatchLogsExportConfiguration{
+			EnableLogTypes:  enable,
+			DisableLogTypes: disable,
+		}
+		changed = true
+	}
+
+	if !changed {
+		return nil
+	}
+	return input
+}
@@ -0,0 +1,185 @@
+package rds
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	ec2types "github.com/aws/aws-sdk-go-v2/service/ec2/types"
+	"github.com/aws/smithy-go"
+	"github.com/pkg/errors"
+	"github.com/sorenmat/k8s-rds/crd"
+)
+
+// hasCustomSubnets tells if the subnets are selected in the spec instead of being discovered from the nodes
+func hasCustomSubnets(db *crd.Database) bool {
+	return db.Spec.AWS != nil && (len(db.Spec.AWS.SubnetIDs) > 0 || len(db.Spec.AWS.SubnetSelector) > 0)
+}
+
+// resolveSubnets returns the subnets and the VPC for the database. Explicit subnet IDs take precedence
+// over the tag selector, when neither is set the subnets are discovered from the VPC of the node.
+func resolveSubnets(ctx context.Context, db *crd.Database, nodeInfo *ec2.DescribeInstancesOutput, svc *ec2.Client) ([]string, string, error) {
+	if !hasCustomSubnets(db) {
+		subnets, err := getSubnets(ctx, nodeInfo, svc, db.Spec.PubliclyAccessible)
+		if err != nil {
+			return nil, "", err
+		}
+		return subnets, *nodeInfo.Reservations[0].Instances[0].VpcId, nil
+	}
+
+	input := &ec2.DescribeSubnetsInput{}
+	if len(db.Spec.AWS.SubnetIDs) > 0 {
+		input.SubnetIds = db.Spec.AWS.SubnetIDs
+	} else {
+		input.Filters = subnetFilters(db.Spec.AWS.SubnetSelector)
+	}
+	res, err := svc.DescribeSubnets(ctx, input)
+	if err != nil {
+		return nil, "", errors.Wrap(err, "unable to describe the selected subnets")
+	}
+	if len(res.Subnets) == 0 {
+		return nil, "", fmt.Errorf("no subnets matched the selector %v", db.Spec.AWS.SubnetSelector)
+	}
+
+	var result []string
+	vpcID := ""
+	for _, sn := range res.Subnets {
+		if vpcID != "" && vpcID != *sn.VpcId {
+			return nil, "", fmt.Errorf("the selected subnets are in different VPCs: %v and %v", vpcID, *sn.VpcId)
+		}
+		vpcID = *sn.VpcId
+		result = append(result, *sn.SubnetId)
+	}
+	log.Printf("Using subnets %v in VPC %v from the database spec\n", strings.Join(result, ", "), vpcID)
+	return result, vpcID, nil
+}
+
+// subnetFilters converts a tag selector into EC2 filters, sorted so the request is stable
+func subnetFilters(selector map[string]string) []ec2types.Filter {
+	keys := make([]string, 0, len(selector))
+	for k := range selector {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var filters []ec2types.Filter
+	for _, k := range keys {
+		filters = append(filters, ec2types.Filter{Name: aws.String("tag:" + k), Values: []string{selector[k]}})
+	}
+	return filters
+}
+
+// enginePort returns the default port of the database engine
+func enginePort(engine string) int32 {
+	switch {
+	case strings.Contains(engine, "mysql"), engine == "mariadb":
+		return 3306
+	case strings.HasPrefix(engine, "oracle"):
+		return 1521
+	case strings.HasPrefix(engine, "sqlserver"):
+		return 1433
+	default:
+		return 5432
+	}
+}
+
+func securityGroupName(db *crd.Database) string {
+	return "k8s-rds-" + dbidentifier(db)
+}
+
+// ensureSecurityGroup creates a security group for the database that only allows the security groups
+// of the nodes to connect on the engine port, and returns its ID
+func (r *RDS) ensureSecurityGroup(ctx context.Context, db *crd.Database) (string, error) {
+	name := securityGroupName(db)
+	res, err := r.EC2.DescribeSecurityGroups(ctx, &ec2.DescribeSecurityGroupsInput{
+		Filters: []ec2types.Filter{
+			{Name: aws.String("group-name"), Values: []string{name}},
+			{Name: aws.String("vpc-id"), Values: []string{r.VpcId}},
+		},
+	})
+	if err != nil {
+		return "", errors.Wrap(err, "DescribeSecurityGroups")
+	}
+	if len(res.SecurityGroups) > 0 {
+		// the ingress rule is ensured again, a previous run may have failed to add it
+		log.Printf("Moving on seems like security group %v exists", name)
+		id := *res.SecurityGroups[0].GroupId
+		if len(r.NodeSecurityGroups) == 0 {
+			return id, nil
+		}
+		return id, r.authorizeNodes(ctx, db, id)
+	}
+	if len(r.NodeSecurityGroups) == 0 {
+		return "", fmt.Errorf("unable to create security group %v, the security groups of the nodes are unknown", name)
+	}
+
+	log.Printf("Creating security group %v in VPC %v\n", name, r.VpcId)
+	sg, err := r.EC2.CreateSecurityGroup(ctx, &ec2.CreateSecurityGroupInput{
+		GroupName:   aws.String(name),
+		Description: aws.String(fmt.Sprintf("Access to database %v in namespace %v", db.Name, db.Namespace)),
+		VpcId:       aws.String(r.VpcId),
+		TagSpecifications: []ec2types.TagSpecification{{
+			ResourceType: ec2types.ResourceTypeSecurityGroup,
+			Tags:         []ec2types.Tag{{Key: aws.String("Warning"), Value: aws.String("Managed by k8s-rds.")}},
+		}},
+	})
+	if err != nil {
+		return "", errors.Wrap(err, "CreateSecurityGroup")
+	}
+	return *sg.GroupId, r.authorizeNodes(ctx, db, *sg.GroupId)
+}
+
+// authorizeNodes allows the security groups of the nodes to connect to the engine port, the rule already
+// existing isn't an error
+func (r *RDS) authorizeNodes(ctx context.Context, db *crd.Database, groupID string) error {
+	port := enginePort(db.Spec.Engine)
+	var pairs []ec2types.UserIdGroupPair
+	for _, id := range r.NodeSecurityGroups {
+		pairs = append(pairs, ec2types.UserIdGroupPair{GroupId: aws.String(id)})
+	}
+	_, err := r.EC2.AuthorizeSecurityGroupIngress(ctx, &ec2.AuthorizeSecurityGroupIngressInput{
+		GroupId: aws.String(groupID),
+		IpPermissions: []ec2types.IpPermission{{
+			IpProtocol:       aws.String("tcp"),
+			FromPort:         aws.Int32(port),
+			ToPort:           aws.Int32(port),
+			UserIdGroupPairs: pairs,
+		}},
+	})
+	if err != nil && !isDuplicatePermission(err) {
+		return errors.Wrap(err, "AuthorizeSecurityGroupIngress")
+	}
+	return nil
+}
+
+// isDuplicatePermission tells if the error is EC2 rejecting an ingress rule that the group already has
+func isDuplicatePermission(err error) bool {
+	var apiErr smithy.APIError
+	return errors.As(err, &apiErr) && apiErr.ErrorCode() == "InvalidPermission.Duplicate"
+}
+
+// deleteSecurityGroup removes the security group created for the database
+func (r *RDS) deleteSecurityGroup(ctx context.Context, db *crd.Database) error {
+	name := securityGroupName(db)
+	res, err := r.EC2.DescribeSecurityGroups(ctx, &ec2.DescribeSecurityGroupsInput{
+		Filters: []ec2types.Filter{
+			{Name: aws.String("group-name"), Values: []string{name}},
+			{Name: aws.String("vpc-id"), Values: []string{r.VpcId}},
+		},
+	})
+	if err != nil {
+		return errors.Wrap(err, "DescribeSecurityGroups")
+	}
+	for _, sg := range res.SecurityGroups {
+		_, err := r.EC2.DeleteSecurityGroup(ctx, &ec2.DeleteSecurityGroupInput{GroupId: sg.GroupId})
+		if err != nil {
+			return errors.Wrap(err, fmt.Sprintf("unable to delete security group %v", name))
+		}
+		log.Println("Deleted security group: ", name)
+	}
+	return nil
+}
@@ -0,0 +1,270 @@
+package rds
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sort"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/rds"
+	rdstypes "github.com/aws/aws-sdk-go-v2/service/rds/types"
+	"github.com/pkg/errors"
+	"github.com/sorenmat/k8s-rds/crd"
+)
+
+const (
+	// maxParametersPerCall is the number of parameters AWS accepts in a single ModifyDBParameterGroup call
+	maxParametersPerCall = 20
+	pendingReboot        = "pending-reboot"
+)
+
+// parameterGroupName returns the name of the DB parameter group managed for the database
+func parameterGroupName(db *crd.Database) string {
+	return dbidentifier(db)
+}
+
+// ensureParameterGroup creates or updates the DB parameter group holding the parameters from the spec.
+// An empty name is returned if the database doesn't specify any parameters.
+func (r *RDS) ensureParameterGroup(ctx context.Context, db *crd.Database) (string, error) {
+	if len(db.Spec.Parameters) == 0 {
+		return "", nil
+	}
+	svc := r.rdsclient()
+	name := parameterGroupName(db)
+
+	family, err := parameterGroupFamily(ctx, svc, db)
+	if err != nil {
+		return "", err
+	}
+	exists := true
+	res, err := svc.DescribeDBParameterGroups(ctx, &rds.DescribeDBParameterGroupsInput{DBParameterGroupName: aws.String(name)})
+	if err != nil {
+		var notFound *rdstypes.DBParameterGroupNotFoundFault
+		if !errors.As(err, &notFound) {
+			return "", errors.Wrap(err, "DescribeDBParameterGroups")
+		}
+		exists = false
+	}
+	if exists && len(res.DBParameterGroups) > 0 && aws.ToString(res.DBParameterGroups[0].DBParameterGroupFamily) != family {
+		// the version was upgraded, and the instance was moved to the default group of the new family
+		log.Printf("Recreating parameter group %v with family %v\n", name, family)
+		_, err := svc.DeleteDBParameterGroup(ctx, &rds.DeleteDBParameterGroupInput{DBParameterGroupName: aws.String(name)})
+		if err != nil {
+			return "", errors.Wrap(err, "DeleteDBParameterGroup")
+		}
+		exists = false
+	}
+	if !exists {
+		log.Printf("Creating parameter group %v with family %v\n", name, family)
+		_, err = svc.CreateDBParameterGroup(ctx, &rds.CreateDBParameterGroupInput{
+			DBParameterGroupName:   aws.String(name),
+			DBParameterGroupFamily: aws.String(family),
+			Description:            aws.String(fmt.Sprintf("Parameters for database %v in namespace %v", db.Name, db.Namespace)),
+			Tags:                   []rdstypes.Tag{{Key: aws.String("Warning"), Value: aws.String("Managed by k8s-rds.")}},
+		})
+		if err != nil {
+			return "", errors.Wrap(err, "CreateDBParameterGroup")
+		}
+	}
+
+	current, err := describeParameters(ctx, svc, name)
+	if err != nil {
+		return "", err
+	}
+	changes, resets, err := parameterChanges(db.Spec.Parameters, current)
+	if err != nil {
+		return "", err
+	}
+	for len(changes) > 0 {
+		n := len(changes)
+		if n > maxParametersPerCall {
+			n = maxParametersPerCall
+		}
+		log.Printf("Modifying %v parameters in parameter group %v\n", n, name)
+		_, err := svc.ModifyDBParameterGroup(ctx, &rds.ModifyDBParameterGroupInput{
+			DBParameterGroupName: aws.String(name),
+			Parameters:           changes[:n],
+		})
+		if err != nil {
+			return "", errors.Wrap(err, "ModifyDBParameterGroup")
+		}
+		changes = changes[n:]
+	}
+	for len(resets) > 0 {
+		n := len(resets)
+		if n > maxParametersPerCall {
+			n = maxParametersPerCall
+		}
+		log.Printf("Resetting %v parameters in parameter group %v\n", n, name)
+		_, err := svc.ResetDBParameterGroup(ctx, &rds.ResetDBParameterGroupInput{
+			DBParameterGroupName: aws.String(name),
+			Parameters:           resets[:n],
+		})
+		if err != nil {
+			return "", errors.Wrap(err, "ResetDBParameterGroup")
+		}
+		resets = resets[n:]
+	}
+	return name, nil
+}
+
+// parameterGroupFamily looks up the parameter group family for the engine and version of the database
+func parameterGroupFamily(ctx context.Context, svc *rds.Client, db *crd.Database) (string, error) {
+	return engineFamily(ctx, svc, db.Spec.Engine, db.Spec.Version)
+}
+
+// engineFamily looks up the parameter group family of an engine version, the default version is used when it's empty
+func engineFamily(ctx context.Context, svc *rds.Client, engine, version string) (string, error) {
+	input := &rds.DescribeDBEngineVersionsInput{Engine: aws.String(engine)}
+	if version != "" {
+		input.EngineVersion = aws.String(version)
+	} else {
+		input.DefaultOnly = true
+	}
+	res, err := svc.DescribeDBEngineVersions(ctx, input)
+	if err != nil {
+		return "", errors.Wrap(err, "DescribeDBEngineVersions")
+	}
+	if len(res.DBEngineVersions) == 0 || res.DBEngineVersions[0].DBParameterGroupFamily == nil {
+		return "", fmt.Errorf("unable to find a parameter group family for %v %v", engine, version)
+	}
+	return *res.DBEngineVersions[0].DBParameterGroupFamily, nil
+}
+
+// describeParameters returns all parameters of the parameter group indexed by name
+func describeParameters(ctx context.Context, svc *rds.Client, name string) (map[string]rdstypes.Parameter, error) {
+	result := map[string]rdstypes.Parameter{}
+	input := &rds.DescribeDBParametersInput{DBParameterGroupName: aws.String(name)}
+	for {
+		res, err := svc.DescribeDBParameters(ctx, input)
+		if err != nil {
+			return nil, errors.Wrap(err, fmt.Sprintf("unable to describe parameters of %v", name))
+		}
+		for _, p := range res.Parameters {
+			if p.ParameterName != nil {
+				result[*p.ParameterName] = p
+			}
+		}
+		if res.Marker == nil || *res.Marker == "" {
+			return result, nil
+		}
+		input.Marker = res.Marker
+	}
+}
+
+// parameterChanges compares the desired parameters with the ones in the parameter group.
+// It returns the parameters that should be modified and the user defined parameters that
+// are no longer wanted and should be reset to the engine default.
+func parameterChanges(desired map[string]string, current map[string]rdstypes.Parameter) ([]rdstypes.Parameter, []rdstypes.Parameter, error) {
+	var changes, resets []rdstypes.Parameter
+
+	names := make([]string, 0, len(desired))
+	for k := range desired {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		value := desired[name]
+		p, ok := current[name]
+		if !ok {
+			return nil, nil, fmt.Errorf("parameter %v is not supported by the engine", name)
+		}
+		if p.ParameterValue != nil && *p.ParameterValue == value {
+			continue
+		}
+		if !p.IsModifiable {
+			return nil, nil, fmt.Errorf("parameter %v can't be modified", name)
+		}
+		method := rdstypes.ApplyMethodPendingReboot
+		if p.ApplyType != nil && *p.ApplyType == "dynamic" {
+			method = rdstypes.ApplyMethodImmediate
+		}
+		changes = append(changes, rdstypes.Parameter{
+			ParameterName:  aws.String(name),
+			ParameterValue: aws.String(value),
+			ApplyMethod:    method,
+		})
+	}
+
+	for name, p := range current {
+		if _, ok := desired[name]; ok || p.Source == nil || *p.Source != "user" {
+			continue
+		}
+		method := rdstypes.ApplyMethodPendingReboot
+		if p.ApplyType != nil && *p.ApplyType == "dynamic" {
+			method = rdstypes.ApplyMethodImmediate
+		}
+		resets = append(resets, rdstypes.Parameter{ParameterName: aws.String(name), ApplyMethod: method})
+	}
+	sort.Slice(resets, func(i, j int) bool { return *resets[i].ParameterName < *resets[j].ParameterName })
+
+	return changes, resets, nil
+}
+
+// ensureInstanceParameterGroup attaches the managed parameter group to the instance, or falls back
+// to the engine default group if the spec no longer has any parameters
+func (r *RDS) ensureInstanceParameterGroup(ctx context.Context, db *crd.Database, instance *rdstypes.DBInstance, name string) error {
+	attached := ""
+	for _, g := range instance.DBParameterGroups {
+		if g.DBParameterGroupName != nil {
+			attached = *g.DBParameterGroupName
+		}
+	}
+
+	wanted := name
+	if wanted == "" {
+		if attached != parameterGroupName(db) {
+			// not using our group, nothing to revert
+			return nil
+		}
+		family, err := parameterGroupFamily(ctx, r.rdsclient(), db)
+		if err != nil {
+			return err
+		}
+		wanted = "default." + family
+	}
+	if attached == wanted {
+		return nil
+	}
+
+	log.Printf("Changing parameter group of %v from %v to %v\n", *instance.DBInstanceIdentifier, attached, wanted)
+	_, err := r.rdsclient().ModifyDBInstance(ctx, &rds.ModifyDBInstanceInput{
+		DBInstanceIdentifier: instance.DBInstanceIdentifier,
+		DBParameterGroupName: aws.String(wanted),
+		ApplyImmediately:     true,
+	})
+	if err != nil {
+		return errors.Wrap(err, "ModifyDBInstance")
+	}
+	return nil
+}
+
+// parameterStatus reports the parameter group and whether a reboot is needed to apply it
+func parameterStatus(db *crd.Database, instance *rdstypes.DBInstance) {
+	db.Status.ParameterGroup = ""
+	db.Status.PendingReboot = false
+	for _, g := range instance.DBParameterGroups {
+		if g.DBParameterGroupName != nil && *g.DBParameterGroupName == parameterGroupName(db) {
+			db.Status.ParameterGroup = *g.DBParameterGroupName
+		}
+		if g.ParameterApplyStatus != nil && *g.ParameterApplyStatus == pendingReboot {
+			db.Status.PendingReboot = true
+		}
+	}
+}
+
+// deleteParameterGroup removes the managed parameter group, it's only possible once the instance is gone
+func (r *RDS) deleteParameterGroup(ctx context.Context, db *crd.Database) {
+	name := parameterGroupName(db)
+	_, err := r.rdsclient().DeleteDBParameterGroup(ctx, &rds.DeleteDBParameterGroupInput{DBParameterGroupName: aws.String(name)})
+	if err != nil {
+		var notFound *rdstypes.DBParameterGroupNotFoundFault
+		if !errors.As(err, &notFound) {
+			log.Println(errors.Wrap(err, fmt.Sprintf("unable to delete parameter group %v", name)))
+		}
+		return
+	}
+	log.Println("Deleted DB parameter group: ", name)
+}
@@ -0,0 +1,124 @@
+package rds
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/rds"
+	rdstypes "github.com/aws/aws-sdk-go-v2/service/rds/types"
+	"github.com/aws/aws-sdk-go-v2/service/secretsmanager"
+	smtypes "github.com/aws/aws-sdk-go-v2/service/secretsmanager/types"
+	"github.com/pkg/errors"
+	"github.com/sorenmat/k8s-rds/crd"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// managedPassword tells if the master password is generated and rotated by RDS in Secrets Manager
+func managedPassword(db *crd.Database) bool {
+	return db.Spec.AWS != nil && db.Spec.AWS.ManagedPassword
+}
+
+// ensureManagedPassword hands the master password over to RDS for instances that were created with the
+// password from the Kubernetes secret
+func (r *RDS) ensureManagedPassword(ctx context.Context, db *crd.Database, instance *rdstypes.DBInstance) error {
+	if !managedPassword(db) || instance.MasterUserSecret != nil {
+		return nil
+	}
+	log.Printf("Turning on the managed master password for %v\n", *instance.DBInstanceIdentifier)
+	_, err := r.rdsclient().ModifyDBInstance(ctx, &rds.ModifyDBInstanceInput{
+		DBInstanceIdentifier:     instance.DBInstanceIdentifier,
+		ManageMasterUserPassword: aws.Bool(true),
+		ApplyImmediately:         true,
+	})
+	if err != nil {
+		return errors.Wrap(err, "ModifyDBInstance")
+	}
+	return nil
+}
+
+// masterUserSecret is the content of the secret RDS creates in Secrets Manager
+type masterUserSecret struct {
+	Username string `json:"username"`
+	Password string `json:"password"`
+}
+
+// syncManagedPassword copies the master password from Secrets Manager into the secret referenced by
+// spec.password, so the applications can keep reading it from there, and reports the rotation in the status
+func (r *RDS) syncManagedPassword(ctx context.Context, db *crd.Database, instance *rdstypes.DBInstance) error {
+	if !managedPassword(db) || instance.MasterUserSecret == nil || instance.MasterUserSecret.SecretArn == nil {
+		return nil
+	}
+	arn := instance.MasterUserSecret.SecretArn
+	db.Status.PasswordSecretARN = *arn
+	db.Status.PasswordSecretStatus = aws.ToString(instance.MasterUserSecret.SecretStatus)
+
+	sm := secretsmanager.NewFromConfig(r.Config)
+	desc, err := sm.DescribeSecret(ctx, &secretsmanager.DescribeSecretInput{SecretId: arn})
+	if err != nil {
+		return errors.Wrap(err, "DescribeSecret")
+	}
+	if desc.LastRotatedDate != nil {
+		t := metav1.NewTime(*desc.LastRotatedDate)
+		db.Status.PasswordLastRotated = &t
+	}
+	if err := ensureRotationSchedule(ctx, sm, db, desc); err != nil {
+		return err
+	}
+
+	value, err := sm.GetSecretValue(ctx, &secretsmanager.GetSecretValueInput{SecretId: arn})
+	if err != nil {
+		return errors.Wrap(err, "GetSecretValue")
+	}
+	var secret masterUserSecret
+	if err := json.Unmarshal([]byte(aws.ToString(value.SecretString)), &secret); err != nil {
+		return errors.Wrap(err, fmt.Sprintf("unable to read the master user secret %v", *arn))
+	}
+	return r.PutSecret(ctx, db.Namespace, db.Spec.Password.Name, db.Spec.Password.Key, secret.Password)
+}
+
+// ensureRotationSchedule changes the rotation schedule of the managed secret if the spec asks for another one
+func ensureRotationSchedule(ctx context.Context, sm *secretsmanager.Client, db *crd.Database, desc *secretsmanager.DescribeSecretOutput) error {
+	days := db.Spec.AWS.PasswordRotationDays
+	if days == 0 {
+		return nil
+	}
+	if desc.RotationRules != nil && aws.ToInt64(desc.RotationRules.AutomaticallyAfterDays) == days {
+		return nil
+	}
+	log.Printf("Rotating the master password of %v every %v days\n", db.Name, days)
+	_, err := sm.RotateSecret(ctx, &secretsmanager.RotateSecretInput{
+		SecretId:          desc.ARN,
+		RotationRules:     &smtypes.RotationRulesType{AutomaticallyAfterDays: aws.Int64(days)},
+		RotateImmediately: aws.Bool(false),
+	})
+	if err != nil {
+		return errors.Wrap(err, "RotateSecret")
+	}
+	return nil
+}
+
+// UpdatePassword changes the master password of the instance to the new value of the password secret
+func (r *RDS) UpdatePassword(ctx context.Context, db *crd.Database, password string) error {
+	if managedPassword(db) {
+		// RDS owns the password, the secret is only a copy of it
+		return nil
+	}
+	if adoptionPending(db) {
+		return fmt.Errorf("the adoption of %v isn't confirmed, the password can't be changed", instanceIdentifier(db))
+	}
+	log.Printf("Changing the master password of %v\n", instanceIdentifier(db))
+	_, err := r.rdsclient().ModifyDBInstance(ctx, &rds.ModifyDBInstanceInput{
+		DBInstanceIdentifier: aws.String(instanceIdentifier(db)),
+		MasterUserPassword:   aws.String(password),
+		ApplyImmediately:     true,
+	})
+	if err != nil {
+		return errors.Wrap(err, "ModifyDBInstance")
+	}
+	now := metav1.Now()
+	db.Status.PasswordLastRotated = &now
+	return r.updateProxySecret(ctx, db)
+}
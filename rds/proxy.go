@@ -0,0 +1,405 @@
+package rds
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/iam"
+	iamtypes "github.com/aws/aws-sdk-go-v2/service/iam/types"
+	"github.com/aws/aws-sdk-go-v2/service/rds"
+	rdstypes "github.com/aws/aws-sdk-go-v2/service/rds/types"
+	"github.com/aws/aws-sdk-go-v2/service/secretsmanager"
+	smtypes "github.com/aws/aws-sdk-go-v2/service/secretsmanager/types"
+	"github.com/pkg/errors"
+	"github.com/sorenmat/k8s-rds/crd"
+	"github.com/sorenmat/k8s-rds/kube"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	proxyTrust       = `{"Version":"2012-10-17","Statement":[{"Effect":"Allow","Principal":{"Service":"rds.amazonaws.com"},"Action":"sts:AssumeRole"}]}`
+	proxyPolicyName  = "k8s-rds-proxy"
+	proxyTargetGroup = "default"
+	maxProxyName     = 63
+)
+
+func proxy(db *crd.Database) *crd.ProxySpec {
+	if db.Spec.AWS == nil {
+		return nil
+	}
+	return db.Spec.AWS.Proxy
+}
+
+// proxyName is the name of the proxy, and of the role and secret created for it
+func proxyName(db *crd.Database) string {
+	name := "k8s-rds-" + dbidentifier(db)
+	if len(name) > maxProxyName {
+		name = name[:maxProxyName]
+	}
+	return strings.TrimRight(name, "-")
+}
+
+// proxyServiceName is the service pointing at the proxy, unless it replaces the service of the database
+func proxyServiceName(db *crd.Database) string {
+	return db.Name + "-proxy"
+}
+
+func proxyEngineFamily(engine string) (rdstypes.EngineFamily, error) {
+	switch {
+	case strings.Contains(engine, "postgres"):
+		return rdstypes.EngineFamilyPostgresql, nil
+	case strings.Contains(engine, "mysql"), engine == "mariadb":
+		return rdstypes.EngineFamilyMysql, nil
+	case strings.HasPrefix(engine, "sqlserver"):
+		return rdstypes.EngineFamilySqlserver, nil
+	}
+	return "", fmt.Errorf("RDS Proxy doesn't support the %v engine", engine)
+}
+
+func proxyAuth(secretARN string) []rdstypes.UserAuthConfig {
+	return []rdstypes.UserAuthConfig{{
+		AuthScheme:  rdstypes.AuthSchemeSecrets,
+		IAMAuth:     rdstypes.IAMAuthModeDisabled,
+		SecretArn:   aws.String(secretARN),
+		Description: aws.String("Master user managed by k8s-rds"),
+	}}
+}
+
+// proxyInput returns the request creating the proxy in the subnets and security groups of the instance
+func proxyInput(db *crd.Database, instance *rdstypes.DBInstance, secretARN, roleARN string, subnets []string, tags []rdstypes.Tag) (*rds.CreateDBProxyInput, error) {
+	family, err := proxyEngineFamily(aws.ToString(instance.Engine))
+	if err != nil {
+		return nil, err
+	}
+	var sgs []string
+	for _, sg := range instance.VpcSecurityGroups {
+		sgs = append(sgs, aws.ToString(sg.VpcSecurityGroupId))
+	}
+	input := &rds.CreateDBProxyInput{
+		DBProxyName:         aws.String(proxyName(db)),
+		EngineFamily:        family,
+		Auth:                proxyAuth(secretARN),
+		RoleArn:             aws.String(roleARN),
+		VpcSubnetIds:        subnets,
+		VpcSecurityGroupIds: sgs,
+		RequireTLS:          proxy(db).RequireTLS,
+		Tags:                tags,
+	}
+	if t := proxy(db).IdleClientTimeout; t > 0 {
+		input.IdleClientTimeout = aws.Int32(int32(t))
+	}
+	return input, nil
+}
+
+// proxyChanges returns the modification needed to apply the settings of the spec to the proxy, or nil
+func proxyChanges(db *crd.Database, p *rdstypes.DBProxy, secretARN, roleARN string) *rds.ModifyDBProxyInput {
+	spec := proxy(db)
+	input := &rds.ModifyDBProxyInput{DBProxyName: p.DBProxyName}
+	changed := false
+	if spec.RequireTLS != p.RequireTLS {
+		input.RequireTLS = aws.Bool(spec.RequireTLS)
+		changed = true
+	}
+	if t := int32(spec.IdleClientTimeout); t > 0 && t != p.IdleClientTimeout {
+		input.IdleClientTimeout = aws.Int32(t)
+		changed = true
+	}
+	if len(p.Auth) != 1 || aws.ToString(p.Auth[0].SecretArn) != secretARN {
+		input.Auth = proxyAuth(secretARN)
+		changed = true
+	}
+	if aws.ToString(p.RoleArn) != roleARN {
+		input.RoleArn = aws.String(roleARN)
+		changed = true
+	}
+	if !changed {
+		return nil
+	}
+	return input
+}
+
+// targetGroupChanges returns the modification of the connection pool of the target group, or nil
+func targetGroupChanges(db *crd.Database, group *rdstypes.DBProxyTargetGroup) *rds.ModifyDBProxyTargetGroupInput {
+	percent := int32(proxy(db).MaxConnectionsPercent)
+	if percent == 0 || (group.ConnectionPoolConfig != nil && group.ConnectionPoolConfig.MaxConnectionsPercent == percent) {
+		return nil
+	}
+	return &rds.ModifyDBProxyTargetGroupInput{
+		DBProxyName:          group.DBProxyName,
+		TargetGroupName:      group.TargetGroupName,
+		ConnectionPoolConfig: &rdstypes.ConnectionPoolConfiguration{MaxConnectionsPercent: aws.Int32(percent)},
+	}
+}
+
+// proxySecret returns the ARN of the secret the proxy takes the master password from. It's the secret RDS manages
+// for a managed password, otherwise a copy of the password secret is kept in Secrets Manager.
+func (r *RDS) proxySecret(ctx context.Context, db *crd.Database, instance *rdstypes.DBInstance) (string, error) {
+	if managedPassword(db) && instance.MasterUserSecret != nil && instance.MasterUserSecret.SecretArn != nil {
+		return *instance.MasterUserSecret.SecretArn, nil
+	}
+	sm := secretsmanager.NewFromConfig(r.Config)
+	name := aws.String(proxyName(db))
+	res, err := sm.DescribeSecret(ctx, &secretsmanager.DescribeSecretInput{SecretId: name})
+	if err == nil {
+		return aws.ToString(res.ARN), nil
+	}
+	var notFound *smtypes.ResourceNotFoundException
+	if !errors.As(err, &notFound) {
+		return "", errors.Wrap(err, "DescribeSecret")
+	}
+
+	value, err := r.proxySecretValue(ctx, db)
+	if err != nil {
+		return "", err
+	}
+	log.Printf("Creating secret %v for the proxy of %v\n", *name, db.Name)
+	created, err := sm.CreateSecret(ctx, &secretsmanager.CreateSecretInput{
+		Name:         name,
+		Description:  aws.String(fmt.Sprintf("Master password of database %v in namespace %v for RDS Proxy", db.Name, db.Namespace)),
+		SecretString: aws.String(value),
+		Tags:         []smtypes.Tag{{Key: aws.String("Warning"), Value: aws.String("Managed by k8s-rds.")}},
+	})
+	if err != nil {
+		return "", errors.Wrap(err, "CreateSecret")
+	}
+	return aws.ToString(created.ARN), nil
+}
+
+func (r *RDS) proxySecretValue(ctx context.Context, db *crd.Database) (string, error) {
+	password, err := r.GetSecret(ctx, db.Namespace, db.Spec.Password.Name, db.Spec.Password.Key)
+	if err != nil {
+		return "", err
+	}
+	value, err := json.Marshal(masterUserSecret{Username: db.Spec.Username, Password: password})
+	return string(value), err
+}
+
+// updateProxySecret puts the new password in the secret of the proxy, if there is one
+func (r *RDS) updateProxySecret(ctx context.Context, db *crd.Database) error {
+	if proxy(db) == nil || managedPassword(db) {
+		return nil
+	}
+	value, err := r.proxySecretValue(ctx, db)
+	if err != nil {
+		return err
+	}
+	_, err = secretsmanager.NewFromConfig(r.Config).PutSecretValue(ctx, &secretsmanager.PutSecretValueInput{
+		SecretId:     aws.String(proxyName(db)),
+		SecretString: aws.String(value),
+	})
+	var notFound *smtypes.ResourceNotFoundException
+	if err != nil && !errors.As(err, &notFound) {
+		return errors.Wrap(err, "PutSecretValue")
+	}
+	return nil
+}
+
+// proxyRole returns the role the proxy reads the secret with, the role created by the operator is only allowed
+// to read that secret
+func (r *RDS) proxyRole(ctx context.Context, db *crd.Database, secretARN string) (string, error) {
+	if arn := proxy(db).RoleARN; arn != "" {
+		return arn, nil
+	}
+	svc := iam.NewFromConfig(r.Config)
+	name := aws.String(proxyName(db))
+	var arn string
+	res, err := svc.GetRole(ctx, &iam.GetRoleInput{RoleName: name})
+	if err == nil {
+		arn = aws.ToString(res.Role.Arn)
+	} else {
+		var notFound *iamtypes.NoSuchEntityException
+		if !errors.As(err, &notFound) {
+			return "", errors.Wrap(err, "GetRole")
+		}
+		log.Printf("Creating role %v for the proxy of %v\n", *name, db.Name)
+		created, err := svc.CreateRole(ctx, &iam.CreateRoleInput{
+			RoleName:                 name,
+			AssumeRolePolicyDocument: aws.String(proxyTrust),
+			Description:              aws.String(fmt.Sprintf("RDS Proxy of database %v in namespace %v", db.Name, db.Namespace)),
+			Tags:                     []iamtypes.Tag{{Key: aws.String("Warning"), Value: aws.String("Managed by k8s-rds.")}},
+		})
+		if err != nil {
+			return "", errors.Wrap(err, "CreateRole")
+		}
+		arn = aws.ToString(created.Role.Arn)
+	}
+
+	doc, err := json.Marshal(policyDocument{
+		Version:   "2012-10-17",
+		Statement: []policyStatement{{Effect: "Allow", Action: "secretsmanager:GetSecretValue", Resource: []string{secretARN}}},
+	})
+	if err != nil {
+		return "", err
+	}
+	_, err = svc.PutRolePolicy(ctx, &iam.PutRolePolicyInput{RoleName: name, PolicyName: aws.String(proxyPolicyName), PolicyDocument: aws.String(string(doc))})
+	if err != nil {
+		return "", errors.Wrap(err, "PutRolePolicy")
+	}
+	return arn, nil
+}
+
+// ensureProxy creates the proxy in front of the instance and points the service at it. The proxy takes a few
+// minutes to be available, the instance is registered as its target on the updates after that.
+func (r *RDS) ensureProxy(ctx context.Context, db *crd.Database, instance *rdstypes.DBInstance) error {
+	if proxy(db) == nil {
+		if db.Status.ProxyStatus == "" {
+			return nil
+		}
+		// the service of the database is pointed back at the instance with the status returned by Describe
+		if err := r.deleteProxy(ctx, db); err != nil {
+			return err
+		}
+		db.Status.ProxyStatus = ""
+		db.Status.ProxyEndpoint = ""
+		return nil
+	}
+
+	secretARN, err := r.proxySecret(ctx, db, instance)
+	if err != nil {
+		return err
+	}
+	roleARN, err := r.proxyRole(ctx, db, secretARN)
+	if err != nil {
+		return err
+	}
+
+	svc := r.rdsclient()
+	name := aws.String(proxyName(db))
+	res, err := svc.DescribeDBProxies(ctx, &rds.DescribeDBProxiesInput{DBProxyName: name})
+	if err != nil {
+		var notFound *rdstypes.DBProxyNotFoundFault
+		if !errors.As(err, &notFound) {
+			return errors.Wrap(err, "DescribeDBProxies")
+		}
+		tags := append([]rdstypes.Tag{{Key: aws.String("Warning"), Value: aws.String("Managed by k8s-rds.")}}, ownerTags(db, r.ClusterName)...)
+		input, err := proxyInput(db, instance, secretARN, roleARN, r.Subnets, tags)
+		if err != nil {
+			return err
+		}
+		log.Printf("Creating proxy %v for %v\n", *name, *instance.DBInstanceIdentifier)
+		created, err := svc.CreateDBProxy(ctx, input)
+		if err != nil {
+			return errors.Wrap(err, "CreateDBProxy")
+		}
+		db.Status.ProxyStatus = string(created.DBProxy.Status)
+		return nil
+	}
+	if len(res.DBProxies) == 0 {
+		return fmt.Errorf("proxy %v not found", *name)
+	}
+	p := res.DBProxies[0]
+	db.Status.ProxyStatus = string(p.Status)
+	if p.Status != rdstypes.DBProxyStatusAvailable {
+		return nil
+	}
+	endpoint := aws.ToString(p.Endpoint)
+	db.Status.ProxyEndpoint = endpoint
+
+	if input := proxyChanges(db, &p, secretARN, roleARN); input != nil {
+		log.Printf("Updating proxy %v\n", *name)
+		if _, err := svc.ModifyDBProxy(ctx, input); err != nil {
+			return errors.Wrap(err, "ModifyDBProxy")
+		}
+	}
+	groups, err := svc.DescribeDBProxyTargetGroups(ctx, &rds.DescribeDBProxyTargetGroupsInput{DBProxyName: name, TargetGroupName: aws.String(proxyTargetGroup)})
+	if err != nil {
+		return errors.Wrap(err, "DescribeDBProxyTargetGroups")
+	}
+	if len(groups.TargetGroups) > 0 {
+		if input := targetGroupChanges(db, &groups.TargetGroups[0]); input != nil {
+			if _, err := svc.ModifyDBProxyTargetGroup(ctx, input); err != nil {
+				return errors.Wrap(err, "ModifyDBProxyTargetGroup")
+			}
+		}
+	}
+	if err := r.ensureProxyTarget(ctx, name, instance); err != nil {
+		return err
+	}
+	return r.ensureProxyService(ctx, db, endpoint)
+}
+
+// ensureProxyTarget registers the instance in the default target group of the proxy
+func (r *RDS) ensureProxyTarget(ctx context.Context, name *string, instance *rdstypes.DBInstance) error {
+	svc := r.rdsclient()
+	targets, err := svc.DescribeDBProxyTargets(ctx, &rds.DescribeDBProxyTargetsInput{DBProxyName: name, TargetGroupName: aws.String(proxyTargetGroup)})
+	if err != nil {
+		return errors.Wrap(err, "DescribeDBProxyTargets")
+	}
+	for _, t := range targets.Targets {
+		if aws.ToString(t.RdsResourceId) == aws.ToString(instance.DBInstanceIdentifier) {
+			return nil
+		}
+	}
+	log.Printf("Registering %v as target of proxy %v\n", *instance.DBInstanceIdentifier, *name)
+	_, err = svc.RegisterDBProxyTargets(ctx, &rds.RegisterDBProxyTargetsInput{
+		DBProxyName:           name,
+		TargetGroupName:       aws.String(proxyTargetGroup),
+		DBInstanceIdentifiers: []string{aws.ToString(instance.DBInstanceIdentifier)},
+	})
+	if err != nil {
+		return errors.Wrap(err, "RegisterDBProxyTargets")
+	}
+	return nil
+}
+
+// ensureProxyService points the <name>-proxy service at the proxy. When the proxy replaces the service of the
+// database, Describe returns the endpoint of the proxy and the operator points the service of the database at it.
+func (r *RDS) ensureProxyService(ctx context.Context, db *crd.Database, endpoint string) error {
+	if proxy(db).ReplaceService {
+		return r.deleteProxyService(ctx, db)
+	}
+	return r.createService(ctx, db.Namespace, endpoint, proxyServiceName(db), db.Name)
+}
+
+func (r *RDS) deleteProxyService(ctx context.Context, db *crd.Database) error {
+	kubectl, err := kube.Client()
+	if err != nil {
+		return err
+	}
+	err = kubectl.CoreV1().Services(db.Namespace).Delete(ctx, proxyServiceName(db), metav1.DeleteOptions{})
+	if err != nil && !apierrors.IsNotFound(err) {
+		return errors.Wrap(err, fmt.Sprintf("delete of service %v failed in namespace %v", proxyServiceName(db), db.Namespace))
+	}
+	return nil
+}
+
+// deleteProxy deletes the proxy with its service, and the role and secret created for it
+func (r *RDS) deleteProxy(ctx context.Context, db *crd.Database) error {
+	name := aws.String(proxyName(db))
+	log.Printf("Deleting proxy %v\n", *name)
+	_, err := r.rdsclient().DeleteDBProxy(ctx, &rds.DeleteDBProxyInput{DBProxyName: name})
+	var notFound *rdstypes.DBProxyNotFoundFault
+	if err != nil && !errors.As(err, &notFound) {
+		return errors.Wrap(err, "DeleteDBProxy")
+	}
+	if err := r.deleteProxyService(ctx, db); err != nil {
+		return err
+	}
+
+	svc := iam.NewFromConfig(r.Config)
+	var noRole *iamtypes.NoSuchEntityException
+	_, err = svc.DeleteRolePolicy(ctx, &iam.DeleteRolePolicyInput{RoleName: name, PolicyName: aws.String(proxyPolicyName)})
+	if err != nil && !errors.As(err, &noRole) {
+		return errors.Wrap(err, "DeleteRolePolicy")
+	}
+	_, err = svc.DeleteRole(ctx, &iam.DeleteRoleInput{RoleName: name})
+	if err != nil && !errors.As(err, &noRole) {
+		return errors.Wrap(err, "DeleteRole")
+	}
+
+	// the secret only holds a copy of the password, so it's not kept for recovery
+	_, err = secretsmanager.NewFromConfig(r.Config).DeleteSecret(ctx, &secretsmanager.DeleteSecretInput{
+		SecretId:                   name,
+		ForceDeleteWithoutRecovery: aws.Bool(true),
+	})
+	var noSecret *smtypes.ResourceNotFoundException
+	if err != nil && !errors.As(err, &noSecret) {
+		return errors.Wrap(err, "DeleteSecret")
+	}
+	return nil
+}
@@ -7,84 +7,136 @@ import (
 	rdstypes "github.com/aws/aws-sdk-go-v2/service/rds/types"
 	"log"
 	"regexp"
+	"sort"
 	"strings"
 	"time"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
-	"github.com/aws/aws-sdk-go-v2/config"
 	"github.com/aws/aws-sdk-go-v2/service/ec2"
 	"github.com/aws/aws-sdk-go-v2/service/rds"
 	"github.com/pkg/errors"
 	"github.com/sorenmat/k8s-rds/crd"
 	"github.com/sorenmat/k8s-rds/provider"
-	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/client-go/kubernetes"
 )
 
 type RDS struct {
-	EC2             *ec2.Client
-	Config          aws.Config
-	Subnets         []string
-	SecurityGroups  []string
-	VpcId           string
-	ServiceProvider provider.ServiceProvider
+	EC2                *ec2.Client
+	Config             aws.Config
+	Subnets            []string
+	SecurityGroups     []string
+	NodeSecurityGroups []string
+	VpcId              string
+	ClusterName        string
+	ServiceProvider    provider.ServiceProvider
 }
 
-func New(ctx context.Context, db *crd.Database, kc *kubernetes.Clientset) (*RDS, error) {
-	cfg, err := ec2config(ctx, kc)
+func New(ctx context.Context, db *crd.Database, kc kubernetes.Interface, env *Environment) (*RDS, error) {
+	role, err := roleFor(ctx, kc, db)
 	if err != nil {
-		log.Fatal("unable to create a client for EC2 ", err)
+		return nil, err
 	}
+	cfg := env.ConfigFor(role)
+	ec2client := ec2.NewFromConfig(cfg)
 
-	ec2client := ec2.NewFromConfig(*cfg)
-
-	nodeInfo, err := describeNodeEC2Instance(ctx, kc, ec2client)
+	// the nodes are always in the operator's own account
+	nodeClient := ec2.NewFromConfig(env.Config)
+	nodeInfo, err := describeNodeEC2Instance(ctx, env.InstanceID, nodeClient)
 	if err != nil {
 		log.Println(err)
 		return nil, errors.Wrap(err, "unable AWS metadata")
 	}
-	vpcId := *nodeInfo.Reservations[0].Instances[0].VpcId
 
 	log.Println("trying to get subnets")
-	subnets, err := getSubnets(ctx, nodeInfo, ec2client, db.Spec.PubliclyAccessible)
+	subnetClient := nodeClient
+	if hasCustomSubnets(db) {
+		subnetClient = ec2client
+	}
+	subnets, vpcId, err := resolveSubnets(ctx, db, nodeInfo, subnetClient)
 	if err != nil {
 		return nil, fmt.Errorf("unable to get subnets from instance: %v", err)
 
 	}
 
 	log.Println("trying to get security groups")
-	sgs, err := getSGS(ctx, kc, ec2client)
-	if err != nil {
-		return nil, fmt.Errorf("unable to get security groups from instance: %v", err)
+	nodeSgs := getSGS(nodeInfo)
+	sgs := nodeSgs
+	if db.Spec.AWS != nil && len(db.Spec.AWS.SecurityGroupIDs) > 0 {
+		sgs = db.Spec.AWS.SecurityGroupIDs
+	} else if db.Spec.AWS != nil && db.Spec.AWS.CreateSecurityGroup {
+		// the dedicated security group is attached when the database is created
+		sgs = nil
+	}
 
+	clusterName := env.ClusterName
+	if clusterName == "" {
+		clusterName = clusterNameFromTags(nodeInfo.Reservations[0].Instances[0].Tags)
 	}
 
 	r := RDS{
-		EC2:            ec2client,
-		Config:         *cfg,
-		Subnets:        subnets,
-		SecurityGroups: sgs,
-		VpcId:          vpcId,
+		ClusterName:        clusterName,
+		EC2:                ec2client,
+		Config:             cfg,
+		Subnets:            subnets,
+		SecurityGroups:     sgs,
+		NodeSecurityGroups: nodeSgs,
+		VpcId:              vpcId,
 	}
 	return &r, nil
 }
 
-// CreateDatabase creates a database from the CRD database object, is also ensures that the correct
+// create creates a database from the CRD database object, is also ensures that the correct
 // subnets are created for the database so we can access it
-func (r *RDS) CreateDatabase(ctx context.Context, db *crd.Database) (string, error) {
+func (r *RDS) create(ctx context.Context, db *crd.Database) error {
+	if err := validateWindows(db.Spec); err != nil {
+		return err
+	}
+	if err := validateMonitoring(db); err != nil {
+		return err
+	}
+	// a wrong key would only show up after the instance failed to create
+	if _, err := r.validateKMSKey(ctx, db); err != nil {
+		return err
+	}
+	if adopted(db) {
+		// an adopted instance must already exist, nothing is created for it
+		return fmt.Errorf("unable to adopt db instance %v, it doesn't exist", instanceIdentifier(db))
+	}
+
 	// Ensure that the subnets for the DB is create or updated
 	log.Println("Trying to find the correct subnets")
 	subnetName, err := r.ensureSubnets(ctx, db)
 	if err != nil {
-		return "", err
+		return err
+	}
+
+	if _, err := r.ensureParameterGroup(ctx, db); err != nil {
+		return err
 	}
 
-	log.Printf("getting secret: Name: %v Key: %v \n", db.Spec.Password.Name, db.Spec.Password.Key)
-	pw, err := r.GetSecret(ctx, db.Namespace, db.Spec.Password.Name, db.Spec.Password.Key)
+	pw := ""
+	if !managedPassword(db) {
+		log.Printf("getting secret: Name: %v Key: %v \n", db.Spec.Password.Name, db.Spec.Password.Key)
+		pw, err = r.GetSecret(ctx, db.Namespace, db.Spec.Password.Name, db.Spec.Password.Key)
+		if err != nil {
+			return err
+		}
+	}
+	sgs := r.SecurityGroups
+	if db.Spec.AWS != nil && db.Spec.AWS.CreateSecurityGroup {
+		sg, err := r.ensureSecurityGroup(ctx, db)
+		if err != nil {
+			return err
+		}
+		sgs = append(sgs, sg)
+	}
+	monitoringRole, err := r.ensureMonitoringRole(ctx, db)
 	if err != nil {
-		return "", err
+		return err
 	}
-	input := convertSpecToInput(db, subnetName, r.SecurityGroups, pw)
+	input := convertSpecToInput(db, subnetName, sgs, pw)
+	input.Tags = append(input.Tags, ownerTags(db, r.ClusterName)...)
+	applyMonitoring(input, db, monitoringRole)
 
 	// search for the instance
 	log.Printf("Trying to find db instance %v\n", db.Spec.DBName)
@@ -96,21 +148,125 @@ func (r *RDS) CreateDatabase(ctx context.Context, db *crd.Database) (string, err
 		// seems like we didn't find a database with this name, let's create on
 		_, err := r.rdsclient().CreateDBInstance(ctx, input)
 		if err != nil {
-			return "", errors.Wrap(err, "CreateDBInstance")
+			return errors.Wrap(err, "CreateDBInstance")
 		}
+		db.Status.AppliedTags = tagKeys(input.Tags)
+		db.Status.Applied = appliedSettings(db)
 	} else if err != nil {
-		return "", errors.Wrap(err, fmt.Sprintf("wasn't able to describe the db instance with id %v", input.DBInstanceIdentifier))
+		return errors.Wrap(err, fmt.Sprintf("wasn't able to describe the db instance with id %v", input.DBInstanceIdentifier))
 	}
 	log.Printf("Waiting for db instance %v to become available\n", *input.DBInstanceIdentifier)
 
 	time.Sleep(5 * time.Second)
 
-	// Get the newly created database so we can get the endpoint
-	dbHostname, err := getEndpoint(ctx, input.DBInstanceIdentifier, r.rdsclient())
+	instance, err := describeInstance(ctx, input.DBInstanceIdentifier, r.rdsclient())
+	if err != nil {
+		return err
+	}
+	parameterStatus(db, instance)
+	db.Status.KMSKeyID = aws.ToString(instance.KmsKeyId)
+	db.Status.EngineVersion = aws.ToString(instance.EngineVersion)
+	if err := r.syncManagedPassword(ctx, db, instance); err != nil {
+		// the secret might not be ready yet, it's synced again on the next update
+		log.Println(err)
+	}
+	if err := r.ensureConnectPolicies(ctx, db, instance); err != nil {
+		log.Println(err)
+	}
+	return nil
+}
+
+// update applies changes of the CRD database object to an already created instance
+func (r *RDS) update(ctx context.Context, db *crd.Database) error {
+	if err := validateWindows(db.Spec); err != nil {
+		return err
+	}
+	if err := validateMonitoring(db); err != nil {
+		return err
+	}
+	id := aws.String(instanceIdentifier(db))
+	instance, err := describeInstance(ctx, id, r.rdsclient())
+	if err != nil {
+		return err
+	}
+	if adoptionPending(db) {
+		// only report how the instance differs from the spec until the takeover is confirmed
+		importStatus(db, instance)
+		db.Status.Adoption = adoptionPendingState
+		return nil
+	}
+	if stopped, err := r.ensureSchedule(ctx, db, instance, time.Now()); err != nil || stopped {
+		// a stopped instance can't be modified, the spec is applied once it runs again
+		return err
+	}
+	upgrading, err := r.ensureVersion(ctx, db, instance)
+	if err != nil || upgrading {
+		return err
+	}
+	name, err := r.ensureParameterGroup(ctx, db)
+	if err != nil {
+		return err
+	}
+	if err := r.ensureInstanceParameterGroup(ctx, db, instance, name); err != nil {
+		return err
+	}
+	if err := r.ensureManagedPassword(ctx, db, instance); err != nil {
+		return err
+	}
+	if err := r.ensureIAMAuthentication(ctx, db, instance); err != nil {
+		return err
+	}
+	if err := r.ensureKMSKey(ctx, db, instance); err != nil {
+		return err
+	}
+	if input := specChanges(db, instance); input != nil {
+		log.Printf("Applying the changes of the spec to %v\n", *id)
+		if _, err := r.rdsclient().ModifyDBInstance(ctx, input); err != nil {
+			return errors.Wrap(err, "ModifyDBInstance")
+		}
+	}
+	db.Status.Applied = appliedSettings(db)
+	if input := windowChanges(db, instance); input != nil {
+		log.Printf("Updating the maintenance and backup settings of %v\n", *id)
+		if _, err := r.rdsclient().ModifyDBInstance(ctx, input); err != nil {
+			return errors.Wrap(err, "ModifyDBInstance")
+		}
+	}
+	if err := r.ensureStorage(ctx, db, instance, time.Now()); err != nil {
+		return err
+	}
+	if err := r.ensureTags(ctx, db, instance); err != nil {
+		return err
+	}
+	monitoringRole, err := r.ensureMonitoringRole(ctx, db)
 	if err != nil {
-		return "", err
+		return err
+	}
+	if input := monitoringChanges(db, instance, monitoringRole); input != nil {
+		log.Printf("Updating the monitoring settings of %v\n", *id)
+		if _, err := r.rdsclient().ModifyDBInstance(ctx, input); err != nil {
+			return errors.Wrap(err, "ModifyDBInstance")
+		}
+	}
+	instance, err = describeInstance(ctx, id, r.rdsclient())
+	if err != nil {
+		return err
 	}
-	return dbHostname, nil
+	parameterStatus(db, instance)
+	db.Status.KMSKeyID = aws.ToString(instance.KmsKeyId)
+	if adopted(db) {
+		db.Status.Adoption = adoptionConfirmedState
+	}
+	if err := r.ensureDrift(ctx, db, instance); err != nil {
+		return err
+	}
+	if err := r.ensureProxy(ctx, db, instance); err != nil {
+		return err
+	}
+	if err := r.ensureConnectPolicies(ctx, db, instance); err != nil {
+		return err
+	}
+	return r.syncManagedPassword(ctx, db, instance)
 }
 
 // ensureSubnets is ensuring that we have created or updated the subnet according to the data from the CRD object
@@ -118,16 +274,25 @@ func (r *RDS) ensureSubnets(ctx context.Context, db *crd.Database) (string, erro
 	if len(r.Subnets) == 0 {
 		log.Println("Error: unable to continue due to lack of subnets, perhaps we couldn't lookup the subnets")
 	}
-	subnetDescription := "RDS Subnet Group for VPC: " + r.VpcId
-	subnetName := "db-subnetgroup-" + r.VpcId
+	subnetDescription := fmt.Sprintf("RDS Subnet Group for database %v in namespace %v", db.Name, db.Namespace)
+	subnetName := subnetGroupName(db)
 
 	svc := r.rdsclient()
 
+	// instances created before the subnet groups were per database keep the group they were created with
+	instance, err := describeInstance(ctx, aws.String(instanceIdentifier(db)), svc)
+	if err == nil && instance.DBSubnetGroup != nil && instance.DBSubnetGroup.DBSubnetGroupName != nil {
+		subnetName = *instance.DBSubnetGroup.DBSubnetGroupName
+	}
+
 	sf := &rds.DescribeDBSubnetGroupsInput{DBSubnetGroupName: aws.String(subnetName)}
-	_, err := svc.DescribeDBSubnetGroups(ctx, sf)
+	res, err := svc.DescribeDBSubnetGroups(ctx, sf)
 	log.Println("Subnets:", r.Subnets)
 	if err != nil {
-		// assume we didn't find it..
+		var notFound *rdstypes.DBSubnetGroupNotFoundFault
+		if !errors.As(err, &notFound) {
+			return "", errors.Wrap(err, "DescribeDBSubnetGroups")
+		}
 		subnet := &rds.CreateDBSubnetGroupInput{
 			DBSubnetGroupDescription: aws.String(subnetDescription),
 			DBSubnetGroupName:        aws.String(subnetName),
@@ -138,58 +303,177 @@ func (r *RDS) ensureSubnets(ctx context.Context, db *crd.Database) (string, erro
 		if err != nil {
 			return "", errors.Wrap(err, "CreateDBSubnetGroup")
 		}
-	} else {
+		return subnetName, nil
+	}
+
+	if isSharedSubnetGroup(subnetName) {
+		// the other databases in the VPC depend on the shared group, so it's left as it is
 		log.Printf("Moving on seems like %v exsits", subnetName)
+		return subnetName, nil
+	}
+	if len(res.DBSubnetGroups) > 0 && !sameSubnets(groupSubnets(res.DBSubnetGroups[0]), r.Subnets) {
+		log.Printf("Updating subnets of %v to %v\n", subnetName, r.Subnets)
+		_, err := svc.ModifyDBSubnetGroup(ctx, &rds.ModifyDBSubnetGroupInput{
+			DBSubnetGroupName:        aws.String(subnetName),
+			DBSubnetGroupDescription: aws.String(subnetDescription),
+			SubnetIds:                r.Subnets,
+		})
+		if err != nil {
+			return "", errors.Wrap(err, "ModifyDBSubnetGroup")
+		}
 	}
 	return subnetName, nil
 }
 
-func getEndpoint(ctx context.Context, dbName *string, svc *rds.Client) (string, error) {
+func describeInstance(ctx context.Context, dbName *string, svc *rds.Client) (*rdstypes.DBInstance, error) {
 	k := &rds.DescribeDBInstancesInput{DBInstanceIdentifier: dbName}
 
 	instance, err := svc.DescribeDBInstances(ctx, k)
 	if err != nil || len(instance.DBInstances) == 0 {
-		return "", fmt.Errorf("wasn't able to describe the db instance with id %v", dbName)
+		return nil, fmt.Errorf("wasn't able to describe the db instance with id %v", *dbName)
 	}
-	rdsdb := instance.DBInstances[0]
+	return &instance.DBInstances[0], nil
+}
 
-	dbHostname := *rdsdb.Endpoint.Address
-	return dbHostname, nil
+// Ensure creates the instance, or applies the changes of the spec when it exists, and returns its status
+func (r *RDS) Ensure(ctx context.Context, db *crd.Database) (*provider.ProviderStatus, error) {
+	current, err := r.Describe(ctx, db)
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case !current.Exists:
+		err = r.create(ctx, db)
+	case current.State == "creating":
+		// the creation takes minutes, the status is reported until the instance is available
+		return current, nil
+	default:
+		err = r.update(ctx, db)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return r.Describe(ctx, db)
+}
+
+// Describe returns the status of the instance
+func (r *RDS) Describe(ctx context.Context, db *crd.Database) (*provider.ProviderStatus, error) {
+	id := aws.String(instanceIdentifier(db))
+	out, err := r.rdsclient().DescribeDBInstances(ctx, &rds.DescribeDBInstancesInput{DBInstanceIdentifier: id})
+	var notFound *rdstypes.DBInstanceNotFoundFault
+	if errors.As(err, &notFound) {
+		return &provider.ProviderStatus{}, nil
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, fmt.Sprintf("wasn't able to describe the db instance with id %v", *id))
+	}
+	if len(out.DBInstances) == 0 {
+		return &provider.ProviderStatus{}, nil
+	}
+	return proxyStatus(db, instanceStatus(&out.DBInstances[0])), nil
+}
+
+// proxyStatus points the status at the proxy when it replaces the service of the database and is available
+func proxyStatus(db *crd.Database, status *provider.ProviderStatus) *provider.ProviderStatus {
+	p := proxy(db)
+	if p == nil || !p.ReplaceService || db.Status.ProxyEndpoint == "" ||
+		db.Status.ProxyStatus != string(rdstypes.DBProxyStatusAvailable) {
+		return status
+	}
+	status.Hostname = db.Status.ProxyEndpoint
+	return status
+}
+
+// instanceStatus maps the instance to the status of the provider, the service points at its endpoint
+func instanceStatus(instance *rdstypes.DBInstance) *provider.ProviderStatus {
+	status := &provider.ProviderStatus{
+		Exists:        true,
+		State:         aws.ToString(instance.DBInstanceStatus),
+		EngineVersion: aws.ToString(instance.EngineVersion),
+	}
+	if instance.Endpoint != nil {
+		status.Hostname = aws.ToString(instance.Endpoint.Address)
+		status.Port = instance.Endpoint.Port
+	}
+	switch status.State {
+	case "available", "backing-up", "modifying", "configuring-enhanced-monitoring", "configuring-log-exports",
+		"maintenance", "storage-optimization", "upgrading":
+		status.Ready = status.Hostname != ""
+	}
+	return status
 }
 
-func (r *RDS) DeleteDatabase(ctx context.Context, db *crd.Database) error {
+func (r *RDS) Delete(ctx context.Context, db *crd.Database) error {
 	if db.Spec.DeleteProtection {
 		log.Printf("Trying to delete a %v in %v which is a deleted protected database", db.Name, db.Namespace)
 		return nil
 	}
+	if adoptionPending(db) {
+		log.Printf("Keeping db instance %v, its adoption by %v in %v was never confirmed\n", instanceIdentifier(db), db.Name, db.Namespace)
+		return nil
+	}
 	// delete the database instance
 	svc := r.rdsclient()
+	id := aws.String(instanceIdentifier(db))
 
-	_, err := svc.DeleteDBInstance(ctx, &rds.DeleteDBInstanceInput{
-		DBInstanceIdentifier: aws.String(dbidentifier(db)),
-		SkipFinalSnapshot:    true,
-	})
+	subnetName := subnetGroupName(db)
+	instance, err := describeInstance(ctx, id, svc)
+	if err == nil && instance.DBSubnetGroup != nil && instance.DBSubnetGroup.DBSubnetGroupName != nil {
+		subnetName = *instance.DBSubnetGroup.DBSubnetGroupName
+	}
+
+	input := &rds.DeleteDBInstanceInput{DBInstanceIdentifier: id, SkipFinalSnapshot: true}
+	if adopted(db) {
+		// the operator didn't create the instance, its data is kept in case the deletion was a mistake
+		input.SkipFinalSnapshot = false
+		input.FinalDBSnapshotIdentifier = aws.String(finalSnapshotName(*id, "final", time.Now()))
+		log.Printf("Deleting adopted db instance %v with final snapshot %v\n", *id, *input.FinalDBSnapshotIdentifier)
+	}
+	_, err = svc.DeleteDBInstance(ctx, input)
 
 	if err != nil {
 		err := errors.Wrap(err, fmt.Sprintf("unable to delete database %v", db.Spec.DBName))
 		log.Println(err)
 		return err
 	}
+	if err := r.deleteConnectPolicies(ctx, db); err != nil {
+		log.Println(err)
+	}
+	if proxy(db) != nil || db.Status.ProxyStatus != "" {
+		if err := r.deleteProxy(ctx, db); err != nil {
+			log.Println(err)
+		}
+	}
+
+	// the deletion takes minutes, so the resources used by the instance are removed in the background. gc finds
+	// the ones left behind when the operator stops before they're removed.
+	go r.cleanup(context.Background(), db, subnetName)
+	return nil
+}
 
+// cleanup waits for the instance to be deleted, and then removes the subnet group, parameter group and
+// security group that were created for it. They can't be deleted while the instance is using them.
+func (r *RDS) cleanup(ctx context.Context, db *crd.Database, subnetName string) {
 	log.Printf("Waiting for db instance %v to be deleted\n", db.Spec.DBName)
-	time.Sleep(5 * time.Second)
+	if err := waitForDeletion(ctx, aws.String(instanceIdentifier(db)), r.rdsclient()); err != nil {
+		log.Println(err)
+		return
+	}
 
-	// delete the subnet group attached to the instance
-	subnetName := db.Name + "-subnet-" + db.Namespace
-	_, err = svc.DeleteDBSubnetGroup(ctx, &rds.DeleteDBSubnetGroupInput{DBSubnetGroupName: aws.String(subnetName)})
-	if err != nil {
-		e := errors.Wrap(err, fmt.Sprintf("unable to delete subnet %v", subnetName))
-		log.Println(e)
-		return e
-	} else {
-		log.Println("Deleted DBSubnet group: ", subnetName)
+	// the parameters may have been removed from the spec since the group was created
+	r.deleteParameterGroup(ctx, db)
+	if db.Spec.AWS != nil && db.Spec.AWS.CreateSecurityGroup {
+		if err := r.deleteSecurityGroup(ctx, db); err != nil {
+			log.Println(err)
+		}
+	}
+	if adopted(db) && subnetName != subnetGroupName(db) && !isSharedSubnetGroup(subnetName) {
+		// the subnet group came with the adopted instance
+		return
+	}
+	if err := r.deleteSubnetGroup(ctx, subnetName); err != nil {
+		log.Println(err)
 	}
-	return nil
 }
 
 func (r *RDS) rdsclient() *rds.Client {
@@ -231,13 +515,13 @@ func toTags(annotations, labels map[string]string) []rdstypes.Tag {
 
 func gettags(db *crd.Database) []rdstypes.Tag {
 	var tags []rdstypes.Tag
-	if db.Spec.Tags == "" {
-		return tags
+	keys := make([]string, 0, len(db.Spec.Tags))
+	for k := range db.Spec.Tags {
+		keys = append(keys, k)
 	}
-	for _, v := range strings.Split(db.Spec.Tags, ",") {
-		kv := strings.Split(v, "=")
-
-		tags = append(tags, rdstypes.Tag{Key: aws.String(strings.TrimSpace(kv[0])), Value: aws.String(strings.TrimSpace(kv[1]))})
+	sort.Strings(keys)
+	for _, k := range keys {
+		tags = append(tags, rdstypes.Tag{Key: aws.String(k), Value: aws.String(db.Spec.Tags[k])})
 	}
 	return tags
 }
@@ -251,10 +535,9 @@ func convertSpecToInput(v *crd.Database, subnetName string, securityGroups []str
 		AllocatedStorage:      aws.Int32(int32(v.Spec.Size)),
 		MaxAllocatedStorage:   aws.Int32(int32(v.Spec.MaxAllocatedSize)),
 		DBInstanceClass:       aws.String(v.Spec.Class),
-		DBInstanceIdentifier:  aws.String(dbidentifier(v)),
+		DBInstanceIdentifier:  aws.String(instanceIdentifier(v)),
 		VpcSecurityGroupIds:   securityGroups,
 		Engine:                aws.String(v.Spec.Engine),
-		MasterUserPassword:    aws.String(password),
 		MasterUsername:        aws.String(v.Spec.Username),
 		DBSubnetGroupName:     aws.String(subnetName),
 		PubliclyAccessible:    aws.Bool(v.Spec.PubliclyAccessible),
@@ -273,33 +556,49 @@ func convertSpecToInput(v *crd.Database, subnetName string, securityGroups []str
 	if v.Spec.Iops > 0 {
 		input.Iops = aws.Int32(int32(v.Spec.Iops))
 	}
-	return input
-}
-
-//DescribeInstancesResponse
-// describeNodeEC2Instance returns the AWS Metadata for the firt Node from the cluster
-func describeNodeEC2Instance(ctx context.Context, kubectl *kubernetes.Clientset, svc *ec2.Client) (*ec2.DescribeInstancesOutput, error) {
-	nodes, err := kubectl.CoreV1().Nodes().List(ctx, metav1.ListOptions{})
-	if err != nil {
-		return nil, errors.Wrap(err, "unable to get nodes")
+	if v.Spec.StorageThroughput > 0 {
+		input.StorageThroughput = aws.Int32(int32(v.Spec.StorageThroughput))
 	}
-	name := ""
-
-	if len(nodes.Items) == 0 {
-		return nil, fmt.Errorf("unable to find any nodes in the cluster")
+	if len(v.Spec.Parameters) > 0 {
+		input.DBParameterGroupName = aws.String(parameterGroupName(v))
 	}
+	if v.Spec.MaintenanceWindow != "" {
+		input.PreferredMaintenanceWindow = aws.String(v.Spec.MaintenanceWindow)
+	}
+	if v.Spec.BackupWindow != "" {
+		input.PreferredBackupWindow = aws.String(v.Spec.BackupWindow)
+	}
+	if v.Spec.AutoMinorVersionUpgrade != nil {
+		input.AutoMinorVersionUpgrade = v.Spec.AutoMinorVersionUpgrade
+	}
+	if v.Spec.CopyTagsToSnapshot {
+		input.CopyTagsToSnapshot = aws.Bool(true)
+	}
+	if key := kmsKeyID(v); key != "" {
+		input.StorageEncrypted = aws.Bool(true)
+		input.KmsKeyId = aws.String(key)
+	}
+	if iamAuthentication(v) {
+		input.EnableIAMDatabaseAuthentication = aws.Bool(true)
+	}
+	if managedPassword(v) {
+		input.ManageMasterUserPassword = aws.Bool(true)
+	} else {
+		input.MasterUserPassword = aws.String(password)
+	}
+	return input
+}
 
-	// take the first one, we assume that all nodes are created in the same VPC
-	name = getIDFromProvider(nodes.Items[0].Spec.ProviderID)
-
-	log.Printf("Taking subnets from node %v", name)
+// describeNodeEC2Instance returns the AWS Metadata for the EC2 instance of a node from the cluster
+func describeNodeEC2Instance(ctx context.Context, instanceID string, svc *ec2.Client) (*ec2.DescribeInstancesOutput, error) {
+	log.Printf("Taking subnets from node %v", instanceID)
 
 	params := &ec2.DescribeInstancesInput{
 		Filters: []ec2types.Filter{
 			{
 				Name: aws.String("instance-id"),
 				Values: []string{
-					name,
+					instanceID,
 				},
 			},
 		},
@@ -310,9 +609,8 @@ func describeNodeEC2Instance(ctx context.Context, kubectl *kubernetes.Clientset,
 	if err != nil {
 		return nil, errors.Wrap(err, "unable to describe AWS instance")
 	}
-	if len(nodeInfo.Reservations) == 0 {
-		log.Println(err)
-		return nil, fmt.Errorf("unable to describe AWS instance")
+	if len(nodeInfo.Reservations) == 0 || len(nodeInfo.Reservations[0].Instances) == 0 {
+		return nil, fmt.Errorf("unable to describe AWS instance %v", instanceID)
 	}
 
 	return nodeInfo, nil
@@ -333,10 +631,10 @@ func getSubnets(ctx context.Context, nodeInfo *ec2.DescribeInstancesOutput, svc
 		return nil, errors.Wrap(err, fmt.Sprintf("unable to describe subnet in VPC %v", *vpcID))
 	}
 	for _, sn := range subnets.Subnets {
-		if sn.MapPublicIpOnLaunch == public {
+		if aws.ToBool(sn.MapPublicIpOnLaunch) == public {
 			result = append(result, *sn.SubnetId)
 		} else {
-			log.Printf("Skipping subnet %v since it's public state was %v and we were looking for %v\n", *sn.SubnetId, sn.MapPublicIpOnLaunch, public)
+			log.Printf("Skipping subnet %v since it's public state was %v and we were looking for %v\n", *sn.SubnetId, aws.ToBool(sn.MapPublicIpOnLaunch), public)
 		}
 	}
 
@@ -352,79 +650,18 @@ func getIDFromProvider(x string) string {
 	name := x[pos:]
 	return name
 }
-func getSGS(ctx context.Context, kubectl *kubernetes.Clientset, svc *ec2.Client) ([]string, error) {
-
-	nodes, err := kubectl.CoreV1().Nodes().List(ctx, metav1.ListOptions{})
-	if err != nil {
-		return nil, errors.Wrap(err, "unable to get nodes")
-	}
-	name := ""
-
-	if len(nodes.Items) > 0 {
-		// take the first one, we assume that all nodes are created in the same VPC
-		name = getIDFromProvider(nodes.Items[0].Spec.ProviderID)
-	} else {
-		return nil, fmt.Errorf("unable to find any nodes in the cluster")
-	}
-	log.Printf("Taking security groups from node %v", name)
-
-	params := &ec2.DescribeInstancesInput{
-		Filters: []ec2types.Filter{
-			{
-				Name: aws.String("instance-id"),
-				Values: []string{
-					name,
-				},
-			},
-		},
-	}
-	log.Println("trying to describe instance")
-	res, err := svc.DescribeInstances(ctx, params)
-
-	if err != nil {
-		log.Println(err)
-		return nil, errors.Wrap(err, "unable to describe AWS instance")
-	}
-	log.Println("got instance response")
 
+// getSGS returns the security groups of the node
+func getSGS(nodeInfo *ec2.DescribeInstancesOutput) []string {
 	var result []string
-	if len(res.Reservations) >= 1 {
-		for _, v := range res.Reservations[0].Instances[0].SecurityGroups {
-			log.Println("Security groupid: ", *v.GroupId)
-			result = append(result, *v.GroupId)
-		}
+	for _, v := range nodeInfo.Reservations[0].Instances[0].SecurityGroups {
+		log.Println("Security groupid: ", *v.GroupId)
+		result = append(result, *v.GroupId)
 	}
 
 	log.Printf("Found the follwing security groups: ")
 	for _, v := range result {
 		log.Printf(v + " ")
 	}
-	return result, nil
-}
-
-func ec2config(ctx context.Context, kubectl *kubernetes.Clientset) (*aws.Config, error) {
-	nodes, err := kubectl.CoreV1().Nodes().List(ctx, metav1.ListOptions{})
-	if err != nil {
-		return nil, errors.Wrap(err, "unable to get nodes")
-	}
-	name := ""
-	region := ""
-
-	if len(nodes.Items) > 0 {
-		// take the first one, we assume that all nodes are created in the same VPC
-		name = getIDFromProvider(nodes.Items[0].Spec.ProviderID)
-		region = nodes.Items[0].Labels["failure-domain.beta.kubernetes.io/region"]
-	} else {
-		return nil, fmt.Errorf("unable to find any nodes in the cluster")
-	}
-	log.Printf("Found node with ID: %v in region %v", name, region)
-
-	cfg, err := config.LoadDefaultConfig(ctx)
-	if err != nil {
-		panic("unable to load SDK config, " + err.Error())
-	}
-
-	// Set the AWS Region that the service clients should use
-	cfg.Region = region
-	return &cfg, nil
+	return result
 }
@@ -1,12 +1,26 @@
 package rds
 
 import (
+	"context"
+	"fmt"
+	"strings"
 	"testing"
+	"time"
 
+	"github.com/aws/aws-sdk-go-v2/aws"
+	ec2types "github.com/aws/aws-sdk-go-v2/service/ec2/types"
+	kmstypes "github.com/aws/aws-sdk-go-v2/service/kms/types"
+	rdstypes "github.com/aws/aws-sdk-go-v2/service/rds/types"
+	"github.com/aws/smithy-go"
+	"github.com/pkg/errors"
 	"github.com/sorenmat/k8s-rds/crd"
+	"github.com/sorenmat/k8s-rds/provider"
 
 	"github.com/stretchr/testify/assert"
 	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	testclient "k8s.io/client-go/kubernetes/fake"
 )
 
 func TestConvertSpecToInput(t *testing.T) {
@@ -42,6 +56,178 @@ func TestConvertSpecToInput(t *testing.T) {
 	assert.Equal(t, "bad", *i.StorageType)
 	assert.Equal(t, int32(1000), *i.Iops)
 	assert.Equal(t, "9.6", *i.EngineVersion)
+	assert.Nil(t, i.DBParameterGroupName)
+	assert.Nil(t, i.ManageMasterUserPassword)
+}
+
+func TestConvertSpecToInputWithManagedPassword(t *testing.T) {
+	db := &crd.Database{
+		ObjectMeta: metav1.ObjectMeta{Name: "mydb", Namespace: "default"},
+		Spec: crd.DatabaseSpec{
+			DBName:   "mydb",
+			Engine:   "postgres",
+			Username: "myuser",
+			AWS:      &crd.AWSSpec{ManagedPassword: true},
+		},
+	}
+	i := convertSpecToInput(db, "mysubnet", nil, "")
+	assert.True(t, *i.ManageMasterUserPassword)
+	assert.Nil(t, i.MasterUserPassword)
+	assert.Equal(t, "myuser", *i.MasterUsername)
+}
+
+func TestConvertSpecToInputWithKMSKey(t *testing.T) {
+	db := &crd.Database{
+		ObjectMeta: metav1.ObjectMeta{Name: "mydb", Namespace: "default"},
+		Spec: crd.DatabaseSpec{
+			DBName:   "mydb",
+			Engine:   "postgres",
+			Username: "myuser",
+			AWS:      &crd.AWSSpec{KMSKeyID: "alias/tenant-a"},
+		},
+	}
+	i := convertSpecToInput(db, "mysubnet", nil, "")
+	assert.True(t, *i.StorageEncrypted)
+	assert.Equal(t, "alias/tenant-a", *i.KmsKeyId)
+
+	db.Spec.AWS = nil
+	i = convertSpecToInput(db, "mysubnet", nil, "")
+	assert.False(t, *i.StorageEncrypted)
+	assert.Nil(t, i.KmsKeyId)
+}
+
+func TestConvertSpecToInputWithWindows(t *testing.T) {
+	db := &crd.Database{
+		ObjectMeta: metav1.ObjectMeta{Name: "mydb", Namespace: "default"},
+		Spec: crd.DatabaseSpec{
+			DBName:                  "mydb",
+			Engine:                  "postgres",
+			Username:                "myuser",
+			MaintenanceWindow:       "sun:03:00-sun:04:00",
+			BackupWindow:            "01:00-01:30",
+			AutoMinorVersionUpgrade: aws.Bool(false),
+			CopyTagsToSnapshot:      true,
+		},
+	}
+	i := convertSpecToInput(db, "mysubnet", nil, "")
+	assert.Equal(t, "sun:03:00-sun:04:00", *i.PreferredMaintenanceWindow)
+	assert.Equal(t, "01:00-01:30", *i.PreferredBackupWindow)
+	assert.False(t, *i.AutoMinorVersionUpgrade)
+	assert.True(t, *i.CopyTagsToSnapshot)
+
+	db.Spec = crd.DatabaseSpec{}
+	i = convertSpecToInput(db, "mysubnet", nil, "")
+	assert.Nil(t, i.PreferredMaintenanceWindow)
+	assert.Nil(t, i.PreferredBackupWindow)
+	assert.Nil(t, i.AutoMinorVersionUpgrade)
+	assert.Nil(t, i.CopyTagsToSnapshot)
+}
+
+func TestCheckKey(t *testing.T) {
+	key := &kmstypes.KeyMetadata{
+		KeyState: kmstypes.KeyStateEnabled,
+		KeyUsage: kmstypes.KeyUsageTypeEncryptDecrypt,
+		KeySpec:  kmstypes.KeySpecSymmetricDefault,
+	}
+	assert.NoError(t, checkKey("alias/tenant-a", key))
+	assert.Error(t, checkKey("alias/tenant-a", nil))
+
+	key.KeyState = kmstypes.KeyStatePendingDeletion
+	assert.Error(t, checkKey("alias/tenant-a", key))
+
+	key.KeyState = kmstypes.KeyStateEnabled
+	key.KeySpec = kmstypes.KeySpecRsa2048
+	assert.Error(t, checkKey("alias/tenant-a", key))
+
+	key.KeySpec = kmstypes.KeySpecSymmetricDefault
+	key.KeyUsage = kmstypes.KeyUsageTypeSignVerify
+	assert.Error(t, checkKey("alias/tenant-a", key))
+}
+
+func TestKMSKeyCondition(t *testing.T) {
+	db := &crd.Database{}
+	kmsKeyCondition(db, "arn:aws:kms:eu-west-1:123456789012:key/a", "arn:aws:kms:eu-west-1:123456789012:key/a")
+	c := meta.FindStatusCondition(db.Status.Conditions, crd.ConditionKMSKeyMismatch)
+	assert.Equal(t, metav1.ConditionFalse, c.Status)
+
+	kmsKeyCondition(db, "arn:aws:kms:eu-west-1:123456789012:key/a", "arn:aws:kms:eu-west-1:123456789012:key/b")
+	c = meta.FindStatusCondition(db.Status.Conditions, crd.ConditionKMSKeyMismatch)
+	assert.Equal(t, metav1.ConditionTrue, c.Status)
+	assert.Contains(t, c.Message, "key/b")
+
+	kmsKeyCondition(db, "arn:aws:kms:eu-west-1:123456789012:key/a", "")
+	assert.Nil(t, meta.FindStatusCondition(db.Status.Conditions, crd.ConditionKMSKeyMismatch))
+}
+
+func TestConvertSpecToInputWithParameters(t *testing.T) {
+	db := &crd.Database{
+		ObjectMeta: metav1.ObjectMeta{Name: "mydb", Namespace: "default"},
+		Spec: crd.DatabaseSpec{
+			DBName:     "mydb",
+			Engine:     "postgres",
+			Parameters: map[string]string{"max_connections": "200"},
+		},
+	}
+	i := convertSpecToInput(db, "mysubnet", nil, "mypassword")
+	assert.Equal(t, "mydb-default", *i.DBParameterGroupName)
+}
+
+func TestParameterChanges(t *testing.T) {
+	current := map[string]rdstypes.Parameter{
+		"max_connections": {
+			ParameterName: aws.String("max_connections"),
+			ApplyType:     aws.String("static"),
+			IsModifiable:  true,
+			Source:        aws.String("engine-default"),
+		},
+		"log_min_duration_statement": {
+			ParameterName:  aws.String("log_min_duration_statement"),
+			ParameterValue: aws.String("1000"),
+			ApplyType:      aws.String("dynamic"),
+			IsModifiable:   true,
+			Source:         aws.String("user"),
+		},
+		"work_mem": {
+			ParameterName:  aws.String("work_mem"),
+			ParameterValue: aws.String("4096"),
+			ApplyType:      aws.String("dynamic"),
+			IsModifiable:   true,
+			Source:         aws.String("user"),
+		},
+		"rds.extensions": {
+			ParameterName: aws.String("rds.extensions"),
+			ApplyType:     aws.String("static"),
+			IsModifiable:  false,
+			Source:        aws.String("system"),
+		},
+	}
+
+	changes, resets, err := parameterChanges(map[string]string{
+		"max_connections":            "200",
+		"log_min_duration_statement": "500",
+		"work_mem":                   "4096",
+	}, current)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, len(changes))
+	assert.Equal(t, "log_min_duration_statement", *changes[0].ParameterName)
+	assert.Equal(t, "500", *changes[0].ParameterValue)
+	assert.Equal(t, rdstypes.ApplyMethodImmediate, changes[0].ApplyMethod)
+	assert.Equal(t, "max_connections", *changes[1].ParameterName)
+	assert.Equal(t, rdstypes.ApplyMethodPendingReboot, changes[1].ApplyMethod)
+	assert.Equal(t, 0, len(resets))
+
+	changes, resets, err = parameterChanges(map[string]string{"max_connections": "200"}, current)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(changes))
+	assert.Equal(t, 2, len(resets))
+	assert.Equal(t, "log_min_duration_statement", *resets[0].ParameterName)
+	assert.Equal(t, "work_mem", *resets[1].ParameterName)
+
+	_, _, err = parameterChanges(map[string]string{"unknown": "1"}, current)
+	assert.Error(t, err)
+
+	_, _, err = parameterChanges(map[string]string{"rds.extensions": "1"}, current)
+	assert.Error(t, err)
 }
 
 func TestGetIDFromProvider(t *testing.T) {
@@ -132,7 +318,7 @@ func TestToTags(t *testing.T) {
 func TestTags(t *testing.T) {
 	db := &crd.Database{
 		Spec: crd.DatabaseSpec{
-			Tags: "key=value,key1=value1",
+			Tags: crd.ParseTags("key=value,key1=value1"),
 		},
 	}
 	tags := gettags(db)
@@ -147,7 +333,7 @@ func TestTags(t *testing.T) {
 func TestTagsWithSpaces(t *testing.T) {
 	db := &crd.Database{
 		Spec: crd.DatabaseSpec{
-			Tags: "key= value,   key1=value1",
+			Tags: crd.ParseTags("key= value,   key1=value1"),
 		},
 	}
 	tags := gettags(db)
@@ -159,3 +345,897 @@ func TestTagsWithSpaces(t *testing.T) {
 	assert.Equal(t, "value1", *tags[1].Value)
 
 }
+
+func TestSubnetFilters(t *testing.T) {
+	filters := subnetFilters(map[string]string{"tier": "database", "env": "prod"})
+	assert.Equal(t, 2, len(filters))
+	assert.Equal(t, "tag:env", *filters[0].Name)
+	assert.Equal(t, []string{"prod"}, filters[0].Values)
+	assert.Equal(t, "tag:tier", *filters[1].Name)
+	assert.Equal(t, []string{"database"}, filters[1].Values)
+}
+
+func TestHasCustomSubnets(t *testing.T) {
+	db := &crd.Database{}
+	assert.False(t, hasCustomSubnets(db))
+	db.Spec.AWS = &crd.AWSSpec{SecurityGroupIDs: []string{"sg-1234"}}
+	assert.False(t, hasCustomSubnets(db))
+	db.Spec.AWS.SubnetIDs = []string{"subnet-1234"}
+	assert.True(t, hasCustomSubnets(db))
+	db.Spec.AWS = &crd.AWSSpec{SubnetSelector: map[string]string{"tier": "database"}}
+	assert.True(t, hasCustomSubnets(db))
+}
+
+func TestIsDuplicatePermission(t *testing.T) {
+	err := &smithy.GenericAPIError{Code: "InvalidPermission.Duplicate", Message: "the specified rule already exists"}
+	assert.True(t, isDuplicatePermission(errors.Wrap(err, "AuthorizeSecurityGroupIngress")))
+	assert.False(t, isDuplicatePermission(&smithy.GenericAPIError{Code: "InvalidGroup.NotFound"}))
+	assert.False(t, isDuplicatePermission(fmt.Errorf("InvalidPermission.Duplicate")))
+}
+
+func TestEnginePort(t *testing.T) {
+	assert.Equal(t, int32(5432), enginePort("postgres"))
+	assert.Equal(t, int32(5432), enginePort("aurora-postgresql"))
+	assert.Equal(t, int32(3306), enginePort("mysql"))
+	assert.Equal(t, int32(3306), enginePort("aurora-mysql"))
+	assert.Equal(t, int32(3306), enginePort("mariadb"))
+	assert.Equal(t, int32(1521), enginePort("oracle-ee"))
+	assert.Equal(t, int32(1433), enginePort("sqlserver-ex"))
+}
+
+func TestSubnetGroupName(t *testing.T) {
+	db := &crd.Database{ObjectMeta: metav1.ObjectMeta{Name: "mydb", Namespace: "default"}}
+	assert.Equal(t, "mydb-subnet-default", subnetGroupName(db))
+	assert.False(t, isSharedSubnetGroup(subnetGroupName(db)))
+	assert.True(t, isSharedSubnetGroup("db-subnetgroup-vpc-1234"))
+}
+
+func TestSameSubnets(t *testing.T) {
+	assert.True(t, sameSubnets(nil, nil))
+	assert.True(t, sameSubnets([]string{"subnet-1", "subnet-2"}, []string{"subnet-2", "subnet-1"}))
+	assert.False(t, sameSubnets([]string{"subnet-1", "subnet-2"}, []string{"subnet-1"}))
+	assert.False(t, sameSubnets([]string{"subnet-1", "subnet-2"}, []string{"subnet-1", "subnet-3"}))
+
+	a := []string{"subnet-2", "subnet-1"}
+	sameSubnets(a, []string{"subnet-1", "subnet-2"})
+	assert.Equal(t, []string{"subnet-2", "subnet-1"}, a, "the input must not be reordered")
+}
+
+func TestGroupSubnets(t *testing.T) {
+	group := rdstypes.DBSubnetGroup{Subnets: []rdstypes.Subnet{
+		{SubnetIdentifier: aws.String("subnet-1")},
+		{SubnetIdentifier: aws.String("subnet-2")},
+	}}
+	assert.Equal(t, []string{"subnet-1", "subnet-2"}, groupSubnets(group))
+}
+
+func TestResolveRegion(t *testing.T) {
+	noMetadata := func() (string, error) { return "", fmt.Errorf("not running on EC2") }
+	metadata := func() (string, error) { return "eu-central-1", nil }
+	labels := map[string]string{
+		"topology.kubernetes.io/region":            "eu-west-1",
+		"failure-domain.beta.kubernetes.io/region": "eu-west-2",
+	}
+
+	region, _ := resolveRegion("us-east-1", labels, metadata, "us-west-2")
+	assert.Equal(t, "us-east-1", region)
+
+	region, _ = resolveRegion("", labels, metadata, "us-west-2")
+	assert.Equal(t, "eu-west-1", region)
+
+	region, _ = resolveRegion("", map[string]string{"failure-domain.beta.kubernetes.io/region": "eu-west-2"}, metadata, "us-west-2")
+	assert.Equal(t, "eu-west-2", region)
+
+	region, source := resolveRegion("", nil, metadata, "us-west-2")
+	assert.Equal(t, "eu-central-1", region)
+	assert.Equal(t, "the EC2 instance metadata", source)
+
+	region, source = resolveRegion("", nil, noMetadata, "us-west-2")
+	assert.Equal(t, "us-west-2", region)
+	assert.Equal(t, "the SDK default configuration", source)
+}
+
+func TestRoleFor(t *testing.T) {
+	kc := testclient.NewSimpleClientset(&v1.Namespace{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: "team-a",
+			Annotations: map[string]string{
+				RoleAnnotation:       "arn:aws:iam::123456789012:role/team-a",
+				ExternalIDAnnotation: "team-a",
+			},
+		},
+	}, &v1.Namespace{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        "team-b",
+			Annotations: map[string]string{AllowedRolesAnnotation: "arn:aws:iam::210987654321:role/other, arn:aws:iam::210987654321:role/mydb"},
+		},
+	}, &v1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "default"}})
+
+	db := &crd.Database{ObjectMeta: metav1.ObjectMeta{Name: "mydb", Namespace: "team-a"}}
+	role, err := roleFor(context.Background(), kc, db)
+	assert.NoError(t, err)
+	assert.Equal(t, "arn:aws:iam::123456789012:role/team-a", role.ARN)
+	assert.Equal(t, "team-a", role.ExternalID)
+
+	// the spec can't pick a role the namespace doesn't allow
+	db.Spec.AWS = &crd.AWSSpec{RoleARN: "arn:aws:iam::210987654321:role/mydb"}
+	_, err = roleFor(context.Background(), kc, db)
+	assert.Error(t, err)
+
+	db.Spec.AWS = &crd.AWSSpec{RoleARN: "arn:aws:iam::123456789012:role/team-a"}
+	role, err = roleFor(context.Background(), kc, db)
+	assert.NoError(t, err)
+	assert.Equal(t, "arn:aws:iam::123456789012:role/team-a", role.ARN)
+	assert.Equal(t, "team-a", role.ExternalID)
+
+	db.Namespace = "team-b"
+	db.Spec.AWS = &crd.AWSSpec{RoleARN: "arn:aws:iam::210987654321:role/mydb", ExternalID: "mydb"}
+	role, err = roleFor(context.Background(), kc, db)
+	assert.NoError(t, err)
+	assert.Equal(t, "arn:aws:iam::210987654321:role/mydb", role.ARN)
+	assert.Equal(t, "mydb", role.ExternalID)
+
+	db = &crd.Database{ObjectMeta: metav1.ObjectMeta{Name: "mydb", Namespace: "default"}}
+	role, err = roleFor(context.Background(), kc, db)
+	assert.NoError(t, err)
+	assert.Equal(t, "", role.ARN)
+
+	db.Namespace = "missing"
+	_, err = roleFor(context.Background(), kc, db)
+	assert.Error(t, err)
+}
+
+func TestConfigFor(t *testing.T) {
+	env := &Environment{Config: aws.Config{Region: "eu-west-1"}}
+	cfg := env.ConfigFor(assumedRole{})
+	assert.Nil(t, cfg.Credentials)
+
+	role := assumedRole{ARN: "arn:aws:iam::123456789012:role/team-a"}
+	cfg = env.ConfigFor(role)
+	assert.NotNil(t, cfg.Credentials)
+	assert.Equal(t, "eu-west-1", cfg.Region)
+	assert.Nil(t, env.Config.Credentials, "the operator's config must not be changed")
+	assert.Equal(t, cfg.Credentials, env.ConfigFor(role).Credentials, "credentials are cached per role")
+}
+
+func TestDBUserARN(t *testing.T) {
+	arn, err := dbUserARN("arn:aws:rds:eu-west-1:123456789012:db:mydb-default", "db-ABCDEFGHIJKL", "app")
+	assert.NoError(t, err)
+	assert.Equal(t, "arn:aws:rds-db:eu-west-1:123456789012:dbuser:db-ABCDEFGHIJKL/app", arn)
+
+	_, err = dbUserARN("mydb-default", "db-ABCDEFGHIJKL", "app")
+	assert.Error(t, err)
+}
+
+func TestConnectPolicies(t *testing.T) {
+	instanceARN := "arn:aws:rds:eu-west-1:123456789012:db:mydb-default"
+	db := &crd.Database{
+		ObjectMeta: metav1.ObjectMeta{Name: "mydb", Namespace: "default"},
+		Spec: crd.DatabaseSpec{AWS: &crd.AWSSpec{
+			IAMUsers: []crd.IAMUser{
+				{User: "reader", RoleARNs: []string{"arn:aws:iam::123456789012:role/app", "arn:aws:iam::123456789012:role/reports"}},
+				{User: "app", RoleARNs: []string{"arn:aws:iam::123456789012:role/app"}},
+			},
+		}},
+	}
+
+	policies, err := connectPolicies(db, instanceARN, "db-ABC")
+	assert.NoError(t, err)
+	assert.Empty(t, policies, "no policies without IAM authentication")
+
+	db.Spec.AWS.IAMAuthentication = true
+	policies, err = connectPolicies(db, instanceARN, "db-ABC")
+	assert.NoError(t, err)
+	assert.Len(t, policies, 2)
+	assert.Equal(t, `{"Version":"2012-10-17","Statement":[{"Effect":"Allow","Action":"rds-db:connect","Resource":[`+
+		`"arn:aws:rds-db:eu-west-1:123456789012:dbuser:db-ABC/app","arn:aws:rds-db:eu-west-1:123456789012:dbuser:db-ABC/reader"]}]}`,
+		policies["arn:aws:iam::123456789012:role/app"])
+	assert.Equal(t, `{"Version":"2012-10-17","Statement":[{"Effect":"Allow","Action":"rds-db:connect","Resource":[`+
+		`"arn:aws:rds-db:eu-west-1:123456789012:dbuser:db-ABC/reader"]}]}`,
+		policies["arn:aws:iam::123456789012:role/reports"])
+
+	// a role of another account would be confused with the role of the same name in the account of the instance
+	db.Spec.AWS.IAMUsers = append(db.Spec.AWS.IAMUsers, crd.IAMUser{User: "app", RoleARNs: []string{"arn:aws:iam::210987654321:role/app"}})
+	_, err = connectPolicies(db, instanceARN, "db-ABC")
+	assert.Error(t, err)
+}
+
+func TestARNAccount(t *testing.T) {
+	assert.Equal(t, "123456789012", arnAccount("arn:aws:iam::123456789012:role/path/app"))
+	assert.Equal(t, "123456789012", arnAccount("arn:aws:rds:eu-west-1:123456789012:db:mydb-default"))
+	assert.Equal(t, "", arnAccount("app"))
+}
+
+func TestRoleName(t *testing.T) {
+	assert.Equal(t, "app", roleName("arn:aws:iam::123456789012:role/app"))
+	assert.Equal(t, "app", roleName("arn:aws:iam::123456789012:role/team/app"))
+}
+
+func TestValidateWindows(t *testing.T) {
+	tests := []struct {
+		maintenance, backup string
+		valid               bool
+	}{
+		{"", "", true},
+		{"sun:03:00-sun:04:00", "01:00-01:30", true},
+		{"sun:03:00-sun:04:00", "", true},
+		{"", "23:45-00:15", true},
+		{"sat:23:30-sun:00:30", "01:00-01:30", true},
+		{"sun:03:00-sun:04:00", "03:30-04:30", false},
+		{"sat:23:30-sun:00:30", "00:00-00:30", false},
+		{"mon:03:00-mon:04:00", "23:45-03:15", false},
+		{"sun:03:00-sun:03:15", "", false},
+		{"", "01:00-01:10", false},
+		{"sun:25:00-sun:26:00", "", false},
+		{"xyz:03:00-sun:04:00", "", false},
+	}
+	for _, test := range tests {
+		err := validateWindows(crd.DatabaseSpec{MaintenanceWindow: test.maintenance, BackupWindow: test.backup})
+		assert.Equal(t, test.valid, err == nil, "%v %v: %v", test.maintenance, test.backup, err)
+	}
+}
+
+func TestWindowChanges(t *testing.T) {
+	db := &crd.Database{Spec: crd.DatabaseSpec{
+		MaintenanceWindow: "sun:03:00-sun:04:00",
+		BackupWindow:      "01:00-01:30",
+	}}
+	instance := &rdstypes.DBInstance{
+		DBInstanceIdentifier:       aws.String("mydb-default"),
+		PreferredMaintenanceWindow: aws.String("sun:03:00-sun:04:00"),
+		PreferredBackupWindow:      aws.String("01:00-01:30"),
+		AutoMinorVersionUpgrade:    true,
+	}
+	assert.Nil(t, windowChanges(db, instance))
+
+	db.Spec.BackupWindow = "02:00-02:30"
+	db.Spec.AutoMinorVersionUpgrade = aws.Bool(false)
+	db.Spec.CopyTagsToSnapshot = true
+	input := windowChanges(db, instance)
+	assert.Nil(t, input.PreferredMaintenanceWindow)
+	assert.Equal(t, "02:00-02:30", *input.PreferredBackupWindow)
+	assert.False(t, *input.AutoMinorVersionUpgrade)
+	assert.True(t, *input.CopyTagsToSnapshot)
+	assert.True(t, input.ApplyImmediately)
+
+	db.Spec = crd.DatabaseSpec{}
+	assert.Nil(t, windowChanges(db, instance), "settings not in the spec are left as they are")
+}
+
+func TestVersionMatches(t *testing.T) {
+	assert.True(t, versionMatches("13", "13.7"))
+	assert.True(t, versionMatches("13.7", "13.7"))
+	assert.False(t, versionMatches("13", "9.6.20"))
+	assert.False(t, versionMatches("1", "13.7"))
+	assert.False(t, versionMatches("13.7", "13.10"))
+}
+
+func TestCompareVersions(t *testing.T) {
+	assert.True(t, compareVersions("13.10", "13.9") > 0)
+	assert.True(t, compareVersions("9.6.20", "13.1") < 0)
+	assert.Equal(t, 0, compareVersions("13.7", "13.7"))
+	assert.True(t, compareVersions("13.7.1", "13.7") > 0)
+}
+
+func TestUpgradeTarget(t *testing.T) {
+	targets := []rdstypes.UpgradeTarget{
+		{EngineVersion: aws.String("9.6.24")},
+		{EngineVersion: aws.String("13.9"), IsMajorVersionUpgrade: true},
+		{EngineVersion: aws.String("13.10"), IsMajorVersionUpgrade: true},
+		{EngineVersion: aws.String("14.6"), IsMajorVersionUpgrade: true},
+	}
+	target, err := upgradeTarget("13", targets)
+	assert.NoError(t, err)
+	assert.Equal(t, "13.10", *target.EngineVersion)
+	assert.True(t, target.IsMajorVersionUpgrade)
+
+	target, err = upgradeTarget("9.6.24", targets)
+	assert.NoError(t, err)
+	assert.False(t, target.IsMajorVersionUpgrade)
+
+	_, err = upgradeTarget("15", targets)
+	assert.Error(t, err)
+}
+
+func TestUpgradeSnapshotName(t *testing.T) {
+	db := &crd.Database{ObjectMeta: metav1.ObjectMeta{Name: "mydb", Namespace: "default"}}
+	assert.Equal(t, "mydb-default-pre-upgrade-13-7", upgradeSnapshotName(db, "13.7"))
+}
+
+func TestValidateMonitoring(t *testing.T) {
+	db := &crd.Database{Spec: crd.DatabaseSpec{AWS: &crd.AWSSpec{Monitoring: &crd.MonitoringSpec{}}}}
+	for _, days := range []int64{0, 7, 31, 93, 731} {
+		db.Spec.AWS.Monitoring.PerformanceInsightsRetentionDays = days
+		assert.NoError(t, validateMonitoring(db), days)
+	}
+	db.Spec.AWS.Monitoring.PerformanceInsightsRetentionDays = 30
+	assert.Error(t, validateMonitoring(db))
+}
+
+func TestApplyMonitoring(t *testing.T) {
+	db := &crd.Database{
+		ObjectMeta: metav1.ObjectMeta{Name: "mydb", Namespace: "default"},
+		Spec: crd.DatabaseSpec{
+			DBName:   "mydb",
+			Engine:   "postgres",
+			Username: "myuser",
+		},
+	}
+	i := convertSpecToInput(db, "mysubnet", nil, "")
+	applyMonitoring(i, db, "")
+	assert.Nil(t, i.EnablePerformanceInsights)
+	assert.Nil(t, i.MonitoringInterval)
+
+	db.Spec.AWS = &crd.AWSSpec{Monitoring: &crd.MonitoringSpec{
+		PerformanceInsights:              true,
+		PerformanceInsightsRetentionDays: 31,
+		Interval:                         60,
+		LogExports:                       []string{"postgresql", "upgrade"},
+	}}
+	applyMonitoring(i, db, "arn:aws:iam::123456789012:role/k8s-rds-monitoring")
+	assert.True(t, *i.EnablePerformanceInsights)
+	assert.Equal(t, int32(31), *i.PerformanceInsightsRetentionPeriod)
+	assert.Nil(t, i.PerformanceInsightsKMSKeyId)
+	assert.Equal(t, int32(60), *i.MonitoringInterval)
+	assert.Equal(t, "arn:aws:iam::123456789012:role/k8s-rds-monitoring", *i.MonitoringRoleArn)
+	assert.Equal(t, []string{"postgresql", "upgrade"}, i.EnableCloudwatchLogsExports)
+}
+
+func TestMonitoringChanges(t *testing.T) {
+	role := "arn:aws:iam::123456789012:role/k8s-rds-monitoring"
+	db := &crd.Database{Spec: crd.DatabaseSpec{}}
+	instance := &rdstypes.DBInstance{
+		DBInstanceIdentifier:         aws.String("mydb-default"),
+		PerformanceInsightsEnabled:   aws.Bool(false),
+		MonitoringInterval:           aws.Int32(0),
+		EnabledCloudwatchLogsExports: []string{"postgresql"},
+	}
+	assert.Nil(t, monitoringChanges(db, instance, role), "settings not in the spec are left as they are")
+
+	db.Spec.AWS = &crd.AWSSpec{Monitoring: &crd.MonitoringSpec{
+		PerformanceInsights: true,
+		Interval:            30,
+		LogExports:          []string{"upgrade"},
+	}}
+	input := monitoringChanges(db, instance, role)
+	assert.True(t, *input.EnablePerformanceInsights)
+	assert.Equal(t, int32(30), *input.MonitoringInterval)
+	assert.Equal(t, role, *input.MonitoringRoleArn)
+	assert.Equal(t, []string{"upgrade"}, input.CloudwatchLogsExportConfiguration.EnableLogTypes)
+	assert.Equal(t, []string{"postgresql"}, input.CloudwatchLogsExportConfiguration.DisableLogTypes)
+
+	instance.PerformanceInsightsEnabled = aws.Bool(true)
+	instance.PerformanceInsightsRetentionPeriod = aws.Int32(7)
+	instance.MonitoringInterval = aws.Int32(30)
+	instance.MonitoringRoleArn = aws.String(role)
+	instance.EnabledCloudwatchLogsExports = []string{"upgrade"}
+	assert.Nil(t, monitoringChanges(db, instance, role))
+
+	db.Spec.AWS.Monitoring.Interval = 0
+	input = monitoringChanges(db, instance, "")
+	assert.Equal(t, int32(0), *input.MonitoringInterval)
+	assert.Nil(t, input.MonitoringRoleArn)
+}
+
+func TestMonitoringPolicyARN(t *testing.T) {
+	assert.Equal(t, "arn:aws:iam::aws:policy/service-role/AmazonRDSEnhancedMonitoringRole",
+		monitoringPolicyARN("arn:aws:iam::123456789012:role/k8s-rds-monitoring"))
+	assert.Equal(t, "arn:aws-cn:iam::aws:policy/service-role/AmazonRDSEnhancedMonitoringRole",
+		monitoringPolicyARN("arn:aws-cn:iam::123456789012:role/k8s-rds-monitoring"))
+}
+
+func TestStorageChanges(t *testing.T) {
+	db := &crd.Database{Spec: crd.DatabaseSpec{Size: 100, MaxAllocatedSize: 200, StorageType: "gp2"}}
+	instance := &rdstypes.DBInstance{
+		DBInstanceIdentifier: aws.String("mydb-default"),
+		AllocatedStorage:     100,
+		MaxAllocatedStorage:  aws.Int32(200),
+		StorageType:          aws.String("gp2"),
+	}
+	autoscaling, storage, message := storageChanges(db, instance)
+	assert.Nil(t, autoscaling)
+	assert.Nil(t, storage)
+	assert.Empty(t, message)
+
+	db.Spec.Iops = 3000
+	_, storage, _ = storageChanges(db, instance)
+	assert.Nil(t, storage, "gp2 doesn't have provisioned IOPS")
+
+	db.Spec.Size = 150
+	db.Spec.MaxAllocatedSize = 500
+	db.Spec.StorageType = "gp3"
+	db.Spec.Iops = 12000
+	db.Spec.StorageThroughput = 500
+	autoscaling, storage, _ = storageChanges(db, instance)
+	assert.Equal(t, int32(500), *autoscaling.MaxAllocatedStorage)
+	assert.Nil(t, autoscaling.AllocatedStorage)
+	assert.Equal(t, int32(150), *storage.AllocatedStorage)
+	assert.Equal(t, "gp3", *storage.StorageType)
+	assert.Equal(t, int32(12000), *storage.Iops)
+	assert.Equal(t, int32(500), *storage.StorageThroughput)
+	assert.True(t, storage.ApplyImmediately)
+
+	db.Spec = crd.DatabaseSpec{Size: 50}
+	autoscaling, storage, message = storageChanges(db, instance)
+	assert.Nil(t, autoscaling)
+	assert.Nil(t, storage)
+	assert.NotEmpty(t, message, "the storage can't shrink")
+
+	// a pending resize isn't requested again
+	db.Spec = crd.DatabaseSpec{Size: 150, StorageType: "gp3", StorageThroughput: 500}
+	instance.PendingModifiedValues = &rdstypes.PendingModifiedValues{
+		AllocatedStorage:  aws.Int32(150),
+		StorageType:       aws.String("gp3"),
+		StorageThroughput: aws.Int32(500),
+	}
+	_, storage, message = storageChanges(db, instance)
+	assert.Nil(t, storage)
+	assert.Equal(t, "A storage modification is in progress", message)
+
+	db.Spec.Size = 200
+	_, storage, _ = storageChanges(db, instance)
+	assert.Equal(t, int32(200), *storage.AllocatedStorage)
+	assert.Nil(t, storage.StorageType)
+}
+
+func TestEnsureStoragePostponed(t *testing.T) {
+	now := time.Date(2023, 1, 10, 12, 0, 0, 0, time.UTC)
+	next := metav1.NewTime(now.Add(2 * time.Hour))
+	db := &crd.Database{
+		Spec:   crd.DatabaseSpec{Size: 150},
+		Status: crd.DatabaseStatus{NextStorageModification: &next},
+	}
+	instance := &rdstypes.DBInstance{DBInstanceIdentifier: aws.String("mydb-default"), AllocatedStorage: 100}
+	r := &RDS{}
+	assert.NoError(t, r.ensureStorage(context.Background(), db, instance, now))
+	assert.Contains(t, db.Status.StorageMessage, "2023-01-10T14:00:00Z")
+
+	db.Status.NextStorageModification = nil
+	instance.DBInstanceStatus = aws.String("storage-optimization")
+	assert.NoError(t, r.ensureStorage(context.Background(), db, instance, now))
+	assert.Equal(t, now.Add(storageRetryPeriod), db.Status.NextStorageModification.Time)
+}
+
+func TestIsStorageCooldown(t *testing.T) {
+	err := &smithy.GenericAPIError{
+		Code:    "InvalidParameterCombination",
+		Message: "You can't currently modify the storage of this DB instance because the previous storage change is being optimized.",
+	}
+	assert.True(t, isStorageCooldown(errors.Wrap(err, "ModifyDBInstance")))
+	assert.False(t, isStorageCooldown(&smithy.GenericAPIError{Code: "InvalidParameterCombination", Message: "wrong engine"}))
+	assert.False(t, isStorageCooldown(fmt.Errorf("storage")))
+}
+
+func TestDesiredTags(t *testing.T) {
+	db := &crd.Database{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "mydb",
+			Namespace: "default",
+			UID:       "8a2c5f1e-1b6e-4d2a-9c1f-0e7d3b2a1c4f",
+			Labels:    map[string]string{"team": "payments"},
+		},
+		Spec: crd.DatabaseSpec{Tags: crd.Tags{"env": "prod", "k8s-rds.io/namespace": "other"}},
+	}
+	assert.Equal(t, map[string]string{
+		"team":                 "payments",
+		"env":                  "prod",
+		"k8s-rds.io/cluster":   "prod-eu",
+		"k8s-rds.io/namespace": "default",
+		"k8s-rds.io/database":  "mydb",
+		"k8s-rds.io/uid":       "8a2c5f1e-1b6e-4d2a-9c1f-0e7d3b2a1c4f",
+	}, desiredTags(db, "prod-eu"))
+
+	_, ok := desiredTags(db, "")["k8s-rds.io/cluster"]
+	assert.False(t, ok)
+}
+
+func TestTagChanges(t *testing.T) {
+	desired := map[string]string{"env": "prod", "team": "payments"}
+	current := []rdstypes.Tag{
+		{Key: aws.String("env"), Value: aws.String("staging")},
+		{Key: aws.String("old"), Value: aws.String("value")},
+		{Key: aws.String("backup-plan"), Value: aws.String("daily")},
+		{Key: aws.String("aws:cloudformation:stack-name"), Value: aws.String("stack")},
+	}
+	add, remove := tagChanges(desired, current, []string{"env", "gone", "old"})
+	assert.Equal(t, []rdstypes.Tag{
+		{Key: aws.String("env"), Value: aws.String("prod")},
+		{Key: aws.String("team"), Value: aws.String("payments")},
+	}, add)
+	assert.Equal(t, []string{"old"}, remove, "only the tags applied by the operator are removed")
+
+	add, remove = tagChanges(desired, current, nil)
+	assert.Len(t, add, 2)
+	assert.Empty(t, remove)
+
+	add, remove = tagChanges(desired, []rdstypes.Tag{
+		{Key: aws.String("env"), Value: aws.String("prod")},
+		{Key: aws.String("team"), Value: aws.String("payments")},
+	}, []string{"env", "team"})
+	assert.Empty(t, add)
+	assert.Empty(t, remove)
+}
+
+func TestTagKeys(t *testing.T) {
+	assert.Equal(t, []string{"env", "k8s-rds.io/database"}, tagKeys([]rdstypes.Tag{
+		{Key: aws.String("k8s-rds.io/database"), Value: aws.String("mydb")},
+		{Key: aws.String("env"), Value: aws.String("prod")},
+	}))
+}
+
+func TestClusterNameFromTags(t *testing.T) {
+	assert.Equal(t, "prod-eu", clusterNameFromTags([]ec2types.Tag{
+		{Key: aws.String("kubernetes.io/cluster/other"), Value: aws.String("owned")},
+		{Key: aws.String("aws:eks:cluster-name"), Value: aws.String("prod-eu")},
+	}))
+	assert.Equal(t, "kops-cluster", clusterNameFromTags([]ec2types.Tag{
+		{Key: aws.String("Name"), Value: aws.String("node")},
+		{Key: aws.String("kubernetes.io/cluster/kops-cluster"), Value: aws.String("owned")},
+	}))
+	assert.Equal(t, "", clusterNameFromTags(nil))
+}
+
+func TestInstanceIdentifier(t *testing.T) {
+	db := &crd.Database{ObjectMeta: metav1.ObjectMeta{Name: "orders", Namespace: "shop"}}
+	assert.Equal(t, "orders-shop", instanceIdentifier(db))
+
+	db.Spec.AWS = &crd.AWSSpec{InstanceIdentifier: "legacy-orders"}
+	assert.Equal(t, "legacy-orders", instanceIdentifier(db))
+	assert.Equal(t, "legacy-orders", *convertSpecToInput(db, "subnet", nil, "pw").DBInstanceIdentifier)
+}
+
+func TestAdoptionPending(t *testing.T) {
+	db := &crd.Database{ObjectMeta: metav1.ObjectMeta{Name: "orders", Namespace: "shop"}}
+	assert.False(t, adoptionPending(db))
+
+	db.Spec.AWS = &crd.AWSSpec{Adopt: true}
+	assert.True(t, adoptionPending(db))
+
+	db.Annotations = map[string]string{AdoptAnnotation: "yes"}
+	assert.True(t, adoptionPending(db))
+
+	db.Annotations[AdoptAnnotation] = "confirmed"
+	assert.False(t, adoptionPending(db))
+}
+
+func TestDetectDrift(t *testing.T) {
+	db := &crd.Database{Spec: crd.DatabaseSpec{
+		Class:                 "db.t3.medium",
+		Engine:                "postgres",
+		Version:               "13",
+		Size:                  20,
+		Username:              "admin",
+		DBName:                "orders",
+		MultiAZ:               true,
+		BackupRetentionPeriod: 7,
+	}}
+	instance := &rdstypes.DBInstance{
+		DBInstanceClass:       aws.String("db.t3.medium"),
+		Engine:                aws.String("postgres"),
+		EngineVersion:         aws.String("13.7"),
+		AllocatedStorage:      40,
+		MasterUsername:        aws.String("admin"),
+		DBName:                aws.String("orders"),
+		MultiAZ:               true,
+		BackupRetentionPeriod: 7,
+		StorageType:           aws.String("gp2"),
+	}
+	assert.Empty(t, detectDrift(db, instance))
+
+	instance.DBInstanceClass = aws.String("db.m5.large")
+	instance.PubliclyAccessible = true
+	instance.BackupRetentionPeriod = 1
+	assert.Equal(t, []crd.DriftedField{
+		{Field: "class", Desired: "db.t3.medium", Actual: "db.m5.large"},
+		{Field: "publicaccess", Desired: "false", Actual: "true"},
+		{Field: "backupretentionperiod", Desired: "7", Actual: "1"},
+	}, detectDrift(db, instance))
+
+	// a pending modification back to the spec isn't drift
+	instance.PendingModifiedValues = &rdstypes.PendingModifiedValues{
+		DBInstanceClass:       aws.String("db.t3.medium"),
+		BackupRetentionPeriod: aws.Int32(7),
+	}
+	assert.Equal(t, []crd.DriftedField{
+		{Field: "publicaccess", Desired: "false", Actual: "true"},
+	}, detectDrift(db, instance))
+}
+
+func TestSpecChanges(t *testing.T) {
+	db := &crd.Database{Spec: crd.DatabaseSpec{Class: "db.t3.medium", Engine: "postgres", BackupRetentionPeriod: 7}}
+	instance := &rdstypes.DBInstance{
+		DBInstanceIdentifier:  aws.String("orders-shop"),
+		DBInstanceClass:       aws.String("db.t3.medium"),
+		BackupRetentionPeriod: 7,
+	}
+	// nothing is known to be applied yet
+	assert.Nil(t, specChanges(db, instance))
+
+	db.Status.Applied = appliedSettings(db)
+	assert.Nil(t, specChanges(db, instance))
+	// a change outside of the spec is drift
+	instance.PubliclyAccessible = true
+	assert.Nil(t, specChanges(db, instance))
+
+	db.Spec.Class = "db.m5.large"
+	db.Spec.MultiAZ = true
+	db.Spec.DeleteProtection = true
+	input := specChanges(db, instance)
+	assert.Equal(t, "orders-shop", *input.DBInstanceIdentifier)
+	assert.Equal(t, "db.m5.large", *input.DBInstanceClass)
+	assert.True(t, *input.MultiAZ)
+	assert.True(t, *input.DeletionProtection)
+	assert.Nil(t, input.PubliclyAccessible)
+	assert.Nil(t, input.BackupRetentionPeriod)
+	assert.True(t, input.ApplyImmediately)
+
+	// the pending modification isn't sent again
+	instance.PendingModifiedValues = &rdstypes.PendingModifiedValues{DBInstanceClass: aws.String("db.m5.large"), MultiAZ: aws.Bool(true)}
+	instance.DeletionProtection = true
+	assert.Nil(t, specChanges(db, instance))
+}
+
+func TestRevertChanges(t *testing.T) {
+	db := &crd.Database{Spec: crd.DatabaseSpec{Class: "db.t3.medium", Engine: "postgres", BackupRetentionPeriod: 7}}
+	instance := &rdstypes.DBInstance{DBInstanceIdentifier: aws.String("orders-shop")}
+
+	assert.Nil(t, revertChanges(db, instance, nil))
+	assert.Nil(t, revertChanges(db, instance, []crd.DriftedField{{Field: "engine"}, {Field: "size"}}))
+
+	input := revertChanges(db, instance, []crd.DriftedField{{Field: "class"}, {Field: "backupretentionperiod"}, {Field: "dbname"}})
+	assert.Equal(t, "db.t3.medium", *input.DBInstanceClass)
+	assert.Equal(t, int32(7), *input.BackupRetentionPeriod)
+	assert.Nil(t, input.PubliclyAccessible)
+	assert.True(t, input.ApplyImmediately)
+}
+
+func TestReportDrift(t *testing.T) {
+	cached := &crd.Database{}
+	reportDrift(cached, nil, false)
+	assert.Equal(t, metav1.ConditionFalse, cached.Status.Conditions[0].Status)
+
+	db := *cached
+	reportDrift(&db, []crd.DriftedField{{Field: "class"}, {Field: "multiaz"}}, true)
+	assert.Equal(t, metav1.ConditionTrue, db.Status.Conditions[0].Status)
+	assert.Equal(t, "Reverting", db.Status.Conditions[0].Reason)
+	assert.Equal(t, "The instance differs from the spec: class, multiaz", db.Status.Conditions[0].Message)
+	// the cached object is left as it is
+	assert.Equal(t, metav1.ConditionFalse, cached.Status.Conditions[0].Status)
+}
+
+func TestOrphanInstance(t *testing.T) {
+	keep := func(namespace, name string) bool { return namespace == "shop" && name == "orders" }
+	instance := func(tags map[string]string) rdstypes.DBInstance {
+		var list []rdstypes.Tag
+		for k, v := range tags {
+			list = append(list, rdstypes.Tag{Key: aws.String(k), Value: aws.String(v)})
+		}
+		return rdstypes.DBInstance{TagList: list}
+	}
+
+	assert.False(t, orphanInstance(instance(map[string]string{clusterTag: "prod", namespaceTag: "shop", databaseTag: "orders"}), "prod", keep))
+	assert.True(t, orphanInstance(instance(map[string]string{clusterTag: "prod", namespaceTag: "shop", databaseTag: "carts"}), "prod", keep))
+	// instances of other clusters and instances without owner are left alone
+	assert.False(t, orphanInstance(instance(map[string]string{clusterTag: "staging", namespaceTag: "shop", databaseTag: "carts"}), "prod", keep))
+	assert.False(t, orphanInstance(instance(map[string]string{"team": "shop"}), "prod", keep))
+	assert.True(t, orphanInstance(instance(map[string]string{namespaceTag: "shop", databaseTag: "carts"}), "", keep))
+}
+
+func TestOrphanSubnetGroup(t *testing.T) {
+	keep := func(namespace, name string) bool { return namespace == "shop" && name == "orders" }
+	managed := []rdstypes.Tag{{Key: aws.String("Warning"), Value: aws.String("Managed by k8s-rds.")}}
+	group := func(name, description string) rdstypes.DBSubnetGroup {
+		return rdstypes.DBSubnetGroup{DBSubnetGroupName: aws.String(name), DBSubnetGroupDescription: aws.String(description), VpcId: aws.String("vpc-1")}
+	}
+	used := map[string]bool{"db-subnetgroup-used": true}
+
+	assert.False(t, orphanSubnetGroup(group("orders-subnet-shop", "RDS Subnet Group for database orders in namespace shop"), managed, used, "vpc-1", keep))
+	assert.True(t, orphanSubnetGroup(group("carts-subnet-shop", "RDS Subnet Group for database carts in namespace shop"), managed, used, "vpc-1", keep))
+	assert.False(t, orphanSubnetGroup(group("carts-subnet-shop", "RDS Subnet Group for database carts in namespace shop"), nil, used, "vpc-1", keep))
+	assert.False(t, orphanSubnetGroup(group("carts-subnet-shop", "RDS Subnet Group for database carts in namespace shop"), managed, used, "vpc-2", keep))
+	assert.True(t, orphanSubnetGroup(group("db-subnetgroup-unused", "shared"), managed, used, "vpc-1", keep))
+	assert.False(t, orphanSubnetGroup(group("db-subnetgroup-used", "shared"), managed, used, "vpc-1", keep))
+}
+
+func TestOrphanParameterGroup(t *testing.T) {
+	keep := func(namespace, name string) bool { return namespace == "shop" && name == "orders" }
+	managed := []rdstypes.Tag{{Key: aws.String("Warning"), Value: aws.String("Managed by k8s-rds.")}}
+	group := func(name, description string) rdstypes.DBParameterGroup {
+		return rdstypes.DBParameterGroup{DBParameterGroupName: aws.String(name), Description: aws.String(description)}
+	}
+	used := map[string]bool{"carts-staging": true}
+
+	assert.False(t, orphanParameterGroup(group("orders-shop", "Parameters for database orders in namespace shop"), managed, used, keep))
+	assert.True(t, orphanParameterGroup(group("carts-shop", "Parameters for database carts in namespace shop"), managed, used, keep))
+	assert.False(t, orphanParameterGroup(group("carts-shop", "Parameters for database carts in namespace shop"), nil, used, keep))
+	assert.False(t, orphanParameterGroup(group("carts-staging", "Parameters for database carts in namespace staging"), managed, used, keep))
+	assert.False(t, orphanParameterGroup(group("default.postgres14", "Default parameter group for postgres14"), managed, used, keep))
+}
+
+func TestOrphanSecurityGroup(t *testing.T) {
+	keep := func(namespace, name string) bool { return namespace == "shop" && name == "orders" }
+	managed := []ec2types.Tag{{Key: aws.String("Warning"), Value: aws.String("Managed by k8s-rds.")}}
+	group := func(id, description string, tags []ec2types.Tag) ec2types.SecurityGroup {
+		return ec2types.SecurityGroup{GroupId: aws.String(id), Description: aws.String(description), VpcId: aws.String("vpc-1"), Tags: tags}
+	}
+	used := map[string]bool{"sg-used": true}
+
+	assert.False(t, orphanSecurityGroup(group("sg-1", "Access to database orders in namespace shop", managed), used, "vpc-1", keep))
+	assert.True(t, orphanSecurityGroup(group("sg-2", "Access to database carts in namespace shop", managed), used, "vpc-1", keep))
+	assert.False(t, orphanSecurityGroup(group("sg-2", "Access to database carts in namespace shop", nil), used, "vpc-1", keep))
+	assert.False(t, orphanSecurityGroup(group("sg-2", "Access to database carts in namespace shop", managed), used, "vpc-2", keep))
+	assert.False(t, orphanSecurityGroup(group("sg-used", "Access to database carts in namespace shop", managed), used, "vpc-1", keep))
+}
+
+func TestFinalSnapshotName(t *testing.T) {
+	now := time.Date(2021, 6, 7, 9, 0, 0, 0, time.FixedZone("CEST", 2*60*60))
+	assert.Equal(t, "orders-shop-final-20210607070000", finalSnapshotName("orders-shop", "final", now))
+}
+
+func TestNextScheduleAction(t *testing.T) {
+	now := time.Date(2021, 6, 7, 9, 0, 0, 0, time.UTC)
+	scheduled := &crd.Database{Spec: crd.DatabaseSpec{Schedule: &crd.ScheduleSpec{Start: "0 8 * * 1-5", Stop: "0 20 * * 1-5"}}}
+
+	action, stopped := nextScheduleAction(scheduled, "available", true, now)
+	assert.Equal(t, scheduleNone, action)
+	assert.False(t, stopped)
+
+	action, stopped = nextScheduleAction(scheduled, "available", false, now)
+	assert.Equal(t, scheduleStop, action)
+	assert.True(t, stopped)
+
+	action, _ = nextScheduleAction(scheduled, "stopping", true, now)
+	assert.Equal(t, scheduleNone, action)
+
+	since := metav1.NewTime(now.Add(-24 * time.Hour))
+	scheduled.Status.StoppedSince = &since
+	action, _ = nextScheduleAction(scheduled, "stopped", true, now)
+	assert.Equal(t, scheduleStart, action)
+	action, _ = nextScheduleAction(scheduled, "stopped", false, now)
+	assert.Equal(t, scheduleNone, action)
+
+	// AWS would start it after 7 days
+	since = metav1.NewTime(now.Add(-7*24*time.Hour + 5*time.Hour))
+	action, stopped = nextScheduleAction(scheduled, "stopped", false, now)
+	assert.Equal(t, scheduleRestart, action)
+	assert.True(t, stopped)
+
+	// an instance stopped by hand is left alone, one stopped by a removed schedule is started
+	unscheduled := &crd.Database{}
+	action, stopped = nextScheduleAction(unscheduled, "stopped", true, now)
+	assert.Equal(t, scheduleNone, action)
+	assert.True(t, stopped)
+	unscheduled.Status.StoppedSince = &since
+	action, _ = nextScheduleAction(unscheduled, "stopped", true, now)
+	assert.Equal(t, scheduleStart, action)
+}
+
+func TestProxyName(t *testing.T) {
+	db := &crd.Database{ObjectMeta: metav1.ObjectMeta{Name: "orders", Namespace: "shop"}}
+	assert.Equal(t, "k8s-rds-orders-shop", proxyName(db))
+
+	db.Name = strings.Repeat("a", 50)
+	db.Namespace = "bcd-" + strings.Repeat("e", 10)
+	assert.Len(t, proxyName(db), 62)
+	assert.False(t, strings.HasSuffix(proxyName(db), "-"))
+}
+
+func TestProxyEngineFamily(t *testing.T) {
+	for engine, family := range map[string]rdstypes.EngineFamily{
+		"postgres":          rdstypes.EngineFamilyPostgresql,
+		"mysql":             rdstypes.EngineFamilyMysql,
+		"mariadb":           rdstypes.EngineFamilyMysql,
+		"sqlserver-ex":      rdstypes.EngineFamilySqlserver,
+		"aurora-mysql":      rdstypes.EngineFamilyMysql,
+		"aurora-postgresql": rdstypes.EngineFamilyPostgresql,
+	} {
+		f, err := proxyEngineFamily(engine)
+		assert.NoError(t, err)
+		assert.Equal(t, family, f, engine)
+	}
+	_, err := proxyEngineFamily("oracle-ee")
+	assert.Error(t, err)
+}
+
+func TestProxyInput(t *testing.T) {
+	db := &crd.Database{
+		ObjectMeta: metav1.ObjectMeta{Name: "orders", Namespace: "shop"},
+		Spec:       crd.DatabaseSpec{AWS: &crd.AWSSpec{Proxy: &crd.ProxySpec{RequireTLS: true, IdleClientTimeout: 600}}},
+	}
+	instance := &rdstypes.DBInstance{
+		Engine:            aws.String("postgres"),
+		VpcSecurityGroups: []rdstypes.VpcSecurityGroupMembership{{VpcSecurityGroupId: aws.String("sg-1")}},
+	}
+	input, err := proxyInput(db, instance, "arn:secret", "arn:role", []string{"subnet-1", "subnet-2"}, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, "k8s-rds-orders-shop", *input.DBProxyName)
+	assert.Equal(t, rdstypes.EngineFamilyPostgresql, input.EngineFamily)
+	assert.Equal(t, "arn:secret", *input.Auth[0].SecretArn)
+	assert.Equal(t, "arn:role", *input.RoleArn)
+	assert.Equal(t, []string{"sg-1"}, input.VpcSecurityGroupIds)
+	assert.Equal(t, []string{"subnet-1", "subnet-2"}, input.VpcSubnetIds)
+	assert.True(t, input.RequireTLS)
+	assert.Equal(t, int32(600), *input.IdleClientTimeout)
+}
+
+func TestProxyChanges(t *testing.T) {
+	db := &crd.Database{Spec: crd.DatabaseSpec{AWS: &crd.AWSSpec{Proxy: &crd.ProxySpec{IdleClientTimeout: 600}}}}
+	p := &rdstypes.DBProxy{
+		DBProxyName:       aws.String("k8s-rds-orders-shop"),
+		IdleClientTimeout: 600,
+		RoleArn:           aws.String("arn:role"),
+		Auth:              []rdstypes.UserAuthConfigInfo{{SecretArn: aws.String("arn:secret")}},
+	}
+	assert.Nil(t, proxyChanges(db, p, "arn:secret", "arn:role"))
+
+	db.Spec.AWS.Proxy.RequireTLS = true
+	input := proxyChanges(db, p, "arn:managed-secret", "arn:role")
+	assert.True(t, *input.RequireTLS)
+	assert.Equal(t, "arn:managed-secret", *input.Auth[0].SecretArn)
+	assert.Nil(t, input.IdleClientTimeout)
+	assert.Nil(t, input.RoleArn)
+}
+
+func TestTargetGroupChanges(t *testing.T) {
+	db := &crd.Database{Spec: crd.DatabaseSpec{AWS: &crd.AWSSpec{Proxy: &crd.ProxySpec{}}}}
+	group := &rdstypes.DBProxyTargetGroup{
+		DBProxyName:          aws.String("k8s-rds-orders-shop"),
+		TargetGroupName:      aws.String("default"),
+		ConnectionPoolConfig: &rdstypes.ConnectionPoolConfigurationInfo{MaxConnectionsPercent: 100},
+	}
+	assert.Nil(t, targetGroupChanges(db, group))
+
+	db.Spec.AWS.Proxy.MaxConnectionsPercent = 100
+	assert.Nil(t, targetGroupChanges(db, group))
+
+	db.Spec.AWS.Proxy.MaxConnectionsPercent = 50
+	input := targetGroupChanges(db, group)
+	assert.Equal(t, int32(50), *input.ConnectionPoolConfig.MaxConnectionsPercent)
+	assert.Equal(t, "default", *input.TargetGroupName)
+}
+
+func TestInstanceStatus(t *testing.T) {
+	s := instanceStatus(&rdstypes.DBInstance{DBInstanceStatus: aws.String("creating"), EngineVersion: aws.String("14.4")})
+	assert.Equal(t, &provider.ProviderStatus{Exists: true, State: "creating", EngineVersion: "14.4"}, s)
+
+	s = instanceStatus(&rdstypes.DBInstance{
+		DBInstanceStatus: aws.String("backing-up"),
+		Endpoint:         &rdstypes.Endpoint{Address: aws.String("orders.abc.eu-west-1.rds.amazonaws.com"), Port: 5432},
+	})
+	assert.True(t, s.Ready)
+	assert.Equal(t, "orders.abc.eu-west-1.rds.amazonaws.com", s.Hostname)
+	assert.Equal(t, int32(5432), s.Port)
+
+	s = instanceStatus(&rdstypes.DBInstance{
+		DBInstanceStatus: aws.String("stopped"),
+		Endpoint:         &rdstypes.Endpoint{Address: aws.String("orders.abc.eu-west-1.rds.amazonaws.com"), Port: 5432},
+	})
+	assert.False(t, s.Ready, "a stopped instance keeps its endpoint")
+	assert.Equal(t, "orders.abc.eu-west-1.rds.amazonaws.com", s.Hostname)
+}
+
+func TestProxyStatus(t *testing.T) {
+	instance := &rdstypes.DBInstance{
+		DBInstanceStatus: aws.String("available"),
+		Endpoint:         &rdstypes.Endpoint{Address: aws.String("orders.abc.eu-west-1.rds.amazonaws.com"), Port: 5432},
+	}
+	db := &crd.Database{Spec: crd.DatabaseSpec{AWS: &crd.AWSSpec{Proxy: &crd.ProxySpec{}}}}
+	db.Status.ProxyStatus = string(rdstypes.DBProxyStatusAvailable)
+	db.Status.ProxyEndpoint = "k8s-rds-orders.proxy-abc.eu-west-1.rds.amazonaws.com"
+	s := proxyStatus(db, instanceStatus(instance))
+	assert.Equal(t, "orders.abc.eu-west-1.rds.amazonaws.com", s.Hostname, "the proxy has its own service")
+
+	db.Spec.AWS.Proxy.ReplaceService = true
+	s = proxyStatus(db, instanceStatus(instance))
+	assert.Equal(t, "k8s-rds-orders.proxy-abc.eu-west-1.rds.amazonaws.com", s.Hostname)
+	assert.True(t, s.Ready)
+
+	db.Status.ProxyStatus = string(rdstypes.DBProxyStatusModifying)
+	s = proxyStatus(db, instanceStatus(instance))
+	assert.Equal(t, "orders.abc.eu-west-1.rds.amazonaws.com", s.Hostname, "the proxy isn't available")
+
+	db.Spec.AWS.Proxy = nil
+	db.Status.ProxyStatus = string(rdstypes.DBProxyStatusAvailable)
+	s = proxyStatus(db, instanceStatus(instance))
+	assert.Equal(t, "orders.abc.eu-west-1.rds.amazonaws.com", s.Hostname, "the proxy is removed")
+}
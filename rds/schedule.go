@@ -0,0 +1,97 @@
+package rds
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/rds"
+	rdstypes "github.com/aws/aws-sdk-go-v2/service/rds/types"
+	"github.com/pkg/errors"
+	"github.com/sorenmat/k8s-rds/crd"
+	"github.com/sorenmat/k8s-rds/schedule"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// maxStopped is how long an instance stays stopped, AWS starts it again after 7 days so it's restarted before that
+const maxStopped = 7*24*time.Hour - 6*time.Hour
+
+// scheduleAction is what the schedule needs done with the instance
+type scheduleAction int
+
+const (
+	scheduleNone scheduleAction = iota
+	scheduleStart
+	scheduleStop
+	scheduleRestart
+)
+
+// nextScheduleAction returns what to do with an instance in the status according to the schedule. It also tells
+// if the instance isn't running, in which case it can't be modified.
+func nextScheduleAction(db *crd.Database, status string, active bool, now time.Time) (scheduleAction, bool) {
+	switch status {
+	case "stopping", "starting":
+		return scheduleNone, true
+	case "stopped":
+		if db.Spec.Schedule == nil && db.Status.StoppedSince == nil {
+			// stopped by hand, it's left as it is
+			return scheduleNone, true
+		}
+		if active {
+			return scheduleStart, true
+		}
+		if db.Status.StoppedSince != nil && now.Sub(db.Status.StoppedSince.Time) >= maxStopped {
+			return scheduleRestart, true
+		}
+		return scheduleNone, true
+	case "available":
+		if !active {
+			return scheduleStop, true
+		}
+	}
+	return scheduleNone, false
+}
+
+// ensureSchedule stops and starts the instance according to the schedule, and tells if it isn't running
+func (r *RDS) ensureSchedule(ctx context.Context, db *crd.Database, instance *rdstypes.DBInstance, now time.Time) (bool, error) {
+	active, err := schedule.Active(db.Spec.Schedule, now)
+	if err != nil {
+		return true, err
+	}
+	status := aws.ToString(instance.DBInstanceStatus)
+	if status == "stopped" && db.Spec.Schedule != nil && db.Status.StoppedSince == nil {
+		// stopped by hand, the 7 days are counted from now
+		t := metav1.NewTime(now)
+		db.Status.StoppedSince = &t
+	}
+	action, stopped := nextScheduleAction(db, status, active, now)
+
+	svc := r.rdsclient()
+	switch action {
+	case scheduleStart, scheduleRestart:
+		log.Printf("Starting db instance %v\n", *instance.DBInstanceIdentifier)
+		if _, err := svc.StartDBInstance(ctx, &rds.StartDBInstanceInput{DBInstanceIdentifier: instance.DBInstanceIdentifier}); err != nil {
+			return true, errors.Wrap(err, "StartDBInstance")
+		}
+		db.Status.ScheduleMessage = "Started on schedule"
+		if action == scheduleRestart {
+			// it's stopped again as soon as it's available, which resets the 7 days
+			db.Status.ScheduleMessage = "Restarted before AWS starts it after 7 days"
+		}
+	case scheduleStop:
+		log.Printf("Stopping db instance %v\n", *instance.DBInstanceIdentifier)
+		if _, err := svc.StopDBInstance(ctx, &rds.StopDBInstanceInput{DBInstanceIdentifier: instance.DBInstanceIdentifier}); err != nil {
+			return true, errors.Wrap(err, "StopDBInstance")
+		}
+		t := metav1.NewTime(now)
+		db.Status.StoppedSince = &t
+		db.Status.ScheduleMessage = "Stopped on schedule"
+	}
+
+	db.Status.Stopped = status == "stopped" || status == "stopping" || action == scheduleStop
+	if status == "available" && action == scheduleNone {
+		db.Status.StoppedSince = nil
+	}
+	return stopped, nil
+}
@@ -8,12 +8,13 @@ import (
 	"github.com/pkg/errors"
 	"github.com/sorenmat/k8s-rds/kube"
 	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/util/intstr"
 )
 
 // create an External named service object for Kubernetes
-func (r *RDS) createServiceObj(s *v1.Service, namespace string, hostname string, internalname string) *v1.Service {
+func (r *RDS) createServiceObj(s *v1.Service, namespace string, hostname string, internalname string, owner string) *v1.Service {
 	var ports []v1.ServicePort
 
 	ports = append(ports, v1.ServicePort{
@@ -26,13 +27,18 @@ func (r *RDS) createServiceObj(s *v1.Service, namespace string, hostname string,
 
 	s.Spec.Ports = ports
 	s.Name = internalname
-	s.Annotations = map[string]string{"origin": "rds"}
+	s.Annotations = map[string]string{"origin": "rds", kube.DatabaseAnnotation: owner}
 	s.Namespace = namespace
 	return s
 }
 
 // CreateService Creates or updates a service in Kubernetes with the new information
 func (r *RDS) CreateService(ctx context.Context, namespace string, hostname string, internalname string) error {
+	return r.createService(ctx, namespace, hostname, internalname, internalname)
+}
+
+// createService creates or updates a service of the Database named owner
+func (r *RDS) createService(ctx context.Context, namespace string, hostname string, internalname string, owner string) error {
 
 	// create a service in kubernetes that points to the AWS RDS instance
 	kubectl, err := kube.Client()
@@ -41,14 +47,14 @@ func (r *RDS) CreateService(ctx context.Context, namespace string, hostname stri
 	}
 	serviceInterface := kubectl.CoreV1().Services(namespace)
 
-	s, sErr := serviceInterface.Get(ctx, hostname, metav1.GetOptions{})
+	s, sErr := serviceInterface.Get(ctx, internalname, metav1.GetOptions{})
 
 	create := false
 	if sErr != nil {
 		s = &v1.Service{}
 		create = true
 	}
-	s = r.createServiceObj(s, namespace, hostname, internalname)
+	s = r.createServiceObj(s, namespace, hostname, internalname, owner)
 	if create {
 		_, err = serviceInterface.Create(ctx, s, metav1.CreateOptions{})
 	} else {
@@ -84,3 +90,40 @@ func (r *RDS) GetSecret(ctx context.Context, namespace string, name string, key
 	password := secret.Data[key]
 	return string(password), nil
 }
+
+// PutSecret stores the value under the key in the secret, the secret is created if it doesn't exist
+func (r *RDS) PutSecret(ctx context.Context, namespace string, name string, key string, value string) error {
+	kubectl, err := kube.Client()
+	if err != nil {
+		return err
+	}
+	secrets := kubectl.CoreV1().Secrets(namespace)
+	secret, err := secrets.Get(ctx, name, metav1.GetOptions{})
+	if err != nil && !apierrors.IsNotFound(err) {
+		return errors.Wrap(err, fmt.Sprintf("unable to fetch secret %v", name))
+	}
+	if apierrors.IsNotFound(err) {
+		secret = &v1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: namespace, Annotations: map[string]string{"origin": "rds"}},
+			Data:       map[string][]byte{key: []byte(value)},
+		}
+		_, err = secrets.Create(ctx, secret, metav1.CreateOptions{})
+		if err != nil {
+			return errors.Wrap(err, fmt.Sprintf("unable to create secret %v", name))
+		}
+		return nil
+	}
+	if string(secret.Data[key]) == value {
+		return nil
+	}
+	if secret.Data == nil {
+		secret.Data = map[string][]byte{}
+	}
+	secret.Data[key] = []byte(value)
+	_, err = secrets.Update(ctx, secret, metav1.UpdateOptions{})
+	if err != nil {
+		return errors.Wrap(err, fmt.Sprintf("unable to update secret %v", name))
+	}
+	log.Printf("Updated the password in secret %v in namespace %v\n", name, namespace)
+	return nil
+}
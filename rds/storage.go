@@ -0,0 +1,156 @@
+package rds
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/rds"
+	rdstypes "github.com/aws/aws-sdk-go-v2/service/rds/types"
+	"github.com/aws/smithy-go"
+	"github.com/pkg/errors"
+	"github.com/sorenmat/k8s-rds/crd"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	// storageCooldown is the time AWS requires between two modifications of the storage
+	storageCooldown = 6 * time.Hour
+	// storageRetryPeriod is used when AWS refuses a storage change and the end of the cooldown is unknown
+	storageRetryPeriod  = time.Hour
+	storageOptimization = "storage-optimization"
+)
+
+// provisionedIops tells if the IOPS can be set for the storage type
+func provisionedIops(storageType string) bool {
+	return storageType == "io1" || storageType == "io2" || storageType == "gp3"
+}
+
+// currentStorage returns the size, type, IOPS and throughput of the instance, a pending modification takes
+// precedence over the current value since it's applied already
+func currentStorage(instance *rdstypes.DBInstance) (int32, string, int32, int32, bool) {
+	size, storageType := instance.AllocatedStorage, aws.ToString(instance.StorageType)
+	iops, throughput := aws.ToInt32(instance.Iops), aws.ToInt32(instance.StorageThroughput)
+	pending := instance.PendingModifiedValues
+	if pending == nil {
+		return size, storageType, iops, throughput, false
+	}
+	modifying := false
+	if pending.AllocatedStorage != nil {
+		size, modifying = *pending.AllocatedStorage, true
+	}
+	if pending.StorageType != nil {
+		storageType, modifying = *pending.StorageType, true
+	}
+	if pending.Iops != nil {
+		iops, modifying = *pending.Iops, true
+	}
+	if pending.StorageThroughput != nil {
+		throughput, modifying = *pending.StorageThroughput, true
+	}
+	return size, storageType, iops, throughput, modifying
+}
+
+// storageChanges compares the storage of the spec with the instance, including its pending modifications. It
+// returns the change of the maximum for storage autoscaling, and the changes of the size, type, IOPS and
+// throughput, which are subject to the cooldown. The storage can only grow, a smaller size is reported in the message.
+func storageChanges(db *crd.Database, instance *rdstypes.DBInstance) (*rds.ModifyDBInstanceInput, *rds.ModifyDBInstanceInput, string) {
+	var autoscaling, storage *rds.ModifyDBInstanceInput
+	newInput := func() *rds.ModifyDBInstanceInput {
+		return &rds.ModifyDBInstanceInput{DBInstanceIdentifier: instance.DBInstanceIdentifier, ApplyImmediately: true}
+	}
+	message := ""
+
+	if max := int32(db.Spec.MaxAllocatedSize); max > 0 && max != aws.ToInt32(instance.MaxAllocatedStorage) {
+		autoscaling = newInput()
+		autoscaling.MaxAllocatedStorage = aws.Int32(max)
+	}
+
+	currentSize, storageType, currentIops, currentThroughput, modifying := currentStorage(instance)
+	if modifying {
+		message = "A storage modification is in progress"
+	}
+	storage = newInput()
+	changed := false
+	if size := int32(db.Spec.Size); size > currentSize {
+		storage.AllocatedStorage = aws.Int32(size)
+		changed = true
+	} else if size > 0 && size < currentSize {
+		message = fmt.Sprintf("The storage can't shrink from %v to %v GB", currentSize, size)
+	}
+	if db.Spec.StorageType != "" && db.Spec.StorageType != storageType {
+		storageType = db.Spec.StorageType
+		storage.StorageType = aws.String(storageType)
+		changed = true
+	}
+	if iops := int32(db.Spec.Iops); iops > 0 && provisionedIops(storageType) && (iops != currentIops || changed) {
+		storage.Iops = aws.Int32(iops)
+		changed = true
+	}
+	if throughput := int32(db.Spec.StorageThroughput); throughput > 0 && storageType == "gp3" &&
+		(throughput != currentThroughput || storage.StorageType != nil) {
+		storage.StorageThroughput = aws.Int32(throughput)
+		changed = true
+	}
+	if !changed {
+		storage = nil
+	}
+	return autoscaling, storage, message
+}
+
+// isStorageCooldown tells if AWS refused the change because the storage was modified recently
+func isStorageCooldown(err error) bool {
+	var apiErr smithy.APIError
+	if !errors.As(err, &apiErr) {
+		return false
+	}
+	return apiErr.ErrorCode() == "InvalidParameterCombination" && strings.Contains(strings.ToLower(apiErr.ErrorMessage()), "storage")
+}
+
+// ensureStorage applies the storage of the spec to the instance. Changes that fall in the cooldown after the
+// previous storage modification are postponed, the time they will be applied is reported in the status.
+func (r *RDS) ensureStorage(ctx context.Context, db *crd.Database, instance *rdstypes.DBInstance, now time.Time) error {
+	autoscaling, storage, message := storageChanges(db, instance)
+	db.Status.StorageMessage = message
+	if autoscaling != nil {
+		log.Printf("Changing the maximum storage of %v to %v GB\n", *instance.DBInstanceIdentifier, *autoscaling.MaxAllocatedStorage)
+		if _, err := r.rdsclient().ModifyDBInstance(ctx, autoscaling); err != nil {
+			return errors.Wrap(err, "ModifyDBInstance")
+		}
+	}
+	if storage == nil {
+		return nil
+	}
+
+	next := db.Status.NextStorageModification
+	if aws.ToString(instance.DBInstanceStatus) == storageOptimization && (next == nil || !now.Before(next.Time)) {
+		t := metav1.NewTime(now.Add(storageRetryPeriod))
+		next = &t
+		db.Status.NextStorageModification = next
+	}
+	if next != nil && now.Before(next.Time) {
+		db.Status.StorageMessage = fmt.Sprintf("Storage changes are postponed until %v, AWS allows one storage modification every %v",
+			next.UTC().Format(time.RFC3339), storageCooldown)
+		return nil
+	}
+
+	log.Printf("Modifying the storage of %v\n", *instance.DBInstanceIdentifier)
+	_, err := r.rdsclient().ModifyDBInstance(ctx, storage)
+	if isStorageCooldown(err) {
+		t := metav1.NewTime(now.Add(storageRetryPeriod))
+		db.Status.NextStorageModification = &t
+		db.Status.StorageMessage = fmt.Sprintf("Storage changes are postponed until %v: %v", t.UTC().Format(time.RFC3339), err)
+		return nil
+	}
+	if err != nil {
+		return errors.Wrap(err, "ModifyDBInstance")
+	}
+	modified := metav1.NewTime(now)
+	t := metav1.NewTime(now.Add(storageCooldown))
+	db.Status.StorageModified = &modified
+	db.Status.NextStorageModification = &t
+	return nil
+}
@@ -0,0 +1,123 @@
+package rds
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/rds"
+	rdstypes "github.com/aws/aws-sdk-go-v2/service/rds/types"
+	"github.com/pkg/errors"
+	"github.com/sorenmat/k8s-rds/crd"
+)
+
+const (
+	// sharedSubnetGroupPrefix is used by the subnet groups that were shared by all databases in a VPC
+	sharedSubnetGroupPrefix = "db-subnetgroup-"
+	maxDeleteWaitIterations = 120
+	deleteWaitPeriod        = 15 * time.Second
+)
+
+// subnetGroupName returns the name of the DB subnet group created for the database
+func subnetGroupName(db *crd.Database) string {
+	return db.Name + "-subnet-" + db.Namespace
+}
+
+func isSharedSubnetGroup(name string) bool {
+	return strings.HasPrefix(name, sharedSubnetGroupPrefix)
+}
+
+func groupSubnets(group rdstypes.DBSubnetGroup) []string {
+	var result []string
+	for _, sn := range group.Subnets {
+		if sn.SubnetIdentifier != nil {
+			result = append(result, *sn.SubnetIdentifier)
+		}
+	}
+	return result
+}
+
+// sameSubnets tells if the two lists contain the same subnets regardless of the order
+func sameSubnets(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	x := append([]string(nil), a...)
+	y := append([]string(nil), b...)
+	sort.Strings(x)
+	sort.Strings(y)
+	for i := range x {
+		if x[i] != y[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// subnetGroupUsers counts the instances that are attached to the subnet group
+func subnetGroupUsers(ctx context.Context, svc *rds.Client, name string) (int, error) {
+	users := 0
+	input := &rds.DescribeDBInstancesInput{}
+	for {
+		res, err := svc.DescribeDBInstances(ctx, input)
+		if err != nil {
+			return 0, errors.Wrap(err, "DescribeDBInstances")
+		}
+		for _, instance := range res.DBInstances {
+			if instance.DBSubnetGroup != nil && instance.DBSubnetGroup.DBSubnetGroupName != nil &&
+				*instance.DBSubnetGroup.DBSubnetGroupName == name {
+				users++
+			}
+		}
+		if res.Marker == nil || *res.Marker == "" {
+			return users, nil
+		}
+		input.Marker = res.Marker
+	}
+}
+
+// deleteSubnetGroup deletes the subnet group, a shared group is only deleted when no instances are using it anymore
+func (r *RDS) deleteSubnetGroup(ctx context.Context, name string) error {
+	svc := r.rdsclient()
+	if isSharedSubnetGroup(name) {
+		users, err := subnetGroupUsers(ctx, svc, name)
+		if err != nil {
+			return err
+		}
+		if users > 0 {
+			log.Printf("Keeping DBSubnet group %v, it's still used by %v instances\n", name, users)
+			return nil
+		}
+	}
+
+	_, err := svc.DeleteDBSubnetGroup(ctx, &rds.DeleteDBSubnetGroupInput{DBSubnetGroupName: aws.String(name)})
+	if err != nil {
+		var notFound *rdstypes.DBSubnetGroupNotFoundFault
+		if errors.As(err, &notFound) {
+			return nil
+		}
+		return errors.Wrap(err, fmt.Sprintf("unable to delete subnet %v", name))
+	}
+	log.Println("Deleted DBSubnet group: ", name)
+	return nil
+}
+
+// waitForDeletion polls the instance until AWS reports that it no longer exists
+func waitForDeletion(ctx context.Context, id *string, svc *rds.Client) error {
+	for i := 0; i < maxDeleteWaitIterations; i++ {
+		_, err := svc.DescribeDBInstances(ctx, &rds.DescribeDBInstancesInput{DBInstanceIdentifier: id})
+		if err != nil {
+			var notFound *rdstypes.DBInstanceNotFoundFault
+			if errors.As(err, &notFound) {
+				return nil
+			}
+			log.Printf("unable to describe db instance %v while waiting for deletion: %v\n", *id, err)
+		}
+		time.Sleep(deleteWaitPeriod)
+	}
+	return fmt.Errorf("db instance %v wasn't deleted within %v", *id, maxDeleteWaitIterations*deleteWaitPeriod)
+}
@@ -0,0 +1,137 @@
+package rds
+
+import (
+	"context"
+	"log"
+	"sort"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	ec2types "github.com/aws/aws-sdk-go-v2/service/ec2/types"
+	"github.com/aws/aws-sdk-go-v2/service/rds"
+	rdstypes "github.com/aws/aws-sdk-go-v2/service/rds/types"
+	"github.com/pkg/errors"
+	"github.com/sorenmat/k8s-rds/crd"
+)
+
+// tags set by the operator on every instance, so the owner can be found from AWS
+const (
+	clusterTag   = "k8s-rds.io/cluster"
+	namespaceTag = "k8s-rds.io/namespace"
+	databaseTag  = "k8s-rds.io/database"
+	uidTag       = "k8s-rds.io/uid"
+
+	eksClusterTag         = "aws:eks:cluster-name"
+	clusterOwnedTagPrefix = "kubernetes.io/cluster/"
+)
+
+// clusterNameFromTags finds the name of the cluster in the tags of a node
+func clusterNameFromTags(tags []ec2types.Tag) string {
+	for _, t := range tags {
+		if aws.ToString(t.Key) == eksClusterTag {
+			return aws.ToString(t.Value)
+		}
+	}
+	for _, t := range tags {
+		if k := aws.ToString(t.Key); strings.HasPrefix(k, clusterOwnedTagPrefix) {
+			return strings.TrimPrefix(k, clusterOwnedTagPrefix)
+		}
+	}
+	return ""
+}
+
+// ownerTags returns the tags identifying the Database object that owns the instance
+func ownerTags(db *crd.Database, clusterName string) []rdstypes.Tag {
+	tags := []rdstypes.Tag{
+		{Key: aws.String(namespaceTag), Value: aws.String(db.Namespace)},
+		{Key: aws.String(databaseTag), Value: aws.String(db.Name)},
+		{Key: aws.String(uidTag), Value: aws.String(string(db.UID))},
+	}
+	if clusterName != "" {
+		tags = append([]rdstypes.Tag{{Key: aws.String(clusterTag), Value: aws.String(clusterName)}}, tags...)
+	}
+	return tags
+}
+
+// desiredTags returns the tags the instance should have, the owner tags can't be overridden
+func desiredTags(db *crd.Database, clusterName string) map[string]string {
+	result := map[string]string{}
+	tags := toTags(db.Annotations, db.Labels)
+	tags = append(tags, gettags(db)...)
+	tags = append(tags, ownerTags(db, clusterName)...)
+	for _, t := range tags {
+		result[aws.ToString(t.Key)] = aws.ToString(t.Value)
+	}
+	return result
+}
+
+// tagChanges returns the tags to add or update and the keys of the tags to remove. Only the tags the operator applied
+// before are removed, the ones added by other tools or that came with an adopted instance are kept.
+func tagChanges(desired map[string]string, current []rdstypes.Tag, applied []string) ([]rdstypes.Tag, []string) {
+	existing := map[string]string{}
+	for _, t := range current {
+		existing[aws.ToString(t.Key)] = aws.ToString(t.Value)
+	}
+
+	var add []rdstypes.Tag
+	for k, v := range desired {
+		if old, ok := existing[k]; !ok || old != v {
+			add = append(add, rdstypes.Tag{Key: aws.String(k), Value: aws.String(v)})
+		}
+	}
+	sort.Slice(add, func(i, j int) bool { return *add[i].Key < *add[j].Key })
+
+	var remove []string
+	for _, k := range applied {
+		if _, ok := desired[k]; ok {
+			continue
+		}
+		if _, ok := existing[k]; ok {
+			remove = append(remove, k)
+		}
+	}
+	sort.Strings(remove)
+	return add, remove
+}
+
+// tagKeys returns the sorted keys of the tags, they're recorded in the status as the tags applied by the operator
+func tagKeys(tags []rdstypes.Tag) []string {
+	var keys []string
+	for _, t := range tags {
+		keys = append(keys, aws.ToString(t.Key))
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// ensureTags makes the tags of the instance match the annotations, labels and tags of the spec, the keys of the tags
+// are recorded in the status so the ones that are no longer wanted can be removed
+func (r *RDS) ensureTags(ctx context.Context, db *crd.Database, instance *rdstypes.DBInstance) error {
+	svc := r.rdsclient()
+	res, err := svc.ListTagsForResource(ctx, &rds.ListTagsForResourceInput{ResourceName: instance.DBInstanceArn})
+	if err != nil {
+		return errors.Wrap(err, "ListTagsForResource")
+	}
+	desired := desiredTags(db, r.ClusterName)
+	add, remove := tagChanges(desired, res.TagList, db.Status.AppliedTags)
+	if len(add) > 0 {
+		log.Printf("Adding %v tags to %v\n", len(add), *instance.DBInstanceIdentifier)
+		_, err := svc.AddTagsToResource(ctx, &rds.AddTagsToResourceInput{ResourceName: instance.DBInstanceArn, Tags: add})
+		if err != nil {
+			return errors.Wrap(err, "AddTagsToResource")
+		}
+	}
+	if len(remove) > 0 {
+		log.Printf("Removing tags %v from %v\n", strings.Join(remove, ", "), *instance.DBInstanceIdentifier)
+		_, err := svc.RemoveTagsFromResource(ctx, &rds.RemoveTagsFromResourceInput{ResourceName: instance.DBInstanceArn, TagKeys: remove})
+		if err != nil {
+			return errors.Wrap(err, "RemoveTagsFromResource")
+		}
+	}
+	var applied []rdstypes.Tag
+	for k := range desired {
+		applied = append(applied, rdstypes.Tag{Key: aws.String(k)})
+	}
+	db.Status.AppliedTags = tagKeys(applied)
+	return nil
+}
@@ -0,0 +1,198 @@
+package rds
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/rds"
+	rdstypes "github.com/aws/aws-sdk-go-v2/service/rds/types"
+	"github.com/pkg/errors"
+	"github.com/sorenmat/k8s-rds/crd"
+)
+
+// states of an engine version upgrade reported in status.upgradestate
+const (
+	upgradeSnapshotting = "Snapshotting"
+	upgradeUpgrading    = "Upgrading"
+	upgradeDone         = "Upgraded"
+	upgradeFailed       = "Failed"
+)
+
+// versionMatches tells if the running version satisfies the version in the spec, ex: 13 is satisfied by 13.7
+func versionMatches(wanted, actual string) bool {
+	return actual == wanted || strings.HasPrefix(actual, wanted+".")
+}
+
+// compareVersions compares dotted versions numerically, ex: 13.10 is after 13.9
+func compareVersions(a, b string) int {
+	x := strings.Split(a, ".")
+	y := strings.Split(b, ".")
+	for i := 0; i < len(x) && i < len(y); i++ {
+		m, errM := strconv.Atoi(x[i])
+		n, errN := strconv.Atoi(y[i])
+		if errM != nil || errN != nil {
+			if c := strings.Compare(x[i], y[i]); c != 0 {
+				return c
+			}
+			continue
+		}
+		if m != n {
+			if m < n {
+				return -1
+			}
+			return 1
+		}
+	}
+	return len(x) - len(y)
+}
+
+// upgradeTarget picks the newest valid upgrade target matching the version in the spec
+func upgradeTarget(wanted string, targets []rdstypes.UpgradeTarget) (*rdstypes.UpgradeTarget, error) {
+	var result *rdstypes.UpgradeTarget
+	var valid []string
+	for i := range targets {
+		v := aws.ToString(targets[i].EngineVersion)
+		valid = append(valid, v)
+		if !versionMatches(wanted, v) {
+			continue
+		}
+		if result == nil || compareVersions(v, aws.ToString(result.EngineVersion)) > 0 {
+			result = &targets[i]
+		}
+	}
+	if result == nil {
+		return nil, fmt.Errorf("%v is not a valid upgrade target, valid targets are: %v", wanted, strings.Join(valid, ", "))
+	}
+	return result, nil
+}
+
+// upgradeSnapshotName returns the name of the snapshot taken before upgrading to the version
+func upgradeSnapshotName(db *crd.Database, version string) string {
+	return fmt.Sprintf("%v-pre-upgrade-%v", instanceIdentifier(db), strings.ReplaceAll(version, ".", "-"))
+}
+
+func failUpgrade(db *crd.Database, message string) (bool, error) {
+	log.Printf("Upgrade of %v failed: %v\n", db.Name, message)
+	db.Status.UpgradeState = upgradeFailed
+	db.Status.UpgradeMessage = message
+	return true, nil
+}
+
+// ensureVersion upgrades the instance to the version in the spec. A major upgrade is done in steps over several
+// updates: a snapshot is taken first, and once it's available the instance is modified. It returns true while
+// an upgrade is in progress or has failed, the rest of the spec is applied once it's done.
+func (r *RDS) ensureVersion(ctx context.Context, db *crd.Database, instance *rdstypes.DBInstance) (bool, error) {
+	current := aws.ToString(instance.EngineVersion)
+	db.Status.EngineVersion = current
+	wanted := db.Spec.Version
+	if wanted == "" || versionMatches(wanted, current) {
+		switch db.Status.UpgradeState {
+		case upgradeUpgrading:
+			db.Status.UpgradeState = upgradeDone
+			db.Status.UpgradeMessage = fmt.Sprintf("Upgraded to %v", current)
+		case upgradeSnapshotting, upgradeFailed:
+			db.Status.UpgradeState = ""
+			db.Status.UpgradeMessage = fmt.Sprintf("Upgrade to %v cancelled", db.Status.UpgradeTarget)
+		}
+		return false, nil
+	}
+
+	if db.Status.UpgradeTarget == wanted {
+		switch db.Status.UpgradeState {
+		case upgradeFailed:
+			// retrying wouldn't help, wait for the spec to change
+			return true, nil
+		case upgradeUpgrading:
+			pending := instance.PendingModifiedValues != nil && instance.PendingModifiedValues.EngineVersion != nil
+			if aws.ToString(instance.DBInstanceStatus) != "available" || pending {
+				return true, nil
+			}
+			return failUpgrade(db, fmt.Sprintf("the instance is available on version %v, the upgrade to %v didn't apply, see the events of the instance", current, wanted))
+		}
+	} else {
+		db.Status.UpgradeTarget = wanted
+		db.Status.UpgradeState = ""
+		db.Status.UpgradeSnapshot = ""
+		db.Status.UpgradeMessage = ""
+	}
+
+	svc := r.rdsclient()
+	res, err := svc.DescribeDBEngineVersions(ctx, &rds.DescribeDBEngineVersionsInput{
+		Engine:        instance.Engine,
+		EngineVersion: instance.EngineVersion,
+	})
+	if err != nil {
+		return true, errors.Wrap(err, "DescribeDBEngineVersions")
+	}
+	var targets []rdstypes.UpgradeTarget
+	for _, v := range res.DBEngineVersions {
+		targets = append(targets, v.ValidUpgradeTarget...)
+	}
+	target, err := upgradeTarget(wanted, targets)
+	if err != nil {
+		return failUpgrade(db, fmt.Sprintf("unable to upgrade from %v: %v", current, err))
+	}
+
+	input := &rds.ModifyDBInstanceInput{
+		DBInstanceIdentifier: instance.DBInstanceIdentifier,
+		EngineVersion:        target.EngineVersion,
+		ApplyImmediately:     true,
+	}
+	if target.IsMajorVersionUpgrade {
+		ready, err := r.ensureUpgradeSnapshot(ctx, db, instance)
+		if err != nil || !ready {
+			return true, err
+		}
+		family, err := engineFamily(ctx, svc, aws.ToString(instance.Engine), aws.ToString(target.EngineVersion))
+		if err != nil {
+			return true, err
+		}
+		// the parameter group of the old family can't be used with the new version, the managed
+		// group is recreated for the new family once the upgrade is done
+		input.AllowMajorVersionUpgrade = true
+		input.DBParameterGroupName = aws.String("default." + family)
+	}
+
+	log.Printf("Upgrading %v from %v to %v\n", *instance.DBInstanceIdentifier, current, *target.EngineVersion)
+	if _, err := svc.ModifyDBInstance(ctx, input); err != nil {
+		return true, errors.Wrap(err, "ModifyDBInstance")
+	}
+	db.Status.UpgradeState = upgradeUpgrading
+	db.Status.UpgradeMessage = fmt.Sprintf("Upgrading from %v to %v", current, *target.EngineVersion)
+	return true, nil
+}
+
+// ensureUpgradeSnapshot takes a snapshot of the instance before a major upgrade, and tells if it's available
+func (r *RDS) ensureUpgradeSnapshot(ctx context.Context, db *crd.Database, instance *rdstypes.DBInstance) (bool, error) {
+	svc := r.rdsclient()
+	name := upgradeSnapshotName(db, db.Spec.Version)
+	db.Status.UpgradeSnapshot = name
+	db.Status.UpgradeState = upgradeSnapshotting
+
+	res, err := svc.DescribeDBSnapshots(ctx, &rds.DescribeDBSnapshotsInput{DBSnapshotIdentifier: aws.String(name)})
+	if err != nil {
+		var notFound *rdstypes.DBSnapshotNotFoundFault
+		if !errors.As(err, &notFound) {
+			return false, errors.Wrap(err, "DescribeDBSnapshots")
+		}
+		log.Printf("Taking snapshot %v before upgrading %v\n", name, *instance.DBInstanceIdentifier)
+		_, err := svc.CreateDBSnapshot(ctx, &rds.CreateDBSnapshotInput{
+			DBInstanceIdentifier: instance.DBInstanceIdentifier,
+			DBSnapshotIdentifier: aws.String(name),
+			Tags:                 []rdstypes.Tag{{Key: aws.String("Warning"), Value: aws.String("Managed by k8s-rds.")}},
+		})
+		if err != nil {
+			return false, errors.Wrap(err, "CreateDBSnapshot")
+		}
+		db.Status.UpgradeMessage = fmt.Sprintf("Taking snapshot %v before upgrading to %v", name, db.Spec.Version)
+		return false, nil
+	}
+	if len(res.DBSnapshots) == 0 || aws.ToString(res.DBSnapshots[0].Status) != "available" {
+		return false, nil
+	}
+	return true, nil
+}
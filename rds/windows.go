@@ -0,0 +1,136 @@
+package rds
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/rds"
+	rdstypes "github.com/aws/aws-sdk-go-v2/service/rds/types"
+	"github.com/sorenmat/k8s-rds/crd"
+)
+
+const (
+	minutesPerDay   = 24 * 60
+	minutesPerWeek  = 7 * minutesPerDay
+	minWindowLength = 30
+)
+
+var weekdays = []string{"mon", "tue", "wed", "thu", "fri", "sat", "sun"}
+
+// parseTime returns the minutes since midnight of a hh24:mi time
+func parseTime(s string) (int, error) {
+	t, err := time.Parse("15:04", s)
+	if err != nil {
+		return 0, fmt.Errorf("invalid time %v, expected hh24:mi", s)
+	}
+	return t.Hour()*60 + t.Minute(), nil
+}
+
+// parseWeekTime returns the minutes since monday midnight of a ddd:hh24:mi time
+func parseWeekTime(s string) (int, error) {
+	parts := strings.SplitN(s, ":", 2)
+	if len(parts) != 2 {
+		return 0, fmt.Errorf("invalid time %v, expected ddd:hh24:mi", s)
+	}
+	for day, name := range weekdays {
+		if strings.EqualFold(parts[0], name) {
+			minutes, err := parseTime(parts[1])
+			return day*minutesPerDay + minutes, err
+		}
+	}
+	return 0, fmt.Errorf("invalid day %v, expected one of %v", parts[0], strings.Join(weekdays, ", "))
+}
+
+// window is a range of minutes, the end is exclusive and can be before the start when it wraps around
+type window struct {
+	start, end, period int
+}
+
+func (w window) length() int {
+	return ((w.end-w.start)%w.period + w.period) % w.period
+}
+
+// contains tells if the minute of the week is inside the window
+func (w window) contains(minute int) bool {
+	return ((minute-w.start)%w.period+w.period)%w.period < w.length()
+}
+
+func parseWindow(s string, parse func(string) (int, error), period int) (window, error) {
+	parts := strings.Split(s, "-")
+	if len(parts) != 2 {
+		return window{}, fmt.Errorf("invalid window %v", s)
+	}
+	start, err := parse(parts[0])
+	if err != nil {
+		return window{}, err
+	}
+	end, err := parse(parts[1])
+	if err != nil {
+		return window{}, err
+	}
+	w := window{start: start, end: end, period: period}
+	if w.length() < minWindowLength {
+		return window{}, fmt.Errorf("window %v must be at least %v minutes", s, minWindowLength)
+	}
+	return w, nil
+}
+
+// validateWindows checks the format and the length of the windows, and that the daily backup window
+// doesn't overlap the weekly maintenance window
+func validateWindows(spec crd.DatabaseSpec) error {
+	var maintenance, backup *window
+	if spec.MaintenanceWindow != "" {
+		w, err := parseWindow(spec.MaintenanceWindow, parseWeekTime, minutesPerWeek)
+		if err != nil {
+			return fmt.Errorf("invalid maintenance window: %v", err)
+		}
+		maintenance = &w
+	}
+	if spec.BackupWindow != "" {
+		w, err := parseWindow(spec.BackupWindow, parseTime, minutesPerDay)
+		if err != nil {
+			return fmt.Errorf("invalid backup window: %v", err)
+		}
+		backup = &w
+	}
+	if maintenance == nil || backup == nil {
+		return nil
+	}
+	for minute := 0; minute < minutesPerWeek; minute++ {
+		if maintenance.contains(minute) && backup.contains(minute%minutesPerDay) {
+			return fmt.Errorf("backup window %v overlaps the maintenance window %v", spec.BackupWindow, spec.MaintenanceWindow)
+		}
+	}
+	return nil
+}
+
+// windowChanges returns the modification needed to apply the windows and upgrade settings of the spec,
+// or nil when the instance already has them. Settings that are not in the spec are left as they are.
+func windowChanges(db *crd.Database, instance *rdstypes.DBInstance) *rds.ModifyDBInstanceInput {
+	input := &rds.ModifyDBInstanceInput{DBInstanceIdentifier: instance.DBInstanceIdentifier}
+	changed := false
+	if w := db.Spec.MaintenanceWindow; w != "" && !strings.EqualFold(w, aws.ToString(instance.PreferredMaintenanceWindow)) {
+		input.PreferredMaintenanceWindow = aws.String(w)
+		changed = true
+	}
+	if w := db.Spec.BackupWindow; w != "" && w != aws.ToString(instance.PreferredBackupWindow) {
+		input.PreferredBackupWindow = aws.String(w)
+		changed = true
+	}
+	if v := db.Spec.AutoMinorVersionUpgrade; v != nil && *v != instance.AutoMinorVersionUpgrade {
+		input.AutoMinorVersionUpgrade = v
+		changed = true
+	}
+	if db.Spec.CopyTagsToSnapshot != instance.CopyTagsToSnapshot {
+		input.CopyTagsToSnapshot = aws.Bool(db.Spec.CopyTagsToSnapshot)
+		changed = true
+	}
+	if !changed {
+		return nil
+	}
+	// the windows and upgrade settings don't cause downtime, so there's no need to wait for the maintenance window
+	input.ApplyImmediately = true
+	return input
+}
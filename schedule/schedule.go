@@ -0,0 +1,55 @@
+// Package schedule tells if a database should be running according to the start and stop times of its schedule
+package schedule
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/robfig/cron/v3"
+	"github.com/sorenmat/k8s-rds/crd"
+
+	// the operator image doesn't have the time zone database
+	_ "time/tzdata"
+)
+
+// lookback is how far back the last start and stop are searched, a week and a day covers weekly schedules
+const lookback = 8 * 24 * time.Hour
+
+// last returns the last time the schedule fired between from and now, or the zero time
+func last(s cron.Schedule, from, now time.Time) time.Time {
+	var result time.Time
+	for t := s.Next(from); !t.IsZero() && !t.After(now); t = s.Next(t) {
+		result = t
+	}
+	return result
+}
+
+// Active tells if the database should be running at the time: it's running when it was last started after it was
+// last stopped. It's also running when neither happened within the last 8 days, or when both happen at once.
+func Active(spec *crd.ScheduleSpec, now time.Time) (bool, error) {
+	if spec == nil {
+		return true, nil
+	}
+	location := time.UTC
+	if spec.Timezone != "" {
+		l, err := time.LoadLocation(spec.Timezone)
+		if err != nil {
+			return false, fmt.Errorf("invalid schedule time zone %v: %v", spec.Timezone, err)
+		}
+		location = l
+	}
+	start, err := cron.ParseStandard(spec.Start)
+	if err != nil {
+		return false, fmt.Errorf("invalid schedule start %v: %v", spec.Start, err)
+	}
+	stop, err := cron.ParseStandard(spec.Stop)
+	if err != nil {
+		return false, fmt.Errorf("invalid schedule stop %v: %v", spec.Stop, err)
+	}
+
+	now = now.In(location)
+	from := now.Add(-lookback)
+	lastStart := last(start, from, now)
+	lastStop := last(stop, from, now)
+	return !lastStart.Before(lastStop), nil
+}
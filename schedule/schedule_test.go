@@ -0,0 +1,46 @@
+package schedule
+
+import (
+	"testing"
+	"time"
+
+	"github.com/sorenmat/k8s-rds/crd"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestActive(t *testing.T) {
+	officeHours := &crd.ScheduleSpec{Start: "0 8 * * 1-5", Stop: "0 20 * * 1-5"}
+	tests := []struct {
+		name   string
+		spec   *crd.ScheduleSpec
+		now    string
+		active bool
+	}{
+		{"no schedule", nil, "2021-06-07T03:00:00Z", true},
+		{"monday morning", officeHours, "2021-06-07T09:00:00Z", true},
+		{"monday night", officeHours, "2021-06-07T21:00:00Z", false},
+		{"before the start", officeHours, "2021-06-08T07:59:00Z", false},
+		{"at the start", officeHours, "2021-06-08T08:00:00Z", true},
+		{"weekend", officeHours, "2021-06-12T12:00:00Z", false},
+		// 08:00 in Copenhagen is 06:00 UTC in the summer
+		{"time zone", &crd.ScheduleSpec{Start: "0 8 * * 1-5", Stop: "0 20 * * 1-5", Timezone: "Europe/Copenhagen"}, "2021-06-07T06:30:00Z", true},
+		{"time zone before the start", &crd.ScheduleSpec{Start: "0 8 * * 1-5", Stop: "0 20 * * 1-5", Timezone: "Europe/Copenhagen"}, "2021-06-07T05:30:00Z", false},
+		{"never stopped", &crd.ScheduleSpec{Start: "0 8 * * *", Stop: "0 0 31 2 *"}, "2021-06-07T05:30:00Z", true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			now, err := time.Parse(time.RFC3339, test.now)
+			assert.NoError(t, err)
+			active, err := Active(test.spec, now)
+			assert.NoError(t, err)
+			assert.Equal(t, test.active, active)
+		})
+	}
+}
+
+func TestActiveInvalid(t *testing.T) {
+	_, err := Active(&crd.ScheduleSpec{Start: "0 8 * *", Stop: "0 20 * * *"}, time.Now())
+	assert.Error(t, err)
+	_, err = Active(&crd.ScheduleSpec{Start: "0 8 * * *", Stop: "0 20 * * *", Timezone: "Mars/Olympus"}, time.Now())
+	assert.Error(t, err)
+}